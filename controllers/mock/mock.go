@@ -8,8 +8,8 @@ import (
 	"github.com/flimzy/log"
 	"github.com/gopherjs/gopherjs/js"
 
-	fb "github.com/FlashbackSRS/flashback-model"
 	"github.com/FlashbackSRS/flashback/controllers"
+	"github.com/FlashbackSRS/flashback/fb"
 	"github.com/FlashbackSRS/flashback/webclient/views/studyview"
 )
 
@@ -46,10 +46,76 @@ type Card struct {
 	Interval    Interval `json:"interval,omitempty"`
 	EaseFactor  float32  `json:"easeFactor,omitempty"`
 	ReviewCount int      `json:"reviewCount,omitempty"`
-	// 	LapseCount  *int           `json:"lapseCount,omitempty"`
+	// BuryReason records why the card was last buried.
+	BuryReason BuryReason `json:"buryReason,omitempty"`
+	// Stability and Difficulty hold the card's memory state, as used by the
+	// FSRS scheduler.
+	Stability  float64 `json:"stability,omitempty"`
+	Difficulty float64 `json:"difficulty,omitempty"`
+	// LearningStep is the 1-based index of the learning or relearning step
+	// the card is currently at, or 0 if it is not in learning.
+	LearningStep int `json:"learningStep,omitempty"`
+	// Relearning is true while the card is in its relearning steps, following
+	// a lapse.
+	Relearning bool `json:"relearning,omitempty"`
+	// LapseCount is the number of times the card has been forgotten after
+	// graduating to review.
+	LapseCount int `json:"lapseCount,omitempty"`
+	// Leech is true if the card has been flagged as a leech, due to repeated
+	// lapses.
+	Leech   bool        `json:"leech,omitempty"`
 	Context interface{} `json:"context,omitempty"`
 }
 
+// BuryReason is the reason a card was buried.
+type BuryReason string
+
+// Burial reasons
+const (
+	// BuryReasonSibling means the card was buried because a related card was
+	// studied.
+	BuryReasonSibling BuryReason = "sibling"
+	// BuryReasonManual means the card was buried by the user.
+	BuryReasonManual BuryReason = "manual"
+	// BuryReasonReview means the card was buried after being reviewed.
+	BuryReasonReview BuryReason = "review"
+)
+
+// Study queues, as stored in a card's "queue" field. Suspended cards belong to
+// no queue.
+const (
+	// QueueNew holds cards which have never been studied.
+	QueueNew = "new"
+	// QueueReview holds cards which have been studied.
+	QueueReview = "review"
+)
+
+// Queue returns the study queue to which the card belongs, or "" if it is
+// suspended.
+func (c *Card) Queue() string {
+	switch {
+	case c.Suspended:
+		return ""
+	case c.Due.IsZero():
+		return QueueNew
+	}
+	return QueueReview
+}
+
+// Available returns the time from which the card may be studied. This is the
+// card's due date, or for a new card its creation time, or if it is buried
+// until later, the end of its burial.
+func (c *Card) Available() Due {
+	available := c.Due
+	if available.IsZero() {
+		available = Due(c.Created.UTC())
+	}
+	if c.BuriedUntil.After(available) {
+		available = c.BuriedUntil
+	}
+	return available
+}
+
 // Validate validates that all of the data in the card appears valid and self
 // consistent. A nil return value means no errors were detected.
 func (c *Card) Validate() error {
@@ -120,9 +186,16 @@ func (c *Card) MarshalJSON() ([]byte, error) {
 		LastReview  *time.Time `json:"lastReview,omitempty"`
 		Due         *Due       `json:"due,omitempty"`
 		BuriedUntil *Due       `json:"buriedUntil,omitempty"`
+		Queue       string     `json:"queue,omitempty"`
+		Available   *Due       `json:"available,omitempty"`
 	}{
 		Type:      "card",
 		cardAlias: cardAlias(*c),
+		Queue:     c.Queue(),
+	}
+	if doc.Queue != "" {
+		available := c.Available()
+		doc.Available = &available
 	}
 	if c.Suspended {
 		doc.Suspended = &c.Suspended
@@ -117,7 +117,9 @@ func TestCardMarshalJSON(t *testing.T) {
 			"_id":      "card-foo.bar.1",
 			"created":  "2017-01-01T01:01:01Z",
 			"model":    "theme-baz/2",
-			"modified": "2017-01-01T01:01:01Z"
+			"modified": "2017-01-01T01:01:01Z",
+			"queue":     "new",
+			"available": "2017-01-01 01:01:01"
 		}`)
 		result, err := json.Marshal(card)
 		checkErr(t, nil, err)
@@ -155,6 +157,32 @@ func TestCardMarshalJSON(t *testing.T) {
 			t.Error(d)
 		}
 	})
+	t.Run("buried review", func(t *testing.T) {
+		card := &Card{
+			ID:          "card-foo.bar.1",
+			ModelID:     "theme-baz/2",
+			Created:     parseTime("2017-01-01T01:01:01Z"),
+			Modified:    parseTime("2017-01-01T01:01:01Z"),
+			BuriedUntil: Due(parseTime("2017-03-01T00:00:00Z")),
+			Due:         Due(parseTime("2017-02-01T00:00:00Z")),
+		}
+		expected := []byte(`{
+			"type":        "card",
+			"_id":         "card-foo.bar.1",
+			"model":       "theme-baz/2",
+			"created":     "2017-01-01T01:01:01Z",
+			"modified":    "2017-01-01T01:01:01Z",
+			"buriedUntil": "2017-03-01",
+			"due":         "2017-02-01",
+			"queue":       "review",
+			"available":   "2017-03-01"
+		}`)
+		result, err := json.Marshal(card)
+		checkErr(t, nil, err)
+		if d := diff.JSON(expected, result); d != nil {
+			t.Error(d)
+		}
+	})
 }
 
 func TestUnmarshalJSON(t *testing.T) {
@@ -44,5 +44,10 @@ review element to be studied. Card documents are stored in the UserDB, as they
 are unique per user,  whereas all other doc types may potentially be shared
 among multiple users.
 
+This package began as a copy of github.com/FlashbackSRS/flashback-model, at
+revision a9780f22bcf46434069d7ed33edd6ea5a16253fe. It is kept in this
+repository, rather than vendored, as the app's scheduling, review log and study
+queues extend its Card, Review and Note documents.
+
 */
 package fb
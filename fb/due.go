@@ -93,8 +93,10 @@ func (d Due) After(d2 Due) bool {
 	return t1.After(t2)
 }
 
+// String formats d in UTC, to the second, or as a date if it falls on UTC
+// midnight.
 func (d Due) String() string {
-	t := time.Time(d)
+	t := time.Time(d).UTC()
 	if t.Truncate(time.Duration(Day)).Equal(t) {
 		return t.Format(DueDays)
 	}
@@ -70,6 +70,11 @@ func TestStringer(t *testing.T) {
 			I:        Due(parseTime("2017-01-17T00:01:40Z")),
 			Expected: "2017-01-17 00:01:40",
 		},
+		{
+			Name:     "Due seconds, local time",
+			I:        Due(parseTime("2017-01-17T01:01:40+01:00")),
+			Expected: "2017-01-17 00:01:40",
+		},
 		{
 			Name:     "Due days",
 			I:        Due(parseTime("1970-04-11T00:00:00Z")),
@@ -26,6 +26,7 @@ type Note struct {
 	ThemeID     string          `json:"theme"`
 	ModelID     uint32          `json:"model"`
 	FieldValues []*FieldValue   `json:"fieldValues"`
+	Tags        []string        `json:"tags,omitempty"`
 	Attachments *FileCollection `json:"_attachments,omitempty"`
 	Model       *Model          `json:"-"`
 	// Set to true by UnmarshalJSON, to skip certain validation checks
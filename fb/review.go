@@ -0,0 +1,142 @@
+package fb
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Review represents a single card-review event.
+type Review struct {
+	// ID is the unique ID for the review. It is a compound key, in the format:
+	//
+	//    review-<bundle>.<note>.<template>.<timestamp>
+	//
+	// where timestamp is the review time in nanoseconds since the Unix epoch.
+	ID               string        `json:"_id,omitempty"`
+	Rev              string        `json:"_rev,omitempty"`
+	CardID           string        `json:"cardID"`
+	Timestamp        time.Time     `json:"timestamp"`
+	Ease             ReviewEase    `json:"ease,omitempty"`
+	Interval         Interval      `json:"interval,omitempty"`
+	PreviousInterval Interval      `json:"previousInterval,omitempty"`
+	SRSFactor        float32       `json:"srsFactor,omitempty"`
+	ReviewTime       time.Duration `json:"reviewTime,omitempty"`
+	Type             ReviewType    `json:"reviewType"`
+}
+
+// Validate validates that all of the data in the review appears valid and self
+// consistent. A nil return value means no errors were detected.
+func (r *Review) Validate() error {
+	if r.CardID == "" {
+		return errors.New("card id required")
+	}
+	if _, _, _, err := parseCardID(r.CardID); err != nil {
+		return err
+	}
+	if r.Timestamp.IsZero() {
+		return errors.New("timestamp required")
+	}
+	if r.ID != "" && !strings.HasPrefix(r.ID, reviewIDPrefix(r.CardID)) {
+		return errors.New("review id does not match card id")
+	}
+	return nil
+}
+
+// reviewIDPrefix returns the prefix shared by the IDs of all reviews of the
+// card.
+func reviewIDPrefix(cardID string) string {
+	return "review-" + strings.TrimPrefix(cardID, "card-") + "."
+}
+
+// ReviewIDRange returns the start and end keys which span all review IDs
+// for the card.
+func ReviewIDRange(cardID string) (startKey, endKey string) {
+	prefix := reviewIDPrefix(cardID)
+	return prefix, prefix + string(rune(0x10FFFF))
+}
+
+type reviewAlias Review
+
+// MarshalJSON satisfies the json.Marshaler interface.
+func (r *Review) MarshalJSON() ([]byte, error) {
+	if err := r.Validate(); err != nil {
+		return nil, err
+	}
+	doc := struct {
+		reviewAlias
+		Type string `json:"type"`
+	}{
+		Type:        "review",
+		reviewAlias: reviewAlias(*r),
+	}
+	return json.Marshal(doc)
+}
+
+// UnmarshalJSON satisfies the json.Unmarshaler interface.
+func (r *Review) UnmarshalJSON(data []byte) error {
+	doc := &reviewAlias{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return err
+	}
+	*r = Review(*doc)
+	return r.Validate()
+}
+
+// ReviewEase represents the answer button pressed during a review.
+type ReviewEase int
+
+// The available review eases
+const (
+	ReviewEaseWrong ReviewEase = 1
+	ReviewEaseHard  ReviewEase = 2
+	ReviewEaseOK    ReviewEase = 3
+	ReviewEaseEasy  ReviewEase = 4
+)
+
+// ReviewType represents the state of the card at the time of review.
+type ReviewType int
+
+// The available review types
+const (
+	ReviewTypeLearn ReviewType = iota
+	ReviewTypeReview
+	ReviewTypeRelearn
+	ReviewTypeCram
+)
+
+// NewReview returns a new, empty Review for the provided Card.
+func NewReview(cardID string) (*Review, error) {
+	return NewReviewAt(cardID, now())
+}
+
+// NewReviewAt returns a new, empty Review for the provided Card, which took
+// place at time t.
+func NewReviewAt(cardID string, t time.Time) (*Review, error) {
+	ts := t.UTC()
+	r := &Review{
+		ID:        fmt.Sprintf("%s%d", reviewIDPrefix(cardID), ts.UnixNano()),
+		CardID:    cardID,
+		Timestamp: ts,
+	}
+	return r, r.Validate()
+}
+
+// SetRev sets the Review's _rev attribute.
+func (r *Review) SetRev(rev string) { r.Rev = rev }
+
+// DocID returns the Review's _id attribute.
+func (r *Review) DocID() string { return r.ID }
+
+// ImportedTime returns the zero time, as reviews are never imported.
+func (r *Review) ImportedTime() time.Time { return time.Time{} }
+
+// ModifiedTime returns the time of the review.
+func (r *Review) ModifiedTime() time.Time { return r.Timestamp }
+
+// MergeImport always fails, as reviews are immutable.
+func (r *Review) MergeImport(_ interface{}) (bool, error) {
+	return false, errors.New("reviews cannot be merged")
+}
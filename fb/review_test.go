@@ -21,6 +21,7 @@ func TestNewReview(t *testing.T) {
 			name:   "valid",
 			cardID: "card-krsxg5baij2w4zdmmu.mViuXQThMLoh1G1Nlc4d_E8kR8o.0",
 			expected: &Review{
+				ID:        "review-krsxg5baij2w4zdmmu.mViuXQThMLoh1G1Nlc4d_E8kR8o.0.1483228800000000000",
 				CardID:    "card-krsxg5baij2w4zdmmu.mViuXQThMLoh1G1Nlc4d_E8kR8o.0",
 				Timestamp: now(),
 			},
@@ -55,7 +56,7 @@ func TestReviewMarshalJSON(t *testing.T) {
 		{
 			name:     "valid",
 			review:   &Review{CardID: "card-abcde.mViuXQThMLoh1G1Nlc4d_E8kR8o.0", Timestamp: now()},
-			expected: `{"cardID":"card-abcde.mViuXQThMLoh1G1Nlc4d_E8kR8o.0", "timestamp":"2017-01-01T00:00:00Z"}`,
+			expected: `{"type":"review", "cardID":"card-abcde.mViuXQThMLoh1G1Nlc4d_E8kR8o.0", "timestamp":"2017-01-01T00:00:00Z", "reviewType":0}`,
 		},
 	}
 	for _, test := range tests {
@@ -132,6 +133,11 @@ func TestReviewValidate(t *testing.T) {
 			v:    &Review{CardID: "card-abcde.mViuXQThMLoh1G1Nlc4d_E8kR8o.0"},
 			err:  "timestamp required",
 		},
+		{
+			name: "mismatched id",
+			v:    &Review{ID: "review-foo.bar.0.1", CardID: "card-abcde.mViuXQThMLoh1G1Nlc4d_E8kR8o.0", Timestamp: now()},
+			err:  "review id does not match card id",
+		},
 		{
 			name: "valid",
 			v:    &Review{CardID: "card-abcde.mViuXQThMLoh1G1Nlc4d_E8kR8o.0", Timestamp: now()},
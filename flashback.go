@@ -4,7 +4,7 @@ import (
 	"context"
 	"time"
 
-	fb "github.com/FlashbackSRS/flashback-model"
+	"github.com/FlashbackSRS/flashback/fb"
 	"github.com/FlashbackSRS/flashback/webclient/views/studyview"
 )
 
@@ -28,20 +28,20 @@ const (
 	AnswerPerfect
 )
 
-// Ease factor options
+// Ease factor options for the default SM-2 scheduler
 const (
 	InitialEase float32 = 2.5
 	MaxEase     float32 = 2.5
 	MinEase     float32 = 1.3
 )
 
-// Interval options
+// Interval options for the default SM-2 scheduler
 const (
 	InitialInterval = fb.Interval(24 * fb.Hour)
 	SecondInterval  = fb.Interval(6 * fb.Day)
 )
 
-// Lapse options
+// Lapse options for the default SM-2 scheduler
 const (
 	LapseInterval = fb.Interval(10 * fb.Minute)
 )
@@ -0,0 +1,886 @@
+package model
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"html"
+	"html/template"
+	"io/ioutil"
+	"mime"
+	"net/url"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/FlashbackSRS/flashback/fb"
+	"github.com/FlashbackSRS/flashback/sqlite"
+)
+
+// ImportAnki imports an Anki package, as exported by Anki as an *.apkg deck
+// package or *.colpkg collection package.
+//
+// Only collections in the legacy (schema 11) format can be read. Current
+// versions of Anki include one only when "Support older Anki versions" is
+// checked on export; packages containing only the newer collection.anki21b
+// format are rejected with ErrUnsupportedAnkiFormat.
+func (r *Repo) ImportAnki(ctx context.Context, data []byte) error {
+	return r.importAnki(ctx, data, nil)
+}
+
+// importAnki is ImportAnki, calling progress, if it is not nil, as the
+// import proceeds.
+func (r *Repo) importAnki(ctx context.Context, data []byte, progress func(ImportProgress)) error {
+	if _, err := r.CurrentUser(); err != nil {
+		return err
+	}
+	clock, err := r.studyClock(ctx)
+	if err != nil {
+		return err
+	}
+	pkg, err := ankiPackage(data, r.user, clock)
+	if err != nil {
+		return errors.Wrap(err, "read Anki package")
+	}
+	return r.importPackage(ctx, pkg, progress)
+}
+
+// ErrUnsupportedAnkiFormat is returned when an Anki package contains only a
+// collection in the collection.anki21b format, which can't be read.
+var ErrUnsupportedAnkiFormat = errors.New(`unsupported Anki collection format; export with "Support older Anki versions" checked`)
+
+// ankiCollectionFiles are the names of the collection databases which may be
+// found in an Anki package, in order of preference. Packages which support
+// older versions of Anki include a legacy collection alongside the current
+// one.
+var ankiCollectionFiles = []string{"collection.anki21", "collection.anki2"}
+
+// ankiCol holds the relevant columns of an Anki collection's col table.
+type ankiCol struct {
+	crt      int64
+	modified int64
+	models   map[string]*ankiModel
+	decks    map[string]*ankiDeck
+	options  map[string]*ankiDeckOptions
+}
+
+// ankiModel is an Anki note type. Only the fields up to Templates are read
+// on import; the rest are written on export.
+type ankiModel struct {
+	Name      string          `json:"name"`
+	Type      int             `json:"type"`
+	CSS       string          `json:"css"`
+	Modified  int64           `json:"mod"`
+	Fields    []*ankiField    `json:"flds"`
+	Templates []*ankiTemplate `json:"tmpls"`
+
+	ID        int64           `json:"id"`
+	USN       int             `json:"usn"`
+	SortField int             `json:"sortf"`
+	Deck      int64           `json:"did"`
+	LatexPre  string          `json:"latexPre"`
+	LatexPost string          `json:"latexPost"`
+	Required  [][]interface{} `json:"req"`
+	Tags      []string        `json:"tags"`
+	Vers      []int           `json:"vers"`
+}
+
+// Anki note types
+const (
+	ankiModelStandard = 0
+	ankiModelCloze    = 1
+)
+
+type ankiField struct {
+	Name string `json:"name"`
+	Ord  int    `json:"ord"`
+
+	Sticky bool     `json:"sticky"`
+	RTL    bool     `json:"rtl"`
+	Font   string   `json:"font"`
+	Size   int      `json:"size"`
+	Media  []string `json:"media"`
+}
+
+// ankiTemplate is an Anki card type, with the mustache-like question and
+// answer formats.
+type ankiTemplate struct {
+	Name     string `json:"name"`
+	Ord      int    `json:"ord"`
+	Question string `json:"qfmt"`
+	Answer   string `json:"afmt"`
+
+	BrowserQuestion string `json:"bqfmt"`
+	BrowserAnswer   string `json:"bafmt"`
+	Deck            *int64 `json:"did"`
+}
+
+type ankiDeck struct {
+	Name        string `json:"name"`
+	Description string `json:"desc"`
+	Dynamic     int    `json:"dyn"`
+	Modified    int64  `json:"mod"`
+
+	ID               int64 `json:"id"`
+	USN              int   `json:"usn"`
+	Conf             int64 `json:"conf"`
+	Collapsed        bool  `json:"collapsed"`
+	BrowserCollapsed bool  `json:"browserCollapsed"`
+	ExtendNew        int   `json:"extendNew"`
+	ExtendRev        int   `json:"extendRev"`
+	NewToday         []int `json:"newToday"`
+	RevToday         []int `json:"revToday"`
+	LrnToday         []int `json:"lrnToday"`
+	TimeToday        []int `json:"timeToday"`
+}
+
+// ankiDeckOptions holds the learning steps of an Anki deck options group. Steps
+// are given in minutes.
+type ankiDeckOptions struct {
+	New struct {
+		Delays []float64 `json:"delays"`
+	} `json:"new"`
+	Lapse struct {
+		Delays []float64 `json:"delays"`
+	} `json:"lapse"`
+}
+
+// ankiDefaultOptionsID is the ID of the default Anki deck options group.
+const ankiDefaultOptionsID = 1
+
+// Anki card queues, as stored in the cards table.
+const (
+	ankiQueueNew         = 0
+	ankiQueueLearning    = 1
+	ankiQueueReview      = 2
+	ankiQueueDayLearning = 3
+	ankiQueueSuspended   = -1
+	ankiQueueSiblingBury = -2
+	ankiQueueManualBury  = -3
+)
+
+// Anki card types, as stored in the cards table.
+const (
+	ankiCardNew        = 0
+	ankiCardLearning   = 1
+	ankiCardReview     = 2
+	ankiCardRelearning = 3
+)
+
+// ankiTemplateContentType is the content type of the original Anki question
+// and answer templates, which are kept alongside the converted model template,
+// but must not be parsed as Go templates.
+const ankiTemplateContentType = "text/x-anki-template"
+
+// ankiMedia provides the media files of an Anki package, by name.
+type ankiMedia struct {
+	files map[string]*zip.File
+}
+
+// ankiMediaTypes are the content types of common Anki media which aren't
+// known to all mime type tables.
+var ankiMediaTypes = map[string]string{
+	".mp3": "audio/mpeg",
+	".ogg": "audio/ogg",
+	".wav": "audio/wav",
+	".m4a": "audio/mp4",
+}
+
+// ankiImport holds the state of the conversion of an Anki collection to a
+// Flashback package.
+type ankiImport struct {
+	bundleID string
+	col      *ankiCol
+	media    *ankiMedia
+	clock    studyClock
+	imported time.Time
+	pkg      *fb.Package
+	models   map[int64]*fb.Model
+	notes    map[int64]*fb.Note
+	decks    map[int64]*fb.Deck
+}
+
+// ankiPackage reads the Anki package in data, and converts it to a Flashback
+// package, owned by owner. Review due dates, which Anki counts in days from
+// the creation of the collection, are interpreted with clock.
+func ankiPackage(data []byte, owner string, clock studyClock) (*fb.Package, error) {
+	z, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, err
+	}
+	files := make(map[string]*zip.File, len(z.File))
+	for _, f := range z.File {
+		files[f.Name] = f
+	}
+	var colFile *zip.File
+	for _, name := range ankiCollectionFiles {
+		if f, ok := files[name]; ok {
+			colFile = f
+			break
+		}
+	}
+	if colFile == nil {
+		if _, ok := files["collection.anki21b"]; ok {
+			return nil, ErrUnsupportedAnkiFormat
+		}
+		return nil, errors.New("no collection found")
+	}
+	media, err := readAnkiMedia(files)
+	if err != nil {
+		return nil, err
+	}
+	colData, err := readZipFile(colFile)
+	if err != nil {
+		return nil, err
+	}
+	db, err := sqlite.Open(colData)
+	if err != nil {
+		return nil, errors.Wrap(err, "open collection")
+	}
+	col, err := readAnkiCol(db)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha1.Sum([]byte(owner + "-anki-" + strconv.FormatInt(col.crt, 10)))
+	imp := &ankiImport{
+		bundleID: fb.EncodeDBID("bundle", sum[:]),
+		col:      col,
+		media:    media,
+		clock:    clock,
+		imported: now().UTC(),
+		pkg:      &fb.Package{},
+		models:   make(map[int64]*fb.Model),
+		notes:    make(map[int64]*fb.Note),
+		decks:    make(map[int64]*fb.Deck),
+	}
+	if err := imp.convertModels(); err != nil {
+		return nil, err
+	}
+	if err := imp.convertNotes(db); err != nil {
+		return nil, err
+	}
+	if err := imp.convertCards(db); err != nil {
+		return nil, err
+	}
+	if err := imp.convertBundle(owner); err != nil {
+		return nil, err
+	}
+	return imp.pkg, nil
+}
+
+func readZipFile(f *zip.File) ([]byte, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return nil, errors.Wrapf(err, "open %s", f.Name)
+	}
+	defer func() { _ = rc.Close() }()
+	data, err := ioutil.ReadAll(rc)
+	return data, errors.Wrapf(err, "read %s", f.Name)
+}
+
+// readAnkiMedia reads the media map, which maps the numbered files in the
+// package to the original file names.
+func readAnkiMedia(files map[string]*zip.File) (*ankiMedia, error) {
+	media := &ankiMedia{
+		files: make(map[string]*zip.File),
+	}
+	f, ok := files["media"]
+	if !ok {
+		return media, nil
+	}
+	data, err := readZipFile(f)
+	if err != nil {
+		return nil, err
+	}
+	var names map[string]string
+	if err := json.Unmarshal(data, &names); err != nil {
+		return nil, errors.Wrap(err, "decode media map")
+	}
+	for number, name := range names {
+		if file, ok := files[number]; ok {
+			media.files[name] = file
+		}
+	}
+	return media, nil
+}
+
+// get returns the content and type of the named media file, which must
+// exist.
+func (m *ankiMedia) get(name string) ([]byte, string, error) {
+	content, err := readZipFile(m.files[name])
+	if err != nil {
+		return nil, "", err
+	}
+	ext := strings.ToLower(path.Ext(name))
+	ctype, ok := ankiMediaTypes[ext]
+	if !ok {
+		ctype = mime.TypeByExtension(ext)
+	}
+	if ctype == "" {
+		ctype = "application/octet-stream"
+	}
+	return content, ctype, nil
+}
+
+// mediaName returns the name of the media file referenced by ref, which may
+// be HTML or URL escaped, or "" if there is none.
+func (m *ankiMedia) mediaName(ref string) string {
+	candidates := []string{ref, html.UnescapeString(ref)}
+	if unescaped, err := url.PathUnescape(candidates[1]); err == nil {
+		candidates = append(candidates, unescaped)
+	}
+	for _, name := range candidates {
+		if _, ok := m.files[name]; ok {
+			return name
+		}
+	}
+	return ""
+}
+
+func readAnkiCol(db *sqlite.DB) (*ankiCol, error) {
+	rows, err := db.Rows("col")
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, errors.New("collection is empty")
+	}
+	row := rows[0]
+	col := &ankiCol{
+		crt:      row.Int("crt"),
+		modified: row.Int("mod"),
+	}
+	if err := json.Unmarshal([]byte(row.Text("models")), &col.models); err != nil {
+		return nil, errors.Wrap(err, "decode note types")
+	}
+	if err := json.Unmarshal([]byte(row.Text("decks")), &col.decks); err != nil {
+		return nil, errors.Wrap(err, "decode decks")
+	}
+	if err := json.Unmarshal([]byte(row.Text("dconf")), &col.options); err != nil {
+		return nil, errors.Wrap(err, "decode deck options")
+	}
+	if len(col.models) == 0 {
+		return nil, errors.Errorf("unsupported collection schema version %d", row.Int("ver"))
+	}
+	return col, nil
+}
+
+// docID returns the ID of the document of type docType converted from the
+// Anki object with the given ID.
+func (imp *ankiImport) docID(docType string, ankiID int64) string {
+	sum := sha1.Sum([]byte(imp.bundleID + ":" + strconv.FormatInt(ankiID, 10)))
+	return fb.EncodeDocID(docType, sum[:])
+}
+
+// ankiTime converts an Anki ID, which is the creation time in milliseconds,
+// to a time. IDs which predate the collection, such as that of the default
+// deck, yield the creation time of the collection.
+func (imp *ankiImport) ankiTime(id int64) time.Time {
+	if id/1000 < imp.col.crt {
+		return time.Unix(imp.col.crt, 0).UTC()
+	}
+	return time.Unix(0, id*int64(time.Millisecond)).UTC()
+}
+
+// modifiedTime converts an Anki modification time, in seconds, to a time.
+func (imp *ankiImport) modifiedTime(mod int64, created time.Time) time.Time {
+	if t := time.Unix(mod, 0).UTC(); t.After(created) {
+		return t
+	}
+	return created
+}
+
+// convertModels creates a theme for each Anki note type, with a single model.
+func (imp *ankiImport) convertModels() error {
+	ids := make([]int64, 0, len(imp.col.models))
+	for id := range imp.col.models {
+		i, err := strconv.ParseInt(id, 10, 64)
+		if err != nil {
+			return errors.Wrapf(err, "invalid note type ID %s", id)
+		}
+		ids = append(ids, i)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	for _, id := range ids {
+		m := imp.col.models[strconv.FormatInt(id, 10)]
+		model, err := imp.convertModel(id, m)
+		if err != nil {
+			return errors.Wrapf(err, "note type '%s'", m.Name)
+		}
+		imp.models[id] = model
+		imp.pkg.Themes = append(imp.pkg.Themes, model.Theme)
+	}
+	return nil
+}
+
+func (imp *ankiImport) convertModel(id int64, m *ankiModel) (*fb.Model, error) {
+	theme, err := fb.NewTheme(imp.docID("theme", id))
+	if err != nil {
+		return nil, err
+	}
+	theme.Name = m.Name
+	theme.Created = imp.ankiTime(id)
+	theme.Modified = imp.modifiedTime(m.Modified, theme.Created)
+	theme.Imported = imp.imported
+	modelType := fb.AnkiStandardModel
+	if m.Type == ankiModelCloze {
+		modelType = fb.AnkiClozeModel
+	}
+	model, err := theme.NewModel(modelType)
+	if err != nil {
+		return nil, err
+	}
+	model.Name = m.Name
+	sort.Slice(m.Fields, func(i, j int) bool { return m.Fields[i].Ord < m.Fields[j].Ord })
+	for _, f := range m.Fields {
+		if err := model.AddField(fb.AnkiField, f.Name); err != nil {
+			return nil, err
+		}
+	}
+	sort.Slice(m.Templates, func(i, j int) bool { return m.Templates[i].Ord < m.Templates[j].Ord })
+	sources := []string{m.CSS}
+	for _, t := range m.Templates {
+		model.Templates = append(model.Templates, t.Name)
+		prefix := fmt.Sprintf("!%s.%s ", m.Name, t.Name)
+		if err := model.AddFile(prefix+"question.html", ankiTemplateContentType, []byte(t.Question)); err != nil {
+			return nil, err
+		}
+		if err := model.AddFile(prefix+"answer.html", ankiTemplateContentType, []byte(t.Answer)); err != nil {
+			return nil, err
+		}
+		sources = append(sources, t.Question, t.Answer)
+	}
+	tmpl, err := ankiModelTemplate(m)
+	if err != nil {
+		return nil, err
+	}
+	if err := model.AddFile(fmt.Sprintf("$template.%d.html", model.ID), fb.TemplateContentType, []byte(tmpl)); err != nil {
+		return nil, err
+	}
+	theme.SetFile(mainCSS, "text/css", []byte(m.CSS))
+	// Attach media used by the styling or templates, such as fonts.
+	for _, name := range imp.media.referencedBy(strings.Join(sources, "\n")) {
+		content, ctype, err := imp.media.get(name)
+		if err != nil {
+			return nil, err
+		}
+		theme.SetFile(name, ctype, content)
+	}
+	return model, nil
+}
+
+// referencedBy returns the names of the media files which appear in text, in
+// sorted order.
+func (m *ankiMedia) referencedBy(text string) []string {
+	names := make([]string, 0)
+	for name := range m.files {
+		if strings.Contains(text, name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+var ankiTagRE = regexp.MustCompile(`{{(.*?)}}`)
+
+// ankiModelTemplate converts the Anki templates of m to a Flashback model
+// template, with a question and an answer div for each. A cloze note type has
+// a single template, used for every card, so its divs take their ID from the
+// card.
+func ankiModelTemplate(m *ankiModel) (string, error) {
+	buf := &bytes.Buffer{}
+	for _, t := range m.Templates {
+		dataID := strconv.Itoa(t.Ord)
+		if m.Type == ankiModelCloze {
+			dataID = "{{.Card.TemplateID}}"
+		}
+		question, err := convertAnkiTemplate(m, t, t.Question, "")
+		if err != nil {
+			return "", errors.Wrapf(err, "card type '%s' question", t.Name)
+		}
+		answer, err := convertAnkiTemplate(m, t, t.Answer, question)
+		if err != nil {
+			return "", errors.Wrapf(err, "card type '%s' answer", t.Name)
+		}
+		fmt.Fprintf(buf, "<div class=\"question\" data-id=\"%s\">%s</div>\n", dataID, question)
+		fmt.Fprintf(buf, "<div class=\"answer\" data-id=\"%s\">%s</div>\n", dataID, answer)
+	}
+	// Ensure the result parses, so that a broken note type is reported now,
+	// rather than when a card is studied.
+	funcs := template.FuncMap{"cloze": func(s template.HTML) template.HTML { return s }}
+	if _, err := template.New("template.html").Funcs(funcs).Parse(buf.String()); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// convertAnkiTemplate converts an Anki question or answer template to a Go
+// template, which reads field values from the card's Fields. frontSide is the
+// converted question, for use by the answer.
+func convertAnkiTemplate(m *ankiModel, t *ankiTemplate, src, frontSide string) (string, error) {
+	buf := &bytes.Buffer{}
+	sections := make([]string, 0)
+	last := 0
+	for _, loc := range ankiTagRE.FindAllStringSubmatchIndex(src, -1) {
+		buf.WriteString(src[last:loc[0]])
+		last = loc[1]
+		tag := strings.TrimSpace(src[loc[2]:loc[3]])
+		switch {
+		case tag == "":
+		case tag[0] == '#' || tag[0] == '^':
+			name := strings.TrimSpace(tag[1:])
+			sections = append(sections, name)
+			if tag[0] == '#' {
+				fmt.Fprintf(buf, "{{if %s}}", fieldValue(name))
+			} else {
+				fmt.Fprintf(buf, "{{if not (%s)}}", fieldValue(name))
+			}
+		case tag[0] == '/':
+			name := strings.TrimSpace(tag[1:])
+			if len(sections) == 0 || sections[len(sections)-1] != name {
+				return "", errors.Errorf("unexpected {{%s}}", tag)
+			}
+			sections = sections[:len(sections)-1]
+			buf.WriteString("{{end}}")
+		default:
+			buf.WriteString(ankiReplacement(m, t, tag, frontSide))
+		}
+	}
+	if strings.Contains(src[last:], "{{") {
+		return "", errors.New("unterminated {{")
+	}
+	buf.WriteString(src[last:])
+	if len(sections) > 0 {
+		return "", errors.Errorf("unclosed {{#%s}}", sections[len(sections)-1])
+	}
+	return buf.String(), nil
+}
+
+// fieldValue returns a template expression for the value of the named field.
+func fieldValue(name string) string {
+	return fmt.Sprintf("index $.Fields %s", strconv.Quote(name))
+}
+
+// ankiReplacement returns the template text replacing an Anki field
+// reference, which may include filters, such as {{cloze:Text}}.
+func ankiReplacement(m *ankiModel, t *ankiTemplate, tag, frontSide string) string {
+	parts := strings.Split(tag, ":")
+	name := strings.TrimSpace(parts[len(parts)-1])
+	filters := parts[:len(parts)-1]
+	switch name {
+	case "FrontSide":
+		return frontSide
+	case "Tags":
+		return `{{range $i, $tag := $.Note.Tags}}{{if $i}} {{end}}{{$tag}}{{end}}`
+	case "Type":
+		return html.EscapeString(m.Name)
+	case "Card":
+		return html.EscapeString(t.Name)
+	case "Deck", "Subdeck", "CardFlag":
+		// The deck and flag of a card aren't available to the template.
+		return ""
+	}
+	for _, filter := range filters {
+		switch strings.TrimSpace(filter) {
+		case "type":
+			return fmt.Sprintf(`<input type="text" name="%s">`, html.EscapeString("type:"+name))
+		case "cloze":
+			if m.Type == ankiModelCloze {
+				return fmt.Sprintf("{{cloze (%s)}}", fieldValue(name))
+			}
+		}
+	}
+	return fmt.Sprintf("{{%s}}", fieldValue(name))
+}
+
+var (
+	ankiImageRE = regexp.MustCompile(`(?i)<img[^>]*?\ssrc=["']?([^"'>\s]+)`)
+	ankiSoundRE = regexp.MustCompile(`\[sound:([^\]]+)\]`)
+)
+
+// convertNotes creates a note for each Anki note.
+func (imp *ankiImport) convertNotes(db *sqlite.DB) error {
+	rows, err := db.Rows("notes")
+	if err != nil {
+		return err
+	}
+	for _, row := range rows {
+		id := row.Int("id")
+		model, ok := imp.models[row.Int("mid")]
+		if !ok {
+			return errors.Errorf("note %d: unknown note type %d", id, row.Int("mid"))
+		}
+		note, err := fb.NewNote(imp.docID("note", id), model)
+		if err != nil {
+			return errors.Wrapf(err, "note %d", id)
+		}
+		note.Created = imp.ankiTime(id)
+		note.Modified = imp.modifiedTime(row.Int("mod"), note.Created)
+		note.Imported = imp.imported
+		note.Tags = strings.Fields(row.Text("tags"))
+		values := strings.Split(row.Text("flds"), "\x1f")
+		attached := make(map[string]bool)
+		for i := range model.Fields {
+			fv := note.GetFieldValue(i)
+			if i < len(values) {
+				fv.Text = values[i]
+			}
+			if err := imp.attachFieldMedia(fv, attached); err != nil {
+				return errors.Wrapf(err, "note %d", id)
+			}
+		}
+		imp.notes[id] = note
+		imp.pkg.Notes = append(imp.pkg.Notes, note)
+	}
+	return nil
+}
+
+// attachFieldMedia attaches the images and sounds referenced by the field
+// value, converting sound references to audio elements. A file is attached to
+// the first field of the note which references it; attached records the files
+// already attached to the note.
+func (imp *ankiImport) attachFieldMedia(fv *fb.FieldValue, attached map[string]bool) error {
+	attach := func(ref string) (string, string, error) {
+		name := imp.media.mediaName(ref)
+		if name == "" {
+			return "", "", nil
+		}
+		content, ctype, err := imp.media.get(name)
+		if err != nil {
+			return "", "", err
+		}
+		if !attached[name] {
+			if err := fv.AddFile(name, ctype, content); err != nil {
+				return "", "", err
+			}
+			attached[name] = true
+		}
+		return name, ctype, nil
+	}
+	for _, match := range ankiImageRE.FindAllStringSubmatch(fv.Text, -1) {
+		if _, _, err := attach(match[1]); err != nil {
+			return err
+		}
+	}
+	var err error
+	fv.Text = ankiSoundRE.ReplaceAllStringFunc(fv.Text, func(tag string) string {
+		ref := ankiSoundRE.FindStringSubmatch(tag)[1]
+		name, ctype, e := attach(ref)
+		if e != nil {
+			err = e
+		}
+		if name == "" {
+			return tag
+		}
+		return fmt.Sprintf(`<audio src="%s" type="%s"></audio>`, url.PathEscape(name), ctype)
+	})
+	return err
+}
+
+// convertCards creates a card, with its scheduling state, for each Anki card,
+// and adds it to the deck of the Anki card.
+func (imp *ankiImport) convertCards(db *sqlite.DB) error {
+	lastReviews, err := ankiLastReviews(db)
+	if err != nil {
+		return err
+	}
+	rows, err := db.Rows("cards")
+	if err != nil {
+		return err
+	}
+	crtDay := imp.clock.on(time.Unix(imp.col.crt, 0))
+	for _, row := range rows {
+		id := row.Int("id")
+		note, ok := imp.notes[row.Int("nid")]
+		if !ok {
+			return errors.Errorf("card %d: unknown note %d", id, row.Int("nid"))
+		}
+		cardID := fmt.Sprintf("card-%s.%s.%d", strings.TrimPrefix(imp.bundleID, "bundle-"),
+			strings.TrimPrefix(note.ID, "note-"), row.Int("ord"))
+		card, err := fb.NewCard(note.ThemeID, note.ModelID, cardID)
+		if err != nil {
+			return errors.Wrapf(err, "card %d", id)
+		}
+		card.Created = imp.ankiTime(id)
+		card.Modified = imp.modifiedTime(row.Int("mod"), card.Created)
+		card.Imported = imp.imported
+		card.LastReview = lastReviews[id]
+		deckID, due := row.Int("did"), row.Int("due")
+		// Cards in a filtered deck remember their original deck and due.
+		if odid := row.Int("odid"); odid != 0 {
+			deckID = odid
+			if odue := row.Int("odue"); odue != 0 {
+				due = odue
+			}
+		}
+		imp.schedule(card, row, crtDay, due, imp.deckOptions(deckID))
+		deck, err := imp.deck(deckID)
+		if err != nil {
+			return errors.Wrapf(err, "card %d", id)
+		}
+		deck.AddCard(card.ID)
+		imp.pkg.Cards = append(imp.pkg.Cards, card)
+	}
+	return nil
+}
+
+// ankiLastReviews returns the time of the latest review of each card, from
+// the review log.
+func ankiLastReviews(db *sqlite.DB) (map[int64]time.Time, error) {
+	lastReviews := make(map[int64]time.Time)
+	rows, err := db.Rows("revlog")
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		cardID := row.Int("cid")
+		t := time.Unix(0, row.Int("id")*int64(time.Millisecond)).UTC()
+		if t.After(lastReviews[cardID]) {
+			lastReviews[cardID] = t
+		}
+	}
+	return lastReviews, nil
+}
+
+// schedule sets the scheduling state of card from the Anki card. due is
+// interpreted according to the card type: reviews are due a number of days
+// after crtDay, the day the collection was created, and learning cards are
+// due at a time, in seconds since the epoch, unless they are learning across
+// days. options gives the learning steps of the card's deck.
+func (imp *ankiImport) schedule(card *fb.Card, row sqlite.Row, crtDay fb.Due, due int64, options *ankiDeckOptions) {
+	queue, cardType := row.Int("queue"), row.Int("type")
+	card.Suspended = queue == ankiQueueSuspended
+	switch queue {
+	case ankiQueueSiblingBury, ankiQueueManualBury:
+		// Anki unburies cards at the start of the next day.
+		card.BuriedUntil = imp.clock.today().Add(fb.Day)
+		card.BuryReason = fb.BuryReasonSibling
+		if queue == ankiQueueManualBury {
+			card.BuryReason = fb.BuryReasonManual
+		}
+	}
+	if cardType == ankiCardNew {
+		return
+	}
+	card.ReviewCount = int(row.Int("reps"))
+	card.LapseCount = int(row.Int("lapses"))
+	if factor := row.Int("factor"); factor > 0 {
+		card.EaseFactor = float32(factor) / 1000
+	}
+	switch ivl := row.Int("ivl"); {
+	case ivl > 0:
+		card.Interval = fb.Interval(ivl) * fb.Day
+	case ivl < 0:
+		card.Interval = fb.Interval(-ivl) * fb.Second
+	}
+	// Anki distinguishes learning timestamps from day numbers by size.
+	if due > 1000000000 {
+		card.Due = fb.Due(time.Unix(due, 0).UTC())
+	} else {
+		card.Due = crtDay.Add(fb.Interval(due) * fb.Day)
+	}
+	switch cardType {
+	case ankiCardLearning:
+		card.LearningStep = ankiLearningStep(row.Int("left"), options.New.Delays)
+		card.Interval = 0
+	case ankiCardRelearning:
+		card.LearningStep = ankiLearningStep(row.Int("left"), options.Lapse.Delays)
+		card.Relearning = true
+	}
+}
+
+// deckOptions returns the options group of the Anki deck with the given ID,
+// falling back to the default group, or to no steps at all, if it is missing.
+func (imp *ankiImport) deckOptions(deckID int64) *ankiDeckOptions {
+	optionsID := int64(ankiDefaultOptionsID)
+	if d, ok := imp.col.decks[strconv.FormatInt(deckID, 10)]; ok && d.Conf != 0 {
+		optionsID = d.Conf
+	}
+	if options, ok := imp.col.options[strconv.FormatInt(optionsID, 10)]; ok {
+		return options
+	}
+	if options, ok := imp.col.options[strconv.Itoa(ankiDefaultOptionsID)]; ok {
+		return options
+	}
+	return &ankiDeckOptions{}
+}
+
+// ankiLearningStep converts Anki's left field to a 1-based learning step.
+// Anki counts the steps remaining, including the current one, in the last
+// three digits of left, so the step is found by counting back from the number
+// of steps configured. Steps which are out of range, because the options have
+// changed since the card was answered, are clamped to the first or last step.
+func ankiLearningStep(left int64, steps []float64) int {
+	step := len(steps) - int(left%1000) + 1
+	if step > len(steps) {
+		step = len(steps)
+	}
+	if step < 1 {
+		step = 1
+	}
+	return step
+}
+
+// deck returns the deck converted from the Anki deck with the given ID,
+// creating it on first use, so that decks without cards aren't imported.
+// Cards in a deck which no longer exists are placed in a default deck.
+func (imp *ankiImport) deck(id int64) (*fb.Deck, error) {
+	if deck, ok := imp.decks[id]; ok {
+		return deck, nil
+	}
+	d, ok := imp.col.decks[strconv.FormatInt(id, 10)]
+	if !ok || d.Dynamic != 0 {
+		d = &ankiDeck{Name: "Default"}
+	}
+	deck, err := fb.NewDeck(imp.docID("deck", id))
+	if err != nil {
+		return nil, err
+	}
+	deck.Name = d.Name
+	deck.Description = d.Description
+	deck.Created = imp.ankiTime(id)
+	deck.Modified = imp.modifiedTime(d.Modified, deck.Created)
+	deck.Imported = imp.imported
+	imp.decks[id] = deck
+	imp.pkg.Decks = append(imp.pkg.Decks, deck)
+	return deck, nil
+}
+
+// convertBundle creates the bundle, named after the top-level decks from
+// which cards were imported.
+func (imp *ankiImport) convertBundle(owner string) error {
+	bundle, err := fb.NewBundle(imp.bundleID, owner)
+	if err != nil {
+		return err
+	}
+	bundle.Created = time.Unix(imp.col.crt, 0).UTC()
+	bundle.Modified = bundle.Created
+	if t := time.Unix(0, imp.col.modified*int64(time.Millisecond)).UTC(); t.After(bundle.Created) {
+		bundle.Modified = t
+	}
+	bundle.Imported = imp.imported
+	names := make([]string, 0, len(imp.pkg.Decks))
+	seen := make(map[string]bool)
+	for _, deck := range imp.pkg.Decks {
+		name := strings.SplitN(deck.Name, "::", 2)[0]
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	bundle.Name = strings.Join(names, ", ")
+	imp.pkg.Bundle = bundle
+	imp.pkg.Created = imp.imported
+	imp.pkg.Modified = imp.imported
+	return nil
+}
@@ -0,0 +1,823 @@
+package model
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"math"
+	"net/url"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/FlashbackSRS/flashback/fb"
+	"github.com/FlashbackSRS/flashback/sqlite"
+)
+
+// ExportAnki writes the bundle to w as an Anki *.apkg deck package, which
+// may be imported by Anki, or by ImportAnki. The collection is written in
+// the legacy (schema 11) format, which all versions of Anki can read.
+func (r *Repo) ExportAnki(ctx context.Context, bundleID string, w io.Writer, opts ExportOptions) error {
+	pkg, err := r.bundlePackage(ctx, bundleID, opts)
+	if err != nil {
+		return err
+	}
+	clock, err := r.studyClock(ctx)
+	if err != nil {
+		return err
+	}
+	return errors.Wrap(writeAnkiPackage(w, pkg, clock), "write Anki package")
+}
+
+// ankiCollectionSchema creates the tables and indexes of a schema 11 Anki
+// collection.
+var ankiCollectionSchema = []string{
+	`CREATE TABLE col (
+    id integer primary key, crt integer not null, mod integer not null,
+    scm integer not null, ver integer not null, dty integer not null,
+    usn integer not null, ls integer not null, conf text not null,
+    models text not null, decks text not null, dconf text not null,
+    tags text not null)`,
+	`CREATE TABLE notes (
+    id integer primary key, guid text not null, mid integer not null,
+    mod integer not null, usn integer not null, tags text not null,
+    flds text not null, sfld integer not null, csum integer not null,
+    flags integer not null, data text not null)`,
+	`CREATE TABLE cards (
+    id integer primary key, nid integer not null, did integer not null,
+    ord integer not null, mod integer not null, usn integer not null,
+    type integer not null, queue integer not null, due integer not null,
+    ivl integer not null, factor integer not null, reps integer not null,
+    lapses integer not null, left integer not null, odue integer not null,
+    odid integer not null, flags integer not null, data text not null)`,
+	`CREATE TABLE revlog (
+    id integer primary key, cid integer not null, usn integer not null,
+    ease integer not null, ivl integer not null, lastIvl integer not null,
+    factor integer not null, time integer not null, type integer not null)`,
+	`CREATE TABLE graves (usn integer not null, oid integer not null, type integer not null)`,
+	`CREATE INDEX ix_notes_usn on notes (usn)`,
+	`CREATE INDEX ix_cards_usn on cards (usn)`,
+	`CREATE INDEX ix_revlog_usn on revlog (usn)`,
+	`CREATE INDEX ix_cards_nid on cards (nid)`,
+	`CREATE INDEX ix_cards_sched on cards (did, queue, due)`,
+	`CREATE INDEX ix_revlog_cid on revlog (cid)`,
+	`CREATE INDEX ix_notes_csum on notes (csum)`,
+}
+
+// ankiDeckConf is Anki's default deck options group, used by all exported
+// decks.
+const ankiDeckConf = `{"1": {
+	"id": 1, "name": "Default", "mod": 0, "usn": 0, "maxTaken": 60,
+	"autoplay": true, "timer": 0, "replayq": true, "dyn": false,
+	"new": {"bury": true, "delays": [1, 10], "initialFactor": 2500,
+		"ints": [1, 4, 7], "order": 1, "perDay": 20, "separate": true},
+	"lapse": {"delays": [10], "leechAction": 0, "leechFails": 8,
+		"minInt": 1, "mult": 0},
+	"rev": {"bury": true, "ease4": 1.3, "fuzz": 0.05, "ivlFct": 1,
+		"maxIvl": 36500, "minSpace": 1, "perDay": 100}
+}}`
+
+// The number of learning and relearning steps in ankiDeckConf.
+const (
+	ankiDefaultNewSteps   = 2
+	ankiDefaultLapseSteps = 1
+)
+
+const (
+	ankiDefaultDeckID = 1
+	// ankiDefaultFactor is the ease factor of new cards, in permille.
+	ankiDefaultFactor = 2500
+	ankiLatexPre      = "\\documentclass[12pt]{article}\n\\special{papersize=3in,5in}\n" +
+		"\\usepackage[utf8]{inputenc}\n\\usepackage{amssymb,amsmath}\n\\pagestyle{empty}\n" +
+		"\\setlength{\\parindent}{0in}\n\\begin{document}\n"
+	ankiLatexPost = "\\end{document}"
+)
+
+// ankiIDs assigns Anki IDs, which are creation times in milliseconds, making
+// them unique by incrementing them as necessary.
+type ankiIDs map[int64]bool
+
+func (ids ankiIDs) next(t time.Time) int64 {
+	id := t.UnixNano() / int64(time.Millisecond)
+	for ids[id] {
+		id++
+	}
+	ids[id] = true
+	return id
+}
+
+// ankiMediaExport collects the media files of an exported package, by name.
+type ankiMediaExport map[string][]byte
+
+// add adds a media file, and returns its name in the package, which differs
+// from name if a different file of that name was already added.
+func (m ankiMediaExport) add(name string, content []byte) string {
+	if existing, ok := m[name]; !ok || bytes.Equal(existing, content) {
+		m[name] = content
+		return name
+	}
+	sum := sha1.Sum(content)
+	ext := path.Ext(name)
+	renamed := fmt.Sprintf("%s-%x%s", strings.TrimSuffix(name, ext), sum[:4], ext)
+	m[renamed] = content
+	return renamed
+}
+
+// renameMedia replaces the references to a renamed media file in text.
+func renameMedia(text, name, renamed string) string {
+	if name == renamed {
+		return text
+	}
+	text = strings.Replace(text, name, renamed, -1)
+	if escaped := url.PathEscape(name); escaped != name {
+		text = strings.Replace(text, escaped, url.PathEscape(renamed), -1)
+	}
+	return text
+}
+
+// ankiExport holds the state of the conversion of a Flashback package to an
+// Anki collection.
+type ankiExport struct {
+	pkg    *fb.Package
+	clock  studyClock
+	crt    time.Time
+	crtDay fb.Due
+	db     *sqlite.Writer
+	media  ankiMediaExport
+
+	models    map[string]*ankiModel
+	decks     map[string]*ankiDeck
+	notes     map[string]int64
+	positions map[string]int64
+	cards     map[string]int64
+	cardDecks map[string]int64
+
+	modelIDs, deckIDs, noteIDs, cardIDs, reviewIDs ankiIDs
+}
+
+// writeAnkiPackage converts pkg to an Anki collection, and writes it to w
+// with its media, as an Anki package. Due dates are converted to Anki's day
+// numbers with clock.
+func writeAnkiPackage(w io.Writer, pkg *fb.Package, clock studyClock) error {
+	crtDay := clock.on(pkg.Bundle.Created)
+	exp := &ankiExport{
+		pkg:       pkg,
+		clock:     clock,
+		crt:       clock.start(crtDay),
+		crtDay:    crtDay,
+		db:        sqlite.NewWriter(),
+		media:     make(ankiMediaExport),
+		models:    make(map[string]*ankiModel),
+		decks:     make(map[string]*ankiDeck),
+		notes:     make(map[string]int64),
+		positions: make(map[string]int64),
+		cards:     make(map[string]int64),
+		cardDecks: make(map[string]int64),
+		modelIDs:  make(ankiIDs),
+		deckIDs:   ankiIDs{ankiDefaultDeckID: true},
+		noteIDs:   make(ankiIDs),
+		cardIDs:   make(ankiIDs),
+		reviewIDs: make(ankiIDs),
+	}
+	for _, sql := range ankiCollectionSchema {
+		create := exp.db.CreateTable
+		if strings.HasPrefix(sql, "CREATE INDEX") {
+			create = exp.db.CreateIndex
+		}
+		if err := create(sql); err != nil {
+			return err
+		}
+	}
+	if err := exp.convertThemes(); err != nil {
+		return err
+	}
+	exp.convertDecks()
+	if err := exp.convertNotes(); err != nil {
+		return err
+	}
+	if err := exp.convertCards(); err != nil {
+		return err
+	}
+	if err := exp.convertReviews(); err != nil {
+		return err
+	}
+	if err := exp.insertCol(); err != nil {
+		return err
+	}
+	colData, err := exp.db.Bytes()
+	if err != nil {
+		return errors.Wrap(err, "write collection")
+	}
+	return exp.writeZip(w, colData)
+}
+
+// modelKey identifies a model within a package.
+func modelKey(themeID string, modelID uint32) string {
+	return fmt.Sprintf("%s/%d", themeID, modelID)
+}
+
+// convertThemes creates an Anki note type for each model. The theme's files,
+// other than its styling and templates, are exported as media.
+func (exp *ankiExport) convertThemes() error {
+	for _, theme := range exp.pkg.Themes {
+		css := ""
+		if att, ok := theme.Attachments.GetFile(mainCSS); ok {
+			css = string(att.Content)
+		}
+		renamed := make(map[string]string)
+		for _, name := range theme.Attachments.FileList() {
+			att, _ := theme.Attachments.GetFile(name)
+			if _, ok := templateTypes[att.ContentType]; ok || att.ContentType == ankiTemplateContentType {
+				continue
+			}
+			renamed[name] = exp.media.add(name, att.Content)
+		}
+		for _, model := range theme.Models {
+			m, err := exp.convertModel(theme, model)
+			if err != nil {
+				return errors.Wrapf(err, "model '%s'", model.Name)
+			}
+			m.CSS = css
+			for name, newName := range renamed {
+				m.CSS = renameMedia(m.CSS, name, newName)
+				for _, t := range m.Templates {
+					t.Question = renameMedia(t.Question, name, newName)
+					t.Answer = renameMedia(t.Answer, name, newName)
+				}
+			}
+			exp.models[modelKey(theme.ID, model.ID)] = m
+		}
+	}
+	return nil
+}
+
+func (exp *ankiExport) convertModel(theme *fb.Theme, model *fb.Model) (*ankiModel, error) {
+	m := &ankiModel{
+		ID:        exp.modelIDs.next(theme.Created),
+		Name:      model.Name,
+		Type:      ankiModelStandard,
+		Modified:  theme.Modified.Unix(),
+		Deck:      ankiDefaultDeckID,
+		LatexPre:  ankiLatexPre,
+		LatexPost: ankiLatexPost,
+		Tags:      []string{},
+		Vers:      []int{},
+	}
+	if m.Name == "" {
+		m.Name = theme.Name
+	}
+	if model.Type == fb.AnkiClozeModel {
+		m.Type = ankiModelCloze
+	}
+	ords := make([]interface{}, len(model.Fields))
+	for i, f := range model.Fields {
+		m.Fields = append(m.Fields, &ankiField{
+			Name:  f.Name,
+			Ord:   i,
+			Font:  "Arial",
+			Size:  20,
+			Media: []string{},
+		})
+		ords[i] = i
+	}
+	templates, err := ankiCardTypes(model)
+	if err != nil {
+		return nil, err
+	}
+	m.Templates = templates
+	for _, t := range templates {
+		m.Required = append(m.Required, []interface{}{t.Ord, "any", ords})
+	}
+	return m, nil
+}
+
+var templateDivRE = regexp.MustCompile(`<div\s+class="(question|answer)"\s+data-id="([^"]*)"\s*>`)
+
+// ankiCardTypes returns an Anki card type for each question in the model's
+// template. The original Anki templates are used if the model was imported
+// from Anki; otherwise the template is converted back to Anki's syntax.
+func ankiCardTypes(model *fb.Model) ([]*ankiTemplate, error) {
+	mainTemplate := fmt.Sprintf("$template.%d.html", model.ID)
+	att, ok := model.Files.GetFile(mainTemplate)
+	if !ok {
+		return nil, errors.Errorf("main template '%s' not found in model", mainTemplate)
+	}
+	src := string(att.Content)
+	questions := make(map[int]string)
+	answers := make(map[int]string)
+	ords := make([]int, 0)
+	for _, loc := range templateDivRE.FindAllStringSubmatchIndex(src, -1) {
+		ord := 0
+		// A cloze model has a single template, whose ID comes from the card.
+		if model.Type != fb.AnkiClozeModel {
+			var err error
+			if ord, err = strconv.Atoi(src[loc[4]:loc[5]]); err != nil {
+				return nil, errors.Errorf("invalid template ID '%s'", src[loc[4]:loc[5]])
+			}
+		}
+		body, ok := divBody(src[loc[1]:])
+		if !ok {
+			return nil, errors.Errorf("unclosed %s div", src[loc[2]:loc[3]])
+		}
+		faces := answers
+		if src[loc[2]:loc[3]] == "question" {
+			faces = questions
+			if _, ok := questions[ord]; !ok {
+				ords = append(ords, ord)
+			}
+		}
+		if _, ok := faces[ord]; !ok {
+			faces[ord] = body
+		}
+	}
+	if len(ords) == 0 {
+		return nil, errors.New("no templates found")
+	}
+	sort.Ints(ords)
+	templates := make([]*ankiTemplate, 0, len(ords))
+	for _, ord := range ords {
+		t := &ankiTemplate{Ord: ord, Name: fmt.Sprintf("Card %d", ord+1)}
+		if ord < len(model.Templates) {
+			t.Name = model.Templates[ord]
+		}
+		prefix := fmt.Sprintf("!%s.%s ", model.Name, t.Name)
+		q, qok := model.Files.GetFile(prefix + "question.html")
+		a, aok := model.Files.GetFile(prefix + "answer.html")
+		if qok && aok && q.ContentType == ankiTemplateContentType && a.ContentType == ankiTemplateContentType {
+			t.Question, t.Answer = string(q.Content), string(a.Content)
+			templates = append(templates, t)
+			continue
+		}
+		var err error
+		if t.Question, err = ankiTemplateSource(model, questions[ord], 0); err != nil {
+			return nil, errors.Wrapf(err, "template '%s' question", t.Name)
+		}
+		if t.Answer, err = ankiTemplateSource(model, answers[ord], 0); err != nil {
+			return nil, errors.Wrapf(err, "template '%s' answer", t.Name)
+		}
+		// On a cloze card, FrontSide shows the question with its deletions
+		// hidden, so it can only replace the question of a standard card.
+		if model.Type != fb.AnkiClozeModel && t.Question != "" && strings.HasPrefix(t.Answer, t.Question) {
+			t.Answer = "{{FrontSide}}" + strings.TrimPrefix(t.Answer, t.Question)
+		}
+		templates = append(templates, t)
+		if model.Type == fb.AnkiClozeModel {
+			break
+		}
+	}
+	return templates, nil
+}
+
+var divTagRE = regexp.MustCompile(`(?i)<(/?)div\b[^>]*>`)
+
+// divBody returns the content of the div which starts src, up to its closing
+// tag.
+func divBody(src string) (string, bool) {
+	depth := 1
+	for _, loc := range divTagRE.FindAllStringSubmatchIndex(src, -1) {
+		if loc[3] > loc[2] {
+			depth--
+		} else {
+			depth++
+		}
+		if depth == 0 {
+			return src[:loc[0]], true
+		}
+	}
+	return "", false
+}
+
+const (
+	// ankiTagsRange is the conversion of Anki's {{Tags}} on import.
+	ankiTagsRange = `{{range $i, $tag := $.Note.Tags}}{{if $i}} {{end}}{{$tag}}{{end}}`
+	// maxTemplateDepth limits the nesting of included templates.
+	maxTemplateDepth = 10
+)
+
+var (
+	templateActionRE = regexp.MustCompile(`(?s){{-?\s*(.*?)\s*-?}}`)
+	typeInputRE      = regexp.MustCompile(`<input type="text" name="type:([^"]*)">`)
+	fieldExprRE      = regexp.MustCompile(`^\(?\s*index\s+(?:\$g|\$)?\.Fields\s+("(?:[^"\\]|\\.)*")\s*\)?$`)
+	templateCallRE   = regexp.MustCompile(`^template\s+("(?:[^"\\]|\\.)*")\s*(?:\.|\$|\$g)?$`)
+)
+
+// ankiTemplateSource converts a question or answer of a model template back
+// to Anki's template syntax. Only field references, conditionals on fields,
+// cloze deletions, tags and the inclusion of other templates of the model
+// can be converted.
+func ankiTemplateSource(model *fb.Model, src string, depth int) (string, error) {
+	if depth > maxTemplateDepth {
+		return "", errors.New("templates nested too deeply")
+	}
+	// Tags and typed answers are converted to placeholders, which are
+	// replaced once the template actions have been converted.
+	placeholders := make([]string, 0)
+	placeholder := func(tag string) string {
+		placeholders = append(placeholders, tag)
+		return fmt.Sprintf("\x00%d\x00", len(placeholders)-1)
+	}
+	src = strings.Replace(src, ankiTagsRange, placeholder("{{Tags}}"), -1)
+	src = typeInputRE.ReplaceAllStringFunc(src, func(input string) string {
+		name := html.UnescapeString(typeInputRE.FindStringSubmatch(input)[1])
+		return placeholder("{{type:" + name + "}}")
+	})
+	buf := &bytes.Buffer{}
+	sections := make([]string, 0)
+	last := 0
+	for _, loc := range templateActionRE.FindAllStringSubmatchIndex(src, -1) {
+		buf.WriteString(src[last:loc[0]])
+		last = loc[1]
+		action := src[loc[2]:loc[3]]
+		switch {
+		case strings.HasPrefix(action, "/*"):
+		case action == "end":
+			if len(sections) == 0 {
+				return "", errors.New("unexpected {{end}}")
+			}
+			fmt.Fprintf(buf, "{{/%s}}", sections[len(sections)-1])
+			sections = sections[:len(sections)-1]
+		case strings.HasPrefix(action, "if not "):
+			name, err := fieldName(strings.TrimPrefix(action, "if not "))
+			if err != nil {
+				return "", err
+			}
+			sections = append(sections, name)
+			fmt.Fprintf(buf, "{{^%s}}", name)
+		case strings.HasPrefix(action, "if "):
+			name, err := fieldName(strings.TrimPrefix(action, "if "))
+			if err != nil {
+				return "", err
+			}
+			sections = append(sections, name)
+			fmt.Fprintf(buf, "{{#%s}}", name)
+		case strings.HasPrefix(action, "cloze "):
+			name, err := fieldName(strings.TrimPrefix(action, "cloze "))
+			if err != nil {
+				return "", err
+			}
+			fmt.Fprintf(buf, "{{cloze:%s}}", name)
+		case templateCallRE.MatchString(action):
+			included, err := includedTemplate(model, templateCallRE.FindStringSubmatch(action)[1])
+			if err != nil {
+				return "", err
+			}
+			converted, err := ankiTemplateSource(model, included, depth+1)
+			if err != nil {
+				return "", err
+			}
+			buf.WriteString(converted)
+		default:
+			name, err := fieldName(action)
+			if err != nil {
+				return "", err
+			}
+			fmt.Fprintf(buf, "{{%s}}", name)
+		}
+	}
+	buf.WriteString(src[last:])
+	if len(sections) > 0 {
+		return "", errors.Errorf("unclosed {{if}} on field %s", sections[len(sections)-1])
+	}
+	result := buf.String()
+	for i, tag := range placeholders {
+		result = strings.Replace(result, fmt.Sprintf("\x00%d\x00", i), tag, -1)
+	}
+	return result, nil
+}
+
+// fieldName returns the name of the field referenced by a template
+// expression.
+func fieldName(expr string) (string, error) {
+	m := fieldExprRE.FindStringSubmatch(strings.TrimSpace(expr))
+	if m == nil {
+		return "", errors.Errorf("unsupported template action {{%s}}", expr)
+	}
+	return strconv.Unquote(m[1])
+}
+
+// includedTemplate returns the content of the model or theme template with
+// the given quoted name.
+func includedTemplate(model *fb.Model, quoted string) (string, error) {
+	name, err := strconv.Unquote(quoted)
+	if err != nil {
+		return "", err
+	}
+	if att, ok := model.Files.GetFile("!" + model.Name + "." + name); ok {
+		return string(att.Content), nil
+	}
+	if model.Theme != nil && model.Theme.Files != nil {
+		if att, ok := model.Theme.Files.GetFile(name); ok {
+			return string(att.Content), nil
+		}
+	}
+	return "", errors.Errorf("template %s not found", quoted)
+}
+
+// convertDecks creates an Anki deck for each deck, and for any parents of
+// nested decks which don't exist. A deck named Default is exported as Anki's
+// default deck.
+func (exp *ankiExport) convertDecks() {
+	exp.addDeck(&ankiDeck{ID: ankiDefaultDeckID, Name: "Default"})
+	for _, deck := range exp.pkg.Decks {
+		d, ok := exp.decks[deck.Name]
+		if !ok {
+			d = &ankiDeck{ID: exp.deckIDs.next(deck.Created), Name: deck.Name}
+			exp.addDeck(d)
+		}
+		d.Description = deck.Description
+		d.Modified = deck.Modified.Unix()
+		for _, id := range deck.Cards.All() {
+			if _, ok := exp.cardDecks[id]; !ok {
+				exp.cardDecks[id] = d.ID
+			}
+		}
+		parts := strings.Split(deck.Name, "::")
+		for i := 1; i < len(parts); i++ {
+			name := strings.Join(parts[:i], "::")
+			if _, ok := exp.decks[name]; !ok {
+				exp.addDeck(&ankiDeck{ID: exp.deckIDs.next(deck.Created), Name: name, Modified: deck.Modified.Unix()})
+			}
+		}
+	}
+}
+
+func (exp *ankiExport) addDeck(d *ankiDeck) {
+	d.Conf = 1
+	d.NewToday = []int{0, 0}
+	d.RevToday = []int{0, 0}
+	d.LrnToday = []int{0, 0}
+	d.TimeToday = []int{0, 0}
+	exp.decks[d.Name] = d
+}
+
+var ankiAudioRE = regexp.MustCompile(`<audio src="([^"]*)"[^>]*></audio>`)
+
+// convertNotes creates an Anki note for each note, with its attachments as
+// media. Audio elements are converted back to Anki's sound references.
+func (exp *ankiExport) convertNotes() error {
+	for i, note := range exp.pkg.Notes {
+		m, ok := exp.models[modelKey(note.ThemeID, note.ModelID)]
+		if !ok {
+			return errors.Errorf("note %s: model %s not found", note.ID, modelKey(note.ThemeID, note.ModelID))
+		}
+		values := make([]string, len(m.Fields))
+		for j := range values {
+			if j < len(note.FieldValues) {
+				values[j] = ankiAudioRE.ReplaceAllStringFunc(note.FieldValues[j].Text, func(audio string) string {
+					src := html.UnescapeString(ankiAudioRE.FindStringSubmatch(audio)[1])
+					if name, err := url.PathUnescape(src); err == nil {
+						src = name
+					}
+					return "[sound:" + src + "]"
+				})
+			}
+		}
+		if note.Attachments != nil {
+			for _, name := range note.Attachments.FileList() {
+				att, _ := note.Attachments.GetFile(name)
+				renamed := exp.media.add(name, att.Content)
+				for j := range values {
+					values[j] = renameMedia(values[j], name, renamed)
+				}
+			}
+		}
+		id := exp.noteIDs.next(note.Created)
+		exp.notes[note.ID] = id
+		exp.positions[note.ID] = int64(i + 1)
+		tags := ""
+		if len(note.Tags) > 0 {
+			tags = " " + strings.Join(note.Tags, " ") + " "
+		}
+		sortField := stripHTML(values[0])
+		sum := sha1.Sum([]byte(sortField))
+		csum, _ := strconv.ParseInt(hex.EncodeToString(sum[:4]), 16, 64)
+		guid := strings.TrimPrefix(note.ID, "note-")
+		if err := exp.db.Insert("notes", id, guid, m.ID, note.Modified.Unix(), 0, tags,
+			strings.Join(values, "\x1f"), sortField, csum, 0, ""); err != nil {
+			return errors.Wrapf(err, "note %s", note.ID)
+		}
+	}
+	return nil
+}
+
+var htmlTagRE = regexp.MustCompile(`(?s)<[^>]*>`)
+
+// stripHTML returns the text of an HTML fragment, as Anki does to compare
+// notes.
+func stripHTML(s string) string {
+	return strings.TrimSpace(html.UnescapeString(htmlTagRE.ReplaceAllString(s, "")))
+}
+
+// ankiCardState is the scheduling state of an Anki card.
+type ankiCardState struct {
+	cardType, queue, due, ivl, factor, left int64
+}
+
+// convertCards creates an Anki card for each card, in the deck which lists
+// it, or the default deck.
+func (exp *ankiExport) convertCards() error {
+	for _, card := range exp.pkg.Cards {
+		nid, ok := exp.notes[card.NoteID()]
+		if !ok {
+			return errors.Errorf("card %s: note not found", card.ID)
+		}
+		did, ok := exp.cardDecks[card.ID]
+		if !ok {
+			did = ankiDefaultDeckID
+		}
+		id := exp.cardIDs.next(card.Created)
+		exp.cards[card.ID] = id
+		s := exp.schedule(card, exp.positions[card.NoteID()])
+		if err := exp.db.Insert("cards", id, nid, did, int64(card.TemplateID()), card.Modified.Unix(), 0,
+			s.cardType, s.queue, s.due, s.ivl, s.factor, int64(card.ReviewCount), int64(card.LapseCount),
+			s.left, 0, 0, 0, ""); err != nil {
+			return errors.Wrapf(err, "card %s", card.ID)
+		}
+	}
+	return nil
+}
+
+// ankiLeft returns Anki's left field for a card at the given 1-based learning
+// step, out of steps: the number of steps remaining, including the current
+// one. Steps beyond those exported are clamped to the last step.
+func ankiLeft(step, steps int) int64 {
+	if left := steps - step + 1; left > 1 {
+		return int64(left)
+	}
+	return 1
+}
+
+// schedule returns the Anki scheduling state of card. New cards are due in
+// the order of their notes, given by position.
+func (exp *ankiExport) schedule(card *fb.Card, position int64) ankiCardState {
+	var s ankiCardState
+	switch {
+	case card.Due.IsZero():
+		s = ankiCardState{cardType: ankiCardNew, queue: ankiQueueNew, due: position}
+	case card.LearningStep > 0:
+		s.cardType = ankiCardLearning
+		if card.Relearning {
+			s.cardType = ankiCardRelearning
+		}
+		steps := ankiDefaultNewSteps
+		if card.Relearning {
+			steps = ankiDefaultLapseSteps
+		}
+		s.left = ankiLeft(card.LearningStep, steps)
+		if isDay(card.Due) {
+			s.queue, s.due = ankiQueueDayLearning, exp.days(card.Due)
+		} else {
+			s.queue, s.due = ankiQueueLearning, card.Due.Time().Unix()
+		}
+	default:
+		s = ankiCardState{cardType: ankiCardReview, queue: ankiQueueReview, due: exp.days(card.Due)}
+	}
+	if s.cardType != ankiCardNew {
+		s.factor = int64(math.Floor(float64(card.EaseFactor)*1000 + 0.5))
+		if s.factor == 0 {
+			s.factor = ankiDefaultFactor
+		}
+		if s.cardType != ankiCardLearning {
+			s.ivl = ankiInterval(card.Interval)
+		}
+	}
+	switch {
+	case card.Suspended:
+		s.queue = ankiQueueSuspended
+	case card.BuriedUntil.After(exp.clock.today()):
+		s.queue = ankiQueueSiblingBury
+		if card.BuryReason == fb.BuryReasonManual {
+			s.queue = ankiQueueManualBury
+		}
+	}
+	return s
+}
+
+// days returns the Anki day number of due, counted from the creation of the
+// collection.
+func (exp *ankiExport) days(due fb.Due) int64 {
+	if !isDay(due) {
+		due = exp.clock.on(due.Time())
+	}
+	return int64(due.Sub(exp.crtDay) / fb.Day)
+}
+
+// ankiInterval converts an interval to Anki's representation: positive
+// values count days, and negative values seconds.
+func ankiInterval(ivl fb.Interval) int64 {
+	if ivl >= fb.Day {
+		return int64(ivl.Days())
+	}
+	return -int64(ivl / fb.Second)
+}
+
+// maxAnkiReviewTime is the longest review time Anki records.
+const maxAnkiReviewTime = time.Minute
+
+// convertReviews adds the reviews of the exported cards to the review log.
+func (exp *ankiExport) convertReviews() error {
+	for _, review := range exp.pkg.Reviews {
+		cid, ok := exp.cards[review.CardID]
+		if !ok {
+			continue
+		}
+		reviewTime := review.ReviewTime
+		if reviewTime > maxAnkiReviewTime {
+			reviewTime = maxAnkiReviewTime
+		}
+		factor := int64(math.Floor(float64(review.SRSFactor)*1000 + 0.5))
+		if err := exp.db.Insert("revlog", exp.reviewIDs.next(review.Timestamp), cid, 0,
+			int64(review.Ease), ankiInterval(review.Interval), ankiInterval(review.PreviousInterval),
+			factor, int64(reviewTime/time.Millisecond), int64(review.Type)); err != nil {
+			return errors.Wrapf(err, "review %s", review.ID)
+		}
+	}
+	return nil
+}
+
+// insertCol adds the collection's single row, with its note types, decks and
+// configuration.
+func (exp *ankiExport) insertCol() error {
+	models := make(map[string]*ankiModel, len(exp.models))
+	for _, m := range exp.models {
+		models[strconv.FormatInt(m.ID, 10)] = m
+	}
+	decks := make(map[string]*ankiDeck, len(exp.decks))
+	for _, d := range exp.decks {
+		decks[strconv.FormatInt(d.ID, 10)] = d
+	}
+	conf := map[string]interface{}{
+		"activeDecks":   []int{ankiDefaultDeckID},
+		"curDeck":       ankiDefaultDeckID,
+		"newSpread":     0,
+		"collapseTime":  1200,
+		"timeLim":       0,
+		"estTimes":      true,
+		"dueCounts":     true,
+		"curModel":      nil,
+		"nextPos":       len(exp.pkg.Notes) + 1,
+		"sortType":      "noteFld",
+		"sortBackwards": false,
+		"addToCur":      true,
+	}
+	values := make([]string, 0, 3)
+	for _, v := range []interface{}{conf, models, decks} {
+		x, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		values = append(values, string(x))
+	}
+	mod := exp.pkg.Modified.UnixNano() / int64(time.Millisecond)
+	return exp.db.Insert("col", 1, exp.crt.Unix(), mod, mod, 11, 0, 0, 0,
+		values[0], values[1], values[2], ankiDeckConf, "{}")
+}
+
+// writeZip writes the package: the collection, the media files, numbered in
+// the order of their names, and the media map, which gives their names.
+func (exp *ankiExport) writeZip(w io.Writer, colData []byte) error {
+	z := zip.NewWriter(w)
+	write := func(name string, content []byte) error {
+		f, err := z.Create(name)
+		if err != nil {
+			return err
+		}
+		_, err = f.Write(content)
+		return err
+	}
+	if err := write("collection.anki2", colData); err != nil {
+		return err
+	}
+	names := make([]string, 0, len(exp.media))
+	for name := range exp.media {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	mediaMap := make(map[string]string, len(names))
+	for i, name := range names {
+		number := strconv.Itoa(i)
+		mediaMap[number] = name
+		if err := write(number, exp.media[name]); err != nil {
+			return err
+		}
+	}
+	x, err := json.Marshal(mediaMap)
+	if err != nil {
+		return err
+	}
+	if err := write("media", x); err != nil {
+		return err
+	}
+	return z.Close()
+}
@@ -0,0 +1,324 @@
+package model
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/FlashbackSRS/flashback/fb"
+	"github.com/flimzy/diff"
+
+	"github.com/FlashbackSRS/flashback/sqlite"
+)
+
+func testExportModel(t *testing.T) *fb.Model {
+	theme, err := fb.NewTheme("theme-VGVzdCBUaGVtZQ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	model, err := theme.NewModel(fb.AnkiStandardModel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	model.Name = "Basic"
+	files := map[string]string{
+		"!Basic.Card 1 question.html": `<b>{{index $.Fields "Front"}}</b>`,
+		"!Basic.loop.html":            `{{template "loop.html" $g}}`,
+	}
+	for name, content := range files {
+		if err := model.AddFile(name, fb.TemplateContentType, []byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return model
+}
+
+func TestAnkiTemplateSource(t *testing.T) {
+	tests := []struct {
+		name     string
+		src      string
+		expected string
+		err      string
+	}{
+		{
+			name:     "field",
+			src:      `<p>{{index $.Fields "Front"}}</p>`,
+			expected: `<p>{{Front}}</p>`,
+		},
+		{
+			name:     "trimmed",
+			src:      `{{- index .Fields "Two words" -}}`,
+			expected: `{{Two words}}`,
+		},
+		{
+			name:     "sections",
+			src:      `{{if index $.Fields "A"}}a{{if not (index $.Fields "B")}}b{{end}}{{end}}`,
+			expected: `{{#A}}a{{^B}}b{{/B}}{{/A}}`,
+		},
+		{
+			name:     "cloze",
+			src:      `{{cloze (index $.Fields "Text")}}`,
+			expected: `{{cloze:Text}}`,
+		},
+		{
+			name:     "tags and typed answer",
+			src:      ankiTagsRange + `<input type="text" name="type:Back &amp; more">`,
+			expected: `{{Tags}}{{type:Back & more}}`,
+		},
+		{
+			name:     "comment",
+			src:      `a{{/* comment */}}b`,
+			expected: `ab`,
+		},
+		{
+			name:     "included template",
+			src:      `{{template "Card 1 question.html" $g}}<hr>`,
+			expected: `<b>{{Front}}</b><hr>`,
+		},
+		{
+			name: "missing template",
+			src:  `{{template "missing.html" .}}`,
+			err:  `template "missing.html" not found`,
+		},
+		{
+			name: "recursive template",
+			src:  `{{template "loop.html" $g}}`,
+			err:  "templates nested too deeply",
+		},
+		{
+			name: "unsupported action",
+			src:  `{{.Card.TemplateID}}`,
+			err:  "unsupported template action {{.Card.TemplateID}}",
+		},
+		{
+			name: "unexpected end",
+			src:  `{{end}}`,
+			err:  "unexpected {{end}}",
+		},
+		{
+			name: "unclosed if",
+			src:  `{{if index $.Fields "A"}}`,
+			err:  "unclosed {{if}} on field A",
+		},
+	}
+	model := testExportModel(t)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result, err := ankiTemplateSource(model, test.src, 0)
+			checkErr(t, test.err, err)
+			if result != test.expected {
+				t.Errorf("Unexpected result: %s", result)
+			}
+		})
+	}
+}
+
+func TestAnkiCardTypes(t *testing.T) {
+	model := testExportModel(t)
+	_, err := ankiCardTypes(model)
+	checkErr(t, "main template '$template.0.html' not found in model", err)
+
+	model.Templates = []string{"Card 1"}
+	tmpl := `<div class="question" data-id="0">{{template "Card 1 question.html" $g}}</div>
+<div class="answer" data-id="0"><b>{{index $.Fields "Front"}}</b><div>{{index $.Fields "Back"}}</div></div>
+<div class="question" data-id="1">{{index $.Fields "Back"}}</div>
+<div class="answer" data-id="1">{{index $.Fields "Front"}}</div>`
+	if err := model.AddFile("$template.0.html", fb.TemplateContentType, []byte(tmpl)); err != nil {
+		t.Fatal(err)
+	}
+	expected := []*ankiTemplate{
+		{Name: "Card 1", Ord: 0, Question: "<b>{{Front}}</b>", Answer: "{{FrontSide}}<div>{{Back}}</div>"},
+		{Name: "Card 2", Ord: 1, Question: "{{Back}}", Answer: "{{Front}}"},
+	}
+	templates, err := ankiCardTypes(model)
+	checkErr(t, "", err)
+	if d := diff.Interface(expected, templates); d != nil {
+		t.Error(d)
+	}
+
+	// Original Anki templates are used as they are.
+	for _, face := range []string{"question", "answer"} {
+		if err := model.AddFile("!Basic.Card 2 "+face+".html", ankiTemplateContentType, []byte("{{"+face+"}}")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	model.Templates = append(model.Templates, "Card 2")
+	expected[1].Question, expected[1].Answer = "{{question}}", "{{answer}}"
+	templates, err = ankiCardTypes(model)
+	checkErr(t, "", err)
+	if d := diff.Interface(expected, templates); d != nil {
+		t.Error(d)
+	}
+}
+
+// readAnkiExport returns the collection and media map of an exported
+// package.
+func readAnkiExport(t *testing.T, data []byte) (*sqlite.DB, map[string]string) {
+	z, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := make(map[string]*zip.File)
+	for _, f := range z.File {
+		files[f.Name] = f
+	}
+	colData, err := readZipFile(files["collection.anki2"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := sqlite.Open(colData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mediaData, err := readZipFile(files["media"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	media := make(map[string]string)
+	if err := json.Unmarshal(mediaData, &media); err != nil {
+		t.Fatal(err)
+	}
+	return db, media
+}
+
+// normalizeAnkiPackage clears the values which aren't preserved by exporting
+// a package to Anki and importing it again.
+func normalizeAnkiPackage(pkg *fb.Package) {
+	pkg.Bundle.Modified = pkg.Bundle.Created
+	for _, card := range pkg.Cards {
+		card.LastReview = time.Time{}
+	}
+}
+
+func TestWriteAnkiPackage(t *testing.T) {
+	pkg, err := ankiPackage(testApkg(t), "mjxwe", studyClock{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := &bytes.Buffer{}
+	if e := writeAnkiPackage(buf, pkg, studyClock{}); e != nil {
+		t.Fatal(e)
+	}
+
+	db, media := readAnkiExport(t, buf.Bytes())
+	expectedMedia := map[string]string{"0": "_font.ttf", "1": "cat.jpg", "2": "meow.mp3"}
+	if d := diff.Interface(expectedMedia, media); d != nil {
+		t.Error(d)
+	}
+	col, err := readAnkiCol(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if col.crt != 1483228800 {
+		t.Errorf("Unexpected creation time %d", col.crt)
+	}
+	types := make(map[string]int)
+	for _, m := range col.models {
+		types[m.Name] = m.Type
+	}
+	if d := diff.Interface(map[string]int{"Basic": ankiModelStandard, "Cloze": ankiModelCloze}, types); d != nil {
+		t.Error(d)
+	}
+	notes, err := db.Rows("notes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if flds := notes[0].Text("flds"); flds != "hablar <img src=\"cat.jpg\">\x1fto speak [sound:meow.mp3][sound:missing.mp3]" {
+		t.Errorf("Unexpected fields: %q", flds)
+	}
+	if notes[0].Text("sfld") != "hablar" || notes[0].Int("csum") != 0x18c35a29 || notes[0].Text("tags") != " spanish verb " {
+		t.Errorf("Unexpected note: sfld %s, csum %x, tags %q", notes[0].Text("sfld"), notes[0].Int("csum"), notes[0].Text("tags"))
+	}
+	type cardState struct {
+		Type, Queue, Due, Ivl, Factor, Left int64
+	}
+	cards, err := db.Rows("cards")
+	if err != nil {
+		t.Fatal(err)
+	}
+	states := make([]cardState, len(cards))
+	for i, row := range cards {
+		states[i] = cardState{row.Int("type"), row.Int("queue"), row.Int("due"), row.Int("ivl"), row.Int("factor"), row.Int("left")}
+	}
+	expectedStates := []cardState{
+		{Type: ankiCardNew, Queue: ankiQueueNew, Due: 1},
+		{Type: ankiCardReview, Queue: ankiQueueReview, Due: 10, Ivl: 3, Factor: 2300},
+		{Type: ankiCardLearning, Queue: ankiQueueLearning, Due: 1483318800, Factor: 2500, Left: 1},
+		{Type: ankiCardRelearning, Queue: ankiQueueSuspended, Due: 1483318800, Ivl: 2, Factor: 2100, Left: 1},
+	}
+	if d := diff.Interface(expectedStates, states); d != nil {
+		t.Error(d)
+	}
+
+	// Importing the export yields the original package.
+	again, err := ankiPackage(buf.Bytes(), "mjxwe", studyClock{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	normalizeAnkiPackage(pkg)
+	normalizeAnkiPackage(again)
+	if d := diff.AsJSON(pkg, again); d != nil {
+		t.Error(d)
+	}
+}
+
+func TestWriteAnkiPackageMedia(t *testing.T) {
+	pkg, err := ankiPackage(testApkg(t), "mjxwe", studyClock{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A different file of the same name must be renamed.
+	att, _ := pkg.Notes[1].Attachments.GetFile("cat.jpg")
+	att.Content = []byte("another cat")
+	buf := &bytes.Buffer{}
+	if e := writeAnkiPackage(buf, pkg, studyClock{}); e != nil {
+		t.Fatal(e)
+	}
+	db, media := readAnkiExport(t, buf.Bytes())
+	expectedMedia := map[string]string{"0": "_font.ttf", "1": "cat-31aa79ba.jpg", "2": "cat.jpg", "3": "meow.mp3"}
+	if d := diff.Interface(expectedMedia, media); d != nil {
+		t.Error(d)
+	}
+	notes, err := db.Rows("notes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if flds := notes[1].Text("flds"); flds != "{{c1::Madrid}} is the capital of {{c2::Spain}} <img src=\"cat-31aa79ba.jpg\">\x1f" {
+		t.Errorf("Unexpected fields: %q", flds)
+	}
+}
+
+func TestExportAnki(t *testing.T) {
+	err := (&Repo{}).ExportAnki(context.Background(), "bundle-foo", &bytes.Buffer{}, ExportOptions{})
+	checkErr(t, "not logged in", err)
+
+	repo := testExportRepo(t, "mjxwe")
+	if e := repo.ImportFile(context.Background(), &mockFile{body: testApkg(t)}); e != nil {
+		t.Fatal(e)
+	}
+	pkg, err := ankiPackage(testApkg(t), "mjxwe", studyClock{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, opts := range []ExportOptions{{}, {Cards: true, Reviews: true}} {
+		buf := &bytes.Buffer{}
+		if e := repo.ExportAnki(context.Background(), pkg.Bundle.ID, buf, opts); e != nil {
+			t.Fatal(e)
+		}
+		db, _ := readAnkiExport(t, buf.Bytes())
+		cards, err := db.Rows("cards")
+		if err != nil {
+			t.Fatal(err)
+		}
+		reviews := 0
+		for _, card := range cards {
+			reviews += int(card.Int("reps"))
+		}
+		if expected := map[bool]int{false: 0, true: 15}[opts.Cards]; len(cards) != 4 || reviews != expected {
+			t.Errorf("Unexpected %d cards with %d reviews", len(cards), reviews)
+		}
+	}
+}
@@ -0,0 +1,408 @@
+package model
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/FlashbackSRS/flashback/fb"
+	"github.com/flimzy/diff"
+)
+
+// testdata/collection.anki2 is created by testdata/generate_anki.py
+
+// ankiZip returns a zip file containing the named files.
+func ankiZip(t *testing.T, files map[string][]byte) []byte {
+	buf := &bytes.Buffer{}
+	w := zip.NewWriter(buf)
+	for name, content := range files {
+		f, err := w.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := f.Write(content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func testAnkiCollection(t *testing.T) []byte {
+	data, err := ioutil.ReadFile("testdata/collection.anki2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func testApkg(t *testing.T) []byte {
+	return ankiZip(t, map[string][]byte{
+		"collection.anki2": testAnkiCollection(t),
+		"media":            []byte(`{"0":"cat.jpg","1":"meow.mp3","2":"_font.ttf","3":"unused.png"}`),
+		"0":                []byte("cat"),
+		"1":                []byte("meow"),
+		"2":                []byte("font"),
+		"3":                []byte("unused"),
+	})
+}
+
+func TestConvertAnkiTemplate(t *testing.T) {
+	basic := &ankiModel{Name: "Basic"}
+	cloze := &ankiModel{Name: "Cloze", Type: ankiModelCloze}
+	tests := []struct {
+		name      string
+		model     *ankiModel
+		src       string
+		frontSide string
+		expected  string
+		err       string
+	}{
+		{
+			name:     "plain text",
+			model:    basic,
+			src:      "<b>no fields</b>",
+			expected: "<b>no fields</b>",
+		},
+		{
+			name:     "fields",
+			model:    basic,
+			src:      `{{Front}} {{ Field "2" }}`,
+			expected: `{{index $.Fields "Front"}} {{index $.Fields "Field \"2\""}}`,
+		},
+		{
+			name:     "sections",
+			model:    basic,
+			src:      "{{#A}}a{{^B}}not b{{/B}}{{/A}}",
+			expected: `{{if index $.Fields "A"}}a{{if not (index $.Fields "B")}}not b{{end}}{{end}}`,
+		},
+		{
+			name:      "front side",
+			model:     basic,
+			src:       "{{FrontSide}}<hr>",
+			frontSide: "question",
+			expected:  "question<hr>",
+		},
+		{
+			name:     "special fields",
+			model:    basic,
+			src:      "{{Type}} {{Card}} {{Deck}}",
+			expected: "Basic Card 1 ",
+		},
+		{
+			name:     "typed answer",
+			model:    basic,
+			src:      "{{type:Back}}",
+			expected: `<input type="text" name="type:Back">`,
+		},
+		{
+			name:     "cloze",
+			model:    cloze,
+			src:      "{{cloze:Text}}",
+			expected: `{{cloze (index $.Fields "Text")}}`,
+		},
+		{
+			name:     "cloze in standard note type",
+			model:    basic,
+			src:      "{{cloze:Text}}",
+			expected: `{{index $.Fields "Text"}}`,
+		},
+		{
+			name:     "other filters",
+			model:    basic,
+			src:      "{{text:hint:Back}}",
+			expected: `{{index $.Fields "Back"}}`,
+		},
+		{
+			name:  "unexpected close",
+			model: basic,
+			src:   "{{#A}}{{/B}}",
+			err:   "unexpected {{/B}}",
+		},
+		{
+			name:  "unclosed section",
+			model: basic,
+			src:   "{{#A}}",
+			err:   "unclosed {{#A}}",
+		},
+		{
+			name:  "unterminated tag",
+			model: basic,
+			src:   "{{Front",
+			err:   "unterminated {{",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result, err := convertAnkiTemplate(test.model, &ankiTemplate{Name: "Card 1"}, test.src, test.frontSide)
+			checkErr(t, test.err, err)
+			if result != test.expected {
+				t.Errorf("Unexpected result: %s", result)
+			}
+		})
+	}
+}
+
+func TestAnkiModelTemplate(t *testing.T) {
+	m := &ankiModel{
+		Name: "Cloze",
+		Type: ankiModelCloze,
+		Templates: []*ankiTemplate{
+			{Name: "Cloze", Question: "{{cloze:Text}}", Answer: "{{FrontSide}}{{Extra}}"},
+		},
+	}
+	expected := `<div class="question" data-id="{{.Card.TemplateID}}">{{cloze (index $.Fields "Text")}}</div>
+<div class="answer" data-id="{{.Card.TemplateID}}">{{cloze (index $.Fields "Text")}}{{index $.Fields "Extra"}}</div>
+`
+	result, err := ankiModelTemplate(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := diff.Text(expected, result); d != nil {
+		t.Error(d)
+	}
+	m.Templates[0].Question = "{{cloze:Text}}}}{{"
+	_, err = ankiModelTemplate(m)
+	checkErr(t, "card type 'Cloze' question: unterminated {{", err)
+}
+
+func TestAnkiPackageErrors(t *testing.T) {
+	collection := testAnkiCollection(t)
+	tests := []struct {
+		name string
+		data []byte
+		err  string
+	}{
+		{
+			name: "not a zip file",
+			data: []byte("bogus"),
+			err:  "zip: not a valid zip file",
+		},
+		{
+			name: "no collection",
+			data: ankiZip(t, map[string][]byte{"media": []byte("{}")}),
+			err:  "no collection found",
+		},
+		{
+			name: "new collection format",
+			data: ankiZip(t, map[string][]byte{"collection.anki21b": collection}),
+			err:  ErrUnsupportedAnkiFormat.Error(),
+		},
+		{
+			name: "invalid media map",
+			data: ankiZip(t, map[string][]byte{"collection.anki2": collection, "media": []byte("bogus")}),
+			err:  "decode media map: invalid character 'b' looking for beginning of value",
+		},
+		{
+			name: "invalid collection",
+			data: ankiZip(t, map[string][]byte{"collection.anki2": []byte("bogus")}),
+			err:  "open collection: not a SQLite 3 database",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := ankiPackage(test.data, "mjxwe", studyClock{})
+			checkErr(t, test.err, err)
+		})
+	}
+}
+
+func TestAnkiPackage(t *testing.T) {
+	pkg, err := ankiPackage(testApkg(t), "mjxwe", studyClock{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pkg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if pkg.Bundle.Name != "Spanish" || pkg.Bundle.Owner != "mjxwe" {
+		t.Errorf("Unexpected bundle %s, owned by %s", pkg.Bundle.Name, pkg.Bundle.Owner)
+	}
+	again, err := ankiPackage(testApkg(t), "mjxwe", studyClock{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.Bundle.ID != pkg.Bundle.ID || again.Cards[0].ID != pkg.Cards[0].ID {
+		t.Errorf("Expected IDs to be stable across imports")
+	}
+
+	themes := make(map[string]*fb.Theme)
+	for _, theme := range pkg.Themes {
+		themes[theme.Name] = theme
+	}
+	basic := themes["Basic"]
+	if basic == nil || themes["Cloze"] == nil {
+		t.Fatalf("Expected Basic and Cloze themes")
+	}
+	if m := themes["Cloze"].Models[0]; m.Type != fb.AnkiClozeModel {
+		t.Errorf("Unexpected cloze model type %s", m.Type)
+	}
+	model := basic.Models[0]
+	if model.Type != fb.AnkiStandardModel {
+		t.Errorf("Unexpected model type %s", model.Type)
+	}
+	if d := diff.Interface([]string{"Card 1", "Card 2"}, model.Templates); d != nil {
+		t.Error(d)
+	}
+	if d := diff.Interface([]*fb.Field{{Type: fb.AnkiField, Name: "Front"}, {Type: fb.AnkiField, Name: "Back"}}, model.Fields); d != nil {
+		t.Error(d)
+	}
+	expectedFiles := []string{"!Basic.Card 1 answer.html", "!Basic.Card 1 question.html",
+		"!Basic.Card 2 answer.html", "!Basic.Card 2 question.html", "$template.0.html"}
+	files := model.Files.FileList()
+	sort.Strings(files)
+	if d := diff.Interface(expectedFiles, files); d != nil {
+		t.Error(d)
+	}
+	// Only the converted template may be parsed as a Go template when the
+	// card is rendered.
+	for _, name := range files {
+		att, _ := model.Files.GetFile(name)
+		expected := ankiTemplateContentType
+		if name == "$template.0.html" {
+			expected = fb.TemplateContentType
+		}
+		if att.ContentType != expected {
+			t.Errorf("Unexpected content type %s for %s", att.ContentType, name)
+		}
+	}
+	themeFiles := basic.Files.FileList()
+	sort.Strings(themeFiles)
+	if d := diff.Interface([]string{"$main.css", "_font.ttf"}, themeFiles); d != nil {
+		t.Error(d)
+	}
+
+	var note *fb.Note
+	for _, n := range pkg.Notes {
+		if n.ThemeID == basic.ID {
+			note = n
+		}
+	}
+	if d := diff.Interface([]string{"spanish", "verb"}, note.Tags); d != nil {
+		t.Error(d)
+	}
+	expectedText := []string{
+		`hablar <img src="cat.jpg">`,
+		`to speak <audio src="meow.mp3" type="audio/mpeg"></audio>[sound:missing.mp3]`,
+	}
+	for i, expected := range expectedText {
+		if text := note.FieldValues[i].Text; text != expected {
+			t.Errorf("Unexpected field %d text: %s", i, text)
+		}
+	}
+	files = note.Attachments.FileList()
+	sort.Strings(files)
+	if d := diff.Interface([]string{"cat.jpg", "meow.mp3"}, files); d != nil {
+		t.Error(d)
+	}
+
+	cards := make(map[string]*fb.Card)
+	for _, card := range pkg.Cards {
+		cards[card.NoteID()+"."+strconv.Itoa(int(card.TemplateID()))] = card
+	}
+	basicNote := note.ID
+	if card := cards[basicNote+".0"]; card.Queue() != fb.QueueNew || card.Interval != 0 {
+		t.Errorf("Expected a new card, got %s due %s", card.Queue(), card.Due)
+	}
+	review := cards[basicNote+".1"]
+	if review.Due.String() != "2017-01-11" || review.Interval != 3*fb.Day || review.EaseFactor != 2.3 ||
+		review.ReviewCount != 5 || review.LapseCount != 1 || review.LearningStep != 0 {
+		t.Errorf("Unexpected review card: due %s, interval %s, ease %f, reviews %d, lapses %d",
+			review.Due, review.Interval, review.EaseFactor, review.ReviewCount, review.LapseCount)
+	}
+	if expected := parseTime(t, "2017-01-01T22:33:20Z"); !review.LastReview.Equal(expected) {
+		t.Errorf("Unexpected last review %s", review.LastReview)
+	}
+	var learning, relearning *fb.Card
+	for key, card := range cards {
+		if !strings.HasPrefix(key, basicNote) {
+			if card.Relearning {
+				relearning = card
+			} else {
+				learning = card
+			}
+		}
+	}
+	if learning.Due.String() != "2017-01-02 01:00:00" || learning.LearningStep != 2 || learning.Interval != 0 {
+		t.Errorf("Unexpected learning card: due %s, step %d", learning.Due, learning.LearningStep)
+	}
+	if !relearning.Suspended || relearning.Interval != 2*fb.Day || relearning.LearningStep != 1 {
+		t.Errorf("Unexpected relearning card: suspended %t, interval %s, step %d", relearning.Suspended, relearning.Interval, relearning.LearningStep)
+	}
+
+	decks := make(map[string][]string)
+	for _, deck := range pkg.Decks {
+		decks[deck.Name] = deck.Cards.All()
+	}
+	if len(decks) != 2 || len(decks["Spanish"]) != 1 || len(decks["Spanish::Verbs"]) != 3 {
+		t.Errorf("Unexpected decks: %v", decks)
+	}
+}
+
+func TestAnkiLearningStep(t *testing.T) {
+	tests := []struct {
+		name     string
+		left     int64
+		steps    []float64
+		expected int
+	}{
+		{name: "first step", left: 3, steps: []float64{1, 10, 60}, expected: 1},
+		{name: "middle step", left: 2002, steps: []float64{1, 10, 60}, expected: 2},
+		{name: "last step", left: 1001, steps: []float64{1, 10, 60}, expected: 3},
+		{name: "steps removed", left: 5, steps: []float64{1, 10}, expected: 1},
+		{name: "none left", left: 0, steps: []float64{1, 10}, expected: 2},
+		{name: "no steps", left: 1, expected: 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if step := ankiLearningStep(test.left, test.steps); step != test.expected {
+				t.Errorf("Expected step %d, got %d", test.expected, step)
+			}
+		})
+	}
+}
+
+func TestImportAnki(t *testing.T) {
+	ctx := context.Background()
+	local := testClient(t)
+	if err := local.CreateDB(ctx, "user-mjxwe"); err != nil {
+		t.Fatal(err)
+	}
+	repo := &Repo{user: "mjxwe", local: local}
+	if err := repo.ImportFile(ctx, &mockFile{body: testApkg(t)}); err != nil {
+		t.Fatal(err)
+	}
+	pkg, err := ankiPackage(testApkg(t), "mjxwe", studyClock{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	udb, err := repo.userDB(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, card := range pkg.Cards {
+		stored := &fb.Card{}
+		if err := getDoc(ctx, udb, card.ID, stored); err != nil {
+			t.Fatal(err)
+		}
+		if !stored.Due.Equal(card.Due) || stored.Suspended != card.Suspended {
+			t.Errorf("%s: unexpected due %s", card.ID, stored.Due)
+		}
+	}
+	bdb, err := repo.bundleDB(ctx, pkg.Bundle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, note := range pkg.Notes {
+		if _, err := bdb.Get(ctx, note.ID); err != nil {
+			t.Errorf("%s: %s", note.ID, err)
+		}
+	}
+}
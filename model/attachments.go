@@ -3,7 +3,7 @@ package model
 import (
 	"context"
 
-	fb "github.com/FlashbackSRS/flashback-model"
+	"github.com/FlashbackSRS/flashback/fb"
 )
 
 // FetchAttachment fetches the requested attachment associated with the specified
@@ -4,7 +4,7 @@ import (
 	"context"
 	"testing"
 
-	fb "github.com/FlashbackSRS/flashback-model"
+	"github.com/FlashbackSRS/flashback/fb"
 	"github.com/flimzy/diff"
 )
 
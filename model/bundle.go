@@ -5,7 +5,7 @@ import (
 
 	"github.com/pkg/errors"
 
-	fb "github.com/FlashbackSRS/flashback-model"
+	"github.com/FlashbackSRS/flashback/fb"
 )
 
 // SaveBundle saves the bundle.
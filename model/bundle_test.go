@@ -7,7 +7,7 @@ import (
 	"strings"
 	"testing"
 
-	fb "github.com/FlashbackSRS/flashback-model"
+	"github.com/FlashbackSRS/flashback/fb"
 	"github.com/flimzy/diff"
 )
 
@@ -4,13 +4,28 @@ import (
 	"context"
 	"io"
 
-	fb "github.com/FlashbackSRS/flashback-model"
+	"github.com/FlashbackSRS/flashback/fb"
 	"github.com/pkg/errors"
 )
 
-// BuryRelatedCards buries related cards.
+// BuryStrategy determines how the siblings of a studied card are buried.
+type BuryStrategy string
+
+// Burial strategies
+const (
+	// BuryNone leaves sibling cards unburied.
+	BuryNone BuryStrategy = "none"
+	// BuryNextDay buries sibling cards until the next day.
+	BuryNextDay BuryStrategy = "day"
+	// BuryProportional buries sibling cards for a period proportional to the
+	// studied card's interval, as described for BuryRelatedCards.
+	BuryProportional BuryStrategy = "proportional"
+)
+
+// BuryRelatedCards buries related cards, according to the card's configured
+// BuryStrategy.
 //
-// The burial strategy involves the following:
+// The proportional burial strategy involves the following:
 //
 // 1. New cards (reviewCount = 0) are buried for NewBuryTime. This should help
 //    start of new card review to get off on the right foot.
@@ -18,8 +33,12 @@ import (
 // 3. The target burial time is the current card's interval, divided by the number
 //    of related cards. NewBuryTime is used as the minimum target burial time.
 // 4. The maximum burial is MaxBuryRatio of the card's interval.
-func (r *Repo) BuryRelatedCards(ctx context.Context, card *fb.Card) error {
+func (r *Repo) BuryRelatedCards(ctx context.Context, card *Card) error {
 	defer profile("BuryRelatedCards")()
+	strategy := card.settings().BuryStrategy
+	if strategy == BuryNone {
+		return nil
+	}
 	db, err := r.userDB(ctx)
 	if err != nil {
 		return err
@@ -28,27 +47,43 @@ func (r *Repo) BuryRelatedCards(ctx context.Context, card *fb.Card) error {
 	if err != nil {
 		return err
 	}
-	toBury := setBurials(card.Interval, cards)
+	prev := make(map[string]fb.Card, len(cards))
+	for _, c := range cards {
+		prev[c.ID] = *c
+	}
+	toBury := setBurials(card.clock, strategy, card.Interval, cards)
 	if len(toBury) == 0 {
 		return nil
 	}
-	return updateDocs(ctx, db, toBury)
+	if err := updateDocs(ctx, db, toBury); err != nil {
+		return err
+	}
+	siblings := make([]*fb.Card, len(toBury))
+	for i, c := range toBury {
+		sibling := prev[c.ID]
+		siblings[i] = &sibling
+	}
+	r.undo.setBurials(card.ID, siblings)
+	return nil
 }
 
-func setBurials(interval fb.Interval, cards []*fb.Card) []*fb.Card {
-	if len(cards) == 0 {
-		return cards
+func setBurials(clock studyClock, strategy BuryStrategy, interval fb.Interval, cards []*fb.Card) []*fb.Card {
+	if len(cards) == 0 || strategy == BuryNone {
+		return cards[:0]
 	}
 	buryTarget := interval / fb.Interval(len(cards))
 	burials := make([]*fb.Card, 0, len(cards))
 	for _, card := range cards {
-		newInterval := buryInterval(buryTarget, card.Interval, card.ReviewCount == 0)
-		buryUntil := fb.Due(now().UTC()).Add(newInterval)
-		// buryUntil := fb.DueIn(newInterval)
+		newInterval := MinBuryTime
+		if strategy != BuryNextDay {
+			newInterval = buryInterval(buryTarget, card.Interval, card.ReviewCount == 0)
+		}
+		buryUntil := clock.dueIn(newInterval)
 		// Now update the card, but only if we're trying to bury it longer
 		// than it already is, to avoid unnecessary updates.
 		if buryUntil.After(card.BuriedUntil) {
 			card.BuriedUntil = buryUntil
+			card.BuryReason = fb.BuryReasonSibling
 			burials = append(burials, card)
 		}
 
@@ -56,6 +91,64 @@ func setBurials(interval fb.Interval, cards []*fb.Card) []*fb.Card {
 	return burials
 }
 
+// BuryCards buries the cards with the given IDs until the next study day.
+func (r *Repo) BuryCards(ctx context.Context, cardIDs ...string) error {
+	clock, err := r.studyClock(ctx)
+	if err != nil {
+		return err
+	}
+	buryUntil := clock.today().Add(fb.Day)
+	return r.updateCards(ctx, cardIDs, func(card *fb.Card) bool {
+		if !buryUntil.After(card.BuriedUntil) {
+			return false
+		}
+		card.BuriedUntil = buryUntil
+		card.BuryReason = fb.BuryReasonManual
+		return true
+	})
+}
+
+// UnburyCards returns the cards with the given IDs to study immediately.
+func (r *Repo) UnburyCards(ctx context.Context, cardIDs ...string) error {
+	return r.updateCards(ctx, cardIDs, func(card *fb.Card) bool {
+		if card.BuriedUntil.IsZero() {
+			return false
+		}
+		card.BuriedUntil = fb.Due{}
+		card.BuryReason = ""
+		return true
+	})
+}
+
+// BuriedCards returns all cards which are currently buried. The reason for
+// each burial is recorded in the card's BuryReason.
+func (r *Repo) BuriedCards(ctx context.Context) ([]*fb.Card, error) {
+	udb, err := r.userDB(ctx)
+	if err != nil {
+		return nil, err
+	}
+	clock, err := r.studyClock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// The selector includes any card buried until yesterday, UTC, or later,
+	// which covers the current study day in any time zone.
+	t := now()
+	cards, err := findCards(ctx, udb, map[string]interface{}{
+		"buriedUntil": map[string]interface{}{"$gte": fb.On(t.UTC().AddDate(0, 0, -1)).String()},
+	})
+	if err != nil {
+		return nil, err
+	}
+	buried := make([]*fb.Card, 0, len(cards))
+	for _, card := range cards {
+		if !clock.reached(card.BuriedUntil, t) {
+			buried = append(buried, card)
+		}
+	}
+	return buried, nil
+}
+
 // fetchRelatedCards fetches cards related to the provided card ID.
 func fetchRelatedCards(ctx context.Context, db allDocer, cardID string) ([]*fb.Card, error) {
 	startKey, endKey := relatedKeyRange(cardID)
@@ -6,7 +6,7 @@ import (
 	"testing"
 	"time"
 
-	fb "github.com/FlashbackSRS/flashback-model"
+	"github.com/FlashbackSRS/flashback/fb"
 	"github.com/flimzy/diff"
 	"github.com/flimzy/kivik"
 )
@@ -140,13 +140,13 @@ func TestBuryRelatedCards(t *testing.T) {
 	tests := []struct {
 		name string
 		repo *Repo
-		card *fb.Card
+		card *Card
 		err  string
 	}{
 		{
 			name: "not logged in",
 			repo: &Repo{},
-			card: &fb.Card{ID: "card-foo.bar.0"},
+			card: &Card{Card: &fb.Card{ID: "card-foo.bar.0"}},
 			err:  "not logged in",
 		},
 		{
@@ -158,7 +158,7 @@ func TestBuryRelatedCards(t *testing.T) {
 					},
 				},
 			},
-			card: &fb.Card{ID: "card-foo.bar.0"},
+			card: &Card{Card: &fb.Card{ID: "card-foo.bar.0"}},
 			err:  "db error",
 		},
 		{
@@ -167,7 +167,15 @@ func TestBuryRelatedCards(t *testing.T) {
 				local: &buryClient{db: &mockAllDocer{
 					rows: &mockRows{},
 				}}},
-			card: &fb.Card{ID: "card-foo.bar.0"},
+			card: &Card{Card: &fb.Card{ID: "card-foo.bar.0"}},
+		},
+		{
+			name: "no burial",
+			repo: &Repo{},
+			card: &Card{
+				Card:   &fb.Card{ID: "card-foo.bar.0"},
+				config: &DeckSettings{BuryStrategy: BuryNone},
+			},
 		},
 	}
 	for _, test := range tests {
@@ -179,19 +187,24 @@ func TestBuryRelatedCards(t *testing.T) {
 }
 
 func TestSetBurials(t *testing.T) {
+	defer func(n func() time.Time) { now = n }(now)
+	now = func() time.Time { return parseTime(t, "2017-01-01T12:00:00Z") }
 	tests := []struct {
 		name     string
+		strategy BuryStrategy
 		interval fb.Interval
 		cards    []*fb.Card
 		expected []*fb.Card
 	}{
 		{
 			name:     "no cards",
+			strategy: BuryProportional,
 			cards:    []*fb.Card{},
 			expected: []*fb.Card{},
 		},
 		{
 			name:     "two cards",
+			strategy: BuryProportional,
 			interval: fb.Interval(24 * time.Hour),
 			cards: []*fb.Card{
 				{}, // new
@@ -205,21 +218,125 @@ func TestSetBurials(t *testing.T) {
 				}, // Should not be re-buried
 			},
 			expected: []*fb.Card{
-				{BuriedUntil: fb.Due(parseTime(t, "2017-01-08T00:00:00Z"))},
+				{
+					BuriedUntil: fb.Due(parseTime(t, "2017-01-08T00:00:00Z")),
+					BuryReason:  fb.BuryReasonSibling,
+				},
 				{
 					ReviewCount: 1,
 					Interval:    fb.Interval(24 * time.Hour),
 					BuriedUntil: fb.Due(parseTime(t, "2017-01-02T00:00:00Z")),
+					BuryReason:  fb.BuryReasonSibling,
+				},
+			},
+		},
+		{
+			name:     "no burial",
+			strategy: BuryNone,
+			interval: 10 * fb.Day,
+			cards:    []*fb.Card{{ReviewCount: 1, Interval: 20 * fb.Day}},
+			expected: []*fb.Card{},
+		},
+		{
+			name:     "next day",
+			strategy: BuryNextDay,
+			interval: 10 * fb.Day,
+			cards: []*fb.Card{
+				{}, // new
+				{ReviewCount: 1, Interval: 20 * fb.Day},
+			},
+			expected: []*fb.Card{
+				{
+					BuriedUntil: fb.Due(parseTime(t, "2017-01-02T00:00:00Z")),
+					BuryReason:  fb.BuryReasonSibling,
+				},
+				{
+					ReviewCount: 1,
+					Interval:    20 * fb.Day,
+					BuriedUntil: fb.Due(parseTime(t, "2017-01-02T00:00:00Z")),
+					BuryReason:  fb.BuryReasonSibling,
 				},
 			},
 		},
 	}
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
-			result := setBurials(test.interval, test.cards)
+			result := setBurials(studyClock{}, test.strategy, test.interval, test.cards)
 			if d := diff.Interface(test.expected, result); d != nil {
 				t.Error(d)
 			}
 		})
 	}
 }
+
+func TestRepoBuryCards(t *testing.T) {
+	defer func(n func() time.Time) { now = n }(now)
+	now = func() time.Time { return parseTime(t, "2017-01-01T12:00:00Z") }
+	ctx := context.Background()
+	t.Run("not logged in", func(t *testing.T) {
+		err := (&Repo{}).BuryCards(ctx, "card-foo.bar.0")
+		checkErr(t, "not logged in", err)
+	})
+	repo := &Repo{user: "mjxwe", local: testClient(t)}
+	if err := repo.local.CreateDB(ctx, "user-mjxwe"); err != nil {
+		t.Fatal(err)
+	}
+	udb, err := repo.userDB(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, card := range []*fb.Card{
+		{ID: "card-foo.bar.0"},
+		{ID: "card-foo.bar.1", BuriedUntil: parseDue(t, "2017-01-05"), BuryReason: fb.BuryReasonSibling},
+		{ID: "card-foo.bar.2", BuriedUntil: parseDue(t, "2016-12-01"), BuryReason: fb.BuryReasonReview},
+		{ID: "card-foo.bar.3"},
+	} {
+		card.ModelID = "theme-foo/0"
+		card.Created = now()
+		card.Modified = now()
+		if err := saveDoc(ctx, udb, card); err != nil {
+			t.Fatal(err)
+		}
+	}
+	buried := func(t *testing.T) map[string]fb.BuryReason {
+		cards, err := repo.BuriedCards(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		result := make(map[string]fb.BuryReason, len(cards))
+		for _, card := range cards {
+			result[card.ID] = card.BuryReason
+		}
+		return result
+	}
+	t.Run("bury", func(t *testing.T) {
+		if err := repo.BuryCards(ctx, "card-foo.bar.0", "card-foo.bar.1"); err != nil {
+			t.Fatal(err)
+		}
+		card := &fb.Card{}
+		if err := getDoc(ctx, udb, "card-foo.bar.0", card); err != nil {
+			t.Fatal(err)
+		}
+		if expected := parseDue(t, "2017-01-02"); !card.BuriedUntil.Equal(expected) {
+			t.Errorf("Unexpected burial: %s", card.BuriedUntil)
+		}
+		expected := map[string]fb.BuryReason{
+			"card-foo.bar.0": fb.BuryReasonManual,
+			"card-foo.bar.1": fb.BuryReasonSibling, // already buried longer
+		}
+		if d := diff.Interface(expected, buried(t)); d != nil {
+			t.Error(d)
+		}
+	})
+	t.Run("unbury", func(t *testing.T) {
+		if err := repo.UnburyCards(ctx, "card-foo.bar.1", "card-foo.bar.3"); err != nil {
+			t.Fatal(err)
+		}
+		expected := map[string]fb.BuryReason{
+			"card-foo.bar.0": fb.BuryReasonManual,
+		}
+		if d := diff.Interface(expected, buried(t)); d != nil {
+			t.Error(d)
+		}
+	})
+}
@@ -18,8 +18,8 @@ import (
 	"github.com/pkg/errors"
 
 	"github.com/FlashbackSRS/flashback"
-	fb "github.com/FlashbackSRS/flashback-model"
 	"github.com/FlashbackSRS/flashback/controllers/done"
+	"github.com/FlashbackSRS/flashback/fb"
 	"github.com/FlashbackSRS/flashback/webclient/views/studyview"
 )
 
@@ -30,6 +30,29 @@ type Card struct {
 	model  *fbModel
 	appURL string
 	repo   *Repo
+	// config holds the effective deck settings for the card. If nil, the
+	// defaults are used.
+	config *DeckSettings
+	// review is the pending review log entry, set when the card is scheduled,
+	// and stored along with the card.
+	review *fb.Review
+	// decks holds the IDs of the decks containing the card. It is only set
+	// when deck settings are configured.
+	decks []string
+	// clock is the user's study clock.
+	clock studyClock
+	// cram is true if the card is being studied in a custom study session
+	// which doesn't affect scheduling.
+	cram bool
+}
+
+// settings returns the effective settings for the card.
+func (c *Card) settings() *DeckSettings {
+	if c.config == nil {
+		s := defaultDeckSettings
+		return &s
+	}
+	return c.config
 }
 
 var _ flashback.CardView = &Card{}
@@ -130,15 +153,45 @@ func (c *Card) Action(ctx context.Context, face *int, startTime time.Time, query
 	if err != nil {
 		return false, err
 	}
+	prev := *c.Card
 	done, err = mc.Action(c, face, startTime, query)
 	if err != nil {
 		return false, err
 	}
+	cram := c.cram && c.review != nil
+	if c.review != nil {
+		c.repo.sessionAnswered(c.ID, c.review.Ease == fb.ReviewEaseWrong)
+		if cram {
+			// Discard the new schedule, but keep the review.
+			*c.Card = prev
+			c.review.Type = fb.ReviewTypeCram
+			completeReview(c.review, c.Card)
+		}
+	}
 	db, err := c.repo.userDB(ctx)
 	if err != nil {
 		return false, err
 	}
-	return done, saveDoc(ctx, db, c.Card)
+	if !cram {
+		if err := saveDoc(ctx, db, c.Card); err != nil {
+			return false, err
+		}
+	}
+	if c.review != nil {
+		if err := saveDoc(ctx, db, c.review); err != nil {
+			return false, errors.Wrap(err, "save review")
+		}
+		day := c.clock.on(c.review.Timestamp)
+		scopes := countScopes(c.BundleID(), c.decks)
+		if err := countAnswer(ctx, db, day, c.review, scopes, 1); err != nil {
+			return false, err
+		}
+		if !cram {
+			c.repo.undo.push(&undoEntry{card: &prev, review: c.review, day: day, scopes: scopes})
+		}
+		c.review = nil
+	}
+	return done, nil
 }
 
 var now = time.Now
@@ -155,98 +208,23 @@ const (
 	newBatchSize = 5
 	oldBatchSize = 45
 
-	// limitPadding is added to the query limit, to reduce the total number of
-	// queries which must be performed.
+	// limitPadding is added to the query limit, so that cards rejected after
+	// fetching rarely require another query.
 	limitPadding = 20
 )
 
-func getCardsFromView(ctx context.Context, db querier, view string, limit int) ([]*cardSchedule, error) {
-	defer profile("getCardsFromView: " + view)()
-	if limit <= 0 {
-		return nil, errors.New("invalid limit")
-	}
-	cards := make([]*cardSchedule, 0, limit)
-	offset := 0
-	for i := 0; len(cards) < limit && i < 100; i++ {
-		result, readRows, totalRows, err := queryView(ctx, db, view, limit, offset)
-		if err != nil {
-			return nil, err
-		}
-		if len(result) > 0 {
-			cards = append(cards, result...)
-		}
-		if len(cards) == limit {
-			break
-		}
-		offset = offset + readRows
-		if totalRows <= offset {
-			break
-		}
-	}
-	return cards, nil
-}
-
-func queryView(ctx context.Context, db querier, view string, limit, offset int) (cards []*cardSchedule, readRows, totalRows int, err error) {
-	defer profile("queryView: " + view)()
-	log.Debugf("Trying to fetch %d (%d) %s cards\n", limit, offset, view)
-	rows, err := db.Query(context.TODO(), "index", view, map[string]interface{}{
-		"limit": limit + limitPadding,
-		"skip":  offset,
-		"sort":  map[string]string{"due": "desc", "created": "asc"},
-	})
-	if err != nil {
-		return nil, 0, 0, errors.Wrap(err, "query failed")
-	}
-	defer func() { _ = rows.Close() }()
-	cards = make([]*cardSchedule, 0, limit)
-	var count int
-	defer func() { _ = rows.Close() }()
-	for rows.Next() {
-		count++
-		card := &cardSchedule{}
-		if e := rows.ScanValue(card); e != nil {
-			return nil, count, 0, errors.Wrap(e, "ScanValue")
-		}
-		if card.BuriedUntil.After(fb.Due(now())) {
-			continue
-		}
-		var key []string
-		if e := rows.ScanKey(&key); e != nil {
-			return nil, count, 0, errors.Wrap(e, "ScanKey")
-		}
-		if key[0] != "" {
-			due, e := fb.ParseDue(key[0])
-			if e != nil {
-				return nil, count, 0, errors.Wrap(e, "ParseDue")
-			}
-			card.Due = due
-		}
-		card.ID = rows.ID()
-		cards = append(cards, card)
-		if len(cards) == limit {
-			log.Debugf("Got %d cards, early exiting", len(cards))
-			return cards, count, 0, nil
-		}
-	}
-	return cards, count, int(rows.TotalRows()), nil
-}
-
 // cardPriority returns a number 0 or greater, as a priority to be used in
 // determining card study order.
-func cardPriority(due fb.Due, interval fb.Interval, now time.Time) float64 {
+func cardPriority(clock studyClock, due fb.Due, interval fb.Interval, now time.Time) float64 {
 	if due.IsZero() || interval == 0 {
 		return newPriority
 	}
-	// Remove the timezone
-	_, offset := now.Zone()
-	utc := now.UTC().Add(time.Duration(offset) * time.Second)
-
-	return float64(math.Pow(1+float64(utc.Sub(time.Time(due)))/float64(time.Duration(interval)), 3))
+	return float64(math.Pow(1+float64(now.Sub(clock.time(due)))/float64(time.Duration(interval)), 3))
 }
 
 var rnd = rand.New(rand.NewSource(time.Now().UnixNano()))
 
-func selectWeightedCard(cards []*cardSchedule) string {
+func selectWeightedCard(rnd *rand.Rand, clock studyClock, cards []*cardSchedule) string {
 	switch len(cards) {
 	case 0:
 		return ""
@@ -256,7 +234,7 @@ func selectWeightedCard(cards []*cardSchedule) string {
 	var weights float64
 	priorities := make([]float64, len(cards))
 	for i, card := range cards {
-		priority := cardPriority(card.Due, card.Interval, now())
+		priority := cardPriority(clock, card.Due, card.Interval, now())
 		priorities[i] = priority
 		weights += priority
 	}
@@ -283,9 +261,12 @@ func (r *Repo) GetCardToStudy(ctx context.Context) (flashback.CardView, error) {
 	if err == nil && card == nil {
 		return done.GetCard(), nil
 	}
+	if card.cram {
+		return card, nil
+	}
 	go func() {
 		// Bury related cards
-		if err := r.BuryRelatedCards(ctx, card.Card); err != nil {
+		if err := r.BuryRelatedCards(ctx, card); err != nil {
 			log.Printf("Failed to bury cards: %s\n", err)
 		}
 	}()
@@ -299,16 +280,58 @@ func (r *Repo) getCardToStudy(ctx context.Context) (*Card, error) {
 	if err != nil {
 		return nil, err
 	}
-	card, err := getCardToStudy(ctx, udb)
+	if cardID, mode, ok := r.sessionCard(); ok {
+		card := &fb.Card{}
+		if err := getDoc(ctx, udb, cardID, card); err != nil {
+			return nil, errors.Wrap(err, "fetch session card")
+		}
+		c, err := r.loadCard(ctx, card)
+		if err != nil {
+			return nil, err
+		}
+		c.cram = mode == StudyCram
+		return c, nil
+	}
+	if err := r.migrateUserDB(ctx, udb); err != nil {
+		return nil, err
+	}
+	limits, err := r.studyLimits(ctx, udb)
+	if err != nil {
+		return nil, err
+	}
+	allow := limits.allow
+	scope := r.StudyScope()
+	if !scope.IsZero() {
+		inScope := r.scopeFilter(ctx, scope)
+		allow = func(card *cardSchedule, isNew bool) (bool, error) {
+			if ok, err := inScope(card); err != nil || !ok {
+				return false, err
+			}
+			return limits.allow(card, isNew)
+		}
+	}
+	clock := limits.settings.clock()
+	card, err := getCardToStudy(ctx, udb, clock, allow, r.pick(limits.settings.selection(scope), clock))
 	if err != nil || card == nil {
 		return nil, err
 	}
+	return r.loadCard(ctx, card)
+}
+
+// loadCard wraps card, along with its note, model and settings.
+func (r *Repo) loadCard(ctx context.Context, card *fb.Card) (*Card, error) {
 	c := &Card{
 		Card:   card,
 		appURL: r.appURL,
 		repo:   r,
 	}
-	return c, c.fetch(ctx, r.local)
+	if err := c.fetch(ctx, r.local); err != nil {
+		return nil, err
+	}
+	if err := r.cardSettings(ctx, c); err != nil {
+		return nil, err
+	}
+	return c, nil
 }
 
 func (c *Card) fetch(ctx context.Context, client kivikClient) error {
@@ -351,21 +374,32 @@ type cardSchedule struct {
 	Interval    fb.Interval `json:"interval"`
 	Due         fb.Due      `json:"due"`
 	BuriedUntil fb.Due      `json:"buriedUntil"`
+	Suspended   bool        `json:"suspended"`
+	Available   fb.Due      `json:"available"`
 }
 
-func getCardToStudy(ctx context.Context, db queryGetter) (*fb.Card, error) {
+// getCardToStudy selects a card to study, from among the first new cards
+// ready for study and the first review cards in order of due date, using
+// pick. If allow is non-nil, only those cards for which it returns true are
+// considered.
+func getCardToStudy(ctx context.Context, db findGetter, clock studyClock, allow func(card *cardSchedule, isNew bool) (bool, error), pick func(newCards, oldCards []*cardSchedule) string) (*fb.Card, error) {
 	defer profile("getCardToStudy")()
 	var newCards, oldCards []*cardSchedule
 	var newErr, oldErr error
 	var wg sync.WaitGroup
+	var allowNew, allowOld func(*cardSchedule) (bool, error)
+	if allow != nil {
+		allowNew = func(card *cardSchedule) (bool, error) { return allow(card, true) }
+		allowOld = func(card *cardSchedule) (bool, error) { return allow(card, false) }
+	}
 	wg.Add(2)
 	go func() {
-		newCards, newErr = getCardsFromView(ctx, db, "newCards", newBatchSize)
+		newCards, newErr = fetchQueue(ctx, db, clock, fb.QueueNew, newBatchSize, true, allowNew)
 		newErr = errors.Wrap(newErr, "newCards")
 		wg.Done()
 	}()
 	go func() {
-		oldCards, oldErr = getCardsFromView(ctx, db, "oldCards", oldBatchSize)
+		oldCards, oldErr = fetchQueue(ctx, db, clock, fb.QueueReview, oldBatchSize, false, allowOld)
 		oldErr = errors.Wrap(oldErr, "oldCards")
 		wg.Done()
 	}()
@@ -373,7 +407,7 @@ func getCardToStudy(ctx context.Context, db queryGetter) (*fb.Card, error) {
 	if err := firstErr(newErr, oldErr); err != nil {
 		return nil, err
 	}
-	cardID := selectWeightedCard(append(newCards, oldCards...))
+	cardID := pick(newCards, oldCards)
 	if cardID == "" {
 		return nil, nil
 	}
@@ -452,3 +486,57 @@ func (c *Card) FieldValue(fieldName string) *fb.FieldValue {
 	}
 	return nil
 }
+
+// updateCards fetches the cards with the given IDs from the user's database,
+// and passes each to update. Cards for which update returns true are marked
+// as modified, and saved.
+func (r *Repo) updateCards(ctx context.Context, cardIDs []string, update func(*fb.Card) bool) error {
+	udb, err := r.userDB(ctx)
+	if err != nil {
+		return err
+	}
+	cards := make([]*fb.Card, 0, len(cardIDs))
+	for _, id := range cardIDs {
+		card := &fb.Card{}
+		if err := getDoc(ctx, udb, id, card); err != nil {
+			return errors.Wrap(err, id)
+		}
+		if !update(card) {
+			continue
+		}
+		card.Modified = now().UTC()
+		cards = append(cards, card)
+	}
+	if len(cards) == 0 {
+		return nil
+	}
+	return errors.Wrap(updateDocs(ctx, udb, cards), "update cards")
+}
+
+// findCards returns all cards in db matching the Mango selector. The
+// "type": "card" condition is added automatically.
+func findCards(ctx context.Context, db finder, selector map[string]interface{}) ([]*fb.Card, error) {
+	sel := map[string]interface{}{"type": "card"}
+	for k, v := range selector {
+		sel[k] = v
+	}
+	rows, err := db.Find(ctx, map[string]interface{}{
+		"selector": sel,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "find cards")
+	}
+	defer func() { _ = rows.Close() }()
+	cards := make([]*fb.Card, 0)
+	for rows.Next() {
+		card := &fb.Card{}
+		if err := rows.ScanDoc(card); err != nil {
+			return nil, errors.Wrapf(err, "scan card %s", rows.ID())
+		}
+		cards = append(cards, card)
+	}
+	if err := rows.Err(); err != nil && err != io.EOF {
+		return nil, err
+	}
+	return cards, nil
+}
@@ -10,9 +10,10 @@ import (
 	"testing"
 	"time"
 
-	fb "github.com/FlashbackSRS/flashback-model"
+	"github.com/FlashbackSRS/flashback/fb"
 	"github.com/flimzy/diff"
 	"github.com/flimzy/kivik"
+	kerrors "github.com/flimzy/kivik/errors"
 )
 
 func init() {
@@ -22,184 +23,6 @@ func init() {
 	}
 }
 
-type mockQuerier struct {
-	rows map[string]*mockRows
-	err  error
-}
-
-var _ querier = &mockQuerier{}
-
-func (db *mockQuerier) Query(ctx context.Context, ddoc, view string, options ...kivik.Options) (kivikRows, error) {
-	if db.err != nil {
-		return nil, db.err
-	}
-	limit, _ := options[0]["limit"].(int)
-	offset, _ := options[0]["skip"].(int)
-	rows, ok := db.rows[view]
-	if !ok {
-		return &mockRows{}, nil
-	}
-	rows.limit = limit + offset
-	rows.i = offset
-	return rows, nil
-}
-
-var storedCards = []string{
-	`{"type": "card", "_id": "card-krsxg5baij2w4zdmmu.VGVzdCBOb3Rl.0", "_rev": "1-6e1b6fb5352429cf3013eab5d692aac8", "created": "2016-07-31T15:08:24.730156517Z", "modified": "2016-07-15T15:07:24.730156517Z", "model": "theme-VGVzdCBUaGVtZQ/0", "buriedUntil": "2099-01-01"}`,
-	`{"type": "card", "_id": "card-krsxg5baij2w4zdmmu.VGVzdCBOb3Rl.1", "_rev": "1-6e1b6fb5352429cf3013eab5d692aac8", "created": "2016-07-31T15:08:24.730156517Z", "modified": "2016-07-31T15:08:24.730156517Z", "model": "theme-VGVzdCBUaGVtZQ/0", "due": "2019-01-01"}`,
-	`{"type": "card", "_id": "card-krsxg5baij2w4zdmmu.VGVzdCBOb3Rl.2", "_rev": "1-6e1b6fb5352429cf3013eab5d692aac8", "created": "2016-07-31T15:08:24.730156517Z", "modified": "2016-07-31T15:08:24.730156517Z", "model": "theme-VGVzdCBUaGVtZQ/0"}`,
-}
-
-var storedCardValues = []string{
-	`{"buriedUntil": "2099-01-01"}`,
-	`{}`,
-	`{}`,
-}
-
-var storedCardKeys = []string{
-	`["2017-05-22 02:00:00","2016-05-04T19:04:23.000000126Z"]`,
-	`["2019-01-01","2015-03-17T04:33:51.00000029Z"]`,
-	`[null,"2017-05-28T18:32:44.000000978Z"]`,
-}
-
-var expectedCards = []map[string]interface{}{
-	{
-		"type":     "card",
-		"_id":      "card-krsxg5baij2w4zdmmu.VGVzdCBOb3Rl.1",
-		"_rev":     "1-6e1b6fb5352429cf3013eab5d692aac8",
-		"created":  "2016-07-31T15:08:24.730156517Z",
-		"modified": "2016-07-31T15:08:24.730156517Z",
-		"model":    "theme-VGVzdCBUaGVtZQ/0",
-		"due":      "2019-01-01",
-	},
-	{
-		"type":     "card",
-		"_id":      "card-krsxg5baij2w4zdmmu.VGVzdCBOb3Rl.2",
-		"_rev":     "1-6e1b6fb5352429cf3013eab5d692aac8",
-		"created":  "2016-07-31T15:08:24.730156517Z",
-		"modified": "2016-07-31T15:08:24.730156517Z",
-		"model":    "theme-VGVzdCBUaGVtZQ/0",
-	},
-}
-
-func TestGetCardsFromView(t *testing.T) {
-	type gcfvTest struct {
-		name     string
-		db       querier
-		view     string
-		limit    int
-		expected interface{}
-		err      string
-	}
-	tests := []gcfvTest{
-		{
-			name:  "query error",
-			db:    &mockQuerier{err: errors.New("query failed")},
-			limit: 1,
-			err:   "query failed: query failed",
-		},
-		{
-			name: "invalid value JSON",
-			db: &mockQuerier{rows: map[string]*mockRows{
-				"test": &mockRows{rows: []string{"foo"}, values: []string{"foo"}},
-			}},
-			limit: 1,
-			view:  "test",
-			err:   "ScanValue: invalid character 'o' in literal false (expecting 'a')",
-		},
-		{
-			name: "invalid key JSON",
-			db: &mockQuerier{rows: map[string]*mockRows{
-				"test": &mockRows{rows: []string{"foo"}, values: []string{"{}"}, keys: []string{"foo"}},
-			}},
-			limit: 1,
-			view:  "test",
-			err:   "ScanKey: invalid character 'o' in literal false (expecting 'a')",
-		},
-		{
-			name: "invalid due value",
-			db: &mockQuerier{rows: map[string]*mockRows{
-				"test": &mockRows{rows: []string{"foo"}, values: []string{"{}"}, keys: []string{`["foo"]`}},
-			}},
-			limit: 1,
-			view:  "test",
-			err:   "ParseDue: Unrecognized input: foo",
-		},
-		{
-			name:     "no results",
-			db:       &mockQuerier{rows: map[string]*mockRows{}},
-			limit:    1,
-			expected: []*cardSchedule{},
-		},
-		{
-			name: "successful fetch",
-			db: &mockQuerier{rows: map[string]*mockRows{
-				"test": &mockRows{rows: storedCards, values: storedCardValues, keys: storedCardKeys},
-			}},
-			limit: 10,
-			view:  "test",
-			expected: []*cardSchedule{
-				{ID: "card-krsxg5baij2w4zdmmu.VGVzdCBOb3Rl.1", Due: parseDue(t, "2019-01-01")},
-				{ID: "card-krsxg5baij2w4zdmmu.VGVzdCBOb3Rl.2"},
-			},
-		},
-		{
-			name:  "limit 0",
-			db:    &mockQuerier{},
-			limit: 0,
-			err:   "invalid limit",
-		},
-		{
-			name: "limit reached",
-			db: &mockQuerier{rows: map[string]*mockRows{
-				"test": &mockRows{rows: storedCards, values: storedCardValues, keys: storedCardKeys},
-			}},
-			limit: 1,
-			view:  "test",
-			expected: []*cardSchedule{
-				{ID: "card-krsxg5baij2w4zdmmu.VGVzdCBOb3Rl.1", Due: parseDue(t, "2019-01-01")},
-			},
-		},
-		{
-			name: "aggregate necessary",
-			db: &mockQuerier{rows: map[string]*mockRows{
-				"test": func() *mockRows {
-					rows := make([]string, 150)
-					values := make([]string, 150)
-					keys := make([]string, 150)
-					for i := 0; i < 150; i++ {
-						rows[i] = storedCards[0]
-						values[i] = storedCardValues[0]
-						keys[i] = storedCardKeys[0]
-					}
-					rows = append(rows, storedCards...)
-					values = append(values, storedCardValues...)
-					keys = append(keys, storedCardKeys...)
-					return &mockRows{rows: rows, values: values, keys: keys}
-				}(),
-			}},
-			limit: 5,
-			view:  "test",
-			expected: []*cardSchedule{
-				{ID: "card-krsxg5baij2w4zdmmu.VGVzdCBOb3Rl.1", Due: parseDue(t, "2019-01-01")},
-				{ID: "card-krsxg5baij2w4zdmmu.VGVzdCBOb3Rl.2"},
-			},
-		},
-	}
-	for _, test := range tests {
-		t.Run(test.name, func(t *testing.T) {
-			cards, err := getCardsFromView(context.Background(), test.db, test.view, test.limit)
-			checkErr(t, test.err, err)
-			if err != nil {
-				return
-			}
-			if d := diff.Interface(test.expected, cards); d != nil {
-				t.Error(d)
-			}
-		})
-	}
-}
-
 func TestCardPriority(t *testing.T) {
 	type cpTest struct {
 		due      fb.Due
@@ -239,7 +62,7 @@ func TestCardPriority(t *testing.T) {
 			expected: 150084.109375,
 		},
 		{
-			due:      parseDue(t, "2017-01-24 11:16:59"),
+			due:      parseDue(t, "2017-01-24 10:16:59"),
 			interval: 10 * fb.Minute,
 			expected: 132.520996,
 			now:      parseTime(t, "2017-01-24T11:57:58+01:00"),
@@ -251,7 +74,7 @@ func TestCardPriority(t *testing.T) {
 			if nowTime.IsZero() {
 				nowTime = parseTime(t, "2017-01-01T00:00:00Z")
 			}
-			prio := cardPriority(test.due, test.interval, nowTime)
+			prio := cardPriority(studyClock{}, test.due, test.interval, nowTime)
 			if !floatCompare(float64(prio), test.expected) {
 				t.Errorf("Unexpected result %f", prio)
 			}
@@ -301,9 +124,11 @@ func TestSelectWeightedCard(t *testing.T) {
 			expected: "c",
 		},
 	}
+	defer func(orig *rand.Rand) { rnd = orig }(rnd)
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
-			result := selectWeightedCard(test.cards)
+			rnd = rand.New(rand.NewSource(1))
+			result := selectWeightedCard(rnd, studyClock{}, test.cards)
 			if test.expected != result {
 				t.Errorf("Unexpected result: %v", result)
 			}
@@ -311,162 +136,136 @@ func TestSelectWeightedCard(t *testing.T) {
 	}
 }
 
-type gctsClient struct {
-	kivikClient
-	db kivikDB
-}
-
-func (c *gctsClient) DB(_ context.Context, _ string, _ ...kivik.Options) (kivikDB, error) {
-	return c.db, nil
-}
-
-type gctsDB struct {
-	kivikDB
-	q     *mockQuerier
-	card  string
-	note  string
-	theme string
-}
-
-func (db *gctsDB) Query(ctx context.Context, ddoc, view string, options ...kivik.Options) (kivikRows, error) {
-	return db.q.Query(ctx, ddoc, view, options...)
-}
-
-func (db *gctsDB) Get(_ context.Context, id string, _ ...kivik.Options) (kivikRow, error) {
-	if strings.HasPrefix(id, "card-") {
-		return mockRow(db.card), nil
-	}
-	if strings.HasPrefix(id, "note-") {
-		return mockRow(db.note), nil
-	}
-	return mockRow(db.theme), nil
-}
-
 func TestRepoGetCardToStudy(t *testing.T) {
-	type rgctsTest struct {
-		name     string
-		repo     *Repo
-		expected interface{}
-		err      string
-	}
-	tests := []rgctsTest{
-		{
-			name: "not logged in",
-			repo: &Repo{},
-			err:  "not logged in",
-		},
-		{
-			name: "logged in",
-			repo: &Repo{user: "mjxwe", local: func() kivikClient {
-				c := testClient(t)
-				if e := c.CreateDB(context.Background(), "user-mjxwe"); e != nil {
-					t.Fatal(e)
-				}
-				return c
-			}()},
-			err: func() string {
-				if env == "js" {
-					return "newCards: query failed: not_found: missing"
-				}
-				return "newCards: query failed: kivik: not yet implemented in memory driver"
-			}(),
-		},
-		{
-			name: "no cards",
-			repo: &Repo{user: "mjxwe", local: &gctsClient{
-				db: &gctsDB{
-					q: &mockQuerier{rows: map[string]*mockRows{
-						"newCards": &mockRows{},
-						"oldCards": &mockRows{},
-					}},
-				}},
-			},
-		},
-		{
-			name: "success",
-			repo: &Repo{
-				user: "bob",
-				local: &gctsClient{
-					db: &gctsDB{
-						q: &mockQuerier{rows: map[string]*mockRows{"newCards": &mockRows{
-							rows:   storedCards[1:2],
-							values: storedCardValues[1:2],
-							keys:   storedCardKeys[1:2],
-						}}},
-						card:  storedCards[1],
-						note:  `{"_id":"note-Zm9v", "theme":"theme-Zm9v", "created":"2017-01-01T01:01:01Z", "modified":"2017-01-01T01:01:01Z"}`,
-						theme: `{"_id":"theme-Zm9v", "created":"2017-01-01T01:01:01Z", "modified":"2017-01-01T01:01:01Z", "_attachments":{}, "files":[], "modelSequence":1, "models": [{"id":0, "files":[], "modelType":"foo"}]}`,
-					},
-				},
+	ctx := context.Background()
+	t.Run("not logged in", func(t *testing.T) {
+		_, err := (&Repo{}).getCardToStudy(ctx)
+		checkErr(t, "not logged in", err)
+	})
+	t.Run("no cards", func(t *testing.T) {
+		client := testClient(t)
+		saveCards(t, client)
+		result, err := (&Repo{user: "mjxwe", local: client}).getCardToStudy(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result != nil {
+			t.Errorf("Expected no card, got %s", result.ID)
+		}
+	})
+	t.Run("success", func(t *testing.T) {
+		// The memory driver doesn't store attachments, so themes are mocked.
+		client := &bundleClient{
+			kivikClient: testClient(t),
+			bundle: &gctsDB{
+				note:  `{"_id":"note-Zm9v", "theme":"theme-Zm9v", "created":"2017-01-01T01:01:01Z", "modified":"2017-01-01T01:01:01Z"}`,
+				theme: `{"_id":"theme-Zm9v", "created":"2017-01-01T01:01:01Z", "modified":"2017-01-01T01:01:01Z", "_attachments":{}, "files":[], "modelSequence":1, "models": [{"id":0, "files":[], "modelType":"foo"}]}`,
 			},
-			expected: map[string]interface{}{"id": "card-krsxg5baij2w4zdmmu.VGVzdCBOb3Rl.1", "model": 0},
-		},
-	}
-	for _, test := range tests {
-		t.Run(test.name, func(t *testing.T) {
-			result, err := test.repo.getCardToStudy(context.Background())
-			checkErr(t, test.err, err)
-			if err != nil {
-				return
-			}
-			if d := diff.AsJSON(test.expected, result); d != nil {
-				t.Error(d)
-			}
-		})
-	}
+		}
+		saveCards(t, client, &fb.Card{ID: "card-krsxg5baij2w4zdmmu.VGVzdCBOb3Rl.1", ModelID: "theme-VGVzdCBUaGVtZQ/0"})
+		result, err := (&Repo{user: "mjxwe", local: client}).getCardToStudy(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := map[string]interface{}{"id": "card-krsxg5baij2w4zdmmu.VGVzdCBOb3Rl.1", "model": 0}
+		if d := diff.AsJSON(expected, result); d != nil {
+			t.Error(d)
+		}
+	})
 }
 
 func TestGetCardToStudy(t *testing.T) {
 	type gctsTest struct {
 		name     string
-		db       queryGetter
-		expected interface{}
+		db       findGetter
+		expected string
 		err      string
 	}
 	tests := []gctsTest{
 		{
 			name: "no cards",
-			db:   &mockQueryGetter{mockQuerier: &mockQuerier{rows: map[string]*mockRows{}}},
+			db:   saveCards(t, testClient(t)),
 		},
 		{
-			name: "new query failure",
-			db: &mockQueryGetter{mockQuerier: &mockQuerier{rows: map[string]*mockRows{
-				"newCards": &mockRows{rows: []string{"invalid json"}, values: []string{"invalid json"}},
-			}}},
-			err: `newCards: ScanValue: invalid character 'i' looking for beginning of value`,
+			name: "find failure",
+			db:   &mockFindGetter{err: errors.New("failed")},
+			err:  "newCards: find failed: failed",
 		},
 		{
-			name: "old query failure",
-			db: &mockQueryGetter{mockQuerier: &mockQuerier{rows: map[string]*mockRows{
-				"oldCards": &mockRows{rows: []string{"invalid json"}, values: []string{"invalid json"}},
-			}}},
-			err: `oldCards: ScanValue: invalid character 'i' looking for beginning of value`,
+			name: "buried and suspended",
+			db: saveCards(t, testClient(t),
+				&fb.Card{ID: "card-foo.bar.0", BuriedUntil: parseDue(t, "2099-01-01")},
+				&fb.Card{ID: "card-foo.bar.1", Due: parseDue(t, "2016-12-01"), Suspended: true},
+			),
 		},
 		{
 			name: "one new card",
-			db: &mockQueryGetter{
-				mockQuerier: &mockQuerier{rows: map[string]*mockRows{
-					"newCards": &mockRows{rows: storedCards[1:2], values: storedCardValues[1:2], keys: storedCardKeys[1:2]},
-				}},
-				row: mockRow(storedCards[1]),
-			},
-			expected: expectedCards[0],
+			db: saveCards(t, testClient(t),
+				&fb.Card{ID: "card-foo.bar.0", BuriedUntil: parseDue(t, "2099-01-01")},
+				&fb.Card{ID: "card-foo.bar.2"},
+			),
+			expected: "card-foo.bar.2",
+		},
+		{
+			name: "one review card",
+			db: saveCards(t, testClient(t),
+				&fb.Card{ID: "card-foo.bar.1", Due: parseDue(t, "2019-01-01"), Interval: fb.Day},
+			),
+			expected: "card-foo.bar.1",
 		},
 	}
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
-			result, err := getCardToStudy(context.Background(), test.db)
+			result, err := getCardToStudy(context.Background(), test.db, studyClock{}, nil, (&Repo{}).pick(SelectWeighted, studyClock{}))
 			checkErr(t, test.err, err)
 			if err != nil {
 				return
 			}
-			if d := diff.AsJSON(test.expected, result); d != nil {
-				t.Error(d)
+			var id string
+			if result != nil {
+				id = result.ID
+			}
+			if id != test.expected {
+				t.Errorf("Unexpected card: %q", id)
 			}
 		})
 	}
 }
 
+type gctsDB struct {
+	kivikDB
+	card  string
+	note  string
+	theme string
+}
+
+func (db *gctsDB) Get(_ context.Context, id string, _ ...kivik.Options) (kivikRow, error) {
+	if strings.HasPrefix(id, "card-") {
+		return mockRow(db.card), nil
+	}
+	if strings.HasPrefix(id, "note-") {
+		return mockRow(db.note), nil
+	}
+	if strings.HasPrefix(id, "theme-") {
+		return mockRow(db.theme), nil
+	}
+	return nil, kerrors.Status(kivik.StatusNotFound, "missing")
+}
+
+// bundleClient serves bundle databases from bundle, and all others from the
+// embedded client.
+type bundleClient struct {
+	kivikClient
+	bundle kivikDB
+}
+
+func (c *bundleClient) DB(ctx context.Context, dbName string, options ...kivik.Options) (kivikDB, error) {
+	if strings.HasPrefix(dbName, "bundle-") {
+		return c.bundle, nil
+	}
+	return c.kivikClient.DB(ctx, dbName, options...)
+}
+
 type cfClient struct {
 	kivikClient
 	db    kivikDB
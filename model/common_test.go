@@ -5,7 +5,7 @@ import (
 	"testing"
 	"time"
 
-	fb "github.com/FlashbackSRS/flashback-model"
+	"github.com/FlashbackSRS/flashback/fb"
 )
 
 func checkErr(t *testing.T, expected interface{}, err error) {
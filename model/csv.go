@@ -0,0 +1,605 @@
+package model
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/flimzy/kivik"
+	"github.com/pkg/errors"
+
+	"github.com/FlashbackSRS/flashback/fb"
+)
+
+// CSVTagsColumn may be given in CSVImportOptions.Columns for the column which
+// holds the tags of each note.
+const CSVTagsColumn = "$tags"
+
+// DuplicatePolicy determines how ImportCSV handles a row whose first field
+// matches that of a note of the same model, which is how Anki identifies
+// duplicates.
+type DuplicatePolicy int
+
+// Duplicate policies
+const (
+	// DuplicateSkip skips the row.
+	DuplicateSkip DuplicatePolicy = iota
+	// DuplicateUpdate updates the existing note with the fields, and the tags,
+	// if any, of the row.
+	DuplicateUpdate
+	// DuplicateAllow imports the row as a new note.
+	DuplicateAllow
+)
+
+// CSVImportOptions configures ImportCSV.
+type CSVImportOptions struct {
+	// BundleID is the bundle to which the notes are added.
+	BundleID string
+	// ThemeID and ModelID identify the model of the notes, which must belong
+	// to the bundle.
+	ThemeID string
+	ModelID uint32
+	// DeckID is the deck of the bundle to which the new cards are added. It
+	// is optional.
+	DeckID string
+	// Delimiter separates the columns. If it is 0, it is a tab if the first
+	// line contains one, or otherwise a comma.
+	Delimiter rune
+	// Header is true if the first row holds the names of the columns.
+	Header bool
+	// Columns maps each column, in order, to the name of the field it fills.
+	// Columns mapped to "" are ignored, and the column mapped to
+	// CSVTagsColumn holds the note's tags, separated by spaces. If Columns is
+	// nil, the names in the header row are matched with the field names, and
+	// a column named "tags" holds the tags; without a header row, the columns
+	// fill the fields in order.
+	Columns []string
+	// Duplicates is the policy for rows which duplicate an existing note.
+	Duplicates DuplicatePolicy
+}
+
+// RowError is the error which prevented a row from being imported. Rows are
+// numbered from 1, including the header row.
+type RowError struct {
+	Row int
+	Err error
+}
+
+func (e *RowError) Error() string {
+	return fmt.Sprintf("row %d: %s", e.Row, e.Err)
+}
+
+// CSVImportResult reports the outcome of ImportCSV for each row.
+type CSVImportResult struct {
+	// Added, Updated and Skipped count the rows which created a note, which
+	// updated a duplicate note, and which were skipped as duplicates.
+	Added   int
+	Updated int
+	Skipped int
+	// Errors holds an error for each row which couldn't be imported, in
+	// order.
+	Errors []*RowError
+}
+
+// csvNote is a note to be saved, with the rows from which it was imported.
+type csvNote struct {
+	note *fb.Note
+	rows []int
+}
+
+// csvImport holds the state of a CSV import.
+type csvImport struct {
+	opts     CSVImportOptions
+	model    *fb.Model
+	columns  []int
+	imported time.Time
+	// notes are the notes to be saved, and duplicates the notes of the
+	// model, by the text of their first field.
+	notes      []*csvNote
+	duplicates map[string]*csvNote
+	// outcomes records whether each row was added, updated or skipped, with
+	// the count to which it contributes.
+	outcomes map[int]*int
+	result   *CSVImportResult
+}
+
+// Column mappings other than a field index.
+const (
+	csvIgnoredColumn = -1
+	csvTags          = -2
+)
+
+// ImportCSV imports a note, with its cards, for each row of delimited text,
+// such as a spreadsheet exported as CSV or TSV. Values are used as the HTML
+// of the fields. An error is returned only if nothing can be imported; rows
+// which can't be imported are reported in the result.
+func (r *Repo) ImportCSV(ctx context.Context, f io.Reader, opts CSVImportOptions) (*CSVImportResult, error) {
+	udb, err := r.userDB(ctx)
+	if err != nil {
+		return nil, err
+	}
+	bdb, err := r.newDB(ctx, opts.BundleID)
+	if err != nil {
+		return nil, err
+	}
+	if e := getDoc(ctx, bdb, opts.BundleID, &fb.Bundle{}); e != nil {
+		return nil, errors.Wrap(e, "fetch bundle")
+	}
+	model, err := fetchModel(ctx, bdb, opts.ThemeID, opts.ModelID)
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+	imp := &csvImport{
+		opts:       opts,
+		model:      model,
+		imported:   now().UTC(),
+		duplicates: make(map[string]*csvNote),
+		outcomes:   make(map[int]*int),
+		result:     &CSVImportResult{},
+	}
+	if err := imp.readRows(csvReader(data, opts.Delimiter)); err != nil {
+		return nil, err
+	}
+	if len(imp.notes) == 0 {
+		return imp.finish(), nil
+	}
+	if opts.Duplicates != DuplicateAllow {
+		if err := imp.findDuplicates(ctx, bdb); err != nil {
+			return nil, err
+		}
+	}
+	cards, err := imp.saveNotes(ctx, bdb)
+	if err != nil {
+		return nil, err
+	}
+	added, err := imp.saveCards(ctx, udb, cards)
+	if err != nil {
+		return nil, err
+	}
+	if opts.DeckID != "" && len(added) > 0 {
+		if err := addToDeck(ctx, bdb, opts.DeckID, added); err != nil {
+			return imp.finish(), err
+		}
+	}
+	return imp.finish(), nil
+}
+
+// fetchModel returns the identified model, from its theme.
+func fetchModel(ctx context.Context, db getter, themeID string, modelID uint32) (*fb.Model, error) {
+	theme := &fb.Theme{}
+	if err := getDoc(ctx, db, themeID, theme); err != nil {
+		return nil, errors.Wrap(err, "fetch theme")
+	}
+	for _, model := range theme.Models {
+		if model.ID == modelID {
+			return model, nil
+		}
+	}
+	return nil, errors.Errorf("model %d not found in theme %s", modelID, themeID)
+}
+
+// csvReader returns a reader for the delimited text in data, detecting the
+// delimiter if it is 0.
+func csvReader(data []byte, delimiter rune) *csv.Reader {
+	if delimiter == 0 {
+		delimiter = ','
+		firstLine := data
+		if i := bytes.IndexByte(data, '\n'); i >= 0 {
+			firstLine = data[:i]
+		}
+		if bytes.IndexByte(firstLine, '\t') >= 0 {
+			delimiter = '\t'
+		}
+	}
+	r := csv.NewReader(bytes.NewReader(data))
+	r.Comma = delimiter
+	r.FieldsPerRecord = -1
+	r.LazyQuotes = true
+	return r
+}
+
+// readRows reads the header row, if any, and converts the other rows to
+// notes, skipping or updating duplicates within the file.
+func (imp *csvImport) readRows(r *csv.Reader) error {
+	row := 0
+	var header []string
+	if imp.opts.Header {
+		row++
+		var err error
+		if header, err = r.Read(); err != nil && err != io.EOF {
+			return errors.Wrap(err, "read header")
+		}
+	}
+	var err error
+	if imp.columns, err = csvColumns(imp.model, header, imp.opts.Columns); err != nil {
+		return err
+	}
+	for {
+		row++
+		record, err := r.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			imp.rowError(row, err)
+			continue
+		}
+		if err := imp.convertRow(row, record); err != nil {
+			imp.rowError(row, err)
+		}
+	}
+}
+
+// csvColumns returns the field index, or other mapping, of each column.
+func csvColumns(model *fb.Model, header, names []string) ([]int, error) {
+	if len(model.Fields) == 0 {
+		return nil, errors.New("model has no fields")
+	}
+	fieldIndex := func(name string) int {
+		for i, field := range model.Fields {
+			if field.Name == name {
+				return i
+			}
+		}
+		for i, field := range model.Fields {
+			if strings.EqualFold(field.Name, name) {
+				return i
+			}
+		}
+		return csvIgnoredColumn
+	}
+	columns := make([]int, 0, len(model.Fields))
+	switch {
+	case names != nil:
+		for _, name := range names {
+			switch i := fieldIndex(name); {
+			case name == "":
+				columns = append(columns, csvIgnoredColumn)
+			case name == CSVTagsColumn:
+				columns = append(columns, csvTags)
+			case i == csvIgnoredColumn:
+				return nil, errors.Errorf("no such field: %s", name)
+			default:
+				columns = append(columns, i)
+			}
+		}
+	case header != nil:
+		for _, name := range header {
+			name = strings.TrimSpace(name)
+			i := fieldIndex(name)
+			if i == csvIgnoredColumn && strings.EqualFold(name, "tags") {
+				i = csvTags
+			}
+			columns = append(columns, i)
+		}
+	default:
+		for i := range model.Fields {
+			columns = append(columns, i)
+		}
+	}
+	mapped := make(map[int]bool)
+	for _, i := range columns {
+		if mapped[i] && i != csvIgnoredColumn {
+			if i == csvTags {
+				return nil, errors.New("more than one tags column")
+			}
+			return nil, errors.Errorf("field %s is mapped to more than one column", model.Fields[i].Name)
+		}
+		mapped[i] = true
+	}
+	if !mapped[0] {
+		return nil, errors.Errorf("no column is mapped to the first field, %s", model.Fields[0].Name)
+	}
+	return columns, nil
+}
+
+func (imp *csvImport) rowError(row int, err error) {
+	imp.result.Errors = append(imp.result.Errors, &RowError{Row: row, Err: err})
+}
+
+// convertRow converts a row to a new note, or applies it to the note it
+// duplicates, according to the policy.
+func (imp *csvImport) convertRow(row int, record []string) error {
+	values := make(map[int]string)
+	var tags []string
+	hasTags := false
+	for col, i := range imp.columns {
+		value := ""
+		if col < len(record) {
+			value = record[col]
+		}
+		switch i {
+		case csvIgnoredColumn:
+		case csvTags:
+			hasTags = true
+			tags = strings.Fields(value)
+		default:
+			values[i] = value
+		}
+	}
+	key := stripHTML(values[0])
+	if key == "" {
+		return errors.Errorf("field %s is empty", imp.model.Fields[0].Name)
+	}
+	if dup, ok := imp.duplicates[key]; ok && imp.opts.Duplicates != DuplicateAllow {
+		if imp.opts.Duplicates == DuplicateSkip {
+			imp.outcomes[row] = &imp.result.Skipped
+			return nil
+		}
+		setNoteFields(dup.note, values, tags, hasTags)
+		dup.rows = append(dup.rows, row)
+		imp.outcomes[row] = &imp.result.Updated
+		return nil
+	}
+	note, err := fb.NewNote(imp.noteID(row), imp.model)
+	if err != nil {
+		return err
+	}
+	note.Created = imp.imported
+	note.Modified = imp.imported
+	for i := range imp.model.Fields {
+		note.GetFieldValue(i)
+	}
+	setNoteFields(note, values, tags, hasTags)
+	if _, err := imp.templateIDs(note); err != nil {
+		return err
+	}
+	n := &csvNote{note: note, rows: []int{row}}
+	imp.notes = append(imp.notes, n)
+	imp.duplicates[key] = n
+	imp.outcomes[row] = &imp.result.Added
+	return nil
+}
+
+// setNoteFields sets the text of the given fields, and the tags if hasTags
+// is true.
+func setNoteFields(note *fb.Note, values map[int]string, tags []string, hasTags bool) {
+	for i, value := range values {
+		if i < len(note.FieldValues) && note.FieldValues[i] != nil {
+			note.FieldValues[i].Text = value
+		}
+	}
+	if hasTags {
+		note.Tags = tags
+	}
+}
+
+// noteID returns the ID of the note imported from the row. IDs are unique to
+// each import, so that importing a file twice creates new notes, unless they
+// are detected as duplicates.
+func (imp *csvImport) noteID(row int) string {
+	sum := sha1.Sum([]byte(imp.opts.BundleID + ":csv:" + imp.imported.Format(time.RFC3339Nano) + ":" + strconv.Itoa(row)))
+	return fb.EncodeDocID("note", sum[:])
+}
+
+var clozeNumberRE = regexp.MustCompile(`{{c(\d+)::`)
+
+// templateIDs returns the templates for which the note has cards: all of the
+// model's templates, or for a cloze model, one for each cloze number.
+func (imp *csvImport) templateIDs(note *fb.Note) ([]uint32, error) {
+	ids := make([]uint32, 0)
+	if imp.model.Type != fb.AnkiClozeModel {
+		for i := range imp.model.Templates {
+			ids = append(ids, uint32(i))
+		}
+		if len(ids) == 0 {
+			return nil, errors.New("model has no templates")
+		}
+		return ids, nil
+	}
+	seen := make(map[uint32]bool)
+	for _, fv := range note.FieldValues {
+		for _, m := range clozeNumberRE.FindAllStringSubmatch(fv.Text, -1) {
+			n, err := strconv.Atoi(m[1])
+			if err != nil || n < 1 {
+				continue
+			}
+			if id := uint32(n - 1); !seen[id] {
+				seen[id] = true
+				ids = append(ids, id)
+			}
+		}
+	}
+	if len(ids) == 0 {
+		return nil, errors.New("no cloze deletions")
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids, nil
+}
+
+// findDuplicates applies the duplicate policy to the new notes which
+// duplicate an existing note of the model. Updated notes are saved with the
+// content of their attachments, which are fetched as stubs.
+func (imp *csvImport) findDuplicates(ctx context.Context, bdb exportDB) error {
+	existing, err := fetchNotes(ctx, bdb)
+	if err != nil {
+		return err
+	}
+	byKey := make(map[string]*fb.Note)
+	for _, note := range existing {
+		if note.ThemeID != imp.model.Theme.ID || note.ModelID != imp.model.ID || len(note.FieldValues) == 0 {
+			continue
+		}
+		key := stripHTML(note.FieldValues[0].Text)
+		if _, ok := byKey[key]; !ok {
+			byKey[key] = note
+		}
+	}
+	notes := make([]*csvNote, 0, len(imp.notes))
+	updated := make(map[string]*csvNote)
+	for _, n := range imp.notes {
+		dup, ok := byKey[stripHTML(n.note.FieldValues[0].Text)]
+		if !ok {
+			notes = append(notes, n)
+			continue
+		}
+		outcome := &imp.result.Skipped
+		if imp.opts.Duplicates == DuplicateUpdate {
+			outcome = &imp.result.Updated
+			u, ok := updated[dup.ID]
+			if !ok {
+				if err := dup.SetModel(imp.model); err != nil {
+					imp.failRows(n, errors.Wrapf(err, "note %s", dup.ID))
+					continue
+				}
+				if err := fetchAttachments(ctx, bdb, dup.ID, dup.Attachments); err != nil {
+					return err
+				}
+				u = &csvNote{note: dup}
+				updated[dup.ID] = u
+				notes = append(notes, u)
+			}
+			if imp.mapped(csvTags) {
+				dup.Tags = n.note.Tags
+			}
+			for i, fv := range n.note.FieldValues {
+				if i < len(dup.FieldValues) && fv != nil && imp.mapped(i) {
+					dup.FieldValues[i].Text = fv.Text
+				}
+			}
+			dup.Modified = imp.imported
+			u.rows = append(u.rows, n.rows...)
+		}
+		for _, row := range n.rows {
+			imp.outcomes[row] = outcome
+		}
+	}
+	imp.notes = notes
+	return nil
+}
+
+// mapped returns true if a column fills the field, or holds the tags.
+func (imp *csvImport) mapped(field int) bool {
+	for _, i := range imp.columns {
+		if i == field {
+			return true
+		}
+	}
+	return false
+}
+
+// failRows reports an error for each row of the note, which wasn't saved.
+func (imp *csvImport) failRows(n *csvNote, err error) {
+	for _, row := range n.rows {
+		delete(imp.outcomes, row)
+		imp.rowError(row, err)
+	}
+}
+
+// saveNotes saves the new and updated notes, and returns the cards of those
+// which were saved.
+func (imp *csvImport) saveNotes(ctx context.Context, bdb bulkDocer) (map[*csvNote][]*fb.Card, error) {
+	docs := make([]interface{}, len(imp.notes))
+	for i, n := range imp.notes {
+		docs[i] = n.note
+	}
+	results, err := bdb.BulkDocs(ctx, docs)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = results.Close() }()
+	cards := make(map[*csvNote][]*fb.Card)
+	for i := 0; results.Next(); i++ {
+		n := imp.notes[i]
+		if err := results.UpdateErr(); err != nil {
+			imp.failRows(n, errors.Wrapf(err, "failed to save note %s", results.ID()))
+			continue
+		}
+		ids, err := imp.templateIDs(n.note)
+		if err != nil {
+			imp.failRows(n, err)
+			continue
+		}
+		for _, id := range ids {
+			cardID := fmt.Sprintf("card-%s.%s.%d", strings.TrimPrefix(imp.opts.BundleID, "bundle-"),
+				strings.TrimPrefix(n.note.ID, "note-"), id)
+			card, err := fb.NewCard(n.note.ThemeID, n.note.ModelID, cardID)
+			if err != nil {
+				imp.failRows(n, err)
+				break
+			}
+			card.Created = imp.imported
+			card.Modified = imp.imported
+			cards[n] = append(cards[n], card)
+		}
+	}
+	if err := results.Err(); err != nil && err != io.EOF {
+		return nil, err
+	}
+	return cards, nil
+}
+
+// saveCards saves the cards of the saved notes, and returns the IDs of the
+// cards added. An updated note may already have some of its cards. The rows of
+// a note whose cards can't all be saved are reported as errors.
+func (imp *csvImport) saveCards(ctx context.Context, udb bulkDocer, cards map[*csvNote][]*fb.Card) ([]string, error) {
+	docs := make([]interface{}, 0)
+	owners := make([]*csvNote, 0)
+	for _, n := range imp.notes {
+		for _, card := range cards[n] {
+			docs = append(docs, card)
+			owners = append(owners, n)
+		}
+	}
+	if len(docs) == 0 {
+		return nil, nil
+	}
+	results, err := udb.BulkDocs(ctx, docs)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = results.Close() }()
+	added := make([]string, 0, len(docs))
+	failed := make(map[*csvNote]bool)
+	for i := 0; results.Next(); i++ {
+		err := results.UpdateErr()
+		switch {
+		case err == nil:
+			added = append(added, results.ID())
+		case kivik.StatusCode(err) != kivik.StatusConflict && !failed[owners[i]]:
+			failed[owners[i]] = true
+			imp.failRows(owners[i], errors.Wrapf(err, "failed to save card %s", results.ID()))
+		}
+	}
+	if err := results.Err(); err != nil && err != io.EOF {
+		return nil, err
+	}
+	return added, nil
+}
+
+// addToDeck adds the cards to the deck.
+func addToDeck(ctx context.Context, db getPutBulkDocer, deckID string, cardIDs []string) error {
+	deck := &fb.Deck{}
+	if err := getDoc(ctx, db, deckID, deck); err != nil {
+		return errors.Wrap(err, "fetch deck")
+	}
+	for _, id := range cardIDs {
+		deck.AddCard(id)
+	}
+	deck.Modified = now().UTC()
+	return errors.Wrap(updateDocs(ctx, db, []interface{}{deck}), "update deck")
+}
+
+// finish counts the outcomes of the rows, and sorts the errors.
+func (imp *csvImport) finish() *CSVImportResult {
+	for _, outcome := range imp.outcomes {
+		*outcome++
+	}
+	sort.SliceStable(imp.result.Errors, func(i, j int) bool {
+		return imp.result.Errors[i].Row < imp.result.Errors[j].Row
+	})
+	return imp.result
+}
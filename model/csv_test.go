@@ -0,0 +1,278 @@
+package model
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/FlashbackSRS/flashback/fb"
+	"github.com/flimzy/diff"
+	"github.com/flimzy/kivik"
+	"github.com/flimzy/kivik/errors"
+)
+
+// testCSVRepo returns a repo into which the test Anki package has been
+// imported, with the IDs of its bundle, Basic and Cloze themes, and a deck.
+func testCSVRepo(t *testing.T) (repo *Repo, bundleID, basicID, clozeID, deckID string) {
+	repo = testExportRepo(t, "mjxwe")
+	if err := repo.ImportFile(context.Background(), &mockFile{body: testApkg(t)}); err != nil {
+		t.Fatal(err)
+	}
+	pkg, err := ankiPackage(testApkg(t), "mjxwe", studyClock{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, theme := range pkg.Themes {
+		switch theme.Name {
+		case "Basic":
+			basicID = theme.ID
+		case "Cloze":
+			clozeID = theme.ID
+		}
+	}
+	return repo, pkg.Bundle.ID, basicID, clozeID, pkg.Decks[0].ID
+}
+
+func TestImportCSV(t *testing.T) {
+	_, bundleID, basicID, clozeID, deckID := testCSVRepo(t)
+	_, err := (&Repo{}).ImportCSV(context.Background(), strings.NewReader(""), CSVImportOptions{})
+	checkErr(t, "not logged in", err)
+
+	type tst struct {
+		name   string
+		input  string
+		opts   CSVImportOptions
+		err    string
+		result *CSVImportResult
+		// notes maps the first field of the model's notes to the rest of
+		// their fields and their tags, separated by "|".
+		notes map[string]string
+		cards int
+		deck  int
+	}
+	basic := CSVImportOptions{BundleID: bundleID, ThemeID: basicID, DeckID: deckID}
+	tests := []tst{
+		{
+			name:  "missing theme",
+			opts:  CSVImportOptions{BundleID: bundleID, ThemeID: "theme-bWlzc2luZw"},
+			input: "a,b",
+			err:   "fetch theme: missing",
+		},
+		{
+			name:  "missing model",
+			opts:  CSVImportOptions{BundleID: bundleID, ThemeID: basicID, ModelID: 3},
+			input: "a,b",
+			err:   "model 3 not found in theme " + basicID,
+		},
+		{
+			name:  "unknown field",
+			opts:  CSVImportOptions{BundleID: bundleID, ThemeID: basicID, Columns: []string{"Front", "Foo"}},
+			input: "a,b",
+			err:   "no such field: Foo",
+		},
+		{
+			name:  "first field unmapped",
+			opts:  CSVImportOptions{BundleID: bundleID, ThemeID: basicID, Columns: []string{"", "Back"}},
+			input: "a,b",
+			err:   "no column is mapped to the first field, Front",
+		},
+		{
+			name:  "field mapped twice",
+			opts:  CSVImportOptions{BundleID: bundleID, ThemeID: basicID, Columns: []string{"Back", "back", "Front"}},
+			input: "a,b,c",
+			err:   "field Back is mapped to more than one column",
+		},
+		{
+			name: "TSV with header",
+			opts: func() CSVImportOptions {
+				opts := basic
+				opts.Header = true
+				return opts
+			}(),
+			input: "tags\tFront\tBack\tNotes\n" +
+				"animal noun\tperro\tdog\tignored\n" +
+				"\thablar\tto talk\n" +
+				"\t\tempty\n" +
+				"\tgato\tcat\n" +
+				"\tgato\tanother cat\n",
+			result: &CSVImportResult{Added: 2, Skipped: 2, Errors: []*RowError{{Row: 4}}},
+			notes: map[string]string{
+				`hablar <img src="cat.jpg">`: "to speak <audio src=\"meow.mp3\" type=\"audio/mpeg\"></audio>[sound:missing.mp3]|spanish verb",
+				"perro":                      "dog|animal noun",
+				"gato":                       "cat|",
+			},
+			cards: 4,
+			deck:  4,
+		},
+		{
+			name: "update duplicates",
+			opts: func() CSVImportOptions {
+				opts := basic
+				opts.Duplicates = DuplicateUpdate
+				return opts
+			}(),
+			input:  "hablar,to talk\nperro,dog\nperro,hound",
+			result: &CSVImportResult{Added: 1, Updated: 2},
+			notes: map[string]string{
+				"hablar": "to talk|spanish verb",
+				"perro":  "hound|",
+			},
+			cards: 2,
+			deck:  2,
+		},
+		{
+			name: "allow duplicates",
+			opts: func() CSVImportOptions {
+				opts := basic
+				opts.Duplicates = DuplicateAllow
+				opts.DeckID = ""
+				return opts
+			}(),
+			input:  "hablar;to talk",
+			result: &CSVImportResult{Added: 1},
+			notes: map[string]string{
+				`hablar <img src="cat.jpg">`: "to speak <audio src=\"meow.mp3\" type=\"audio/mpeg\"></audio>[sound:missing.mp3]|spanish verb",
+				"hablar;to talk":             "|",
+			},
+			cards: 2,
+		},
+		{
+			name: "cloze",
+			opts: CSVImportOptions{
+				BundleID:  bundleID,
+				ThemeID:   clozeID,
+				Delimiter: ';',
+				Columns:   []string{"Text", "", CSVTagsColumn},
+			},
+			input:  "{{c1::Paris}} is in {{c3::France}};x;geo\nno deletions;;\n\"\"\"quoted\"\" {{c1::text}}\";x;",
+			result: &CSVImportResult{Added: 2, Errors: []*RowError{{Row: 2}}},
+			notes: map[string]string{
+				`{{c1::Madrid}} is the capital of {{c2::Spain}} <img src="cat.jpg">`: "|",
+				"{{c1::Paris}} is in {{c3::France}}":                                 "|geo",
+				`"quoted" {{c1::text}}`:                                              "|",
+			},
+			cards: 3,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			repo, _, _, _, _ := testCSVRepo(t)
+			result, err := repo.ImportCSV(context.Background(), strings.NewReader(test.input), test.opts)
+			checkErr(t, test.err, err)
+			if err != nil {
+				return
+			}
+			for _, e := range result.Errors {
+				e.Err = nil
+			}
+			if d := diff.Interface(test.result, result); d != nil {
+				t.Error(d)
+			}
+			bdb, err := repo.newDB(context.Background(), bundleID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			notes, err := fetchNotes(context.Background(), bdb)
+			if err != nil {
+				t.Fatal(err)
+			}
+			found := make(map[string]string)
+			for _, note := range notes {
+				if note.ThemeID != test.opts.ThemeID {
+					continue
+				}
+				rest := make([]string, 0)
+				for _, fv := range note.FieldValues[1:] {
+					rest = append(rest, fv.Text)
+				}
+				found[note.FieldValues[0].Text] = strings.Join(rest, ",") + "|" + strings.Join(note.Tags, " ")
+			}
+			if d := diff.Interface(test.notes, found); d != nil {
+				t.Error(d)
+			}
+			udb, err := repo.userDB(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			cards, err := fetchBundleCards(context.Background(), udb, bundleID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if added := len(cards) - 4; added != test.cards {
+				t.Errorf("Expected %d new cards, got %d", test.cards, added)
+			}
+			deck := &fb.Deck{}
+			if e := getDoc(context.Background(), bdb, deckID, deck); e != nil {
+				t.Fatal(e)
+			}
+			if added := len(deck.Cards.All()) - 1; added != test.deck {
+				t.Errorf("Expected %d cards added to the deck, got %d", test.deck, added)
+			}
+		})
+	}
+}
+
+func TestImportCSVRowErrors(t *testing.T) {
+	repo, bundleID, basicID, _, _ := testCSVRepo(t)
+	result, err := repo.ImportCSV(context.Background(), strings.NewReader("a,b\n,c\nd,\"e\"x\"\n"), CSVImportOptions{
+		BundleID: bundleID,
+		ThemeID:  basicID,
+		DeckID:   "deck-bWlzc2luZw",
+	})
+	checkErr(t, "fetch deck: missing", err)
+	errs := make([]string, len(result.Errors))
+	for i, e := range result.Errors {
+		errs[i] = e.Error()
+	}
+	if d := diff.Interface([]string{"row 2: field Front is empty"}, errs); d != nil {
+		t.Error(d)
+	}
+	if result.Added != 2 {
+		t.Errorf("Expected 2 notes added, got %d", result.Added)
+	}
+}
+
+func TestCSVSaveCards(t *testing.T) {
+	imp := &csvImport{
+		notes: []*csvNote{
+			{rows: []int{1, 2}},
+			{rows: []int{3}},
+			{rows: []int{4}},
+		},
+		outcomes: make(map[int]*int),
+		result:   &CSVImportResult{},
+	}
+	for row := 1; row <= 4; row++ {
+		imp.outcomes[row] = &imp.result.Added
+	}
+	cards := map[*csvNote][]*fb.Card{
+		imp.notes[0]: {{ID: "card-foo.bar.0"}},
+		imp.notes[1]: {{ID: "card-foo.baz.0"}, {ID: "card-foo.baz.1"}},
+		imp.notes[2]: {{ID: "card-foo.qux.0"}},
+	}
+	db := &mockBulkDocer{results: &mockBulkResults{
+		ids: []string{"card-foo.bar.0", "card-foo.baz.0", "card-foo.baz.1", "card-foo.qux.0"},
+		errs: []error{
+			nil,
+			errors.Status(kivik.StatusForbidden, "forbidden"),
+			errors.Status(kivik.StatusForbidden, "forbidden"),
+			errors.Status(kivik.StatusConflict, "conflict"),
+		},
+	}}
+	added, err := imp.saveCards(context.Background(), db, cards)
+	checkErr(t, "", err)
+	if d := diff.Interface([]string{"card-foo.bar.0"}, added); d != nil {
+		t.Error(d)
+	}
+	result := imp.finish()
+	errs := make([]string, len(result.Errors))
+	for i, e := range result.Errors {
+		errs[i] = e.Error()
+	}
+	if d := diff.Interface([]string{"row 3: failed to save card card-foo.baz.0: forbidden"}, errs); d != nil {
+		t.Error(d)
+	}
+	if result.Added != 3 {
+		t.Errorf("Expected 3 rows added, got %d", result.Added)
+	}
+}
@@ -0,0 +1,231 @@
+package model
+
+import (
+	"context"
+	"math/rand"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/FlashbackSRS/flashback/fb"
+)
+
+// StudyFilter selects the cards for a custom study session. Zero values match
+// everything. Suspended cards are only included if StateSuspended is
+// requested explicitly.
+type StudyFilter struct {
+	// Query limits the session to cards matching the search query. See Query
+	// for the syntax.
+	Query string `json:"query,omitempty"`
+	// BundleID limits the session to cards in the bundle.
+	BundleID string `json:"bundleID,omitempty"`
+	// DeckID limits the session to cards in the deck.
+	DeckID string `json:"deckID,omitempty"`
+	// DueWithin limits the session to cards which are overdue, or due within
+	// this interval.
+	DueWithin fb.Interval `json:"dueWithin,omitempty"`
+	// States limits the session to cards in any of the states.
+	States []CardState `json:"states,omitempty"`
+	// FailedToday limits the session to cards answered wrong today.
+	FailedToday bool `json:"failedToday,omitempty"`
+	// Random, if non-zero, selects at most this many cards at random.
+	// Otherwise all matching cards are studied, in order of due date.
+	Random int `json:"random,omitempty"`
+}
+
+// StudyMode determines how answers in a custom study session are treated.
+type StudyMode int
+
+// Study modes
+const (
+	// StudyReschedule reschedules answered cards, as in normal study.
+	StudyReschedule StudyMode = iota
+	// StudyCram leaves the cards' schedules unchanged. Answers are recorded as
+	// reviews of type fb.ReviewTypeCram, which don't count towards the daily
+	// limits.
+	StudyCram
+)
+
+// studySession is a custom study session.
+type studySession struct {
+	mode StudyMode
+	// queue holds the IDs of the cards remaining to be studied. A card stays
+	// at the head of the queue until it is answered.
+	queue []string
+}
+
+// StartCustomStudy selects the cards matching filter, and studies them, in
+// place of the regular selection, until all have been answered or
+// EndCustomStudy is called. Cards answered wrong are requeued. The number of
+// selected cards is returned; if none match, no session is started.
+func (r *Repo) StartCustomStudy(ctx context.Context, filter StudyFilter, mode StudyMode) (int, error) {
+	if filter.Random < 0 {
+		return 0, errors.Errorf("invalid random count: %d", filter.Random)
+	}
+	q, err := ParseQuery(filter.Query)
+	if err != nil {
+		return 0, errors.Wrap(err, "parse query")
+	}
+	udb, err := r.userDB(ctx)
+	if err != nil {
+		return 0, err
+	}
+	cards, err := r.search(ctx, udb, q)
+	if err != nil {
+		return 0, err
+	}
+	clock, err := r.studyClock(ctx)
+	if err != nil {
+		return 0, err
+	}
+	var deckCards map[string]struct{}
+	if filter.DeckID != "" {
+		if deckCards, err = r.deckCards(ctx, filter.BundleID, filter.DeckID, cards); err != nil {
+			return 0, err
+		}
+	}
+	var failed map[string]struct{}
+	if filter.FailedToday {
+		reviews, err := findReviews(ctx, udb)
+		if err != nil {
+			return 0, err
+		}
+		failed = failedOn(reviews, clock, clock.today())
+	}
+	selected := cards[:0]
+	for _, card := range cards {
+		if _, ok := deckCards[card.ID]; deckCards != nil && !ok {
+			continue
+		}
+		if _, ok := failed[card.ID]; failed != nil && !ok {
+			continue
+		}
+		if filter.matches(card, clock, now()) {
+			selected = append(selected, card)
+		}
+	}
+	r.selectMu.Lock()
+	queue := sessionQueue(selected, filter.Random, r.random())
+	r.selectMu.Unlock()
+	r.sessionMu.Lock()
+	defer r.sessionMu.Unlock()
+	r.session = nil
+	if len(queue) > 0 {
+		r.session = &studySession{mode: mode, queue: queue}
+	}
+	return len(queue), nil
+}
+
+// EndCustomStudy ends the current custom study session, if any, returning to
+// regular study.
+func (r *Repo) EndCustomStudy() {
+	r.sessionMu.Lock()
+	defer r.sessionMu.Unlock()
+	r.session = nil
+}
+
+// CustomStudyRemaining returns the number of cards remaining in the current
+// custom study session, or 0 if none is active.
+func (r *Repo) CustomStudyRemaining() int {
+	r.sessionMu.Lock()
+	defer r.sessionMu.Unlock()
+	if r.session == nil {
+		return 0
+	}
+	return len(r.session.queue)
+}
+
+// matches returns true if card passes the filter's card-level conditions.
+func (f *StudyFilter) matches(card *fb.Card, clock studyClock, now time.Time) bool {
+	if f.BundleID != "" && card.BundleID() != f.BundleID {
+		return false
+	}
+	if f.DueWithin > 0 && (card.Due.IsZero() || clock.time(card.Due).After(clock.time(clock.add(now, f.DueWithin)))) {
+		return false
+	}
+	state := cardState(card)
+	if len(f.States) == 0 {
+		return state != StateSuspended
+	}
+	for _, s := range f.States {
+		if s == state {
+			return true
+		}
+	}
+	return false
+}
+
+// failedOn returns the IDs of the cards answered wrong on the study day.
+func failedOn(reviews []*fb.Review, clock studyClock, day fb.Due) map[string]struct{} {
+	failed := make(map[string]struct{})
+	for _, review := range reviews {
+		if review.Ease == fb.ReviewEaseWrong && clock.on(review.Timestamp).Equal(day) {
+			failed[review.CardID] = struct{}{}
+		}
+	}
+	return failed
+}
+
+// sessionQueue orders the cards for study, by due date with new cards last,
+// or if random is non-zero, selects up to random cards in random order, using
+// rnd.
+func sessionQueue(cards []*fb.Card, random int, rnd *rand.Rand) []string {
+	if random > 0 {
+		for i := len(cards) - 1; i > 0; i-- {
+			j := rnd.Intn(i + 1)
+			cards[i], cards[j] = cards[j], cards[i]
+		}
+		if len(cards) > random {
+			cards = cards[:random]
+		}
+	} else {
+		sort.SliceStable(cards, func(i, j int) bool {
+			di, dj := cards[i].Due, cards[j].Due
+			if di.IsZero() || dj.IsZero() {
+				return dj.IsZero() && !di.IsZero()
+			}
+			return dj.After(di)
+		})
+	}
+	queue := make([]string, len(cards))
+	for i, card := range cards {
+		queue[i] = card.ID
+	}
+	return queue
+}
+
+// sessionCard returns the ID of the next card in the custom study session, and
+// the session's mode. ok is false if no session is active.
+func (r *Repo) sessionCard() (cardID string, mode StudyMode, ok bool) {
+	r.sessionMu.Lock()
+	defer r.sessionMu.Unlock()
+	if r.session == nil {
+		return "", 0, false
+	}
+	return r.session.queue[0], r.session.mode, true
+}
+
+// sessionAnswered removes the answered card from the custom study session, or
+// if it was answered wrong, moves it to the end of the queue. The session
+// ends once the queue is empty.
+func (r *Repo) sessionAnswered(cardID string, wrong bool) {
+	r.sessionMu.Lock()
+	defer r.sessionMu.Unlock()
+	if r.session == nil {
+		return
+	}
+	queue := r.session.queue[:0]
+	for _, id := range r.session.queue {
+		if id != cardID {
+			queue = append(queue, id)
+		}
+	}
+	if wrong {
+		queue = append(queue, cardID)
+	}
+	r.session.queue = queue
+	if len(queue) == 0 {
+		r.session = nil
+	}
+}
@@ -0,0 +1,242 @@
+package model
+
+import (
+	"context"
+	"testing"
+
+	"github.com/FlashbackSRS/flashback"
+	"github.com/FlashbackSRS/flashback/fb"
+	"github.com/flimzy/diff"
+)
+
+func TestStudyFilterMatches(t *testing.T) {
+	tests := []struct {
+		name     string
+		filter   StudyFilter
+		card     *fb.Card
+		expected bool
+	}{
+		{
+			name:     "no filter",
+			card:     &fb.Card{ID: "card-foo.bar.0"},
+			expected: true,
+		},
+		{
+			name: "suspended",
+			card: &fb.Card{ID: "card-foo.bar.0", Suspended: true},
+		},
+		{
+			name:     "suspended requested",
+			filter:   StudyFilter{States: []CardState{StateSuspended}},
+			card:     &fb.Card{ID: "card-foo.bar.0", Suspended: true},
+			expected: true,
+		},
+		{
+			name:   "other bundle",
+			filter: StudyFilter{BundleID: "bundle-bar"},
+			card:   &fb.Card{ID: "card-foo.bar.0"},
+		},
+		{
+			name:     "due within",
+			filter:   StudyFilter{DueWithin: 3 * fb.Day},
+			card:     &fb.Card{ID: "card-foo.bar.0", Interval: fb.Day, Due: parseDue(t, "2017-01-04")},
+			expected: true,
+		},
+		{
+			name:     "overdue",
+			filter:   StudyFilter{DueWithin: 3 * fb.Day},
+			card:     &fb.Card{ID: "card-foo.bar.0", Interval: fb.Day, Due: parseDue(t, "2016-12-01")},
+			expected: true,
+		},
+		{
+			name:   "due later",
+			filter: StudyFilter{DueWithin: 3 * fb.Day},
+			card:   &fb.Card{ID: "card-foo.bar.0", Interval: fb.Day, Due: parseDue(t, "2017-01-05")},
+		},
+		{
+			name:   "new card not due",
+			filter: StudyFilter{DueWithin: 3 * fb.Day},
+			card:   &fb.Card{ID: "card-foo.bar.0"},
+		},
+		{
+			name:   "wrong state",
+			filter: StudyFilter{States: []CardState{StateYoung, StateMature}},
+			card:   &fb.Card{ID: "card-foo.bar.0"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if result := test.filter.matches(test.card, studyClock{}, now()); result != test.expected {
+				t.Errorf("Unexpected result: %t", result)
+			}
+		})
+	}
+}
+
+func TestFailedOn(t *testing.T) {
+	reviews := []*fb.Review{
+		{CardID: "card-foo.bar.0", Ease: fb.ReviewEaseWrong, Timestamp: parseTime(t, "2017-01-01T08:00:00Z")},
+		{CardID: "card-foo.bar.1", Ease: fb.ReviewEaseOK, Timestamp: parseTime(t, "2017-01-01T08:00:00Z")},
+		{CardID: "card-foo.bar.2", Ease: fb.ReviewEaseWrong, Timestamp: parseTime(t, "2016-12-31T08:00:00Z")},
+	}
+	expected := map[string]struct{}{"card-foo.bar.0": {}}
+	if d := diff.Interface(expected, failedOn(reviews, studyClock{}, parseDue(t, "2017-01-01"))); d != nil {
+		t.Error(d)
+	}
+}
+
+func TestSessionQueue(t *testing.T) {
+	cards := func() []*fb.Card {
+		return []*fb.Card{
+			{ID: "card-foo.bar.0"},
+			{ID: "card-foo.bar.1", Due: parseDue(t, "2017-01-03")},
+			{ID: "card-foo.bar.2", Due: parseDue(t, "2017-01-02")},
+		}
+	}
+	t.Run("due order", func(t *testing.T) {
+		expected := []string{"card-foo.bar.2", "card-foo.bar.1", "card-foo.bar.0"}
+		if d := diff.Interface(expected, sessionQueue(cards(), 0, rnd)); d != nil {
+			t.Error(d)
+		}
+	})
+	t.Run("random", func(t *testing.T) {
+		if queue := sessionQueue(cards(), 2, rnd); len(queue) != 2 {
+			t.Errorf("Unexpected queue length: %d", len(queue))
+		}
+	})
+}
+
+func TestRepoCustomStudy(t *testing.T) {
+	ctx := context.Background()
+	t.Run("not logged in", func(t *testing.T) {
+		_, err := (&Repo{}).StartCustomStudy(ctx, StudyFilter{}, StudyReschedule)
+		checkErr(t, "not logged in", err)
+	})
+	t.Run("invalid random", func(t *testing.T) {
+		_, err := (&Repo{}).StartCustomStudy(ctx, StudyFilter{Random: -1}, StudyReschedule)
+		checkErr(t, "invalid random count: -1", err)
+	})
+	t.Run("invalid query", func(t *testing.T) {
+		_, err := (&Repo{}).StartCustomStudy(ctx, StudyFilter{Query: "(foo"}, StudyReschedule)
+		checkErr(t, "parse query: missing ')'", err)
+	})
+	repo := &Repo{user: "mjxwe", local: testClient(t)}
+	if err := repo.local.CreateDB(ctx, "user-mjxwe"); err != nil {
+		t.Fatal(err)
+	}
+	udb, err := repo.userDB(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"card-foo.bar.0", "card-foo.bar.1"} {
+		card := &fb.Card{ID: id, ModelID: "theme-foo/0", Created: now(), Modified: now(), Interval: 10 * fb.Day}
+		if err := saveDoc(ctx, udb, card); err != nil {
+			t.Fatal(err)
+		}
+	}
+	review, err := fb.NewReviewAt("card-foo.bar.1", now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	review.Ease = fb.ReviewEaseWrong
+	if err := saveDoc(ctx, udb, review); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("no matches", func(t *testing.T) {
+		n, err := repo.StartCustomStudy(ctx, StudyFilter{BundleID: "bundle-bar"}, StudyReschedule)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != 0 || repo.CustomStudyRemaining() != 0 {
+			t.Errorf("Expected no session, got %d cards", n)
+		}
+		if _, _, ok := repo.sessionCard(); ok {
+			t.Error("Expected no active session")
+		}
+	})
+	t.Run("query", func(t *testing.T) {
+		defer repo.EndCustomStudy()
+		n, err := repo.StartCustomStudy(ctx, StudyFilter{Query: "bundle:foo -is:new"}, StudyReschedule)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != 2 {
+			t.Errorf("Unexpected session size: %d", n)
+		}
+	})
+	t.Run("failed today", func(t *testing.T) {
+		n, err := repo.StartCustomStudy(ctx, StudyFilter{FailedToday: true}, StudyReschedule)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != 1 {
+			t.Fatalf("Unexpected session size: %d", n)
+		}
+		cardID, mode, ok := repo.sessionCard()
+		if !ok || cardID != "card-foo.bar.1" || mode != StudyReschedule {
+			t.Errorf("Unexpected session card: %s, %d, %t", cardID, mode, ok)
+		}
+		repo.sessionAnswered(cardID, true)
+		if repo.CustomStudyRemaining() != 1 {
+			t.Errorf("Wrong answer should be requeued")
+		}
+		repo.sessionAnswered(cardID, false)
+		if _, _, ok := repo.sessionCard(); ok {
+			t.Error("Expected session to end")
+		}
+	})
+	t.Run("cram", func(t *testing.T) {
+		if _, err := repo.StartCustomStudy(ctx, StudyFilter{}, StudyCram); err != nil {
+			t.Fatal(err)
+		}
+		defer repo.EndCustomStudy()
+		stored := &fb.Card{}
+		if err := getDoc(ctx, udb, "card-foo.bar.0", stored); err != nil {
+			t.Fatal(err)
+		}
+		card := &Card{
+			Card:  stored,
+			model: &fbModel{Model: &fb.Model{Type: "answer"}},
+			repo:  repo,
+			cram:  true,
+		}
+		face := Answer
+		if _, err := card.Action(ctx, &face, now(), flashback.AnswerCorrect); err != nil {
+			t.Fatal(err)
+		}
+		result := &fb.Card{}
+		if err := getDoc(ctx, udb, "card-foo.bar.0", result); err != nil {
+			t.Fatal(err)
+		}
+		if result.Interval != 10*fb.Day || result.ReviewCount != 0 {
+			t.Errorf("Crammed card was rescheduled: %s, %d reviews", result.Interval, result.ReviewCount)
+		}
+		if repo.CanUndo() {
+			t.Error("Crammed answers should not be undoable")
+		}
+		reviews, err := findReviews(ctx, udb)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var crammed []*fb.Review
+		for _, review := range reviews {
+			if review.CardID == "card-foo.bar.0" {
+				crammed = append(crammed, review)
+			}
+		}
+		if len(crammed) != 1 || crammed[0].Type != fb.ReviewTypeCram || crammed[0].Interval != 10*fb.Day {
+			t.Errorf("Expected one cram review, leaving the interval unchanged, got %v", crammed)
+		}
+		counts, err := fetchDailyCounts(ctx, udb, card.clock.on(now()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if counts.Reviews[allScope] != 0 || counts.New[allScope] != 0 {
+			t.Errorf("Cram review was counted: %v, %v", counts.Reviews, counts.New)
+		}
+		if repo.CustomStudyRemaining() != 1 {
+			t.Errorf("Unexpected cards remaining: %d", repo.CustomStudyRemaining())
+		}
+	})
+}
@@ -0,0 +1,128 @@
+package model
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/FlashbackSRS/flashback/fb"
+)
+
+// studyClock divides time into study days. A study day starts at dayStart
+// hours, local time, so that late night study counts towards the previous
+// day. A day-granularity fb.Due value, stored as UTC midnight, refers to the
+// study day of the same date. The zero value uses UTC days starting at
+// midnight.
+type studyClock struct {
+	loc      *time.Location
+	dayStart int
+}
+
+// location returns the clock's time zone.
+func (c studyClock) location() *time.Location {
+	if c.loc == nil {
+		return time.UTC
+	}
+	return c.loc
+}
+
+// on returns the study day containing t.
+func (c studyClock) on(t time.Time) fb.Due {
+	local := t.In(c.location())
+	year, month, day := local.Date()
+	if local.Hour() < c.dayStart {
+		day--
+	}
+	return fb.Due(time.Date(year, month, day, 0, 0, 0, 0, time.UTC))
+}
+
+// today returns the current study day.
+func (c studyClock) today() fb.Due {
+	return c.on(now())
+}
+
+// start returns the time at which the study day begins. If the start hour is
+// skipped by a change to daylight saving time, the day begins at the change.
+func (c studyClock) start(day fb.Due) time.Time {
+	year, month, date := day.Time().UTC().Date()
+	t := time.Date(year, month, date, c.dayStart, 0, 0, 0, c.location())
+	if t.Hour() != c.dayStart {
+		// time.Date uses the offset from before the change, so t falls before
+		// the change by the size of the gap.
+		_, before := t.Zone()
+		_, after := t.Add(time.Duration(fb.Day)).Zone()
+		t = t.Add(time.Duration(after-before) * time.Second)
+	}
+	return t
+}
+
+// isDay returns true if d has day granularity.
+func isDay(d fb.Due) bool {
+	t := d.Time().UTC()
+	return t.Equal(t.Truncate(time.Duration(fb.Day)))
+}
+
+// time returns the time at which d is reached: the start of the study day
+// for day-granularity values, or d itself otherwise.
+func (c studyClock) time(d fb.Due) time.Time {
+	if !d.IsZero() && isDay(d) {
+		return c.start(d)
+	}
+	return d.Time()
+}
+
+// reached returns true if d is not after t.
+func (c studyClock) reached(d fb.Due, t time.Time) bool {
+	return !c.time(d).After(t)
+}
+
+// dueIn returns the Due value ivl from now.
+func (c studyClock) dueIn(ivl fb.Interval) fb.Due {
+	return c.add(now(), ivl)
+}
+
+// add returns the Due value ivl after t. Intervals of a day or more are
+// rounded up to whole study days, counted from the study day containing t.
+func (c studyClock) add(t time.Time, ivl fb.Interval) fb.Due {
+	if ivl < fb.Day {
+		return fb.Due(t.UTC()).Add(ivl)
+	}
+	return c.on(t).Add(ivl)
+}
+
+// clock returns the study clock configured by the settings. An unknown time
+// zone, which SaveSettings rejects, is treated as local time.
+func (s *Settings) clock() studyClock {
+	loc := time.Local
+	if s.TimeZone != "" {
+		if l, err := time.LoadLocation(s.TimeZone); err == nil {
+			loc = l
+		}
+	}
+	return studyClock{loc: loc, dayStart: s.DayStart}
+}
+
+// studyClock returns the user's study clock.
+func (r *Repo) studyClock(ctx context.Context) (studyClock, error) {
+	udb, err := r.userDB(ctx)
+	if err != nil {
+		return studyClock{}, err
+	}
+	s, err := fetchSettings(ctx, udb)
+	if err != nil {
+		return studyClock{}, err
+	}
+	return s.clock(), nil
+}
+
+// validateClock checks the study day settings.
+func (s *Settings) validateClock() error {
+	if s.DayStart < 0 || s.DayStart > 23 {
+		return errors.Errorf("invalid day start hour %d", s.DayStart)
+	}
+	if _, err := time.LoadLocation(s.TimeZone); err != nil {
+		return errors.Wrap(err, "invalid time zone")
+	}
+	return nil
+}
@@ -0,0 +1,277 @@
+package model
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/FlashbackSRS/flashback/fb"
+)
+
+func loadLocation(t *testing.T, name string) *time.Location {
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return loc
+}
+
+func TestStudyClockOn(t *testing.T) {
+	newYork := studyClock{loc: loadLocation(t, "America/New_York"), dayStart: 4}
+	tests := []struct {
+		name     string
+		clock    studyClock
+		time     string
+		expected string
+	}{
+		{
+			name:     "zero clock",
+			time:     "2017-01-01T23:00:00-05:00",
+			expected: "2017-01-02",
+		},
+		{
+			name:     "before day start",
+			clock:    newYork,
+			time:     "2017-01-02T03:59:59-05:00",
+			expected: "2017-01-01",
+		},
+		{
+			name:     "day start",
+			clock:    newYork,
+			time:     "2017-01-02T04:00:00-05:00",
+			expected: "2017-01-02",
+		},
+		{
+			name:     "late evening",
+			clock:    newYork,
+			time:     "2017-01-02T23:00:00-05:00",
+			expected: "2017-01-02",
+		},
+		{
+			name:     "DST start, before the change",
+			clock:    newYork,
+			time:     "2017-03-12T01:30:00-05:00",
+			expected: "2017-03-11",
+		},
+		{
+			name:     "DST start, before day start",
+			clock:    newYork,
+			time:     "2017-03-12T03:59:59-04:00",
+			expected: "2017-03-11",
+		},
+		{
+			name:     "DST start, day start",
+			clock:    newYork,
+			time:     "2017-03-12T04:00:00-04:00",
+			expected: "2017-03-12",
+		},
+		{
+			name:     "DST end, first 1:30",
+			clock:    newYork,
+			time:     "2017-11-05T01:30:00-04:00",
+			expected: "2017-11-04",
+		},
+		{
+			name:     "DST end, second 1:30",
+			clock:    newYork,
+			time:     "2017-11-05T01:30:00-05:00",
+			expected: "2017-11-04",
+		},
+		{
+			name:     "DST end, day start",
+			clock:    newYork,
+			time:     "2017-11-05T04:00:00-05:00",
+			expected: "2017-11-05",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result := test.clock.on(parseTime(t, test.time))
+			if result.String() != test.expected {
+				t.Errorf("Unexpected day: %s", result)
+			}
+		})
+	}
+}
+
+func TestStudyClockStart(t *testing.T) {
+	newYork := loadLocation(t, "America/New_York")
+	tests := []struct {
+		name     string
+		clock    studyClock
+		day      string
+		expected string
+		length   time.Duration
+	}{
+		{
+			name:     "zero clock",
+			day:      "2017-01-02",
+			expected: "2017-01-02T00:00:00Z",
+			length:   24 * time.Hour,
+		},
+		{
+			name:     "standard time",
+			clock:    studyClock{loc: newYork, dayStart: 4},
+			day:      "2017-01-02",
+			expected: "2017-01-02T09:00:00Z",
+			length:   24 * time.Hour,
+		},
+		{
+			name:     "DST start",
+			clock:    studyClock{loc: newYork, dayStart: 4},
+			day:      "2017-03-11",
+			expected: "2017-03-11T09:00:00Z",
+			length:   23 * time.Hour,
+		},
+		{
+			name:     "DST end",
+			clock:    studyClock{loc: newYork, dayStart: 4},
+			day:      "2017-11-04",
+			expected: "2017-11-04T08:00:00Z",
+			length:   25 * time.Hour,
+		},
+		{
+			name:     "day start skipped by DST",
+			clock:    studyClock{loc: newYork, dayStart: 2},
+			day:      "2017-03-12",
+			expected: "2017-03-12T07:00:00Z",
+			length:   23 * time.Hour,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			day := parseDue(t, test.day)
+			start := test.clock.start(day)
+			if expected := parseTime(t, test.expected); !start.Equal(expected) {
+				t.Errorf("Unexpected start: %s", start.UTC())
+			}
+			if on := test.clock.on(start); !on.Equal(day) {
+				t.Errorf("Start falls on %s", on)
+			}
+			if length := test.clock.start(day.Add(fb.Day)).Sub(start); length != test.length {
+				t.Errorf("Unexpected day length: %s", length)
+			}
+		})
+	}
+}
+
+func TestStudyClockReached(t *testing.T) {
+	clock := studyClock{loc: loadLocation(t, "America/New_York"), dayStart: 4}
+	tests := []struct {
+		name     string
+		due      fb.Due
+		time     string
+		expected bool
+	}{
+		{
+			name:     "zero",
+			time:     "2017-01-01T00:00:00Z",
+			expected: true,
+		},
+		{
+			name:     "day, before day start",
+			due:      parseDue(t, "2017-03-12"),
+			time:     "2017-03-12T03:30:00-04:00",
+			expected: false,
+		},
+		{
+			name:     "day, after day start",
+			due:      parseDue(t, "2017-03-12"),
+			time:     "2017-03-12T04:00:00-04:00",
+			expected: true,
+		},
+		{
+			name:     "time, before",
+			due:      parseDue(t, "2017-03-12 08:00:00"),
+			time:     "2017-03-12T03:59:59-04:00",
+			expected: false,
+		},
+		{
+			name:     "time, after",
+			due:      parseDue(t, "2017-03-12 08:00:00"),
+			time:     "2017-03-12T04:00:00-04:00",
+			expected: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if result := clock.reached(test.due, parseTime(t, test.time)); result != test.expected {
+				t.Errorf("Unexpected result: %t", result)
+			}
+		})
+	}
+}
+
+func TestStudyClockAdd(t *testing.T) {
+	clock := studyClock{loc: loadLocation(t, "America/New_York"), dayStart: 4}
+	tests := []struct {
+		name     string
+		time     string
+		interval fb.Interval
+		expected string
+	}{
+		{
+			name:     "sub-day",
+			time:     "2017-03-12T01:30:00-05:00",
+			interval: 10 * fb.Minute,
+			expected: "2017-03-12 06:40:00",
+		},
+		{
+			name:     "one day, before day start",
+			time:     "2017-03-12T03:00:00-04:00",
+			interval: fb.Day,
+			expected: "2017-03-12",
+		},
+		{
+			name:     "one day, after day start",
+			time:     "2017-03-12T23:30:00-04:00",
+			interval: fb.Day,
+			expected: "2017-03-13",
+		},
+		{
+			name:     "partial days round up",
+			time:     "2017-11-05T12:00:00-05:00",
+			interval: 36 * fb.Hour,
+			expected: "2017-11-07",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if result := clock.add(parseTime(t, test.time), test.interval); result.String() != test.expected {
+				t.Errorf("Unexpected due: %s", result)
+			}
+		})
+	}
+}
+
+func TestSettingsClock(t *testing.T) {
+	clock := (&Settings{DayStart: 4, TimeZone: "Asia/Tokyo"}).clock()
+	if clock.dayStart != 4 || clock.location().String() != "Asia/Tokyo" {
+		t.Errorf("Unexpected clock: %d %s", clock.dayStart, clock.location())
+	}
+	if loc := (&Settings{}).clock().location(); loc != time.Local {
+		t.Errorf("Expected local time, got %s", loc)
+	}
+}
+
+func TestStudyLimitsRollover(t *testing.T) {
+	ctx := context.Background()
+	repo := &Repo{user: "mjxwe", local: testClient(t)}
+	saveCards(t, repo.local)
+	// It is 21:00 in Tokyo, so with a 22:00 day start, it is still the
+	// previous study day.
+	if err := repo.SaveSettings(ctx, &Settings{DayStart: 22, TimeZone: "Asia/Tokyo"}); err != nil {
+		t.Fatal(err)
+	}
+	udb, err := repo.userDB(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	limits, err := repo.studyLimits(ctx, udb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "counts-2016-12-31"; limits.counts.ID != expected {
+		t.Errorf("Unexpected counts %s", limits.counts.ID)
+	}
+}
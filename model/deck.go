@@ -0,0 +1,32 @@
+package model
+
+import (
+	"context"
+	"io"
+
+	"github.com/FlashbackSRS/flashback/fb"
+	"github.com/pkg/errors"
+)
+
+// fetchDecks returns all of the decks stored in the bundle db.
+func fetchDecks(ctx context.Context, db finder) ([]*fb.Deck, error) {
+	rows, err := db.Find(ctx, map[string]interface{}{
+		"selector": map[string]string{"type": "deck"},
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "find decks")
+	}
+	defer func() { _ = rows.Close() }()
+	decks := make([]*fb.Deck, 0)
+	for rows.Next() {
+		deck := &fb.Deck{}
+		if err := rows.ScanDoc(deck); err != nil {
+			return nil, errors.Wrapf(err, "scan deck %s", rows.ID())
+		}
+		decks = append(decks, deck)
+	}
+	if err := rows.Err(); err != nil && err != io.EOF {
+		return nil, err
+	}
+	return decks, nil
+}
@@ -0,0 +1,282 @@
+package model
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/FlashbackSRS/flashback/fb"
+)
+
+// ExportOptions determines what ExportBundle includes besides the content of
+// the bundle.
+type ExportOptions struct {
+	// Cards includes the user's cards, with their scheduling state. Otherwise,
+	// the exported cards are new.
+	Cards bool
+	// Reviews includes the review history of the user's cards. It has no
+	// effect unless Cards is also set.
+	Reviews bool
+}
+
+// exportDB is the interface required of a bundle database to export it.
+type exportDB interface {
+	findGetter
+	attachmentGetter
+}
+
+// ExportBundle writes the bundle, with its themes, notes and decks, to w, as
+// a gzipped *.fbb package which may be imported with ImportFile.
+func (r *Repo) ExportBundle(ctx context.Context, bundleID string, w io.Writer, opts ExportOptions) error {
+	pkg, err := r.bundlePackage(ctx, bundleID, opts)
+	if err != nil {
+		return err
+	}
+	z := gzip.NewWriter(w)
+	if err := json.NewEncoder(z).Encode(pkg); err != nil {
+		return errors.Wrap(err, "encode package")
+	}
+	return z.Close()
+}
+
+// bundlePackage returns the bundle, with the current user's cards, as a
+// package to be exported.
+func (r *Repo) bundlePackage(ctx context.Context, bundleID string, opts ExportOptions) (*fb.Package, error) {
+	udb, err := r.userDB(ctx)
+	if err != nil {
+		return nil, err
+	}
+	bdb, err := r.newDB(ctx, bundleID)
+	if err != nil {
+		return nil, err
+	}
+	return exportPackage(ctx, bdb, udb, bundleID, opts)
+}
+
+// exportPackage collects the bundle's documents from bdb, along with a card
+// for each card in its decks, from udb if requested.
+func exportPackage(ctx context.Context, bdb exportDB, udb allDocer, bundleID string, opts ExportOptions) (*fb.Package, error) {
+	bundle := &fb.Bundle{}
+	if err := getDoc(ctx, bdb, bundleID, bundle); err != nil {
+		return nil, errors.Wrap(err, "fetch bundle")
+	}
+	bundle.Rev = ""
+	themes, err := fetchThemes(ctx, bdb)
+	if err != nil {
+		return nil, err
+	}
+	for _, theme := range themes {
+		theme.Rev = ""
+		if err := fetchAttachments(ctx, bdb, theme.ID, theme.Attachments); err != nil {
+			return nil, err
+		}
+	}
+	notes, err := fetchNotes(ctx, bdb)
+	if err != nil {
+		return nil, err
+	}
+	for _, note := range notes {
+		note.Rev = ""
+		if err := fetchAttachments(ctx, bdb, note.ID, note.Attachments); err != nil {
+			return nil, err
+		}
+	}
+	decks, err := fetchDecks(ctx, bdb)
+	if err != nil {
+		return nil, err
+	}
+	for _, deck := range decks {
+		deck.Rev = ""
+	}
+	cards, err := exportCards(ctx, udb, bundleID, notes, decks, opts)
+	if err != nil {
+		return nil, err
+	}
+	pkg := &fb.Package{
+		Created:  bundle.Created,
+		Modified: now().UTC(),
+		Bundle:   bundle,
+		Themes:   themes,
+		Notes:    notes,
+		Decks:    decks,
+		Cards:    cards,
+	}
+	if opts.Cards && opts.Reviews {
+		if pkg.Reviews, err = fetchBundleReviews(ctx, udb, bundleID); err != nil {
+			return nil, err
+		}
+	}
+	return pkg, nil
+}
+
+// exportCards returns a card for each card listed in the decks: the user's
+// card, if requested and it exists, or otherwise a new card for the note.
+func exportCards(ctx context.Context, udb allDocer, bundleID string, notes []*fb.Note, decks []*fb.Deck, opts ExportOptions) ([]*fb.Card, error) {
+	userCards := make(map[string]*fb.Card)
+	if opts.Cards {
+		cards, err := fetchBundleCards(ctx, udb, bundleID)
+		if err != nil {
+			return nil, err
+		}
+		for _, card := range cards {
+			card.Rev = ""
+			userCards[card.ID] = card
+		}
+	}
+	notesByID := make(map[string]*fb.Note, len(notes))
+	for _, note := range notes {
+		notesByID[note.ID] = note
+	}
+	cards := make([]*fb.Card, 0)
+	seen := make(map[string]bool)
+	for _, deck := range decks {
+		for _, id := range deck.Cards.All() {
+			if seen[id] {
+				continue
+			}
+			seen[id] = true
+			if card, ok := userCards[id]; ok {
+				cards = append(cards, card)
+				continue
+			}
+			note, ok := notesByID[(&fb.Card{ID: id}).NoteID()]
+			if !ok {
+				return nil, errors.Errorf("card %s: note not found", id)
+			}
+			card, err := fb.NewCard(note.ThemeID, note.ModelID, id)
+			if err != nil {
+				return nil, errors.Wrapf(err, "card %s", id)
+			}
+			cards = append(cards, card)
+		}
+	}
+	return cards, nil
+}
+
+// fetchAttachments fetches the content of the attachments in fc, which are
+// returned by the database as stubs.
+func fetchAttachments(ctx context.Context, db attachmentGetter, docID string, fc *fb.FileCollection) error {
+	if fc == nil {
+		return nil
+	}
+	for _, filename := range fc.FileList() {
+		att, _ := fc.GetFile(filename)
+		if len(att.Content) != 0 {
+			continue
+		}
+		dbAtt, err := getAttachment(ctx, db, docID, filename)
+		if err != nil {
+			return errors.Wrapf(err, "fetch %s attachment %s", docID, filename)
+		}
+		att.Content = dbAtt.Content
+	}
+	return nil
+}
+
+// fetchThemes returns all of the themes stored in the bundle db.
+func fetchThemes(ctx context.Context, db finder) ([]*fb.Theme, error) {
+	themes := make([]*fb.Theme, 0)
+	err := findDocs(ctx, db, "theme", func(scan func(interface{}) error) error {
+		theme := &fb.Theme{}
+		if err := scan(theme); err != nil {
+			return err
+		}
+		themes = append(themes, theme)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return themes, nil
+}
+
+// fetchNotes returns all of the notes stored in the bundle db.
+func fetchNotes(ctx context.Context, db finder) ([]*fb.Note, error) {
+	notes := make([]*fb.Note, 0)
+	err := findDocs(ctx, db, "note", func(scan func(interface{}) error) error {
+		note := &fb.Note{}
+		if err := scan(note); err != nil {
+			return err
+		}
+		notes = append(notes, note)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return notes, nil
+}
+
+// bundleDocRange returns the range of IDs of the documents of docType which
+// belong to the bundle, such as its cards or reviews.
+func bundleDocRange(docType, bundleID string) (startKey, endKey string) {
+	startKey = docType + "-" + strings.TrimPrefix(bundleID, "bundle-") + "."
+	return startKey, startKey + string(rune(0x10FFFF))
+}
+
+// fetchBundleDocs calls fn for each document of docType in db which belongs
+// to the bundle. fn is passed a function which scans the document.
+func fetchBundleDocs(ctx context.Context, db allDocer, docType, bundleID string, fn func(scan func(interface{}) error) error) error {
+	startKey, endKey := bundleDocRange(docType, bundleID)
+	rows, err := db.AllDocs(ctx, map[string]interface{}{
+		"include_docs": true,
+		"start_key":    startKey,
+		"end_key":      endKey,
+	})
+	if err != nil {
+		return errors.Wrapf(err, "fetch %ss", docType)
+	}
+	defer func() { _ = rows.Close() }()
+	for rows.Next() {
+		if !inKeyRange(rows.ID(), startKey, endKey) {
+			continue
+		}
+		if err := fn(rows.ScanDoc); err != nil {
+			return errors.Wrapf(err, "scan %s %s", docType, rows.ID())
+		}
+	}
+	if err := rows.Err(); err != nil && err != io.EOF {
+		return err
+	}
+	return nil
+}
+
+// fetchBundleCards returns the user's cards which belong to the bundle.
+func fetchBundleCards(ctx context.Context, db allDocer, bundleID string) ([]*fb.Card, error) {
+	cards := make([]*fb.Card, 0)
+	err := fetchBundleDocs(ctx, db, "card", bundleID, func(scan func(interface{}) error) error {
+		card := &fb.Card{}
+		if err := scan(card); err != nil {
+			return err
+		}
+		cards = append(cards, card)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return cards, nil
+}
+
+// fetchBundleReviews returns the review history of the user's cards which
+// belong to the bundle.
+func fetchBundleReviews(ctx context.Context, db allDocer, bundleID string) ([]*fb.Review, error) {
+	reviews := make([]*fb.Review, 0)
+	err := fetchBundleDocs(ctx, db, "review", bundleID, func(scan func(interface{}) error) error {
+		review := &fb.Review{}
+		if err := scan(review); err != nil {
+			return err
+		}
+		review.Rev = ""
+		reviews = append(reviews, review)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return reviews, nil
+}
@@ -0,0 +1,388 @@
+package model
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/flimzy/diff"
+	"github.com/flimzy/kivik"
+	"github.com/flimzy/kivik/errors"
+
+	"github.com/FlashbackSRS/flashback/fb"
+)
+
+// attachmentClient wraps the memory driver, which discards attachments, to
+// store them separately, and return stubs for them, as PouchDB does.
+type attachmentClient struct {
+	kivikClient
+	attachments map[string]map[string]map[string]*fb.Attachment
+}
+
+func newAttachmentClient(t *testing.T) *attachmentClient {
+	local, err := localConnection()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &attachmentClient{
+		kivikClient: &docsClient{local},
+		attachments: make(map[string]map[string]map[string]*fb.Attachment),
+	}
+}
+
+func (c *attachmentClient) DB(ctx context.Context, dbName string, options ...kivik.Options) (kivikDB, error) {
+	db, err := c.kivikClient.DB(ctx, dbName, options...)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := c.attachments[dbName]; !ok {
+		c.attachments[dbName] = make(map[string]map[string]*fb.Attachment)
+	}
+	return &attachmentDB{kivikDB: db, attachments: c.attachments[dbName]}, nil
+}
+
+type attachmentDB struct {
+	kivikDB
+	attachments map[string]map[string]*fb.Attachment
+}
+
+func (db *attachmentDB) BulkDocs(ctx context.Context, docs interface{}) (kivikBulkResults, error) {
+	x, err := json.Marshal(docs)
+	if err != nil {
+		return nil, err
+	}
+	var maps []map[string]interface{}
+	if err := json.Unmarshal(x, &maps); err != nil {
+		return nil, err
+	}
+	for _, doc := range maps {
+		atts, ok := doc["_attachments"]
+		if !ok {
+			continue
+		}
+		delete(doc, "_attachments")
+		x, _ := json.Marshal(atts)
+		files := make(map[string]*fb.Attachment)
+		if err := json.Unmarshal(x, &files); err != nil {
+			return nil, err
+		}
+		db.attachments[doc["_id"].(string)] = files
+	}
+	return db.kivikDB.BulkDocs(ctx, maps)
+}
+
+func (db *attachmentDB) GetAttachment(_ context.Context, docID, _, filename string) (*kivik.Attachment, error) {
+	att, ok := db.attachments[docID][filename]
+	if !ok {
+		return nil, errors.Status(kivik.StatusNotFound, "attachment not found")
+	}
+	return &kivik.Attachment{
+		Filename:    filename,
+		ContentType: att.ContentType,
+		ReadCloser:  ioutil.NopCloser(bytes.NewReader(att.Content)),
+	}, nil
+}
+
+func (db *attachmentDB) Find(ctx context.Context, query interface{}) (kivikRows, error) {
+	rows, err := db.kivikDB.Find(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	return &attachmentRows{kivikRows: rows, db: db}, nil
+}
+
+func (db *attachmentDB) Get(ctx context.Context, docID string, options ...kivik.Options) (kivikRow, error) {
+	row, err := db.kivikDB.Get(ctx, docID, options...)
+	if err != nil {
+		return nil, err
+	}
+	return &attachmentRow{kivikRow: row, db: db}, nil
+}
+
+// scanDoc adds stubs for the document's attachments to its JSON.
+func (db *attachmentDB) scanDoc(scan func(interface{}) error, dest interface{}) error {
+	var doc map[string]interface{}
+	if err := scan(&doc); err != nil {
+		return err
+	}
+	if atts, ok := db.attachments[doc["_id"].(string)]; ok {
+		stubs := make(map[string]interface{}, len(atts))
+		for filename, att := range atts {
+			stubs[filename] = map[string]interface{}{
+				"content_type": att.ContentType,
+				"stub":         true,
+			}
+		}
+		doc["_attachments"] = stubs
+	}
+	x, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(x, dest)
+}
+
+type attachmentRow struct {
+	kivikRow
+	db *attachmentDB
+}
+
+func (r *attachmentRow) ScanDoc(dest interface{}) error {
+	return r.db.scanDoc(r.kivikRow.ScanDoc, dest)
+}
+
+type attachmentRows struct {
+	kivikRows
+	db *attachmentDB
+}
+
+func (r *attachmentRows) ScanDoc(dest interface{}) error {
+	return r.db.scanDoc(r.kivikRows.ScanDoc, dest)
+}
+
+const exportTestPackage = `{
+	"version": 2,
+	"bundle": {
+		"_id": "bundle-krsxg5baij2w4zdmmu",
+		"type": "bundle",
+		"created": "2016-07-31T15:08:24.730156517Z",
+		"modified": "2016-07-31T15:08:24.730156517Z",
+		"owner": "mjxwe",
+		"name": "Test Bundle"
+	},
+	"cards": [
+		{
+			"type": "card",
+			"_id": "card-krsxg5baij2w4zdmmu.VGVzdCBOb3Rl.0",
+			"created": "2016-07-31T15:08:24.730156517Z",
+			"modified": "2016-07-31T15:08:24.730156517Z",
+			"model": "theme-VGVzdCBUaGVtZQ/0",
+			"reviewCount": 3
+		}
+	],
+	"notes": [
+		{
+			"_id": "note-VGVzdCBOb3Rl",
+			"type": "note",
+			"created": "2016-07-31T15:08:24.730156517Z",
+			"modified": "2016-07-31T15:08:24.730156517Z",
+			"imported": "2016-08-02T15:08:24.730156517Z",
+			"theme": "theme-VGVzdCBUaGVtZQ",
+			"model": 0,
+			"fieldValues": [{"text": "cat"}]
+		}
+	],
+	"decks": [
+		{
+			"_id": "deck-VGVzdCBEZWNr",
+			"type": "deck",
+			"created": "2016-07-31T15:08:24.730156517Z",
+			"modified": "2016-07-31T15:08:24.730156517Z",
+			"imported": "2016-08-02T15:08:24.730156517Z",
+			"name": "Test Deck",
+			"cards": ["card-krsxg5baij2w4zdmmu.VGVzdCBOb3Rl.0"]
+		}
+	],
+	"themes": [
+		{
+			"_id": "theme-VGVzdCBUaGVtZQ",
+			"type": "theme",
+			"created": "2016-07-31T15:08:24.730156517Z",
+			"modified": "2016-07-31T15:08:24.730156517Z",
+			"imported": "2016-08-02T15:08:24.730156517Z",
+			"name": "Test Theme",
+			"models": [
+				{
+					"id": 0,
+					"modelType": "anki-basic",
+					"name": "Model A",
+					"templates": [],
+					"fields": [{"fieldType": 0, "name": "Word"}],
+					"files": ["m1.html"]
+				}
+			],
+			"_attachments": {
+				"$main.css": {
+					"content_type": "text/css",
+					"data": "LyogYW4gZW1wdHkgQ1NTIGZpbGUgKi8="
+				},
+				"m1.html": {
+					"content_type": "text/html",
+					"data": "PGh0bWw+PC9odG1sPg=="
+				}
+			},
+			"files": ["$main.css"],
+			"modelSequence": 2
+		}
+	],
+	"reviews": [
+		{
+			"cardID": "card-krsxg5baij2w4zdmmu.VGVzdCBOb3Rl.0",
+			"timestamp": "2017-01-01T01:01:01Z"
+		}
+	]
+}`
+
+func testExportRepo(t *testing.T, user string) *Repo {
+	local := newAttachmentClient(t)
+	if err := local.CreateDB(context.Background(), "user-"+user); err != nil {
+		t.Fatal(err)
+	}
+	return &Repo{user: user, local: local}
+}
+
+func TestExportBundle(t *testing.T) {
+	type tst struct {
+		name     string
+		repo     *Repo
+		bundleID string
+		opts     ExportOptions
+		err      string
+		// Expected exported values
+		reviewCount int
+		reviews     int
+	}
+	tests := []tst{
+		{
+			name: "not logged in",
+			repo: &Repo{},
+			err:  "not logged in",
+		},
+		{
+			name: "bundle not found",
+			repo: func() *Repo {
+				repo := testExportRepo(t, "mjxwe")
+				if err := repo.local.CreateDB(context.Background(), "bundle-krsxg5baij2w4zdmmu"); err != nil {
+					t.Fatal(err)
+				}
+				return repo
+			}(),
+			bundleID: "bundle-krsxg5baij2w4zdmmu",
+			err:      "fetch bundle: missing",
+		},
+	}
+	for _, opts := range []ExportOptions{{}, {Cards: true}, {Cards: true, Reviews: true}, {Reviews: true}} {
+		test := tst{
+			name: func() string {
+				x, _ := json.Marshal(opts)
+				return string(x)
+			}(),
+			repo: func() *Repo {
+				repo := testExportRepo(t, "mjxwe")
+				if err := repo.Import(context.Background(), strings.NewReader(exportTestPackage)); err != nil {
+					t.Fatal(err)
+				}
+				return repo
+			}(),
+			bundleID: "bundle-krsxg5baij2w4zdmmu",
+			opts:     opts,
+		}
+		if opts.Cards {
+			test.reviewCount = 3
+			if opts.Reviews {
+				test.reviews = 1
+			}
+		}
+		tests = append(tests, test)
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			buf := &bytes.Buffer{}
+			err := test.repo.ExportBundle(context.Background(), test.bundleID, buf, test.opts)
+			checkErr(t, test.err, err)
+			if err != nil {
+				return
+			}
+			z, err := gzip.NewReader(bytes.NewReader(buf.Bytes()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			pkg := &fb.Package{}
+			if e := json.NewDecoder(z).Decode(pkg); e != nil {
+				t.Fatal(e)
+			}
+			if pkg.Bundle.ID != test.bundleID || pkg.Bundle.Rev != "" {
+				t.Errorf("Unexpected bundle %s, rev %s", pkg.Bundle.ID, pkg.Bundle.Rev)
+			}
+			if len(pkg.Themes) != 1 || len(pkg.Notes) != 1 || len(pkg.Decks) != 1 || len(pkg.Cards) != 1 {
+				t.Fatalf("Unexpected package contents: %d themes, %d notes, %d decks, %d cards",
+					len(pkg.Themes), len(pkg.Notes), len(pkg.Decks), len(pkg.Cards))
+			}
+			css, _ := pkg.Themes[0].Attachments.GetFile("$main.css")
+			if css == nil || string(css.Content) != "/* an empty CSS file */" {
+				t.Errorf("Unexpected theme attachment: %v", css)
+			}
+			if rc := pkg.Cards[0].ReviewCount; rc != test.reviewCount {
+				t.Errorf("Unexpected review count %d", rc)
+			}
+			if len(pkg.Reviews) != test.reviews {
+				t.Errorf("Unexpected %d reviews", len(pkg.Reviews))
+			}
+
+			// The export must import cleanly for another user
+			other := testExportRepo(t, "other")
+			if e := other.ImportFile(context.Background(), &mockFile{body: buf.Bytes()}); e != nil {
+				t.Fatal(e)
+			}
+			udb, err := other.userDB(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			card := &fb.Card{}
+			if e := getDoc(context.Background(), udb, pkg.Cards[0].ID, card); e != nil {
+				t.Fatal(e)
+			}
+			if card.ReviewCount != test.reviewCount {
+				t.Errorf("Unexpected imported review count %d", card.ReviewCount)
+			}
+		})
+	}
+}
+
+func TestExportCards(t *testing.T) {
+	note := &fb.Note{ID: "note-VGVzdCBOb3Rl", ThemeID: "theme-VGVzdCBUaGVtZQ", ModelID: 0}
+	decks := []*fb.Deck{
+		{Cards: fb.NewCardCollection()},
+		{Cards: fb.NewCardCollection()},
+	}
+	decks[0].AddCard("card-krsxg5baij2w4zdmmu.VGVzdCBOb3Rl.0")
+	decks[1].AddCard("card-krsxg5baij2w4zdmmu.VGVzdCBOb3Rl.0")
+	cards, err := exportCards(context.Background(), nil, "bundle-krsxg5baij2w4zdmmu", []*fb.Note{note}, decks, ExportOptions{})
+	checkErr(t, "", err)
+	if len(cards) != 1 || cards[0].ID != "card-krsxg5baij2w4zdmmu.VGVzdCBOb3Rl.0" || cards[0].ModelID != "theme-VGVzdCBUaGVtZQ/0" {
+		t.Errorf("Unexpected cards: %v", cards)
+	}
+
+	decks[1].AddCard("card-krsxg5baij2w4zdmmu.TWlzc2luZw.0")
+	_, err = exportCards(context.Background(), nil, "bundle-krsxg5baij2w4zdmmu", []*fb.Note{note}, decks, ExportOptions{})
+	checkErr(t, "card card-krsxg5baij2w4zdmmu.TWlzc2luZw.0: note not found", err)
+
+	_, err = exportCards(context.Background(), &mockAllDocer{err: errors.New("all docs failed")}, "bundle-krsxg5baij2w4zdmmu", nil, decks, ExportOptions{Cards: true})
+	checkErr(t, "fetch cards: all docs failed", err)
+}
+
+func TestFetchBundleCards(t *testing.T) {
+	db := saveCards(t, testClient(t),
+		&fb.Card{ID: "card-foo.bar.0"},
+		&fb.Card{ID: "card-foo.baz.1"},
+		&fb.Card{ID: "card-foo2.bar.0"},
+		&fb.Card{ID: "card-fop.bar.0"},
+	)
+	cards, err := fetchBundleCards(context.Background(), db, "bundle-foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := make([]string, len(cards))
+	for i, card := range cards {
+		ids[i] = card.ID
+	}
+	sort.Strings(ids)
+	if d := diff.Interface([]string{"card-foo.bar.0", "card-foo.baz.1"}, ids); d != nil {
+		t.Error(d)
+	}
+}
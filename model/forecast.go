@@ -0,0 +1,89 @@
+package model
+
+import (
+	"context"
+
+	"github.com/FlashbackSRS/flashback/fb"
+	"github.com/pkg/errors"
+)
+
+// DueCounts counts due cards, keyed by bundle ID.
+type DueCounts map[string]int
+
+// Total returns the number of due cards in all bundles.
+func (c DueCounts) Total() int {
+	var total int
+	for _, n := range c {
+		total += n
+	}
+	return total
+}
+
+// ForecastDay holds the number of cards which will become due on Day.
+type ForecastDay struct {
+	Day fb.Due    `json:"day"`
+	Due DueCounts `json:"due"`
+}
+
+// Forecast is the predicted review workload.
+type Forecast struct {
+	// Overdue counts cards which were due before today, and have not been
+	// reviewed.
+	Overdue DueCounts `json:"overdue"`
+	// Days holds the cards becoming due on each day, starting with today.
+	Days []*ForecastDay `json:"days"`
+}
+
+// Forecast returns the number of cards which will become due on each of the
+// next 'days' days, starting with today, assuming no reviews are done in the
+// meantime. Buried cards are counted on the day they are unburied, and new
+// and suspended cards are not counted.
+func (r *Repo) Forecast(ctx context.Context, days int) (*Forecast, error) {
+	if days < 0 {
+		return nil, errors.Errorf("invalid number of days: %d", days)
+	}
+	udb, err := r.userDB(ctx)
+	if err != nil {
+		return nil, err
+	}
+	cards, err := findCards(ctx, udb, nil)
+	if err != nil {
+		return nil, err
+	}
+	clock, err := r.studyClock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return forecast(cards, clock, clock.today(), days), nil
+}
+
+func forecast(cards []*fb.Card, clock studyClock, today fb.Due, days int) *Forecast {
+	f := &Forecast{
+		Overdue: DueCounts{},
+		Days:    make([]*ForecastDay, days),
+	}
+	for i := range f.Days {
+		f.Days[i] = &ForecastDay{
+			Day: today.Add(fb.Interval(i) * fb.Day),
+			Due: DueCounts{},
+		}
+	}
+	for _, card := range cards {
+		if card.Suspended || card.Due.IsZero() {
+			continue
+		}
+		due := card.Due
+		if card.BuriedUntil.After(due) {
+			due = card.BuriedUntil
+		}
+		day := clock.on(clock.time(due))
+		if today.After(day) {
+			f.Overdue[card.BundleID()]++
+			continue
+		}
+		if i := int(day.Sub(today) / fb.Day); i < days {
+			f.Days[i].Due[card.BundleID()]++
+		}
+	}
+	return f
+}
@@ -0,0 +1,115 @@
+package model
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/FlashbackSRS/flashback/fb"
+	"github.com/flimzy/diff"
+)
+
+func TestForecast(t *testing.T) {
+	today := parseDue(t, "2017-01-10")
+	tests := []struct {
+		name     string
+		cards    []*fb.Card
+		days     int
+		expected *Forecast
+	}{
+		{
+			name:     "no cards",
+			days:     1,
+			expected: &Forecast{Overdue: DueCounts{}, Days: []*ForecastDay{{Day: today, Due: DueCounts{}}}},
+		},
+		{
+			name: "mixed",
+			cards: []*fb.Card{
+				{ID: "card-foo.bar.0"},                                                  // new
+				{ID: "card-foo.bar.1", Due: parseDue(t, "2017-01-05")},                  // overdue
+				{ID: "card-foo.bar.2", Due: parseDue(t, "2017-01-10 15:00:00")},         // today
+				{ID: "card-foo.bar.3", Due: parseDue(t, "2017-01-12")},                  // day 2
+				{ID: "card-baz.qux.0", Due: parseDue(t, "2017-01-12")},                  // day 2
+				{ID: "card-foo.bar.4", Due: parseDue(t, "2017-01-13")},                  // out of range
+				{ID: "card-foo.bar.5", Due: parseDue(t, "2017-01-05"), Suspended: true}, // suspended
+				{ // buried
+					ID:          "card-baz.qux.1",
+					Due:         parseDue(t, "2017-01-09"),
+					BuriedUntil: parseDue(t, "2017-01-11"),
+				},
+			},
+			days: 3,
+			expected: &Forecast{
+				Overdue: DueCounts{"bundle-foo": 1},
+				Days: []*ForecastDay{
+					{Day: today, Due: DueCounts{"bundle-foo": 1}},
+					{Day: parseDue(t, "2017-01-11"), Due: DueCounts{"bundle-baz": 1}},
+					{Day: parseDue(t, "2017-01-12"), Due: DueCounts{"bundle-foo": 1, "bundle-baz": 1}},
+				},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result := forecast(test.cards, studyClock{}, today, test.days)
+			if d := diff.Interface(test.expected, result); d != nil {
+				t.Error(d)
+			}
+		})
+	}
+}
+
+func TestDueCountsTotal(t *testing.T) {
+	if total := (DueCounts{"bundle-foo": 2, "bundle-bar": 3}).Total(); total != 5 {
+		t.Errorf("Unexpected total: %d", total)
+	}
+}
+
+func TestRepoForecast(t *testing.T) {
+	ctx := context.Background()
+	t.Run("not logged in", func(t *testing.T) {
+		_, err := (&Repo{}).Forecast(ctx, 1)
+		checkErr(t, "not logged in", err)
+	})
+	t.Run("invalid days", func(t *testing.T) {
+		_, err := (&Repo{}).Forecast(ctx, -1)
+		checkErr(t, "invalid number of days: -1", err)
+	})
+	t.Run("success", func(t *testing.T) {
+		defer func(n func() time.Time) { now = n }(now)
+		now = func() time.Time { return parseTime(t, "2017-01-10T12:00:00Z") }
+		repo := &Repo{user: "mjxwe", local: testClient(t)}
+		if err := repo.local.CreateDB(ctx, "user-mjxwe"); err != nil {
+			t.Fatal(err)
+		}
+		udb, err := repo.userDB(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		card := &fb.Card{
+			ID:       "card-foo.bar.0",
+			ModelID:  "theme-foo/0",
+			Created:  now(),
+			Modified: now(),
+			Due:      parseDue(t, "2017-01-11"),
+			Interval: fb.Day,
+		}
+		if err := saveDoc(ctx, udb, card); err != nil {
+			t.Fatal(err)
+		}
+		result, err := repo.Forecast(ctx, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := &Forecast{
+			Overdue: DueCounts{},
+			Days: []*ForecastDay{
+				{Day: parseDue(t, "2017-01-10"), Due: DueCounts{}},
+				{Day: parseDue(t, "2017-01-11"), Due: DueCounts{"bundle-foo": 1}},
+			},
+		}
+		if d := diff.Interface(expected, result); d != nil {
+			t.Error(d)
+		}
+	})
+}
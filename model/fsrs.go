@@ -0,0 +1,245 @@
+package model
+
+import (
+	"context"
+	"math"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/FlashbackSRS/flashback"
+	"github.com/FlashbackSRS/flashback/fb"
+)
+
+func init() {
+	RegisterScheduler(&fsrs{})
+}
+
+// FSRSScheduler is the name of the FSRS scheduler.
+const FSRSScheduler = "fsrs"
+
+// DefaultTargetRetention is the probability of recall the FSRS scheduler aims
+// for, when no other target is configured.
+const DefaultTargetRetention = 0.9
+
+// FSRS bounds
+const (
+	fsrsMinDifficulty = 1.0
+	fsrsMaxDifficulty = 10.0
+	fsrsMinStability  = 0.01
+	fsrsMaxInterval   = 36500 * fb.Day
+)
+
+// fsrsWeights are the default FSRS-4.5 model parameters.
+var fsrsWeights = [17]float64{
+	0.4872, 1.4003, 3.7145, 13.8206, 5.1618, 1.2298, 0.8975, 0.031, 1.6474,
+	0.1367, 1.0461, 2.1072, 0.0793, 0.3246, 1.587, 0.2272, 2.8755,
+}
+
+// The forgetting curve parameters, such that R(S, S) = 0.9.
+const (
+	fsrsDecay  = -0.5
+	fsrsFactor = 19.0 / 81.0
+)
+
+// fsrsGrade is an FSRS answer rating, from 1 (Again) to 4 (Easy).
+type fsrsGrade int
+
+const (
+	gradeAgain fsrsGrade = iota + 1
+	gradeHard
+	gradeGood
+	gradeEasy
+)
+
+func toFSRSGrade(q flashback.AnswerQuality) fsrsGrade {
+	switch {
+	case q <= flashback.AnswerIncorrectEasy:
+		return gradeAgain
+	case q == flashback.AnswerCorrectDifficult:
+		return gradeHard
+	case q == flashback.AnswerCorrect:
+		return gradeGood
+	}
+	return gradeEasy
+}
+
+// fsrs implements the Free Spaced Repetition Scheduler, which models each
+// card's memory state as difficulty, stability and retrievability. See
+// https://github.com/open-spaced-repetition/fsrs4anki/wiki/The-Algorithm
+type fsrs struct{}
+
+var _ Scheduler = &fsrs{}
+
+// Name returns "fsrs".
+func (s *fsrs) Name() string {
+	return FSRSScheduler
+}
+
+// Schedule updates the card's memory state, and schedules the next review
+// such that the predicted recall probability at that time equals the target
+// retention. answerDelay is ignored.
+func (s *fsrs) Schedule(card *Card, _ time.Duration, quality flashback.AnswerQuality) error {
+	retention := card.settings().TargetRetention
+	grade := toFSRSGrade(quality)
+	seedFSRS(card.Card, retention)
+	if card.Stability == 0 {
+		card.Stability = fsrsInitialStability(grade)
+		card.Difficulty = fsrsInitialDifficulty(grade)
+	} else {
+		r := fsrsRetrievability(fsrsElapsedDays(card.Card, now()), card.Stability)
+		if grade == gradeAgain {
+			card.Stability = fsrsForgetStability(card.Difficulty, card.Stability, r)
+		} else {
+			card.Stability = fsrsRecallStability(card.Difficulty, card.Stability, r, grade)
+		}
+		card.Difficulty = fsrsNextDifficulty(card.Difficulty, grade)
+	}
+
+	ivl := fsrsInterval(card.Stability, retention)
+	if grade == gradeAgain {
+		ivl = flashback.LapseInterval
+		card.ReviewCount = 0
+	} else {
+		card.ReviewCount++
+	}
+	card.Due = card.clock.dueIn(ivl)
+	card.Interval = ivl
+	card.LastReview = now().UTC()
+	return nil
+}
+
+// fsrsElapsedDays returns the number of days since the card was last
+// reviewed.
+func fsrsElapsedDays(card *fb.Card, now time.Time) float64 {
+	last := card.LastReview
+	if last.IsZero() {
+		last = time.Time(card.Due.Add(-card.Interval))
+	}
+	elapsed := now.Sub(last).Hours() / 24
+	if elapsed < 0 {
+		return 0
+	}
+	return elapsed
+}
+
+// fsrsRetrievability returns the predicted probability of recall after
+// elapsed days, for a memory of the given stability.
+func fsrsRetrievability(elapsed, stability float64) float64 {
+	return math.Pow(1+fsrsFactor*elapsed/stability, fsrsDecay)
+}
+
+// round returns x rounded to the nearest integer, with halves rounded up. It
+// stands in for math.Round, which requires Go 1.10.
+func round(x float64) float64 {
+	return math.Floor(x + 0.5)
+}
+
+// fsrsInterval returns the interval at which the predicted probability of
+// recall drops to retention. The result is rounded to whole days.
+func fsrsInterval(stability, retention float64) fb.Interval {
+	days := round(stability / fsrsFactor * (math.Pow(retention, 1/fsrsDecay) - 1))
+	if days < 1 {
+		days = 1
+	}
+	ivl := fb.Interval(days) * fb.Day
+	if ivl > fsrsMaxInterval {
+		return fsrsMaxInterval
+	}
+	return ivl
+}
+
+func fsrsInitialStability(g fsrsGrade) float64 {
+	return math.Max(fsrsWeights[g-1], fsrsMinStability)
+}
+
+func fsrsInitialDifficulty(g fsrsGrade) float64 {
+	return clampDifficulty(fsrsWeights[4] - float64(g-3)*fsrsWeights[5])
+}
+
+func fsrsNextDifficulty(d float64, g fsrsGrade) float64 {
+	next := d - fsrsWeights[6]*float64(g-3)
+	// Mean reversion toward the initial difficulty of a 'Good' answer
+	return clampDifficulty(fsrsWeights[7]*fsrsInitialDifficulty(gradeGood) + (1-fsrsWeights[7])*next)
+}
+
+func fsrsRecallStability(d, s, r float64, g fsrsGrade) float64 {
+	hardPenalty, easyBonus := 1.0, 1.0
+	switch g {
+	case gradeHard:
+		hardPenalty = fsrsWeights[15]
+	case gradeEasy:
+		easyBonus = fsrsWeights[16]
+	}
+	return s * (1 + math.Exp(fsrsWeights[8])*
+		(11-d)*
+		math.Pow(s, -fsrsWeights[9])*
+		(math.Exp(fsrsWeights[10]*(1-r))-1)*
+		hardPenalty*
+		easyBonus)
+}
+
+func fsrsForgetStability(d, s, r float64) float64 {
+	next := fsrsWeights[11] *
+		math.Pow(d, -fsrsWeights[12]) *
+		(math.Pow(s+1, fsrsWeights[13]) - 1) *
+		math.Exp(fsrsWeights[14]*(1-r))
+	return math.Max(math.Min(next, s), fsrsMinStability)
+}
+
+func clampDifficulty(d float64) float64 {
+	return math.Min(math.Max(d, fsrsMinDifficulty), fsrsMaxDifficulty)
+}
+
+// seedFSRS initializes the card's FSRS memory state from its SM-2 scheduling
+// history, if it has been reviewed but has no memory state yet. Stability is
+// chosen so that the card's current interval corresponds to the target
+// retention, and difficulty so that a successful review would grow the
+// stability by the card's ease factor, as SM-2 would have done.
+func seedFSRS(card *fb.Card, retention float64) bool {
+	if card.Stability != 0 || card.ReviewCount == 0 || card.Interval < fb.Day {
+		return false
+	}
+	days := float64(card.Interval) / float64(fb.Day)
+	s := math.Max(days*fsrsFactor/(math.Pow(retention, 1/fsrsDecay)-1), fsrsMinStability)
+	ease := float64(card.EaseFactor)
+	if ease == 0 {
+		ease = float64(flashback.InitialEase)
+	}
+	growth := math.Exp(fsrsWeights[8]) *
+		math.Pow(s, -fsrsWeights[9]) *
+		(math.Exp(fsrsWeights[10]*(1-retention)) - 1)
+	card.Stability = s
+	card.Difficulty = clampDifficulty(11 - (ease-1)/growth)
+	return true
+}
+
+// SeedFSRS initializes the FSRS memory state of every previously-reviewed
+// card which doesn't have one, based on its SM-2 ease, interval and review
+// count. It returns the number of cards updated. It is safe to run more than
+// once; cards which are scheduled by FSRS without seeding are seeded
+// automatically on their next review.
+func (r *Repo) SeedFSRS(ctx context.Context) (int, error) {
+	udb, err := r.userDB(ctx)
+	if err != nil {
+		return 0, err
+	}
+	s, err := fetchSettings(ctx, udb)
+	if err != nil {
+		return 0, err
+	}
+	cards, err := findCards(ctx, udb, nil)
+	if err != nil {
+		return 0, err
+	}
+	seeded := make([]*fb.Card, 0, len(cards))
+	for _, card := range cards {
+		if seedFSRS(card, s.For(card.BundleID()).TargetRetention) {
+			seeded = append(seeded, card)
+		}
+	}
+	if len(seeded) == 0 {
+		return 0, nil
+	}
+	return len(seeded), errors.Wrap(updateDocs(ctx, udb, seeded), "update cards")
+}
@@ -0,0 +1,248 @@
+package model
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/FlashbackSRS/flashback"
+	"github.com/FlashbackSRS/flashback/fb"
+)
+
+func TestFSRSSchedule(t *testing.T) {
+	defer func(n func() time.Time) { now = n }(now)
+	now = func() time.Time { return parseTime(t, "2017-01-11T00:00:00Z") }
+	type expected struct {
+		stability, difficulty float64
+		interval              fb.Interval
+		reviewCount           int
+	}
+	tests := []struct {
+		name     string
+		card     *fb.Card
+		config   *DeckSettings
+		quality  flashback.AnswerQuality
+		expected expected
+	}{
+		{
+			name:     "new card, again",
+			card:     &fb.Card{},
+			quality:  flashback.AnswerBlackout,
+			expected: expected{0.4872, 7.6214, flashback.LapseInterval, 0},
+		},
+		{
+			name:     "new card, good",
+			card:     &fb.Card{},
+			quality:  flashback.AnswerCorrect,
+			expected: expected{3.7145, 5.1618, 4 * fb.Day, 1},
+		},
+		{
+			name:     "new card, easy",
+			card:     &fb.Card{},
+			quality:  flashback.AnswerPerfect,
+			expected: expected{13.8206, 3.932, 14 * fb.Day, 1},
+		},
+		{
+			name:     "new card, good, lower retention",
+			card:     &fb.Card{},
+			config:   &DeckSettings{TargetRetention: 0.8},
+			quality:  flashback.AnswerCorrect,
+			expected: expected{3.7145, 5.1618, 9 * fb.Day, 1},
+		},
+		{
+			name: "on time review, hard",
+			card: &fb.Card{
+				Stability:   10,
+				Difficulty:  5,
+				ReviewCount: 2,
+				LastReview:  parseTime(t, "2017-01-01T00:00:00Z"),
+			},
+			quality:  flashback.AnswerCorrectDifficult,
+			expected: expected{15.699, 5.8747, 16 * fb.Day, 3},
+		},
+		{
+			name: "on time review, good",
+			card: &fb.Card{
+				Stability:   10,
+				Difficulty:  5,
+				ReviewCount: 2,
+				LastReview:  parseTime(t, "2017-01-01T00:00:00Z"),
+			},
+			quality:  flashback.AnswerCorrect,
+			expected: expected{35.0839, 5.005, 35 * fb.Day, 3},
+		},
+		{
+			name: "on time review, easy",
+			card: &fb.Card{
+				Stability:   10,
+				Difficulty:  5,
+				ReviewCount: 2,
+				LastReview:  parseTime(t, "2017-01-01T00:00:00Z"),
+			},
+			quality:  flashback.AnswerPerfect,
+			expected: expected{82.1287, 4.1353, 82 * fb.Day, 3},
+		},
+		{
+			name: "on time review, lapse",
+			card: &fb.Card{
+				Stability:   10,
+				Difficulty:  5,
+				ReviewCount: 2,
+				LastReview:  parseTime(t, "2017-01-01T00:00:00Z"),
+			},
+			quality:  flashback.AnswerBlackout,
+			expected: expected{2.5604, 6.7444, flashback.LapseInterval, 0},
+		},
+		{
+			name: "unseeded SM-2 card",
+			card: &fb.Card{
+				Interval:    10 * fb.Day,
+				EaseFactor:  2.5,
+				ReviewCount: 2,
+				Due:         parseDue(t, "2017-01-11"),
+			},
+			quality:  flashback.AnswerCorrect,
+			expected: expected{25, 7.3423, 25 * fb.Day, 3},
+		},
+	}
+	s := &fsrs{}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			card := &Card{Card: test.card}
+			if test.config != nil {
+				config := defaultDeckSettings
+				config.merge(test.config)
+				card.config = &config
+			}
+			if err := s.Schedule(card, time.Second, test.quality); err != nil {
+				t.Fatal(err)
+			}
+			if !floatCompare(card.Stability, test.expected.stability) {
+				t.Errorf("Unexpected stability: %f", card.Stability)
+			}
+			if !floatCompare(card.Difficulty, test.expected.difficulty) {
+				t.Errorf("Unexpected difficulty: %f", card.Difficulty)
+			}
+			if card.Interval != test.expected.interval {
+				t.Errorf("Unexpected interval: %s", card.Interval)
+			}
+			if card.ReviewCount != test.expected.reviewCount {
+				t.Errorf("Unexpected review count: %d", card.ReviewCount)
+			}
+			if due := fb.Due(now()).Add(test.expected.interval); !card.Due.Equal(due) {
+				t.Errorf("Unexpected due: %s", card.Due)
+			}
+			if !card.LastReview.Equal(now()) {
+				t.Errorf("Unexpected last review: %s", card.LastReview)
+			}
+		})
+	}
+}
+
+func TestSeedFSRS(t *testing.T) {
+	tests := []struct {
+		name                  string
+		card                  *fb.Card
+		retention             float64
+		seeded                bool
+		stability, difficulty float64
+	}{
+		{
+			name:      "new card",
+			card:      &fb.Card{},
+			retention: 0.9,
+		},
+		{
+			name:      "learning card",
+			card:      &fb.Card{Interval: 10 * fb.Minute, ReviewCount: 1},
+			retention: 0.9,
+		},
+		{
+			name:       "already seeded",
+			card:       &fb.Card{Interval: 10 * fb.Day, ReviewCount: 1, Stability: 3, Difficulty: 4},
+			retention:  0.9,
+			stability:  3,
+			difficulty: 4,
+		},
+		{
+			name:       "max ease",
+			card:       &fb.Card{Interval: 10 * fb.Day, ReviewCount: 3, EaseFactor: 2.5},
+			retention:  0.9,
+			seeded:     true,
+			stability:  10,
+			difficulty: 7.412,
+		},
+		{
+			name:       "min ease",
+			card:       &fb.Card{Interval: 10 * fb.Day, ReviewCount: 3, EaseFactor: 1.3},
+			retention:  0.9,
+			seeded:     true,
+			stability:  10,
+			difficulty: 10,
+		},
+		{
+			name:       "lower retention",
+			card:       &fb.Card{Interval: 10 * fb.Day, ReviewCount: 3, EaseFactor: 2.5},
+			retention:  0.8,
+			seeded:     true,
+			stability:  4.1701,
+			difficulty: 9.4914,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			seeded := seedFSRS(test.card, test.retention)
+			if seeded != test.seeded {
+				t.Errorf("Unexpected seeded result: %t", seeded)
+			}
+			if !floatCompare(test.card.Stability, test.stability) {
+				t.Errorf("Unexpected stability: %f", test.card.Stability)
+			}
+			if !floatCompare(test.card.Difficulty, test.difficulty) {
+				t.Errorf("Unexpected difficulty: %f", test.card.Difficulty)
+			}
+		})
+	}
+}
+
+func TestRepoSeedFSRS(t *testing.T) {
+	t.Run("not logged in", func(t *testing.T) {
+		_, err := (&Repo{}).SeedFSRS(context.Background())
+		checkErr(t, "not logged in", err)
+	})
+	t.Run("success", func(t *testing.T) {
+		repo := &Repo{user: "mjxwe", local: testClient(t)}
+		if err := repo.local.CreateDB(context.Background(), "user-mjxwe"); err != nil {
+			t.Fatal(err)
+		}
+		udb, err := repo.userDB(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		cards := []*fb.Card{
+			{ID: "card-foo.bar.0", ModelID: "theme-foo/0", Interval: 10 * fb.Day, ReviewCount: 3, EaseFactor: 2.5},
+			{ID: "card-foo.bar.1", ModelID: "theme-foo/0"},
+		}
+		for _, card := range cards {
+			card.Created = now()
+			card.Modified = now()
+			if err := saveDoc(context.Background(), udb, card); err != nil {
+				t.Fatal(err)
+			}
+		}
+		count, err := repo.SeedFSRS(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count != 1 {
+			t.Errorf("Unexpected count: %d", count)
+		}
+		card := &fb.Card{}
+		if err := getDoc(context.Background(), udb, "card-foo.bar.0", card); err != nil {
+			t.Fatal(err)
+		}
+		if !floatCompare(card.Stability, 10) {
+			t.Errorf("Unexpected stability: %f", card.Stability)
+		}
+	})
+}
@@ -0,0 +1,37 @@
+package model
+
+import (
+	"math"
+
+	"github.com/FlashbackSRS/flashback/fb"
+)
+
+// FuzzThreshold is the minimum interval to which fuzz is applied.
+const FuzzThreshold = 3 * fb.Day
+
+// fuzzRange returns the maximum number of days by which an interval of the
+// given number of days may be fuzzed, in either direction.
+func fuzzRange(days int) int {
+	var ratio float64
+	switch {
+	case days < 7:
+		ratio = 0.25
+	case days < 30:
+		ratio = 0.15
+	default:
+		ratio = 0.05
+	}
+	return int(math.Max(1, round(float64(days)*ratio)))
+}
+
+// fuzzInterval randomly adjusts intervals of at least FuzzThreshold by up to
+// a few days, so that cards learned together don't stay together. The fuzz is
+// drawn from rnd, which tests may replace with a seeded source.
+func fuzzInterval(ivl fb.Interval) fb.Interval {
+	if ivl < FuzzThreshold {
+		return ivl
+	}
+	days := ivl.Days()
+	fuzz := fuzzRange(days)
+	return fb.Interval(days-fuzz+rnd.Intn(2*fuzz+1)) * fb.Day
+}
@@ -0,0 +1,64 @@
+package model
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/FlashbackSRS/flashback/fb"
+)
+
+func TestFuzzRange(t *testing.T) {
+	tests := []struct {
+		days     int
+		expected int
+	}{
+		{days: 3, expected: 1},
+		{days: 6, expected: 2},
+		{days: 7, expected: 1},
+		{days: 20, expected: 3},
+		{days: 30, expected: 2},
+		{days: 365, expected: 18},
+	}
+	for _, test := range tests {
+		t.Run(fmt.Sprintf("%dd", test.days), func(t *testing.T) {
+			if r := fuzzRange(test.days); r != test.expected {
+				t.Errorf("Unexpected range: %d", r)
+			}
+		})
+	}
+}
+
+func TestFuzzInterval(t *testing.T) {
+	t.Run("below threshold", func(t *testing.T) {
+		for _, ivl := range []fb.Interval{10 * fb.Minute, fb.Day, 2 * fb.Day} {
+			if result := fuzzInterval(ivl); result != ivl {
+				t.Errorf("%s fuzzed to %s", ivl, result)
+			}
+		}
+	})
+	t.Run("drawn from rnd", func(t *testing.T) {
+		defer func(orig *rand.Rand) { rnd = orig }(rnd)
+		rnd = rand.New(rand.NewSource(1))
+		first := fuzzInterval(100*fb.Day)
+		rnd = rand.New(rand.NewSource(1))
+		if result := fuzzInterval(100*fb.Day); result != first {
+			t.Errorf("Same seed gave different fuzz: %s != %s", result, first)
+		}
+	})
+	t.Run("spread", func(t *testing.T) {
+		defer func(orig *rand.Rand) { rnd = orig }(rnd)
+		rnd = rand.New(rand.NewSource(1))
+		seen := make(map[fb.Interval]int)
+		for i := 0; i < 100; i++ {
+			ivl := fuzzInterval(100*fb.Day)
+			if ivl < 95*fb.Day || ivl > 105*fb.Day {
+				t.Fatalf("Fuzzed interval %s out of range", ivl)
+			}
+			seen[ivl]++
+		}
+		if len(seen) != 11 {
+			t.Errorf("Expected all 11 possible intervals, got %d", len(seen))
+		}
+	})
+}
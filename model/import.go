@@ -5,22 +5,56 @@ import (
 	"compress/gzip"
 	"context"
 	"encoding/json"
+	"fmt"
 	"io"
+	"io/ioutil"
 
 	"github.com/flimzy/kivik"
 	"github.com/flimzy/log"
 	multierror "github.com/hashicorp/go-multierror"
 	"github.com/pkg/errors"
 
-	fb "github.com/FlashbackSRS/flashback-model"
+	"github.com/FlashbackSRS/flashback/fb"
 )
 
 type inputFile interface {
 	Bytes() ([]byte, error)
 }
 
-// ImportFile imports a *.fbb file, as from an HTML form submission.
+// zipMagic identifies a zip file, such as an Anki package.
+var zipMagic = []byte("PK\x03\x04")
+
+const (
+	// importBatchSize is the maximum number of documents stored at once.
+	importBatchSize = 100
+	// importBatchBytes is the maximum JSON size of the documents stored at
+	// once, so that a few media-heavy notes don't fill a batch on their own.
+	importBatchBytes = 4 << 20
+)
+
+// ImportProgress reports how far an import has got.
+type ImportProgress struct {
+	// Docs is the number of documents stored so far, out of Total. Total is
+	// zero while the package is first read to validate it.
+	Docs  int
+	Total int
+	// Bytes is the number of bytes of the file read so far in the current
+	// pass over it, out of TotalBytes. TotalBytes is zero when the package
+	// isn't read from a file.
+	Bytes      int64
+	TotalBytes int64
+}
+
+// ImportFile imports a *.fbb file, or an Anki *.apkg or *.colpkg file, as from
+// an HTML form submission. Anki packages must be in the legacy format; see
+// ImportAnki.
 func (r *Repo) ImportFile(ctx context.Context, f inputFile) error {
+	return r.ImportFileProgress(ctx, f, nil)
+}
+
+// ImportFileProgress is like ImportFile, but calls progress, if it is not nil,
+// as the import proceeds.
+func (r *Repo) ImportFileProgress(ctx context.Context, f inputFile, progress func(ImportProgress)) error {
 	if _, err := r.CurrentUser(); err != nil {
 		return err
 	}
@@ -28,64 +62,533 @@ func (r *Repo) ImportFile(ctx context.Context, f inputFile) error {
 	if err != nil {
 		return err
 	}
-	z, err := gzip.NewReader(bytes.NewReader(b))
-	if err != nil {
+	if bytes.HasPrefix(b, zipMagic) {
+		return r.importAnki(ctx, b, progress)
+	}
+	if _, err := gzip.NewReader(bytes.NewReader(b)); err != nil {
 		return err
 	}
-	defer func() { _ = z.Close() }()
-	return r.Import(ctx, z)
+	open := func() (io.Reader, *countingReader, error) {
+		c := &countingReader{r: bytes.NewReader(b)}
+		z, err := gzip.NewReader(c)
+		return z, c, err
+	}
+	return r.importStream(ctx, open, int64(len(b)), progress)
 }
 
-// Import imports a .fbb file and stores the content
+// Import imports a .fbb file and stores the content. The package is read
+// twice, so unless f is an io.ReadSeeker, it is first read into memory in
+// full. Large packages should therefore be passed as an io.ReadSeeker, such
+// as an *os.File, so that they are streamed rather than buffered.
 func (r *Repo) Import(ctx context.Context, f io.Reader) error {
+	if _, err := r.CurrentUser(); err != nil {
+		return err
+	}
+	rs, ok := f.(io.ReadSeeker)
+	if !ok {
+		b, err := ioutil.ReadAll(f)
+		if err != nil {
+			return err
+		}
+		rs = bytes.NewReader(b)
+	}
+	start, err := rs.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	end, err := rs.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	open := func() (io.Reader, *countingReader, error) {
+		if _, err := rs.Seek(start, io.SeekStart); err != nil {
+			return nil, nil, err
+		}
+		c := &countingReader{r: rs}
+		return c, c, nil
+	}
+	return r.importStream(ctx, open, end-start, nil)
+}
+
+// countingReader counts the bytes read from r.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// packageOpener returns a reader for the JSON content of a package, and the
+// counter of bytes read from the underlying file. It is called once for each
+// pass over the package.
+type packageOpener func() (io.Reader, *countingReader, error)
+
+// importStream imports the package read by open without holding all of it
+// in memory. A first pass validates the package and counts its documents,
+// keeping only the bundle, the themes and enough to check the references
+// between documents. A second pass then stores the documents in batches as
+// they are decoded.
+func (r *Repo) importStream(ctx context.Context, open packageOpener, size int64, progress func(ImportProgress)) error {
 	udb, err := r.userDB(ctx)
 	if err != nil {
 		return err
 	}
-	pkg := &fb.Package{}
-	if err := json.NewDecoder(f).Decode(pkg); err != nil {
+	in, counter, err := open()
+	if err != nil {
+		return err
+	}
+	report := func(docs, total int) {
+		if progress != nil {
+			progress(ImportProgress{Docs: docs, Total: total, Bytes: counter.n, TotalBytes: size})
+		}
+	}
+	scan, err := scanPackage(in, func() { report(0, 0) })
+	if err != nil {
 		return errors.Wrap(err, "Unable to decode JSON")
 	}
-	if err := pkg.Validate(); err != nil {
+
+	bdb, err := r.saveImportedBundle(ctx, scan.bundle)
+	if err != nil {
+		return err
+	}
+	imp := &importer{udb: udb, bdb: bdb, total: scan.total, report: report}
+	for i, theme := range scan.themes {
+		if err := imp.addDoc(ctx, theme, scan.themeSizes[i]); err != nil {
+			return err
+		}
+	}
+	if in, counter, err = open(); err != nil {
+		return err
+	}
+	err = walkPackage(in, func(key string, dec *json.Decoder) error {
+		switch key {
+		case "notes":
+			return decodeArray(dec, func() error {
+				note := &fb.Note{}
+				size, err := decodeSized(dec, note)
+				if err != nil {
+					return err
+				}
+				note.Model = scan.models[noteModelKey(note)]
+				return imp.addDoc(ctx, note, size)
+			})
+		case "decks":
+			return decodeArray(dec, func() error {
+				deck := &fb.Deck{}
+				size, err := decodeSized(dec, deck)
+				if err != nil {
+					return err
+				}
+				return imp.addDoc(ctx, deck, size)
+			})
+		case "cards":
+			return decodeArray(dec, func() error {
+				card := &fb.Card{}
+				if err := dec.Decode(card); err != nil {
+					return err
+				}
+				return imp.addCard(ctx, card)
+			})
+		case "reviews":
+			return decodeArray(dec, func() error {
+				review := &fb.Review{}
+				if err := dec.Decode(review); err != nil {
+					return err
+				}
+				return imp.addReview(ctx, review)
+			})
+		}
+		var skip json.RawMessage
+		if key == "themes" {
+			return decodeArray(dec, func() error { return dec.Decode(&skip) })
+		}
+		return dec.Decode(&skip)
+	})
+	if err != nil {
+		return errors.Wrap(err, "Unable to decode JSON")
+	}
+	if err := imp.flush(ctx); err != nil {
+		return err
+	}
+	log.Printf("Imported:\n%d Bundles\n%d Themes\n%d Decks\n%d Notes\n%d Cards\n%d Reviews\n",
+		1, len(scan.themes), scan.decks, scan.notes, scan.cards, scan.reviews)
+	return nil
+}
+
+// packageScan is what is kept of a package after the first pass over it.
+type packageScan struct {
+	bundle *fb.Bundle
+	themes []*fb.Theme
+	// themeSizes are the JSON sizes of the themes.
+	themeSizes []int64
+	// models maps the theme ID and model ID of each model to the model.
+	models map[string]*fb.Model
+	// total is the number of documents to be stored, excluding the bundle.
+	total                        int
+	notes, decks, cards, reviews int
+}
+
+// noteModelKey returns the key of a note's model in packageScan.models.
+func noteModelKey(note *fb.Note) string {
+	return fmt.Sprintf("%s/%d", note.ThemeID, note.ModelID)
+}
+
+// scanPackage reads a package, validating it as fb.Package's UnmarshalJSON
+// does, while keeping only the bundle and themes of it. tick is called after
+// each batch of documents is read.
+func scanPackage(in io.Reader, tick func()) (*packageScan, error) {
+	scan := &packageScan{models: make(map[string]*fb.Model)}
+	var version, n int
+	count := func() {
+		if n++; n%importBatchSize == 0 {
+			tick()
+		}
+	}
+	cards := make(map[string]bool)
+	deckCards := make(map[string]bool)
+	noteModels := make(map[string]string)
+	err := walkPackage(in, func(key string, dec *json.Decoder) error {
+		switch key {
+		case "version":
+			return dec.Decode(&version)
+		case "bundle":
+			return dec.Decode(&scan.bundle)
+		case "themes":
+			return decodeArray(dec, func() error {
+				theme := &fb.Theme{}
+				size, err := decodeSized(dec, theme)
+				if err != nil {
+					return err
+				}
+				if err := theme.Validate(); err != nil {
+					return errors.Wrapf(err, "theme '%s' validation", theme.ID)
+				}
+				for _, m := range theme.Models {
+					scan.models[fmt.Sprintf("%s/%d", theme.ID, m.ID)] = m
+				}
+				scan.themes = append(scan.themes, theme)
+				scan.themeSizes = append(scan.themeSizes, size)
+				count()
+				return nil
+			})
+		case "notes":
+			return decodeArray(dec, func() error {
+				note := &fb.Note{}
+				if err := dec.Decode(note); err != nil {
+					return err
+				}
+				noteModels[note.ID] = noteModelKey(note)
+				scan.notes++
+				count()
+				return nil
+			})
+		case "decks":
+			return decodeArray(dec, func() error {
+				deck := &fb.Deck{}
+				if err := dec.Decode(deck); err != nil {
+					return err
+				}
+				if err := deck.Validate(); err != nil {
+					return errors.Wrapf(err, "deck '%s' validation", deck.ID)
+				}
+				for _, id := range deck.Cards.All() {
+					if deckCards[id] {
+						return errors.Errorf("card '%s' listed in more than one deck", id)
+					}
+					deckCards[id] = true
+				}
+				scan.decks++
+				count()
+				return nil
+			})
+		case "cards":
+			return decodeArray(dec, func() error {
+				card := &fb.Card{}
+				if err := dec.Decode(card); err != nil {
+					return err
+				}
+				cards[card.ID] = true
+				scan.cards++
+				count()
+				return nil
+			})
+		case "reviews":
+			return decodeArray(dec, func() error {
+				review := &fb.Review{}
+				if err := dec.Decode(review); err != nil {
+					return err
+				}
+				scan.reviews++
+				count()
+				return nil
+			})
+		}
+		var skip json.RawMessage
+		return dec.Decode(&skip)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if version < fb.LowestVersion {
+		return nil, errors.Errorf("package version %d < %d", version, fb.LowestVersion)
+	}
+	if scan.bundle == nil {
+		return nil, errors.New("package has no bundle")
+	}
+	for id := range deckCards {
+		if !cards[id] {
+			return nil, errors.Errorf("card '%s' listed in deck, but not found in package", id)
+		}
+	}
+	for id := range cards {
+		if !deckCards[id] {
+			return nil, errors.Errorf("card '%s' found in package, but not in a deck", id)
+		}
+	}
+	for id, key := range noteModels {
+		if _, ok := scan.models[key]; !ok {
+			return nil, errors.Errorf("note '%s' has no matching model (%s)", id, key)
+		}
+	}
+	scan.total = n
+	return scan, nil
+}
+
+// walkPackage decodes the package object read from in one member at a time.
+// fn is called with the key of each member, and must decode its value from
+// dec.
+func walkPackage(in io.Reader, fn func(key string, dec *json.Decoder) error) error {
+	dec := json.NewDecoder(in)
+	tok, err := dec.Token()
+	if err != nil {
 		return err
 	}
+	if tok != json.Delim('{') {
+		return errors.New("package is not a JSON object")
+	}
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		if err := fn(tok.(string), dec); err != nil {
+			return err
+		}
+	}
+	_, err = dec.Token()
+	return err
+}
 
-	bundle := pkg.Bundle
+// decodeSized decodes the next value of dec into v, and returns the size of
+// its JSON encoding.
+func decodeSized(dec *json.Decoder, v interface{}) (int64, error) {
+	var raw json.RawMessage
+	if err := dec.Decode(&raw); err != nil {
+		return 0, err
+	}
+	return int64(len(raw)), json.Unmarshal(raw, v)
+}
+
+// decodeArray calls fn to decode each element of the array which is the
+// next value of dec. A null value is taken as an empty array.
+func decodeArray(dec *json.Decoder, fn func() error) error {
+	tok, err := dec.Token()
+	if err != nil || tok == nil {
+		return err
+	}
+	if tok != json.Delim('[') {
+		return errors.Errorf("expected an array, found %v", tok)
+	}
+	for dec.More() {
+		if err := fn(); err != nil {
+			return err
+		}
+	}
+	_, err = dec.Token()
+	return err
+}
+
+// importer stores imported documents in batches.
+type importer struct {
+	udb, bdb getPutBulkDocer
+	docs     []FlashbackDoc
+	cards    []FlashbackDoc
+	reviews  []*fb.Review
+	// size is the JSON size of the pending documents.
+	size   int64
+	stored int
+	total  int
+	report func(docs, total int)
+}
+
+// addDoc queues a document for the bundle database. size is its JSON size,
+// which includes any attachments.
+func (imp *importer) addDoc(ctx context.Context, doc FlashbackDoc, size int64) error {
+	imp.docs = append(imp.docs, doc)
+	imp.size += size
+	return imp.flushFull(ctx)
+}
+
+// addEncodedDoc is addDoc, for a document whose JSON size isn't known.
+func (imp *importer) addEncodedDoc(ctx context.Context, doc FlashbackDoc) error {
+	x, err := json.Marshal(doc)
+	if err != nil {
+		return errors.Wrapf(err, "encode %s", doc.DocID())
+	}
+	return imp.addDoc(ctx, doc, int64(len(x)))
+}
+
+// addCard queues a card for the user database.
+func (imp *importer) addCard(ctx context.Context, card *fb.Card) error {
+	imp.cards = append(imp.cards, card)
+	return imp.flushFull(ctx)
+}
+
+// addReview queues a review for the user database.
+func (imp *importer) addReview(ctx context.Context, review *fb.Review) error {
+	imp.reviews = append(imp.reviews, review)
+	return imp.flushFull(ctx)
+}
+
+// pending returns the number of documents not yet stored.
+func (imp *importer) pending() int {
+	return len(imp.docs) + len(imp.cards) + len(imp.reviews)
+}
+
+// flushFull stores the pending documents if the batch is full.
+func (imp *importer) flushFull(ctx context.Context) error {
+	if imp.pending() < importBatchSize && imp.size < importBatchBytes {
+		return nil
+	}
+	return imp.flush(ctx)
+}
+
+// flush stores the pending documents, and reports the progress.
+func (imp *importer) flush(ctx context.Context) error {
+	if len(imp.docs) > 0 {
+		if err := bulkInsert(ctx, imp.bdb, imp.docs...); err != nil {
+			return err
+		}
+	}
+	if len(imp.cards) > 0 {
+		if err := bulkInsert(ctx, imp.udb, imp.cards...); err != nil {
+			return err
+		}
+	}
+	if err := insertReviews(ctx, imp.udb, imp.reviews); err != nil {
+		return err
+	}
+	imp.stored += imp.pending()
+	imp.docs, imp.cards, imp.reviews, imp.size = nil, nil, nil, 0
+	imp.report(imp.stored, imp.total)
+	return nil
+}
+
+// saveImportedBundle saves bundle, owned by the current user, and returns its
+// database.
+func (r *Repo) saveImportedBundle(ctx context.Context, bundle *fb.Bundle) (kivikDB, error) {
 	bundle.Owner = r.user
 	if err := r.SaveBundle(ctx, bundle); err != nil {
+		return nil, err
+	}
+	return r.bundleDB(ctx, bundle)
+}
+
+// importPackage stores the content of pkg, with the bundle owned by the
+// current user.
+func (r *Repo) importPackage(ctx context.Context, pkg *fb.Package, progress func(ImportProgress)) error {
+	udb, err := r.userDB(ctx)
+	if err != nil {
+		return err
+	}
+	if err := pkg.Validate(); err != nil {
 		return err
 	}
 
-	bdb, err := r.bundleDB(ctx, bundle)
+	bdb, err := r.saveImportedBundle(ctx, pkg.Bundle)
 	if err != nil {
 		return err
 	}
 
-	docs := make([]FlashbackDoc, 0, len(pkg.Themes)+len(pkg.Notes)+len(pkg.Decks))
+	imp := &importer{
+		udb:   udb,
+		bdb:   bdb,
+		total: len(pkg.Themes) + len(pkg.Notes) + len(pkg.Decks) + len(pkg.Cards) + len(pkg.Reviews),
+		report: func(docs, total int) {
+			if progress != nil {
+				progress(ImportProgress{Docs: docs, Total: total})
+			}
+		},
+	}
 	for _, theme := range pkg.Themes {
-		docs = append(docs, theme)
+		if err := imp.addEncodedDoc(ctx, theme); err != nil {
+			return err
+		}
 	}
 	for _, note := range pkg.Notes {
-		docs = append(docs, note)
+		if err := imp.addEncodedDoc(ctx, note); err != nil {
+			return err
+		}
 	}
 	for _, deck := range pkg.Decks {
-		docs = append(docs, deck)
+		if err := imp.addEncodedDoc(ctx, deck); err != nil {
+			return err
+		}
 	}
-	if err := bulkInsert(ctx, bdb, docs...); err != nil {
+	for _, card := range pkg.Cards {
+		if err := imp.addCard(ctx, card); err != nil {
+			return err
+		}
+	}
+	for _, review := range pkg.Reviews {
+		if err := imp.addReview(ctx, review); err != nil {
+			return err
+		}
+	}
+	if err := imp.flush(ctx); err != nil {
 		return err
 	}
+	log.Printf("Imported:\n%d Bundles\n%d Themes\n%d Decks\n%d Notes\n%d Cards\n%d Reviews\n",
+		1, len(pkg.Themes), len(pkg.Decks), len(pkg.Notes), len(pkg.Cards), len(pkg.Reviews))
+	return nil
+}
 
-	cards := make([]FlashbackDoc, 0, len(pkg.Cards))
-	for _, card := range pkg.Cards {
-		cards = append(cards, card)
+// insertReviews stores the imported review history. As reviews are immutable,
+// a review which already exists is left as is.
+func insertReviews(ctx context.Context, db bulkDocer, reviews []*fb.Review) error {
+	if len(reviews) == 0 {
+		return nil
 	}
-
-	if err := bulkInsert(ctx, udb, cards...); err != nil {
+	docs := make([]interface{}, len(reviews))
+	for i, review := range reviews {
+		if err := review.Validate(); err != nil {
+			return errors.Wrap(err, "invalid review")
+		}
+		if review.ID == "" {
+			r, err := fb.NewReviewAt(review.CardID, review.Timestamp)
+			if err != nil {
+				return err
+			}
+			review.ID = r.ID
+		}
+		review.Rev = ""
+		docs[i] = review
+	}
+	results, err := db.BulkDocs(ctx, docs)
+	if err != nil {
 		return err
 	}
-	log.Printf("Imported:\n%d Bundles\n%d Themes\n%d Decks\n%d Notes\n%d Cards\n",
-		1, len(pkg.Themes), len(pkg.Decks), len(pkg.Notes), len(pkg.Cards))
-	return nil
+	var errs *multierror.Error
+	for results.Next() {
+		if err := results.UpdateErr(); err != nil && kivik.StatusCode(err) != kivik.StatusConflict {
+			errs = multierror.Append(errs, errors.Wrapf(err, "failed to save review %s", results.ID()))
+		}
+	}
+	return errs.ErrorOrNil()
 }
 
 func bulkInsert(ctx context.Context, db getPutBulkDocer, docs ...FlashbackDoc) error {
@@ -1,14 +1,18 @@
 package model
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"strings"
 	"testing"
 	"time"
 
-	fb "github.com/FlashbackSRS/flashback-model"
+	"github.com/FlashbackSRS/flashback/fb"
 	"github.com/flimzy/diff"
 	"github.com/flimzy/kivik"
 )
@@ -77,7 +81,7 @@ func TestImportFile(t *testing.T) {
 				0x1f, 0x8b, 0x08, 0x08, 0xe2, 0x20, 0x71, 0x59,
 				0x00, 0x03, 0x78, 0x00, 0x33, 0xe4, 0x02, 0x00,
 				0x53, 0xfc, 0x51, 0x67, 0x02, 0x00, 0x00, 0x00}},
-			err: "Unable to decode JSON: json: cannot unmarshal number into Go value of type fb.jsonPackage",
+			err: "Unable to decode JSON: package is not a JSON object",
 		},
 	}
 	for _, test := range tests {
@@ -278,12 +282,22 @@ func TestImport(t *testing.T) {
 			},
 			expectedUserDocs: []interface{}{
 				map[string]interface{}{
-					"_id":      "card-krsxg5baij2w4zdmmu.VGVzdCBOb3Rl.0",
-					"_rev":     "1",
-					"type":     "card",
-					"created":  "2016-07-31T15:08:24.730156517Z",
-					"modified": "2016-07-31T15:08:24.730156517Z",
-					"model":    "theme-VGVzdCBUaGVtZQ/0",
+					"_id":       "card-krsxg5baij2w4zdmmu.VGVzdCBOb3Rl.0",
+					"_rev":      "1",
+					"type":      "card",
+					"created":   "2016-07-31T15:08:24.730156517Z",
+					"modified":  "2016-07-31T15:08:24.730156517Z",
+					"model":     "theme-VGVzdCBUaGVtZQ/0",
+					"queue":     "new",
+					"available": "2016-07-31 15:08:24",
+				},
+				map[string]interface{}{
+					"_id":        "review-krsxg5baij2w4zdmmu.VGVzdCBOb3Rl.0.1483232461000000000",
+					"_rev":       "1",
+					"type":       "review",
+					"cardID":     "card-krsxg5baij2w4zdmmu.VGVzdCBOb3Rl.0",
+					"timestamp":  "2017-01-01T01:01:01Z",
+					"reviewType": 0,
 				},
 			},
 			expectedBundleDocs: []interface{}{
@@ -527,3 +541,157 @@ func TestBulkInsert(t *testing.T) {
 		})
 	}
 }
+
+func TestScanPackage(t *testing.T) {
+	tests := []struct {
+		name     string
+		old, new string
+		total    int
+		err      string
+	}{
+		{
+			name:  "valid",
+			total: 5,
+		},
+		{
+			name: "not an object",
+			old:  exportTestPackage,
+			new:  "[]",
+			err:  "package is not a JSON object",
+		},
+		{
+			name: "old version",
+			old:  `"version": 2`,
+			new:  `"version": 0`,
+			err:  "package version 0 < 1",
+		},
+		{
+			name: "no bundle",
+			old:  `"bundle"`,
+			new:  `"other"`,
+			err:  "package has no bundle",
+		},
+		{
+			name: "not an array",
+			old:  `"reviews": [`,
+			new:  `"reviews": 3, "x": [`,
+			err:  "expected an array, found 3",
+		},
+		{
+			name: "card not in deck",
+			old:  `"cards": ["card-krsxg5baij2w4zdmmu.VGVzdCBOb3Rl.0"]`,
+			new:  `"cards": []`,
+			err:  "card 'card-krsxg5baij2w4zdmmu.VGVzdCBOb3Rl.0' found in package, but not in a deck",
+		},
+		{
+			name: "deck card not found",
+			old:  `"_id": "card-krsxg5baij2w4zdmmu.VGVzdCBOb3Rl.0"`,
+			new:  `"_id": "card-krsxg5baij2w4zdmmu.VGVzdCBOb3Rl.1"`,
+			err:  "card 'card-krsxg5baij2w4zdmmu.VGVzdCBOb3Rl.0' listed in deck, but not found in package",
+		},
+		{
+			name: "no model",
+			old:  `"model": 0,`,
+			new:  `"model": 1,`,
+			err:  "note 'note-VGVzdCBOb3Rl' has no matching model (theme-VGVzdCBUaGVtZQ/1)",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pkg := strings.Replace(exportTestPackage, test.old, test.new, 1)
+			scan, err := scanPackage(strings.NewReader(pkg), func() {})
+			checkErr(t, test.err, err)
+			if err != nil {
+				return
+			}
+			if scan.total != test.total || scan.bundle.ID != "bundle-krsxg5baij2w4zdmmu" || len(scan.themes) != 1 {
+				t.Errorf("Unexpected scan: %d docs, bundle %s, %d themes", scan.total, scan.bundle.ID, len(scan.themes))
+			}
+			if len(scan.themeSizes) != 1 || scan.themeSizes[0] == 0 {
+				t.Errorf("Unexpected theme sizes: %v", scan.themeSizes)
+			}
+		})
+	}
+}
+
+func TestImporterBatchBytes(t *testing.T) {
+	ctx := context.Background()
+	stored := 0
+	imp := &importer{bdb: testDB(t), total: 2, report: func(docs, _ int) { stored = docs }}
+	small, err := fb.NewDeck("deck-c21hbGw")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := imp.addEncodedDoc(ctx, small); err != nil {
+		t.Fatal(err)
+	}
+	if stored != 0 {
+		t.Fatalf("Expected no documents stored, got %d", stored)
+	}
+	large, err := fb.NewDeck("deck-bGFyZ2U")
+	if err != nil {
+		t.Fatal(err)
+	}
+	large.Description = strings.Repeat("x", importBatchBytes)
+	if err := imp.addEncodedDoc(ctx, large); err != nil {
+		t.Fatal(err)
+	}
+	if stored != 2 {
+		t.Errorf("Expected the batch to be stored once full, got %d documents stored", stored)
+	}
+}
+
+func TestImportFileProgress(t *testing.T) {
+	pkg := make(map[string]interface{})
+	if err := json.Unmarshal([]byte(exportTestPackage), &pkg); err != nil {
+		t.Fatal(err)
+	}
+	notes := pkg["notes"].([]interface{})
+	for i := 1; i < 250; i++ {
+		note := make(map[string]interface{})
+		for k, v := range notes[0].(map[string]interface{}) {
+			note[k] = v
+		}
+		note["_id"] = fmt.Sprintf("note-%d", i)
+		notes = append(notes, note)
+	}
+	pkg["notes"] = notes
+	buf := &bytes.Buffer{}
+	z := gzip.NewWriter(buf)
+	if err := json.NewEncoder(z).Encode(pkg); err != nil {
+		t.Fatal(err)
+	}
+	if err := z.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	repo := testExportRepo(t, "mjxwe")
+	var progress [][2]int
+	var last ImportProgress
+	err := repo.ImportFileProgress(context.Background(), &mockFile{body: buf.Bytes()}, func(p ImportProgress) {
+		progress = append(progress, [2]int{p.Docs, p.Total})
+		last = p
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Two reports while scanning, then one for each batch stored.
+	expected := [][2]int{{0, 0}, {0, 0}, {100, 254}, {200, 254}, {254, 254}}
+	if d := diff.Interface(expected, progress); d != nil {
+		t.Error(d)
+	}
+	if last.TotalBytes != int64(buf.Len()) || last.Bytes == 0 || last.Bytes > last.TotalBytes {
+		t.Errorf("Unexpected progress: %d of %d bytes", last.Bytes, last.TotalBytes)
+	}
+	bdb, err := repo.newDB(context.Background(), "bundle-krsxg5baij2w4zdmmu")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored, err := fetchNotes(context.Background(), bdb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stored) != 250 {
+		t.Errorf("Expected 250 notes, found %d", len(stored))
+	}
+}
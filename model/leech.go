@@ -0,0 +1,59 @@
+package model
+
+import (
+	"context"
+
+	"github.com/FlashbackSRS/flashback"
+	"github.com/FlashbackSRS/flashback/fb"
+)
+
+// LeechAction is the action taken when a card becomes a leech.
+type LeechAction string
+
+// Leech actions
+const (
+	// LeechSuspend flags the card as a leech, and suspends it.
+	LeechSuspend LeechAction = "suspend"
+	// LeechTag flags the card as a leech, but leaves it in rotation.
+	LeechTag LeechAction = "tag"
+)
+
+// DefaultLeechThreshold is the number of lapses after which a card becomes a
+// leech, when no other threshold is configured.
+const DefaultLeechThreshold = 8
+
+// recordLapse counts a lapse if a review card (one with an interval of at
+// least a day, prevInterval, prior to rescheduling) was answered wrong, and
+// applies the configured leech action if the card has become a leech. As the
+// user may keep studying a tagged leech, the action is repeated every half
+// threshold lapses thereafter.
+func recordLapse(card *fb.Card, prevInterval fb.Interval, config *DeckSettings, quality flashback.AnswerQuality) {
+	if prevInterval < fb.Day || reviewEase(quality) != fb.ReviewEaseWrong {
+		return
+	}
+	card.LapseCount++
+	threshold := config.LeechThreshold
+	if threshold == 0 || card.LapseCount < threshold {
+		return
+	}
+	repeat := threshold / 2
+	if repeat < 1 {
+		repeat = 1
+	}
+	if (card.LapseCount-threshold)%repeat != 0 {
+		return
+	}
+	card.Leech = true
+	if config.LeechAction == LeechSuspend {
+		card.Suspended = true
+	}
+}
+
+// Leeches returns all of the cards which have been flagged as leeches.
+func (r *Repo) Leeches(ctx context.Context) ([]*fb.Card, error) {
+	udb, err := r.userDB(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return findCards(ctx, udb, map[string]interface{}{"leech": true})
+}
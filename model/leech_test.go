@@ -0,0 +1,151 @@
+package model
+
+import (
+	"context"
+	"testing"
+
+	"github.com/FlashbackSRS/flashback"
+	"github.com/FlashbackSRS/flashback/fb"
+	"github.com/flimzy/diff"
+)
+
+func TestRecordLapse(t *testing.T) {
+	tagConfig := &DeckSettings{LeechThreshold: 4, LeechAction: LeechTag}
+	suspendConfig := &DeckSettings{LeechThreshold: 4, LeechAction: LeechSuspend}
+	tests := []struct {
+		name         string
+		card         *fb.Card
+		prevInterval fb.Interval
+		config       *DeckSettings
+		quality      flashback.AnswerQuality
+		expected     *fb.Card
+	}{
+		{
+			name:         "correct answer",
+			card:         &fb.Card{},
+			prevInterval: 10 * fb.Day,
+			config:       tagConfig,
+			quality:      flashback.AnswerCorrect,
+			expected:     &fb.Card{},
+		},
+		{
+			name:         "learning card",
+			card:         &fb.Card{},
+			prevInterval: 10 * fb.Minute,
+			config:       tagConfig,
+			quality:      flashback.AnswerBlackout,
+			expected:     &fb.Card{},
+		},
+		{
+			name:         "lapse",
+			card:         &fb.Card{LapseCount: 1},
+			prevInterval: 10 * fb.Day,
+			config:       tagConfig,
+			quality:      flashback.AnswerIncorrectEasy,
+			expected:     &fb.Card{LapseCount: 2},
+		},
+		{
+			name:         "tag leech",
+			card:         &fb.Card{LapseCount: 3},
+			prevInterval: fb.Day,
+			config:       tagConfig,
+			quality:      flashback.AnswerBlackout,
+			expected:     &fb.Card{LapseCount: 4, Leech: true},
+		},
+		{
+			name:         "suspend leech",
+			card:         &fb.Card{LapseCount: 3},
+			prevInterval: fb.Day,
+			config:       suspendConfig,
+			quality:      flashback.AnswerBlackout,
+			expected:     &fb.Card{LapseCount: 4, Leech: true, Suspended: true},
+		},
+		{
+			name:         "between repeats",
+			card:         &fb.Card{LapseCount: 4},
+			prevInterval: fb.Day,
+			config:       suspendConfig,
+			quality:      flashback.AnswerBlackout,
+			expected:     &fb.Card{LapseCount: 5},
+		},
+		{
+			name:         "repeat",
+			card:         &fb.Card{LapseCount: 5},
+			prevInterval: fb.Day,
+			config:       suspendConfig,
+			quality:      flashback.AnswerBlackout,
+			expected:     &fb.Card{LapseCount: 6, Leech: true, Suspended: true},
+		},
+		{
+			name:         "disabled",
+			card:         &fb.Card{LapseCount: 30},
+			prevInterval: fb.Day,
+			config:       &DeckSettings{LeechAction: LeechSuspend},
+			quality:      flashback.AnswerBlackout,
+			expected:     &fb.Card{LapseCount: 31},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			recordLapse(test.card, test.prevInterval, test.config, test.quality)
+			if d := diff.Interface(test.expected, test.card); d != nil {
+				t.Error(d)
+			}
+		})
+	}
+}
+
+func TestRepoLeeches(t *testing.T) {
+	ctx := context.Background()
+	t.Run("not logged in", func(t *testing.T) {
+		_, err := (&Repo{}).Leeches(ctx)
+		checkErr(t, "not logged in", err)
+	})
+	t.Run("success", func(t *testing.T) {
+		repo := &Repo{user: "mjxwe", local: testClient(t)}
+		if err := repo.local.CreateDB(ctx, "user-mjxwe"); err != nil {
+			t.Fatal(err)
+		}
+		udb, err := repo.userDB(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, card := range []*fb.Card{
+			{ID: "card-foo.bar.0", LapseCount: 8, Leech: true},
+			{ID: "card-foo.bar.1", LapseCount: 2},
+		} {
+			card.ModelID = "theme-foo/0"
+			card.Created = now()
+			card.Modified = now()
+			if err := saveDoc(ctx, udb, card); err != nil {
+				t.Fatal(err)
+			}
+		}
+		leeches, err := repo.Leeches(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(leeches) != 1 || leeches[0].ID != "card-foo.bar.0" {
+			t.Errorf("Unexpected leeches: %v", leeches)
+		}
+	})
+}
+
+func TestScheduleLeech(t *testing.T) {
+	config := defaultDeckSettings
+	config.LeechThreshold = 2
+	config.LeechAction = LeechSuspend
+	card := &Card{
+		Card:   &fb.Card{ID: "card-foo.bar.0", Interval: 10 * fb.Day, LapseCount: 1, ReviewCount: 3},
+		config: &config,
+	}
+	if err := Schedule(card, 0, flashback.AnswerBlackout); err != nil {
+		t.Fatal(err)
+	}
+	if card.LapseCount != 2 || !card.Leech || !card.Suspended {
+		t.Errorf("Expected suspended leech, got lapses=%d leech=%t suspended=%t", card.LapseCount, card.Leech, card.Suspended)
+	}
+	if !card.Relearning {
+		t.Errorf("Expected card to enter relearning")
+	}
+}
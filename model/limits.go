@@ -0,0 +1,199 @@
+package model
+
+import (
+	"context"
+	"strings"
+
+	"github.com/flimzy/kivik"
+	"github.com/pkg/errors"
+
+	"github.com/FlashbackSRS/flashback/fb"
+)
+
+// Default daily limits
+const (
+	DefaultNewPerDay     = 20
+	DefaultReviewsPerDay = 200
+)
+
+// allScope is the counting scope which includes all cards.
+const allScope = ""
+
+// dailyCounts records the number of new cards and reviews studied on a single
+// day, keyed by scope: allScope, or a bundle or deck ID.
+type dailyCounts struct {
+	ID      string         `json:"_id"`
+	Rev     string         `json:"_rev,omitempty"`
+	New     map[string]int `json:"new"`
+	Reviews map[string]int `json:"reviews"`
+}
+
+func dailyCountsID(day fb.Due) string {
+	return "counts-" + day.String()
+}
+
+// fetchDailyCounts returns the counts for the given day, or empty counts if
+// none have been stored.
+func fetchDailyCounts(ctx context.Context, db getter, day fb.Due) (*dailyCounts, error) {
+	counts := &dailyCounts{}
+	err := getDoc(ctx, db, dailyCountsID(day), counts)
+	if err != nil && kivik.StatusCode(err) != kivik.StatusNotFound {
+		return nil, errors.Wrap(err, "fetch daily counts")
+	}
+	counts.ID = dailyCountsID(day)
+	if counts.New == nil {
+		counts.New = make(map[string]int)
+	}
+	if counts.Reviews == nil {
+		counts.Reviews = make(map[string]int)
+	}
+	return counts, nil
+}
+
+// add adds delta to the counts for the review in each of the scopes. Only the
+// first answer to a new card, and answers to review cards, are counted; cram
+// reviews leave the cards' schedules unchanged, so aren't limited.
+func (d *dailyCounts) add(review *fb.Review, scopes []string, delta int) bool {
+	var counts map[string]int
+	switch {
+	case review.Type == fb.ReviewTypeLearn && review.PreviousInterval == 0:
+		counts = d.New
+	case review.Type == fb.ReviewTypeReview:
+		counts = d.Reviews
+	default:
+		return false
+	}
+	for _, scope := range scopes {
+		counts[scope] += delta
+	}
+	return true
+}
+
+// countAnswer adds delta to the daily counts of day, the study day on which
+// the review took place. A delta of -1 removes a previously counted review.
+func countAnswer(ctx context.Context, db getPutter, day fb.Due, review *fb.Review, scopes []string, delta int) error {
+	counts, err := fetchDailyCounts(ctx, db, day)
+	if err != nil {
+		return err
+	}
+	if !counts.add(review, scopes, delta) {
+		return nil
+	}
+	doc := struct {
+		*dailyCounts
+		Type string `json:"type"`
+	}{
+		dailyCounts: counts,
+		Type:        "counts",
+	}
+	_, err = db.Put(ctx, counts.ID, doc)
+	return errors.Wrap(err, "save daily counts")
+}
+
+// countScopes returns the scopes in which an answer to a card is counted.
+func countScopes(bundleID string, deckIDs []string) []string {
+	return append([]string{allScope, bundleID}, deckIDs...)
+}
+
+// limits returns the daily limits applicable to a card in the given bundle
+// and decks, keyed by scope. The user's default limit applies to all cards,
+// and limits configured for a bundle or deck apply to the cards within it, so
+// a card may be studied only if it is within all of its limits.
+func (s *Settings) limits(bundleID string, deckIDs []string, isNew bool) map[string]int {
+	limit := func(d *DeckSettings) *int {
+		if d == nil {
+			return nil
+		}
+		if isNew {
+			return d.NewPerDay
+		}
+		return d.ReviewsPerDay
+	}
+	defaults := defaultDeckSettings
+	defaults.merge(&s.Default)
+	limits := map[string]int{allScope: *limit(&defaults)}
+	for _, id := range append([]string{bundleID}, deckIDs...) {
+		if l := limit(s.Overrides[id]); l != nil {
+			limits[id] = *l
+		}
+	}
+	return limits
+}
+
+// hasDeckLimits returns true if daily limits are configured for any deck.
+func (s *Settings) hasDeckLimits() bool {
+	for id, o := range s.Overrides {
+		if o != nil && (o.NewPerDay != nil || o.ReviewsPerDay != nil) && strings.HasPrefix(id, "deck-") {
+			return true
+		}
+	}
+	return false
+}
+
+// studyLimits enforces the daily study limits when selecting cards.
+type studyLimits struct {
+	settings *Settings
+	counts   *dailyCounts
+	// decks returns the decks in a bundle. It is nil if no deck limits are
+	// configured.
+	decks func(bundleID string) ([]*fb.Deck, error)
+}
+
+// studyLimits loads the user's settings and today's counts.
+func (r *Repo) studyLimits(ctx context.Context, udb getter) (*studyLimits, error) {
+	s, err := fetchSettings(ctx, udb)
+	if err != nil {
+		return nil, err
+	}
+	counts, err := fetchDailyCounts(ctx, udb, s.clock().today())
+	if err != nil {
+		return nil, err
+	}
+	l := &studyLimits{settings: s, counts: counts}
+	if s.hasDeckLimits() {
+		cache := make(map[string][]*fb.Deck)
+		l.decks = func(bundleID string) ([]*fb.Deck, error) {
+			if decks, ok := cache[bundleID]; ok {
+				return decks, nil
+			}
+			bdb, err := r.local.DB(ctx, bundleID)
+			if err != nil {
+				return nil, err
+			}
+			decks, err := fetchDecks(ctx, bdb)
+			if err != nil {
+				return nil, err
+			}
+			cache[bundleID] = decks
+			return decks, nil
+		}
+	}
+	return l, nil
+}
+
+// allow returns true if the card may be studied without exceeding any daily
+// limit. Cards in learning are not limited.
+func (l *studyLimits) allow(card *cardSchedule, isNew bool) (bool, error) {
+	if !isNew && card.Interval < fb.Day {
+		return true, nil
+	}
+	bundleID := (&fb.Card{ID: card.ID}).BundleID()
+	var deckIDs []string
+	if l.decks != nil {
+		decks, err := l.decks(bundleID)
+		if err != nil {
+			return false, err
+		}
+		deckIDs = decksContaining(decks, card.ID)
+	}
+	counts := l.counts.Reviews
+	if isNew {
+		counts = l.counts.New
+	}
+	for scope, limit := range l.settings.limits(bundleID, deckIDs, isNew) {
+		if limit >= 0 && counts[scope] >= limit {
+			return false, nil
+		}
+	}
+	return true, nil
+}
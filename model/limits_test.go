@@ -0,0 +1,268 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/FlashbackSRS/flashback/fb"
+	"github.com/flimzy/diff"
+)
+
+func TestDailyCountsAdd(t *testing.T) {
+	tests := []struct {
+		name     string
+		review   *fb.Review
+		counted  bool
+		expected *dailyCounts
+	}{
+		{
+			name:     "new card",
+			review:   &fb.Review{Type: fb.ReviewTypeLearn},
+			counted:  true,
+			expected: &dailyCounts{New: map[string]int{"": 1, "bundle-foo": 1}, Reviews: map[string]int{}},
+		},
+		{
+			name:     "learning step",
+			review:   &fb.Review{Type: fb.ReviewTypeLearn, PreviousInterval: fb.Minute},
+			expected: &dailyCounts{New: map[string]int{}, Reviews: map[string]int{}},
+		},
+		{
+			name:     "relearning",
+			review:   &fb.Review{Type: fb.ReviewTypeRelearn, PreviousInterval: 10 * fb.Minute},
+			expected: &dailyCounts{New: map[string]int{}, Reviews: map[string]int{}},
+		},
+		{
+			name:     "review",
+			review:   &fb.Review{Type: fb.ReviewTypeReview, PreviousInterval: 3 * fb.Day},
+			counted:  true,
+			expected: &dailyCounts{New: map[string]int{}, Reviews: map[string]int{"": 1, "bundle-foo": 1}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			counts := &dailyCounts{New: map[string]int{}, Reviews: map[string]int{}}
+			if counted := counts.add(test.review, countScopes("bundle-foo", nil), 1); counted != test.counted {
+				t.Errorf("Unexpected result: %t", counted)
+			}
+			if d := diff.Interface(test.expected, counts); d != nil {
+				t.Error(d)
+			}
+		})
+	}
+}
+
+func TestSettingsLimits(t *testing.T) {
+	s := &Settings{
+		Default: DeckSettings{NewPerDay: DailyLimit(10)},
+		Overrides: map[string]*DeckSettings{
+			"bundle-foo": {ReviewsPerDay: DailyLimit(50)},
+			"deck-foo":   {NewPerDay: DailyLimit(5)},
+			"deck-bar":   {Scheduler: FSRSScheduler},
+		},
+	}
+	tests := []struct {
+		name     string
+		bundleID string
+		deckIDs  []string
+		isNew    bool
+		expected map[string]int
+	}{
+		{
+			name:     "new, no overrides",
+			bundleID: "bundle-bar",
+			isNew:    true,
+			expected: map[string]int{"": 10},
+		},
+		{
+			name:     "reviews, bundle override",
+			bundleID: "bundle-foo",
+			deckIDs:  []string{"deck-foo", "deck-bar"},
+			expected: map[string]int{"": DefaultReviewsPerDay, "bundle-foo": 50},
+		},
+		{
+			name:     "new, deck override",
+			bundleID: "bundle-foo",
+			deckIDs:  []string{"deck-foo", "deck-bar"},
+			isNew:    true,
+			expected: map[string]int{"": 10, "deck-foo": 5},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result := s.limits(test.bundleID, test.deckIDs, test.isNew)
+			if d := diff.Interface(test.expected, result); d != nil {
+				t.Error(d)
+			}
+		})
+	}
+}
+
+func TestStudyLimitsAllow(t *testing.T) {
+	decks := func(_ string) ([]*fb.Deck, error) {
+		deck := &fb.Deck{ID: "deck-foo", Cards: fb.NewCardCollection()}
+		deck.AddCard("card-foo.bar.1")
+		return []*fb.Deck{deck}, nil
+	}
+	tests := []struct {
+		name     string
+		limits   *studyLimits
+		card     *cardSchedule
+		isNew    bool
+		expected bool
+		err      string
+	}{
+		{
+			name: "new, under limit",
+			limits: &studyLimits{
+				settings: &Settings{},
+				counts:   &dailyCounts{New: map[string]int{"": DefaultNewPerDay - 1}},
+			},
+			card:     &cardSchedule{ID: "card-foo.bar.0"},
+			isNew:    true,
+			expected: true,
+		},
+		{
+			name: "new, limit reached",
+			limits: &studyLimits{
+				settings: &Settings{},
+				counts:   &dailyCounts{New: map[string]int{"": DefaultNewPerDay}},
+			},
+			card:  &cardSchedule{ID: "card-foo.bar.0"},
+			isNew: true,
+		},
+		{
+			name: "unlimited",
+			limits: &studyLimits{
+				settings: &Settings{Default: DeckSettings{NewPerDay: DailyLimit(-1)}},
+				counts:   &dailyCounts{New: map[string]int{"": 1000}},
+			},
+			card:     &cardSchedule{ID: "card-foo.bar.0"},
+			isNew:    true,
+			expected: true,
+		},
+		{
+			name: "zero limit",
+			limits: &studyLimits{
+				settings: &Settings{Default: DeckSettings{NewPerDay: DailyLimit(0)}},
+				counts:   &dailyCounts{New: map[string]int{}},
+			},
+			card:  &cardSchedule{ID: "card-foo.bar.0"},
+			isNew: true,
+		},
+		{
+			name: "learning card",
+			limits: &studyLimits{
+				settings: &Settings{},
+				counts:   &dailyCounts{Reviews: map[string]int{"": 1000}},
+			},
+			card:     &cardSchedule{ID: "card-foo.bar.0", Interval: 10 * fb.Minute},
+			expected: true,
+		},
+		{
+			name: "review, bundle limit reached",
+			limits: &studyLimits{
+				settings: &Settings{Overrides: map[string]*DeckSettings{"bundle-foo": {ReviewsPerDay: DailyLimit(10)}}},
+				counts:   &dailyCounts{Reviews: map[string]int{"": 10, "bundle-foo": 10}},
+			},
+			card: &cardSchedule{ID: "card-foo.bar.0", Interval: fb.Day},
+		},
+		{
+			name: "review, other bundle",
+			limits: &studyLimits{
+				settings: &Settings{Overrides: map[string]*DeckSettings{"bundle-foo": {ReviewsPerDay: DailyLimit(10)}}},
+				counts:   &dailyCounts{Reviews: map[string]int{"": 10, "bundle-foo": 10}},
+			},
+			card:     &cardSchedule{ID: "card-bar.bar.0", Interval: fb.Day},
+			expected: true,
+		},
+		{
+			name: "deck limit reached",
+			limits: &studyLimits{
+				settings: &Settings{Overrides: map[string]*DeckSettings{"deck-foo": {NewPerDay: DailyLimit(1)}}},
+				counts:   &dailyCounts{New: map[string]int{"": 1, "bundle-foo": 1, "deck-foo": 1}},
+				decks:    decks,
+			},
+			card:  &cardSchedule{ID: "card-foo.bar.1"},
+			isNew: true,
+		},
+		{
+			name: "not in limited deck",
+			limits: &studyLimits{
+				settings: &Settings{Overrides: map[string]*DeckSettings{"deck-foo": {NewPerDay: DailyLimit(1)}}},
+				counts:   &dailyCounts{New: map[string]int{"": 1, "bundle-foo": 1, "deck-foo": 1}},
+				decks:    decks,
+			},
+			card:     &cardSchedule{ID: "card-foo.bar.0"},
+			isNew:    true,
+			expected: true,
+		},
+		{
+			name: "deck error",
+			limits: &studyLimits{
+				settings: &Settings{},
+				counts:   &dailyCounts{},
+				decks:    func(_ string) ([]*fb.Deck, error) { return nil, errors.New("decks failed") },
+			},
+			card:  &cardSchedule{ID: "card-foo.bar.0"},
+			isNew: true,
+			err:   "decks failed",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result, err := test.limits.allow(test.card, test.isNew)
+			checkErr(t, test.err, err)
+			if result != test.expected {
+				t.Errorf("Unexpected result: %t", result)
+			}
+		})
+	}
+}
+
+func TestCountAnswer(t *testing.T) {
+	ctx := context.Background()
+	repo := &Repo{user: "mjxwe", local: testClient(t)}
+	if err := repo.local.CreateDB(ctx, "user-mjxwe"); err != nil {
+		t.Fatal(err)
+	}
+	udb, err := repo.userDB(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := parseTime(t, "2017-01-01T12:00:00Z")
+	for i := 0; i < 2; i++ {
+		review := &fb.Review{Timestamp: ts, Type: fb.ReviewTypeReview}
+		if err := countAnswer(ctx, udb, fb.On(review.Timestamp), review, countScopes("bundle-foo", []string{"deck-foo"}), 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	counts, err := fetchDailyCounts(ctx, udb, fb.On(ts))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := diff.Interface(map[string]int{"": 2, "bundle-foo": 2, "deck-foo": 2}, counts.Reviews); d != nil {
+		t.Error(d)
+	}
+	other, err := fetchDailyCounts(ctx, udb, fb.On(ts.Add(24*time.Hour)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(other.Reviews) != 0 {
+		t.Errorf("Unexpected counts for the next day: %v", other.Reviews)
+	}
+}
+
+func TestGetCardToStudyLimited(t *testing.T) {
+	db := saveCards(t, testClient(t), &fb.Card{ID: "card-foo.bar.0"})
+	card, err := getCardToStudy(context.Background(), db, studyClock{}, func(_ *cardSchedule, _ bool) (bool, error) {
+		return false, nil
+	}, (&Repo{}).pick(SelectWeighted, studyClock{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if card != nil {
+		t.Errorf("Expected no card, got %s", card.ID)
+	}
+}
@@ -92,6 +92,7 @@ func (db *mockBulkDocer) BulkDocs(_ context.Context, docs interface{}) (kivikBul
 
 type mockBulkResults struct {
 	i    int
+	ids  []string
 	errs []error
 	err  error
 }
@@ -104,7 +105,7 @@ func (r *mockBulkResults) Close() error {
 }
 
 func (r *mockBulkResults) Err() error { return r.err }
-func (r *mockBulkResults) ID() string { panic("not done") }
+func (r *mockBulkResults) ID() string { return r.ids[r.i-1] }
 func (r *mockBulkResults) Next() bool {
 	if r.i >= len(r.errs) {
 		return false
@@ -125,16 +126,15 @@ func (c *mockClient) DB(_ context.Context, _ string, _ ...kivik.Options) (kivikD
 	return nil, c.err
 }
 
-type mockQueryGetter struct {
-	*mockQuerier
-	row kivikRow
+type mockFindGetter struct {
+	findGetter
 	err error
 }
 
-var _ queryGetter = &mockQueryGetter{}
+var _ findGetter = &mockFindGetter{}
 
-func (db *mockQueryGetter) Get(_ context.Context, docID string, _ ...kivik.Options) (kivikRow, error) {
-	return db.row, db.err
+func (db *mockFindGetter) Find(_ context.Context, _ interface{}) (kivikRows, error) {
+	return nil, db.err
 }
 
 type mockRow string
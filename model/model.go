@@ -2,13 +2,15 @@ package model
 
 import (
 	"context"
+	"math/rand"
 	"net/http"
+	"sync"
 
 	"github.com/flimzy/kivik"
 	"github.com/go-kivik/couchdb/chttp"
 	"github.com/pkg/errors"
 
-	fb "github.com/FlashbackSRS/flashback-model"
+	"github.com/FlashbackSRS/flashback/fb"
 	"github.com/FlashbackSRS/flashback/oauth2/auth"
 )
 
@@ -28,6 +30,22 @@ type Repo struct {
 	state  kivikDB
 	// user is the username, without the "user-" prefix
 	user string
+	// undo holds the most recent answers, which may be undone.
+	undo undoStack
+	// session is the active custom study session, if any, and scope restricts
+	// regular study. Both are guarded by sessionMu.
+	sessionMu sync.Mutex
+	session   *studySession
+	scope     StudyScope
+	// rnd, if set, is the seeded random source used to select cards, and
+	// lastBundle is the bundle of the card last selected by SelectInterleave.
+	// Both are guarded by selectMu.
+	selectMu   sync.Mutex
+	rnd        *rand.Rand
+	lastBundle string
+	// migrated records the databases brought up to date this session.
+	schemaMu sync.Mutex
+	migrated map[string]bool
 }
 
 // New returns a new Repo instance, pointing to the specified remote server.
@@ -11,7 +11,7 @@ import (
 	"github.com/flimzy/diff"
 	"github.com/flimzy/kivik"
 
-	fb "github.com/FlashbackSRS/flashback-model"
+	"github.com/FlashbackSRS/flashback/fb"
 )
 
 func TestNew(t *testing.T) {
@@ -94,7 +94,48 @@ func testClient(t *testing.T) kivikClient {
 	if err != nil {
 		t.Fatal(err)
 	}
-	return c
+	return &docsClient{c}
+}
+
+// docsClient wraps the memory driver, whose AllDocs ignores include_docs, to
+// include the documents, as PouchDB and CouchDB do.
+type docsClient struct {
+	kivikClient
+}
+
+func (c *docsClient) DB(ctx context.Context, dbName string, options ...kivik.Options) (kivikDB, error) {
+	db, err := c.kivikClient.DB(ctx, dbName, options...)
+	if err != nil {
+		return nil, err
+	}
+	return &docsDB{db}, nil
+}
+
+type docsDB struct {
+	kivikDB
+}
+
+func (db *docsDB) AllDocs(ctx context.Context, options ...kivik.Options) (kivikRows, error) {
+	rows, err := db.kivikDB.AllDocs(ctx, options...)
+	if err != nil {
+		return nil, err
+	}
+	for _, opts := range options {
+		if opts["include_docs"] == true {
+			return &docsRows{kivikRows: rows, ctx: ctx, db: db.kivikDB}, nil
+		}
+	}
+	return rows, nil
+}
+
+type docsRows struct {
+	kivikRows
+	ctx context.Context
+	db  kivikDB
+}
+
+func (r *docsRows) ScanDoc(dest interface{}) error {
+	return getDoc(r.ctx, r.db, r.ID(), dest)
 }
 
 func testDB(t *testing.T) kivikDB {
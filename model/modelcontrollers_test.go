@@ -48,7 +48,7 @@ func TestRegisterModelController(t *testing.T) {
 }
 
 func TestRegisteredModelControllers(t *testing.T) {
-	expected := []string{"basic", "funcmapper", "foo"}
+	expected := []string{"answer", "basic", "funcmapper", "foo"}
 	result := RegisteredModelControllers()
 	if d := diff.Interface(expected, result); d != nil {
 		t.Error(d)
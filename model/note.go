@@ -3,7 +3,7 @@ package model
 import (
 	"encoding/json"
 
-	fb "github.com/FlashbackSRS/flashback-model"
+	"github.com/FlashbackSRS/flashback/fb"
 )
 
 // fbNote is a wrapper around *fb.Note.
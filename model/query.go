@@ -0,0 +1,605 @@
+package model
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/pkg/errors"
+
+	"github.com/FlashbackSRS/flashback/fb"
+)
+
+// Query is a parsed search query, which matches cards and their notes.
+//
+// A query consists of terms separated by whitespace, all of which must match.
+// Terms may be combined with 'or', negated with a leading '-', and grouped
+// with parentheses. Double quotes may be used to include whitespace in a
+// term. The following terms are supported:
+//
+//	bundle:<id>          cards in the bundle
+//	deck:<name>          cards in a deck with the given name or ID
+//	tag:<tag>            cards whose note has the tag
+//	is:<state>           cards which are new, due, suspended, buried or leech
+//	prop:<prop><op><n>   cards whose ivl, due (in days from today), reps,
+//	                     lapses or ease compare to n with one of the
+//	                     operators =, !=, <, <=, > or >=
+//	field:<name>:<text>  cards whose note's field matches text
+//	<text>               cards whose note contains text in any field
+//
+// Except for field:, which must match the entire field, text matches any
+// part of the value. Text, tags and deck names may contain * wildcards, and
+// are matched without regard to case.
+type Query struct {
+	root queryNode
+}
+
+// queryNeeds indicates the data, in addition to the card, required to
+// evaluate a query.
+type queryNeeds int
+
+const (
+	needNote queryNeeds = 1 << iota
+	needDecks
+)
+
+// searchItem holds a card, and its related data, for evaluation.
+type searchItem struct {
+	card *fb.Card
+	// tags are the tags of the card's note, if needed.
+	tags []string
+	// fields maps the lower-cased field names of the card's note to their
+	// values, if needed.
+	fields map[string]string
+	// decks are the decks containing the card, if needed.
+	decks []*fb.Deck
+	// clock is the study clock against which due dates are compared.
+	clock studyClock
+}
+
+type queryNode interface {
+	match(item *searchItem) bool
+	// selector returns a Mango selector which matches at least those cards
+	// matched by the node, or nil if the node cannot be expressed as such.
+	selector() map[string]interface{}
+	needs() queryNeeds
+}
+
+// ParseQuery parses a search query. An empty query matches all cards.
+func ParseQuery(query string) (*Query, error) {
+	tokens, err := lexQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	p := &queryParser{tokens: tokens}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if !p.done() {
+		return nil, errors.Errorf("unexpected '%s'", p.peek().text)
+	}
+	return &Query{root: root}, nil
+}
+
+// Selector returns a Mango selector which matches at least those cards
+// matched by the query, or nil if the query cannot be expressed as a
+// selector. The cards it matches must still be filtered by evaluating the
+// query, as Repo.Search does.
+func (q *Query) Selector() map[string]interface{} {
+	return q.root.selector()
+}
+
+func (q *Query) match(item *searchItem) bool {
+	return q.root.match(item)
+}
+
+func (q *Query) needs() queryNeeds {
+	return q.root.needs()
+}
+
+type tokenType int
+
+const (
+	tokenTerm tokenType = iota
+	tokenOr
+	tokenAnd
+	tokenNot
+	tokenOpen
+	tokenClose
+)
+
+type queryToken struct {
+	typ  tokenType
+	text string
+}
+
+func lexQuery(query string) ([]queryToken, error) {
+	var tokens []queryToken
+	runes := []rune(query)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+			continue
+		case r == '(':
+			tokens = append(tokens, queryToken{typ: tokenOpen, text: "("})
+			i++
+			continue
+		case r == ')':
+			tokens = append(tokens, queryToken{typ: tokenClose, text: ")"})
+			i++
+			continue
+		case r == '-' && i+1 < len(runes) && !unicode.IsSpace(runes[i+1]):
+			tokens = append(tokens, queryToken{typ: tokenNot, text: "-"})
+			i++
+			continue
+		}
+		var text []rune
+		var quoted, inQuotes bool
+		for ; i < len(runes); i++ {
+			r := runes[i]
+			if r == '"' {
+				quoted = true
+				inQuotes = !inQuotes
+				continue
+			}
+			if !inQuotes && (unicode.IsSpace(r) || r == '(' || r == ')') {
+				break
+			}
+			text = append(text, r)
+		}
+		if inQuotes {
+			return nil, errors.New("unterminated quote")
+		}
+		token := queryToken{typ: tokenTerm, text: string(text)}
+		if !quoted {
+			switch strings.ToLower(token.text) {
+			case "or":
+				token.typ = tokenOr
+			case "and":
+				token.typ = tokenAnd
+			}
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens, nil
+}
+
+type queryParser struct {
+	tokens []queryToken
+	pos    int
+}
+
+func (p *queryParser) done() bool {
+	return p.pos >= len(p.tokens)
+}
+
+func (p *queryParser) peek() queryToken {
+	return p.tokens[p.pos]
+}
+
+func (p *queryParser) parseOr() (queryNode, error) {
+	node, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	children := []queryNode{node}
+	for !p.done() && p.peek().typ == tokenOr {
+		p.pos++
+		node, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		children = append(children, node)
+	}
+	if len(children) == 1 {
+		return children[0], nil
+	}
+	return orNode(children), nil
+}
+
+func (p *queryParser) parseAnd() (queryNode, error) {
+	var children andNode
+	for !p.done() {
+		switch p.peek().typ {
+		case tokenOr, tokenClose:
+			return p.and(children)
+		case tokenAnd:
+			if len(children) == 0 {
+				return nil, errors.New("unexpected 'and'")
+			}
+			p.pos++
+			if p.done() {
+				return nil, errors.New("unexpected end of query")
+			}
+		}
+		node, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		children = append(children, node)
+	}
+	return p.and(children)
+}
+
+func (p *queryParser) and(children andNode) (queryNode, error) {
+	switch len(children) {
+	case 0:
+		if !p.done() || p.pos > 0 {
+			return nil, errors.New("empty expression")
+		}
+		return children, nil
+	case 1:
+		return children[0], nil
+	}
+	return children, nil
+}
+
+func (p *queryParser) parseUnary() (queryNode, error) {
+	token := p.peek()
+	p.pos++
+	switch token.typ {
+	case tokenNot:
+		if p.done() {
+			return nil, errors.New("unexpected end of query")
+		}
+		node, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{node}, nil
+	case tokenOpen:
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.done() || p.peek().typ != tokenClose {
+			return nil, errors.New("missing ')'")
+		}
+		p.pos++
+		return node, nil
+	case tokenTerm:
+		return parseTerm(token.text)
+	}
+	return nil, errors.Errorf("unexpected '%s'", token.text)
+}
+
+func parseTerm(text string) (queryNode, error) {
+	parts := strings.SplitN(text, ":", 2)
+	if len(parts) == 1 {
+		return newTextTerm(text), nil
+	}
+	key, value := strings.ToLower(parts[0]), parts[1]
+	switch key {
+	case "bundle":
+		return bundleTerm(strings.TrimPrefix(value, "bundle-")), nil
+	case "deck":
+		return deckTerm{id: value, name: globPattern(value, true)}, nil
+	case "tag":
+		return tagTerm{globPattern(value, true)}, nil
+	case "is":
+		return parseStateTerm(value)
+	case "prop":
+		return parsePropTerm(value)
+	case "field":
+		fieldParts := strings.SplitN(value, ":", 2)
+		if len(fieldParts) != 2 {
+			return nil, errors.Errorf("invalid field search '%s'", text)
+		}
+		return fieldTerm{name: strings.ToLower(fieldParts[0]), pattern: globPattern(fieldParts[1], true)}, nil
+	}
+	return newTextTerm(text), nil
+}
+
+// globPattern compiles text, which may contain * wildcards, to a
+// case-insensitive regular expression. If whole is true, the entire value
+// must match.
+func globPattern(text string, whole bool) *regexp.Regexp {
+	pattern := strings.Replace(regexp.QuoteMeta(text), `\*`, ".*", -1)
+	if whole {
+		pattern = "^" + pattern + "$"
+	}
+	return regexp.MustCompile("(?is)" + pattern)
+}
+
+type andNode []queryNode
+
+func (n andNode) match(item *searchItem) bool {
+	for _, child := range n {
+		if !child.match(item) {
+			return false
+		}
+	}
+	return true
+}
+
+// selector merges the children's selectors into a single selector, as the
+// local Mango implementation does not support $and. Where two children
+// constrain the same field, only the first is kept, which still matches a
+// superset of the cards.
+func (n andNode) selector() map[string]interface{} {
+	var sel map[string]interface{}
+	for _, child := range n {
+		for field, cond := range child.selector() {
+			if sel == nil {
+				sel = make(map[string]interface{})
+			}
+			if _, ok := sel[field]; !ok {
+				sel[field] = cond
+			}
+		}
+	}
+	return sel
+}
+
+func (n andNode) needs() queryNeeds {
+	var needs queryNeeds
+	for _, child := range n {
+		needs |= child.needs()
+	}
+	return needs
+}
+
+type orNode []queryNode
+
+func (n orNode) match(item *searchItem) bool {
+	for _, child := range n {
+		if child.match(item) {
+			return true
+		}
+	}
+	return false
+}
+
+// selector returns nil, as $or is not supported by all backends.
+func (n orNode) selector() map[string]interface{} { return nil }
+
+func (n orNode) needs() queryNeeds {
+	return andNode(n).needs()
+}
+
+type notNode struct {
+	queryNode
+}
+
+func (n notNode) match(item *searchItem) bool {
+	return !n.queryNode.match(item)
+}
+
+func (n notNode) selector() map[string]interface{} { return nil }
+
+// bundleTerm matches cards in the bundle, given without the "bundle-" prefix.
+type bundleTerm string
+
+func (t bundleTerm) match(item *searchItem) bool {
+	return item.card.BundleID() == "bundle-"+string(t)
+}
+
+// selector only bounds the card ID from below, as the local Mango
+// implementation does not support multiple operators on one field.
+func (t bundleTerm) selector() map[string]interface{} {
+	return map[string]interface{}{
+		"_id": map[string]interface{}{"$gt": "card-" + string(t) + "."},
+	}
+}
+
+func (t bundleTerm) needs() queryNeeds { return 0 }
+
+type deckTerm struct {
+	id   string
+	name *regexp.Regexp
+}
+
+func (t deckTerm) match(item *searchItem) bool {
+	for _, deck := range item.decks {
+		if deck.ID == t.id || t.name != nil && t.name.MatchString(deck.Name) {
+			return true
+		}
+	}
+	return false
+}
+
+func (t deckTerm) selector() map[string]interface{} { return nil }
+
+func (t deckTerm) needs() queryNeeds { return needDecks }
+
+type tagTerm struct {
+	pattern *regexp.Regexp
+}
+
+func (t tagTerm) match(item *searchItem) bool {
+	for _, tag := range item.tags {
+		if t.pattern.MatchString(tag) {
+			return true
+		}
+	}
+	return false
+}
+
+func (t tagTerm) selector() map[string]interface{} { return nil }
+
+func (t tagTerm) needs() queryNeeds { return needNote }
+
+type stateTerm string
+
+func parseStateTerm(state string) (queryNode, error) {
+	switch t := stateTerm(strings.ToLower(state)); t {
+	case "new", "due", "suspended", "buried", "leech":
+		return t, nil
+	}
+	return nil, errors.Errorf("unknown state '%s'", state)
+}
+
+func (t stateTerm) match(item *searchItem) bool {
+	card := item.card
+	switch t {
+	case "new":
+		return card.Interval == 0
+	case "due":
+		return !card.Due.IsZero() && item.clock.reached(card.Due, now())
+	case "suspended":
+		return card.Suspended
+	case "buried":
+		return !item.clock.reached(card.BuriedUntil, now())
+	case "leech":
+		return card.Leech
+	}
+	return false
+}
+
+// selector returns a selector for the state. As the study day depends on the
+// user's time zone, due and buried cards are selected a day either side of
+// today, UTC, and checked by match.
+func (t stateTerm) selector() map[string]interface{} {
+	today := fb.On(now().UTC())
+	switch t {
+	case "due":
+		return map[string]interface{}{"due": map[string]interface{}{"$lt": today.Add(2 * fb.Day).String()}}
+	case "suspended":
+		return map[string]interface{}{"suspended": true}
+	case "buried":
+		return map[string]interface{}{"buriedUntil": map[string]interface{}{"$gte": today.Add(-fb.Day).String()}}
+	case "leech":
+		return map[string]interface{}{"leech": true}
+	}
+	// New cards have no stored interval.
+	return nil
+}
+
+func (t stateTerm) needs() queryNeeds { return 0 }
+
+type propTerm struct {
+	prop  string
+	op    string
+	value float64
+}
+
+// propFields maps properties to the card fields they're stored in, for those
+// properties which may be compared in a selector.
+var propFields = map[string]string{
+	"ivl":    "interval",
+	"reps":   "reviewCount",
+	"lapses": "lapseCount",
+	"ease":   "easeFactor",
+}
+
+func parsePropTerm(text string) (queryNode, error) {
+	i := strings.IndexAny(text, "=!<>")
+	if i < 0 {
+		return nil, errors.Errorf("invalid property search '%s'", text)
+	}
+	t := propTerm{prop: strings.ToLower(text[:i])}
+	rest := text[i:]
+	for _, op := range []string{"!=", "<=", ">=", "=", "<", ">"} {
+		if strings.HasPrefix(rest, op) {
+			t.op = op
+			break
+		}
+	}
+	if t.op == "" {
+		return nil, errors.Errorf("invalid property search '%s'", text)
+	}
+	if _, ok := propFields[t.prop]; !ok && t.prop != "due" {
+		return nil, errors.Errorf("unknown property '%s'", t.prop)
+	}
+	value, err := strconv.ParseFloat(strings.TrimPrefix(rest, t.op), 64)
+	if err != nil {
+		return nil, errors.Errorf("invalid property value in '%s'", text)
+	}
+	t.value = value
+	return t, nil
+}
+
+func (t propTerm) match(item *searchItem) bool {
+	card := item.card
+	var value float64
+	switch t.prop {
+	case "ivl":
+		value = float64(card.Interval.Days())
+	case "due":
+		if card.Due.IsZero() {
+			return false
+		}
+		value = float64(item.clock.on(item.clock.time(card.Due)).Sub(item.clock.today()) / fb.Day)
+	case "reps":
+		value = float64(card.ReviewCount)
+	case "lapses":
+		value = float64(card.LapseCount)
+	case "ease":
+		value = float64(card.EaseFactor)
+	}
+	switch t.op {
+	case "=":
+		return value == t.value
+	case "!=":
+		return value != t.value
+	case "<":
+		return value < t.value
+	case "<=":
+		return value <= t.value
+	case ">":
+		return value > t.value
+	}
+	return value >= t.value
+}
+
+// selector returns a selector only for comparisons which exclude zero values,
+// as these are omitted from stored cards.
+func (t propTerm) selector() map[string]interface{} {
+	field, ok := propFields[t.prop]
+	if !ok || t.value <= 0 {
+		return nil
+	}
+	var op string
+	switch t.op {
+	case "=":
+		op = "$eq"
+	case ">":
+		op = "$gt"
+	case ">=":
+		op = "$gte"
+	default:
+		return nil
+	}
+	return map[string]interface{}{field: map[string]interface{}{op: t.value}}
+}
+
+func (t propTerm) needs() queryNeeds { return 0 }
+
+type fieldTerm struct {
+	name    string
+	pattern *regexp.Regexp
+}
+
+func (t fieldTerm) match(item *searchItem) bool {
+	value, ok := item.fields[t.name]
+	return ok && t.pattern.MatchString(value)
+}
+
+func (t fieldTerm) selector() map[string]interface{} { return nil }
+
+func (t fieldTerm) needs() queryNeeds { return needNote }
+
+type textTerm struct {
+	pattern *regexp.Regexp
+}
+
+func newTextTerm(text string) textTerm {
+	return textTerm{globPattern(text, false)}
+}
+
+func (t textTerm) match(item *searchItem) bool {
+	for _, value := range item.fields {
+		if t.pattern.MatchString(value) {
+			return true
+		}
+	}
+	return false
+}
+
+func (t textTerm) selector() map[string]interface{} { return nil }
+
+func (t textTerm) needs() queryNeeds { return needNote }
@@ -0,0 +1,157 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/FlashbackSRS/flashback/fb"
+	"github.com/flimzy/diff"
+)
+
+func TestParseQueryErrors(t *testing.T) {
+	tests := []struct {
+		query string
+		err   string
+	}{
+		{query: `"foo`, err: "unterminated quote"},
+		{query: "(foo", err: "missing ')'"},
+		{query: "foo)", err: "unexpected ')'"},
+		{query: "()", err: "empty expression"},
+		{query: "foo or", err: "empty expression"},
+		{query: "and foo", err: "unexpected 'and'"},
+		{query: "is:lost", err: "unknown state 'lost'"},
+		{query: "prop:ivl", err: "invalid property search 'ivl'"},
+		{query: "prop:size>3", err: "unknown property 'size'"},
+		{query: "prop:ivl>x", err: "invalid property value in 'ivl>x'"},
+		{query: "field:Front", err: "invalid field search 'field:Front'"},
+	}
+	for _, test := range tests {
+		t.Run(test.query, func(t *testing.T) {
+			_, err := ParseQuery(test.query)
+			checkErr(t, test.err, err)
+		})
+	}
+}
+
+func TestQueryMatch(t *testing.T) {
+	verbs := &searchItem{
+		card:   &fb.Card{ID: "card-foo.bar.0", Interval: 40 * fb.Day, Due: parseDue(t, "2016-12-30"), ReviewCount: 5},
+		tags:   []string{"verbs", "Spanish"},
+		fields: map[string]string{"front": "comer", "back": "to eat"},
+		decks:  []*fb.Deck{{ID: "deck-foo", Name: "Spanish Verbs"}},
+	}
+	newCard := &searchItem{
+		card:   &fb.Card{ID: "card-bar.baz.0"},
+		fields: map[string]string{"front": "hello", "back": "hola"},
+	}
+	suspended := &searchItem{
+		card: &fb.Card{ID: "card-foo.qux.0", Interval: 2 * fb.Day, Suspended: true, Leech: true, LapseCount: 8,
+			BuriedUntil: parseDue(t, "2017-01-02")},
+	}
+	items := []*searchItem{verbs, newCard, suspended}
+	tests := []struct {
+		query    string
+		expected []*searchItem
+	}{
+		{query: "", expected: items},
+		{query: "bundle:foo", expected: []*searchItem{verbs, suspended}},
+		{query: "bundle:bundle-bar", expected: []*searchItem{newCard}},
+		{query: "deck:spanish*", expected: []*searchItem{verbs}},
+		{query: "deck:deck-foo", expected: []*searchItem{verbs}},
+		{query: "tag:verbs", expected: []*searchItem{verbs}},
+		{query: "tag:verb", expected: []*searchItem{}},
+		{query: "is:new", expected: []*searchItem{newCard}},
+		{query: "is:due", expected: []*searchItem{verbs}},
+		{query: "is:suspended", expected: []*searchItem{suspended}},
+		{query: "is:buried", expected: []*searchItem{suspended}},
+		{query: "is:leech", expected: []*searchItem{suspended}},
+		{query: "prop:ivl>30", expected: []*searchItem{verbs}},
+		{query: "prop:ivl<=2", expected: []*searchItem{newCard, suspended}},
+		{query: "prop:due=-2", expected: []*searchItem{verbs}},
+		{query: "prop:reps!=5", expected: []*searchItem{newCard, suspended}},
+		{query: "prop:lapses>=8", expected: []*searchItem{suspended}},
+		{query: "field:Front:*ME*", expected: []*searchItem{verbs}},
+		{query: "field:front:com", expected: []*searchItem{}},
+		{query: "eat", expected: []*searchItem{verbs}},
+		{query: `"to eat"`, expected: []*searchItem{verbs}},
+		{query: "h*a", expected: []*searchItem{newCard}},
+		{query: "-bundle:foo", expected: []*searchItem{newCard}},
+		{query: "bundle:foo -is:suspended", expected: []*searchItem{verbs}},
+		{query: "bundle:foo and is:suspended", expected: []*searchItem{suspended}},
+		{query: "is:new or is:leech", expected: []*searchItem{newCard, suspended}},
+		{query: "bundle:foo (is:due OR is:new)", expected: []*searchItem{verbs}},
+		{query: "-(is:new or is:leech)", expected: []*searchItem{verbs}},
+	}
+	for _, test := range tests {
+		t.Run(test.query, func(t *testing.T) {
+			q, err := ParseQuery(test.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			result := []*searchItem{}
+			for _, item := range items {
+				if q.match(item) {
+					result = append(result, item)
+				}
+			}
+			if d := diff.Interface(test.expected, result); d != nil {
+				t.Error(d)
+			}
+		})
+	}
+}
+
+func TestQuerySelector(t *testing.T) {
+	tests := []struct {
+		query    string
+		expected map[string]interface{}
+		needs    queryNeeds
+	}{
+		{query: ""},
+		{query: "is:new"},
+		{query: "is:suspended", expected: map[string]interface{}{"suspended": true}},
+		{
+			query: "is:leech prop:ivl>30",
+			expected: map[string]interface{}{
+				"leech":    true,
+				"interval": map[string]interface{}{"$gt": float64(30)},
+			},
+		},
+		{
+			query:    "prop:ivl>30 prop:ivl>=60",
+			expected: map[string]interface{}{"interval": map[string]interface{}{"$gt": float64(30)}},
+		},
+		{query: "prop:ivl<30"},
+		{query: "prop:reps=0"},
+		{query: "is:leech or is:suspended"},
+		{query: "-is:suspended"},
+		{
+			query:    "is:suspended tag:verbs",
+			expected: map[string]interface{}{"suspended": true},
+			needs:    needNote,
+		},
+		{
+			query:    "bundle:foo",
+			expected: map[string]interface{}{"_id": map[string]interface{}{"$gt": "card-foo."}},
+		},
+		{
+			query:    "is:due deck:foo",
+			expected: map[string]interface{}{"due": map[string]interface{}{"$lt": "2017-01-03"}},
+			needs:    needDecks,
+		},
+		{query: "-field:front:foo or deck:bar", needs: needNote | needDecks},
+	}
+	for _, test := range tests {
+		t.Run(test.query, func(t *testing.T) {
+			q, err := ParseQuery(test.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if d := diff.Interface(test.expected, q.Selector()); d != nil {
+				t.Error(d)
+			}
+			if needs := q.needs(); needs != test.needs {
+				t.Errorf("Unexpected needs: %d", needs)
+			}
+		})
+	}
+}
@@ -0,0 +1,153 @@
+package model
+
+import (
+	"context"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/FlashbackSRS/flashback/fb"
+)
+
+// cardQueueIndex is the Mango index used to select cards for study. Only
+// cards with a queue field are indexed, so suspended cards are excluded, and
+// cards are ordered by the time from which they may be studied, so that
+// buried cards sort after those ready for study.
+var cardQueueIndex = map[string]interface{}{
+	"fields": []string{"queue", "available", "_id"},
+}
+
+// cardQueueIndexName identifies cardQueueIndex, and its design document.
+const cardQueueIndexName = "card-queue"
+
+// cardQueueFields are the fields fetched when selecting cards.
+var cardQueueFields = []string{"_id", "interval", "due", "buriedUntil", "suspended", "available"}
+
+// queueKey is the position of a card within its queue.
+type queueKey struct {
+	available fb.Due
+	id        string
+}
+
+// fetchQueue returns up to limit cards from queue, in order of availability,
+// skipping buried cards and those for which allow returns false. If ready is
+// true, only cards available now, according to clock, are considered. Each
+// page is fetched by key, starting after the last card of the previous page,
+// so that every query is served directly by cardQueueIndex.
+func fetchQueue(ctx context.Context, db finder, clock studyClock, queue string, limit int, ready bool, allow func(*cardSchedule) (bool, error)) ([]*cardSchedule, error) {
+	defer profile("fetchQueue: %s", queue)()
+	if limit <= 0 {
+		return nil, errors.New("invalid limit")
+	}
+	t := now()
+	// Once a card's availability is beyond the horizon, no later card can be
+	// available now, whatever the time zone.
+	horizon := fb.Due(t.UTC().Add(2 * time.Duration(fb.Day)))
+	pageSize := limit + limitPadding
+	cards := make([]*cardSchedule, 0, limit)
+	var after *queueKey
+	for {
+		page, err := findQueuePage(ctx, db, queue, after, pageSize)
+		if err != nil {
+			return nil, err
+		}
+		for _, card := range page {
+			if ready && card.Available.After(horizon) {
+				return cards, nil
+			}
+			if ready && !clock.reached(card.Available, t) {
+				continue
+			}
+			if card.Suspended || !clock.reached(card.BuriedUntil, t) {
+				continue
+			}
+			if allow != nil {
+				ok, err := allow(card)
+				if err != nil {
+					return nil, err
+				}
+				if !ok {
+					continue
+				}
+			}
+			cards = append(cards, card)
+			if len(cards) == limit {
+				return cards, nil
+			}
+		}
+		if len(page) < pageSize {
+			return cards, nil
+		}
+		last := page[len(page)-1]
+		after = &queueKey{available: last.Available, id: last.ID}
+	}
+}
+
+// findQueuePage returns up to size cards from queue, following after, or from
+// the start of the queue if after is nil. As a Mango selector cannot express
+// a compound key range without $or, which not all backends support, cards
+// sharing after's availability are fetched first, followed by those
+// available later.
+func findQueuePage(ctx context.Context, db finder, queue string, after *queueKey, size int) ([]*cardSchedule, error) {
+	selectors := []map[string]interface{}{
+		{"queue": queue, "available": map[string]interface{}{"$gte": ""}},
+	}
+	if after != nil {
+		selectors = []map[string]interface{}{
+			{"queue": queue, "available": after.available.String(), "_id": map[string]interface{}{"$gt": after.id}},
+			{"queue": queue, "available": map[string]interface{}{"$gt": after.available.String()}},
+		}
+	}
+	page := make([]*cardSchedule, 0, size)
+	for _, selector := range selectors {
+		cards, err := findQueueCards(ctx, db, selector, size-len(page))
+		if err != nil {
+			return nil, err
+		}
+		page = append(page, cards...)
+		if len(page) == size {
+			break
+		}
+	}
+	return page, nil
+}
+
+// findQueueCards returns up to limit cards matching selector, in queue order.
+// The results are also sorted and truncated locally, for backends which
+// ignore the sort order or limit.
+func findQueueCards(ctx context.Context, db finder, selector map[string]interface{}, limit int) ([]*cardSchedule, error) {
+	rows, err := db.Find(ctx, map[string]interface{}{
+		"selector": selector,
+		"sort":     cardQueueIndex["fields"],
+		"limit":    limit,
+		"fields":   cardQueueFields,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "find failed")
+	}
+	defer func() { _ = rows.Close() }()
+	cards := make([]*cardSchedule, 0, limit)
+	for rows.Next() {
+		card := &cardSchedule{}
+		if err := rows.ScanDoc(card); err != nil {
+			return nil, errors.Wrapf(err, "scan card %s", rows.ID())
+		}
+		cards = append(cards, card)
+	}
+	if err := rows.Err(); err != nil && err != io.EOF {
+		return nil, err
+	}
+	sort.Slice(cards, func(i, j int) bool {
+		a, b := cards[i], cards[j]
+		if !a.Available.Equal(b.Available) {
+			return b.Available.After(a.Available)
+		}
+		return a.ID < b.ID
+	})
+	if len(cards) > limit {
+		cards = cards[:limit]
+	}
+	return cards, nil
+}
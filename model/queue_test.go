@@ -0,0 +1,218 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/FlashbackSRS/flashback/fb"
+	"github.com/flimzy/diff"
+)
+
+// saveCards stores the cards in a new user database for mjxwe.
+func saveCards(t *testing.T, client kivikClient, cards ...*fb.Card) kivikDB {
+	ctx := context.Background()
+	if err := client.CreateDB(ctx, "user-mjxwe"); err != nil {
+		t.Fatal(err)
+	}
+	db, err := client.DB(ctx, "user-mjxwe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, card := range cards {
+		if card.ModelID == "" {
+			card.ModelID = "theme-foo/0"
+		}
+		if card.Created.IsZero() {
+			card.Created = parseTime(t, "2016-12-01T00:00:00Z")
+		}
+		card.Modified = card.Created
+		if err := saveDoc(ctx, db, card); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return db
+}
+
+func TestFetchQueue(t *testing.T) {
+	db := saveCards(t, testClient(t),
+		&fb.Card{ID: "card-foo.bar.0"},
+		&fb.Card{ID: "card-foo.bar.1", Created: parseTime(t, "2016-12-02T00:00:00Z"), BuriedUntil: parseDue(t, "2017-01-02")},
+		&fb.Card{ID: "card-foo.bar.2", Created: parseTime(t, "2017-01-01T13:00:00Z")},
+		&fb.Card{ID: "card-foo.baz.0", Due: parseDue(t, "2016-12-30"), Interval: fb.Day},
+		&fb.Card{ID: "card-foo.baz.1", Due: parseDue(t, "2017-01-05"), Interval: fb.Day},
+		&fb.Card{ID: "card-foo.baz.2", Due: parseDue(t, "2016-12-31"), Interval: fb.Day, BuriedUntil: parseDue(t, "2017-01-03")},
+		&fb.Card{ID: "card-foo.baz.3", Due: parseDue(t, "2016-12-25"), Interval: fb.Day, Suspended: true},
+	)
+	ties := make([]*fb.Card, 0, 70)
+	for i := 0; i < 70; i++ {
+		due := "2016-12-20"
+		if i >= 60 {
+			due = "2016-12-21"
+		}
+		ties = append(ties, &fb.Card{ID: fmt.Sprintf("card-foo.qux%02d.0", i), Due: parseDue(t, due), Interval: fb.Day})
+	}
+	tiesDB := saveCards(t, testClient(t), ties...)
+	tests := []struct {
+		name     string
+		db       finder
+		queue    string
+		limit    int
+		ready    bool
+		allow    func(*cardSchedule) (bool, error)
+		expected []string
+		err      string
+	}{
+		{
+			name:  "invalid limit",
+			db:    db,
+			queue: fb.QueueNew,
+			err:   "invalid limit",
+		},
+		{
+			name:  "find error",
+			db:    &mockFindGetter{err: errors.New("boom")},
+			queue: fb.QueueNew,
+			limit: 5,
+			err:   "find failed: boom",
+		},
+		{
+			name:     "new",
+			db:       db,
+			queue:    fb.QueueNew,
+			limit:    5,
+			ready:    true,
+			expected: []string{"card-foo.bar.0"},
+		},
+		{
+			name:     "review",
+			db:       db,
+			queue:    fb.QueueReview,
+			limit:    5,
+			expected: []string{"card-foo.baz.0", "card-foo.baz.1"},
+		},
+		{
+			name:     "limit",
+			db:       db,
+			queue:    fb.QueueReview,
+			limit:    1,
+			expected: []string{"card-foo.baz.0"},
+		},
+		{
+			name:  "filtered",
+			db:    db,
+			queue: fb.QueueReview,
+			limit: 5,
+			allow: func(card *cardSchedule) (bool, error) {
+				return card.ID != "card-foo.baz.0", nil
+			},
+			expected: []string{"card-foo.baz.1"},
+		},
+		{
+			name:  "filter error",
+			db:    db,
+			queue: fb.QueueReview,
+			limit: 5,
+			allow: func(_ *cardSchedule) (bool, error) {
+				return false, errors.New("filter failed")
+			},
+			err: "filter failed",
+		},
+		{
+			name:  "paginated",
+			db:    tiesDB,
+			queue: fb.QueueReview,
+			limit: 5,
+			allow: func(card *cardSchedule) (bool, error) {
+				return strings.HasSuffix(card.ID, "5.0"), nil
+			},
+			expected: []string{"card-foo.qux05.0", "card-foo.qux15.0", "card-foo.qux25.0", "card-foo.qux35.0", "card-foo.qux45.0"},
+		},
+		{
+			name:  "paginated past ties",
+			db:    tiesDB,
+			queue: fb.QueueReview,
+			limit: 5,
+			allow: func(card *cardSchedule) (bool, error) {
+				return card.Due.Equal(parseDue(t, "2016-12-21")), nil
+			},
+			expected: []string{"card-foo.qux60.0", "card-foo.qux61.0", "card-foo.qux62.0", "card-foo.qux63.0", "card-foo.qux64.0"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cards, err := fetchQueue(context.Background(), test.db, studyClock{}, test.queue, test.limit, test.ready, test.allow)
+			checkErr(t, test.err, err)
+			if err != nil {
+				return
+			}
+			ids := make([]string, len(cards))
+			for i, card := range cards {
+				ids[i] = card.ID
+			}
+			if d := diff.Interface(test.expected, ids); d != nil {
+				t.Error(d)
+			}
+		})
+	}
+}
+
+// benchmarkCards is the number of cards stored for BenchmarkFetchQueue.
+const benchmarkCards = 100000
+
+func BenchmarkFetchQueue(b *testing.B) {
+	ctx := context.Background()
+	client, err := localConnection()
+	if err != nil {
+		b.Fatal(err)
+	}
+	if err = client.CreateDB(ctx, "user-mjxwe"); err != nil {
+		b.Fatal(err)
+	}
+	db, err := client.DB(ctx, "user-mjxwe")
+	if err != nil {
+		b.Fatal(err)
+	}
+	created := now().Add(-365 * 24 * time.Hour)
+	for i := 0; i < benchmarkCards; i++ {
+		card := &fb.Card{
+			ID:       fmt.Sprintf("card-foo.bar%06d.0", i),
+			ModelID:  "theme-foo/0",
+			Created:  created,
+			Modified: created,
+		}
+		// One card in four is new; the rest fall due over the year.
+		if i%4 != 0 {
+			card.Due = fb.Due(created.Add(time.Duration(i%365) * 24 * time.Hour))
+			card.Interval = fb.Day
+		}
+		if err := saveDoc(ctx, db, card); err != nil {
+			b.Fatal(err)
+		}
+	}
+	odd := func(card *cardSchedule) (bool, error) {
+		return card.ID[len(card.ID)-3]%2 == 1, nil
+	}
+	benchmarks := []struct {
+		name  string
+		queue string
+		ready bool
+		allow func(*cardSchedule) (bool, error)
+	}{
+		{name: "new", queue: fb.QueueNew, ready: true},
+		{name: "review", queue: fb.QueueReview},
+		{name: "filtered", queue: fb.QueueReview, allow: odd},
+	}
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := fetchQueue(ctx, db, studyClock{}, bm.queue, oldBatchSize, bm.ready, bm.allow); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
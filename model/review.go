@@ -0,0 +1,85 @@
+package model
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/FlashbackSRS/flashback"
+	"github.com/FlashbackSRS/flashback/fb"
+)
+
+// newReview returns a review log entry for the card, recording its state
+// prior to rescheduling. The new interval and ease are recorded by
+// completeReview once the card has been rescheduled.
+func newReview(card *fb.Card, answerDelay time.Duration, quality flashback.AnswerQuality) (*fb.Review, error) {
+	review, err := fb.NewReviewAt(card.ID, now())
+	if err != nil {
+		return nil, err
+	}
+	review.Ease = reviewEase(quality)
+	review.PreviousInterval = card.Interval
+	review.ReviewTime = answerDelay
+	review.Type = reviewType(card)
+	return review, nil
+}
+
+// completeReview records the card's new schedule in the review.
+func completeReview(review *fb.Review, card *fb.Card) {
+	review.Interval = card.Interval
+	review.SRSFactor = card.EaseFactor
+}
+
+// reviewEase maps an answer quality to the corresponding answer button.
+func reviewEase(q flashback.AnswerQuality) fb.ReviewEase {
+	switch {
+	case q <= flashback.AnswerIncorrectEasy:
+		return fb.ReviewEaseWrong
+	case q == flashback.AnswerCorrectDifficult:
+		return fb.ReviewEaseHard
+	case q == flashback.AnswerCorrect:
+		return fb.ReviewEaseOK
+	}
+	return fb.ReviewEaseEasy
+}
+
+// reviewType determines the type of review, based on the card's state before
+// it is rescheduled.
+func reviewType(card *fb.Card) fb.ReviewType {
+	switch {
+	case card.LearningStep > 0 && card.Relearning:
+		return fb.ReviewTypeRelearn
+	case card.LearningStep > 0:
+		return fb.ReviewTypeLearn
+	case card.Interval >= fb.Day:
+		return fb.ReviewTypeReview
+	case card.LastReview.IsZero():
+		return fb.ReviewTypeLearn
+	}
+	return fb.ReviewTypeRelearn
+}
+
+// findReviews returns all of the review log entries stored in db.
+func findReviews(ctx context.Context, db finder) ([]*fb.Review, error) {
+	rows, err := db.Find(ctx, map[string]interface{}{
+		"selector": map[string]string{"type": "review"},
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "find reviews")
+	}
+	defer func() { _ = rows.Close() }()
+	reviews := make([]*fb.Review, 0)
+	for rows.Next() {
+		review := &fb.Review{}
+		if err := rows.ScanDoc(review); err != nil {
+			return nil, errors.Wrapf(err, "scan review %s", rows.ID())
+		}
+		reviews = append(reviews, review)
+	}
+	if err := rows.Err(); err != nil && err != io.EOF {
+		return nil, err
+	}
+	return reviews, nil
+}
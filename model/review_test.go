@@ -0,0 +1,149 @@
+package model
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/FlashbackSRS/flashback"
+	"github.com/FlashbackSRS/flashback/fb"
+	"github.com/flimzy/diff"
+)
+
+// answerCM is a model controller which schedules the card with the quality
+// passed as the query.
+type answerCM struct {
+	ModelController
+}
+
+func (cm *answerCM) Type() string { return "answer" }
+func (cm *answerCM) Action(card *Card, _ *int, _ time.Time, query interface{}) (bool, error) {
+	return true, Schedule(card, time.Second, query.(flashback.AnswerQuality))
+}
+
+var _ ModelController = &answerCM{}
+
+func init() {
+	RegisterModelController(&answerCM{})
+}
+
+func TestReviewEase(t *testing.T) {
+	tests := []struct {
+		quality  flashback.AnswerQuality
+		expected fb.ReviewEase
+	}{
+		{flashback.AnswerBlackout, fb.ReviewEaseWrong},
+		{flashback.AnswerIncorrectRemembered, fb.ReviewEaseWrong},
+		{flashback.AnswerIncorrectEasy, fb.ReviewEaseWrong},
+		{flashback.AnswerCorrectDifficult, fb.ReviewEaseHard},
+		{flashback.AnswerCorrect, fb.ReviewEaseOK},
+		{flashback.AnswerPerfect, fb.ReviewEaseEasy},
+	}
+	for _, test := range tests {
+		t.Run(fmt.Sprintf("%d", test.quality), func(t *testing.T) {
+			if result := reviewEase(test.quality); result != test.expected {
+				t.Errorf("Unexpected result: %d", result)
+			}
+		})
+	}
+}
+
+func TestReviewType(t *testing.T) {
+	tests := []struct {
+		name     string
+		card     *fb.Card
+		expected fb.ReviewType
+	}{
+		{
+			name:     "new",
+			card:     &fb.Card{},
+			expected: fb.ReviewTypeLearn,
+		},
+		{
+			name:     "learning",
+			card:     &fb.Card{Interval: 10 * fb.Minute},
+			expected: fb.ReviewTypeLearn,
+		},
+		{
+			name:     "review",
+			card:     &fb.Card{Interval: 3 * fb.Day, LastReview: now()},
+			expected: fb.ReviewTypeReview,
+		},
+		{
+			name:     "relearning",
+			card:     &fb.Card{Interval: 10 * fb.Minute, LastReview: now()},
+			expected: fb.ReviewTypeRelearn,
+		},
+		{
+			name:     "learning step",
+			card:     &fb.Card{Interval: fb.Day, LearningStep: 2, LastReview: now()},
+			expected: fb.ReviewTypeLearn,
+		},
+		{
+			name:     "relearning step",
+			card:     &fb.Card{Interval: fb.Day, LearningStep: 2, Relearning: true, LastReview: now()},
+			expected: fb.ReviewTypeRelearn,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if result := reviewType(test.card); result != test.expected {
+				t.Errorf("Unexpected result: %d", result)
+			}
+		})
+	}
+}
+
+func TestCardActionReview(t *testing.T) {
+	repo := &Repo{user: "mjxwe", local: testClient(t)}
+	if err := repo.local.CreateDB(context.Background(), "user-mjxwe"); err != nil {
+		t.Fatal(err)
+	}
+	card := &Card{
+		Card: &fb.Card{
+			ID:       "card-foo.bar.0",
+			ModelID:  "theme-foo/0",
+			Created:  now(),
+			Modified: now(),
+			Interval: 3 * fb.Day,
+		},
+		model: &fbModel{Model: &fb.Model{Type: "answer"}},
+		repo:  repo,
+	}
+	face := Answer
+	done, err := card.Action(context.Background(), &face, now(), flashback.AnswerCorrect)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !done {
+		t.Errorf("Expected done")
+	}
+	if card.review != nil {
+		t.Errorf("Pending review not cleared")
+	}
+	udb, err := repo.userDB(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	review := &fb.Review{}
+	reviewID := fmt.Sprintf("review-foo.bar.0.%d", now().UnixNano())
+	if err := getDoc(context.Background(), udb, reviewID, review); err != nil {
+		t.Fatal(err)
+	}
+	review.Rev = ""
+	expected := &fb.Review{
+		ID:               reviewID,
+		CardID:           "card-foo.bar.0",
+		Timestamp:        now().UTC(),
+		Ease:             fb.ReviewEaseOK,
+		Interval:         card.Interval,
+		PreviousInterval: 3 * fb.Day,
+		SRSFactor:        2.5,
+		ReviewTime:       time.Second,
+		Type:             fb.ReviewTypeReview,
+	}
+	if d := diff.Interface(expected, review); d != nil {
+		t.Error(d)
+	}
+}
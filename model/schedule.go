@@ -4,35 +4,83 @@ import (
 	"time"
 
 	"github.com/FlashbackSRS/flashback"
-	fb "github.com/FlashbackSRS/flashback-model"
+	"github.com/FlashbackSRS/flashback/fb"
 	"github.com/flimzy/log"
 )
 
-// Schedule implements the default scheduler.
+func init() {
+	RegisterScheduler(&sm2{})
+}
+
+// Schedule reschedules the card in response to an answer, and buries the card
+// until it may usefully be studied again. Cards in their learning or
+// relearning steps advance through the configured steps; other cards are
+// scheduled using the scheduler configured for the card, and the resulting
+// interval is fuzzed. A review log entry is prepared, to be stored along with
+// the card.
 func Schedule(card *Card, answerDelay time.Duration, quality flashback.AnswerQuality) error {
-	ivl, ease := schedule(card, quality)
-	card.Due = fb.Due(now()).Add(ivl)
-	card.Interval = ivl
-	card.EaseFactor = ease
-	if quality <= flashback.AnswerIncorrectEasy {
-		card.ReviewCount = 0
-	} else {
-		card.LastReview = now().UTC()
-		card.ReviewCount++
+	config := card.settings()
+	s, err := GetScheduler(config.Scheduler)
+	if err != nil {
+		return err
+	}
+	review, err := newReview(card.Card, answerDelay, quality)
+	if err != nil {
+		return err
 	}
+	if !scheduleStep(card.Card, card.clock, config, quality) {
+		prevInterval := card.Interval
+		if err := s.Schedule(card, answerDelay, quality); err != nil {
+			return err
+		}
+		recordLapse(card.Card, prevInterval, config, quality)
+		startRelearning(card.Card, card.clock, prevInterval, config, quality)
+		if ivl := fuzzInterval(card.Interval); ivl != card.Interval {
+			card.Interval = ivl
+			card.Due = card.clock.dueIn(ivl)
+		}
+	}
+	completeReview(review, card.Card)
+	card.review = review
 
 	if card.Interval >= fb.Day {
 		// Bury cards with an interval >= 1d; they would make no progress if
 		// re-studied again today, due to fuzzing.
 		bury := buryInterval(card.Interval, card.Interval, false)
-		card.BuriedUntil = fb.Due(now().UTC()).Add(bury)
-		// card.BuriedUntil = fb.Due(now().UTC()).Add(fb.Day)
+		card.BuriedUntil = card.clock.dueIn(bury)
 	} else {
 		// Bury cards with sub-day intervals until they are due. We only allow
 		// forward-fuzzing for intervals > 1 day.
 		card.BuriedUntil = card.Due
 	}
+	card.BuryReason = fb.BuryReasonReview
+
+	return nil
+}
+
+// sm2 implements the default scheduler, a variant of the SM-2 algorithm.
+type sm2 struct{}
 
+var _ Scheduler = &sm2{}
+
+// Name returns "sm2".
+func (s *sm2) Name() string {
+	return "sm2"
+}
+
+// Schedule schedules the card according to the SM-2 algorithm. answerDelay is
+// ignored.
+func (s *sm2) Schedule(card *Card, _ time.Duration, quality flashback.AnswerQuality) error {
+	ivl, ease := schedule(card, quality)
+	card.Due = card.clock.dueIn(ivl)
+	card.Interval = ivl
+	card.EaseFactor = ease
+	if quality <= flashback.AnswerIncorrectEasy {
+		card.ReviewCount = 0
+	} else {
+		card.LastReview = now().UTC()
+		card.ReviewCount++
+	}
 	return nil
 }
 
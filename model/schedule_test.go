@@ -1,11 +1,13 @@
 package model
 
 import (
+	"fmt"
+	"math/rand"
 	"testing"
 	"time"
 
 	"github.com/FlashbackSRS/flashback"
-	fb "github.com/FlashbackSRS/flashback-model"
+	"github.com/FlashbackSRS/flashback/fb"
 	"github.com/flimzy/diff"
 )
 
@@ -293,32 +295,59 @@ func TestSchedule(t *testing.T) {
 	}{
 		{
 			name:    "new card, correct answer",
-			card:    &Card{Card: &fb.Card{}},
+			card:    &Card{Card: &fb.Card{ID: "card-foo.bar.0"}},
 			quality: flashback.AnswerCorrect,
 			expected: &Card{Card: &fb.Card{
+				ID:          "card-foo.bar.0",
 				LastReview:  now().UTC(),
 				EaseFactor:  2.5,
 				Interval:    86400000000000,
 				Due:         fb.Due(now()).Add(86400000000000),
 				BuriedUntil: fb.Due(now()).Add(86400000000000),
+				BuryReason:  fb.BuryReasonReview,
 				ReviewCount: 1,
-			}},
+			},
+				review: &fb.Review{
+					ID:         fmt.Sprintf("review-foo.bar.0.%d", now().UnixNano()),
+					CardID:     "card-foo.bar.0",
+					Timestamp:  now().UTC(),
+					Ease:       fb.ReviewEaseOK,
+					Interval:   86400000000000,
+					SRSFactor:  2.5,
+					ReviewTime: time.Second,
+					Type:       fb.ReviewTypeLearn,
+				},
+			},
 		},
 		{
 			name:    "new card, incorrect answer",
-			card:    &Card{Card: &fb.Card{}},
+			card:    &Card{Card: &fb.Card{ID: "card-foo.bar.0"}},
 			quality: flashback.AnswerBlackout,
 			expected: &Card{Card: &fb.Card{
+				ID:          "card-foo.bar.0",
 				EaseFactor:  1.7,
 				Interval:    600000000000,
 				Due:         fb.Due(now()).Add(600000000000),
 				BuriedUntil: fb.Due(now()).Add(600000000000),
+				BuryReason:  fb.BuryReasonReview,
 				ReviewCount: 0,
-			}},
+			},
+				review: &fb.Review{
+					ID:         fmt.Sprintf("review-foo.bar.0.%d", now().UnixNano()),
+					CardID:     "card-foo.bar.0",
+					Timestamp:  now().UTC(),
+					Ease:       fb.ReviewEaseWrong,
+					Interval:   600000000000,
+					SRSFactor:  1.7,
+					ReviewTime: time.Second,
+					Type:       fb.ReviewTypeLearn,
+				},
+			},
 		},
 		{
 			name: "mature card, correct answer",
 			card: &Card{Card: &fb.Card{
+				ID:          "card-foo.bar.0",
 				EaseFactor:  2.5,
 				Interval:    60 * fb.Day,
 				ReviewCount: 5,
@@ -326,17 +355,33 @@ func TestSchedule(t *testing.T) {
 			}},
 			quality: flashback.AnswerCorrect,
 			expected: &Card{Card: &fb.Card{
+				ID:          "card-foo.bar.0",
 				LastReview:  now().UTC(),
 				BuriedUntil: fb.Due(now().UTC()).Add(MaxBuryTime),
+				BuryReason:  fb.BuryReasonReview,
 				EaseFactor:  2.5,
-				Interval:    12959999391170560,
-				Due:         fb.Due(now()).Add(12959999391170560),
+				Interval:    143 * fb.Day, // 150 days, fuzzed
+				Due:         fb.Due(now()).Add(143 * fb.Day),
 				ReviewCount: 6,
-			}},
+			},
+				review: &fb.Review{
+					ID:               fmt.Sprintf("review-foo.bar.0.%d", now().UnixNano()),
+					CardID:           "card-foo.bar.0",
+					Timestamp:        now().UTC(),
+					Ease:             fb.ReviewEaseOK,
+					Interval:         143 * fb.Day,
+					PreviousInterval: 60 * fb.Day,
+					SRSFactor:        2.5,
+					ReviewTime:       time.Second,
+					Type:             fb.ReviewTypeReview,
+				},
+			},
 		},
 	}
+	defer func(orig *rand.Rand) { rnd = orig }(rnd)
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
+			rnd = rand.New(rand.NewSource(1))
 			err := Schedule(test.card, time.Second, test.quality)
 			checkErr(t, test.err, err)
 			if err != nil {
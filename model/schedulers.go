@@ -0,0 +1,48 @@
+package model
+
+import (
+	"errors"
+	"time"
+
+	"github.com/FlashbackSRS/flashback"
+)
+
+// Scheduler is an interface for spaced-repetition scheduling algorithms.
+type Scheduler interface {
+	// Name returns the scheduler's unique identifier, as used in Settings.
+	Name() string
+	// Schedule updates the card's scheduling state (Due, Interval, EaseFactor,
+	// and any algorithm-specific values) in response to an answer of the
+	// given quality. answerDelay is the time the user took to answer.
+	Schedule(card *Card, answerDelay time.Duration, quality flashback.AnswerQuality) error
+}
+
+// DefaultScheduler is the name of the scheduler used when none is configured.
+const DefaultScheduler = "sm2"
+
+var schedulers = map[string]Scheduler{}
+var schedulerNames = []string{}
+
+// RegisterScheduler registers a scheduler for use in the app. The passed
+// scheduler's Name() must return a unique value.
+func RegisterScheduler(s Scheduler) {
+	name := s.Name()
+	if _, ok := schedulers[name]; ok {
+		panic("A scheduler named '" + name + "' is already registered")
+	}
+	schedulers[name] = s
+	schedulerNames = append(schedulerNames, name)
+}
+
+// RegisteredSchedulers returns a list of registered scheduler names.
+func RegisteredSchedulers() []string {
+	return schedulerNames
+}
+
+// GetScheduler returns the registered Scheduler named 'name'.
+func GetScheduler(name string) (Scheduler, error) {
+	if s, ok := schedulers[name]; ok {
+		return s, nil
+	}
+	return nil, errors.New("Scheduler '" + name + "' not found")
+}
@@ -0,0 +1,75 @@
+package model
+
+import (
+	"testing"
+	"time"
+
+	"github.com/FlashbackSRS/flashback"
+	"github.com/flimzy/diff"
+)
+
+type mockScheduler struct {
+	name string
+	err  error
+}
+
+var _ Scheduler = &mockScheduler{}
+
+func (s *mockScheduler) Name() string { return s.name }
+func (s *mockScheduler) Schedule(_ *Card, _ time.Duration, _ flashback.AnswerQuality) error {
+	return s.err
+}
+
+var testScheduler = &mockScheduler{name: "test"}
+
+func TestRegisterScheduler(t *testing.T) {
+	t.Run("Success", func(t *testing.T) {
+		RegisterScheduler(testScheduler)
+		if _, ok := schedulers["test"]; !ok {
+			t.Errorf("schedulers not updated")
+		}
+		for _, name := range schedulerNames {
+			if name == "test" {
+				return
+			}
+		}
+		t.Errorf("schedulerNames not updated")
+	})
+	t.Run("Duplicate", func(t *testing.T) {
+		r := func() (r interface{}) {
+			defer func() {
+				r = recover()
+			}()
+			RegisterScheduler(testScheduler)
+			return
+		}()
+		expected := "A scheduler named 'test' is already registered"
+		if d := diff.Interface(expected, r); d != nil {
+			t.Error(d)
+		}
+	})
+}
+
+func TestRegisteredSchedulers(t *testing.T) {
+	expected := []string{"fsrs", "sm2", "test"}
+	result := RegisteredSchedulers()
+	if d := diff.Interface(expected, result); d != nil {
+		t.Error(d)
+	}
+}
+
+func TestGetScheduler(t *testing.T) {
+	t.Run("Default", func(t *testing.T) {
+		s, err := GetScheduler(DefaultScheduler)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := s.(*sm2); !ok {
+			t.Errorf("Unexpected scheduler type %T", s)
+		}
+	})
+	t.Run("Not found", func(t *testing.T) {
+		_, err := GetScheduler("not found")
+		checkErr(t, "Scheduler 'not found' not found", err)
+	})
+}
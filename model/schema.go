@@ -0,0 +1,171 @@
+package model
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+
+	"github.com/flimzy/kivik"
+	"github.com/pkg/errors"
+
+	"github.com/FlashbackSRS/flashback/fb"
+)
+
+// schemaDocID is the ID of the document recording the schema version of a
+// database. As a local document, it is not replicated, so that each copy of
+// the database is migrated independently.
+const schemaDocID = "_local/schema"
+
+// schemaDoc records the migrations applied to a database.
+type schemaDoc struct {
+	ID      string `json:"_id"`
+	Rev     string `json:"_rev,omitempty"`
+	Version int    `json:"version"`
+}
+
+// schemaDB is the database interface required by migrations.
+type schemaDB interface {
+	getPutter
+	finder
+	indexer
+	bulkDocer
+}
+
+// migration is a single, versioned, change to the design documents, indexes
+// or documents of a database.
+type migration struct {
+	version     int
+	description string
+	apply       func(context.Context, schemaDB) error
+}
+
+// userMigrations are applied to user databases, in order. New migrations must
+// be appended with the next version number; released migrations must not be
+// changed, as they will not be re-applied.
+var userMigrations = []migration{
+	{
+		version:     1,
+		description: "create card queue index",
+		apply:       createIndex(cardQueueIndexName, cardQueueIndexName, cardQueueIndex),
+	},
+	{
+		version:     2,
+		description: "add queue fields to cards",
+		apply:       backfillCardQueues,
+	},
+}
+
+// migrateUserDB brings the user database up to date, once per database per
+// session.
+func (r *Repo) migrateUserDB(ctx context.Context, db interface {
+	schemaDB
+	clientNamer
+}) error {
+	r.schemaMu.Lock()
+	defer r.schemaMu.Unlock()
+	if r.migrated[db.Name()] {
+		return nil
+	}
+	if err := migrate(ctx, db, userMigrations); err != nil {
+		return errors.Wrapf(err, "migrate %s", db.Name())
+	}
+	if r.migrated == nil {
+		r.migrated = make(map[string]bool)
+	}
+	r.migrated[db.Name()] = true
+	return nil
+}
+
+// migrate applies those migrations newer than the database's schema version,
+// recording the new version after each.
+func migrate(ctx context.Context, db schemaDB, migrations []migration) error {
+	schema := &schemaDoc{}
+	err := getDoc(ctx, db, schemaDocID, schema)
+	if err != nil && kivik.StatusCode(err) != kivik.StatusNotFound {
+		return errors.Wrap(err, "fetch schema version")
+	}
+	schema.ID = schemaDocID
+	for _, m := range migrations {
+		if m.version <= schema.Version {
+			continue
+		}
+		if err := m.apply(ctx, db); err != nil {
+			return errors.Wrapf(err, "migration %d (%s)", m.version, m.description)
+		}
+		schema.Version = m.version
+		rev, err := db.Put(ctx, schemaDocID, schema)
+		if err != nil {
+			return errors.Wrap(err, "store schema version")
+		}
+		schema.Rev = rev
+	}
+	return nil
+}
+
+// createIndex returns a migration function which creates a Mango index.
+// Backends without index support, such as the memory driver, can still query
+// without it, so only their "not implemented" error is ignored.
+func createIndex(ddoc, name string, index interface{}) func(context.Context, schemaDB) error {
+	return func(ctx context.Context, db schemaDB) error {
+		err := db.CreateIndex(ctx, ddoc, name, index)
+		if err != nil && !indexesUnsupported(err) {
+			return errors.Wrapf(err, "create index %s", name)
+		}
+		return nil
+	}
+}
+
+// memoryFindNotImplemented is the message of the error returned by the memory
+// driver for all index operations. That error is an unexported errors.New
+// value with no status code, and schemaDB doesn't expose which driver is in
+// use, so the message is the only way to recognize it. The driver is
+// vendored, so the message can't change unnoticed: the memory-backed tests
+// would fail to migrate.
+const memoryFindNotImplemented = "find feature not yet implemented"
+
+// indexesUnsupported reports whether err indicates that the backend doesn't
+// support Mango indexes at all, as opposed to failing to create one.
+func indexesUnsupported(err error) bool {
+	return kivik.StatusCode(err) == kivik.StatusNotImplemented ||
+		err.Error() == memoryFindNotImplemented
+}
+
+// backfillCardQueues stores the queue and available fields on cards which
+// were saved before they were introduced, so that they are found by
+// cardQueueIndex.
+func backfillCardQueues(ctx context.Context, db schemaDB) error {
+	rows, err := db.Find(ctx, map[string]interface{}{
+		"selector": map[string]string{"type": "card"},
+	})
+	if err != nil {
+		return errors.Wrap(err, "find cards")
+	}
+	defer func() { _ = rows.Close() }()
+	cards := make([]*fb.Card, 0)
+	for rows.Next() {
+		var doc json.RawMessage
+		if err := rows.ScanDoc(&doc); err != nil {
+			return errors.Wrapf(err, "scan card %s", rows.ID())
+		}
+		var stored struct {
+			Queue string `json:"queue"`
+		}
+		if err := json.Unmarshal(doc, &stored); err != nil {
+			return errors.Wrapf(err, "scan card %s", rows.ID())
+		}
+		card := &fb.Card{}
+		if err := json.Unmarshal(doc, card); err != nil {
+			return errors.Wrapf(err, "scan card %s", rows.ID())
+		}
+		if stored.Queue != card.Queue() {
+			cards = append(cards, card)
+		}
+	}
+	if err := rows.Err(); err != nil && err != io.EOF {
+		return err
+	}
+	if len(cards) == 0 {
+		return nil
+	}
+	return errors.Wrap(updateDocs(ctx, db, cards), "update cards")
+}
@@ -0,0 +1,221 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/FlashbackSRS/flashback/fb"
+	"github.com/flimzy/diff"
+	"github.com/flimzy/kivik"
+	kerrors "github.com/flimzy/kivik/errors"
+)
+
+type failGetDB struct {
+	kivikDB
+}
+
+func (db *failGetDB) Get(_ context.Context, _ string, _ ...kivik.Options) (kivikRow, error) {
+	return nil, errors.New("get failed")
+}
+
+type indexErrDB struct {
+	kivikDB
+	err error
+}
+
+func (db *indexErrDB) CreateIndex(_ context.Context, _, _ string, _ interface{}) error {
+	return db.err
+}
+
+func TestCreateIndex(t *testing.T) {
+	tests := map[string]struct {
+		err      error
+		expected string
+	}{
+		"success":         {},
+		"not implemented": {err: kerrors.Status(kivik.StatusNotImplemented, "not implemented")},
+		"memory driver":   {err: errors.New(memoryFindNotImplemented)},
+		"failure": {
+			err:      errors.New("forbidden"),
+			expected: "create index foo: forbidden",
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			db := &indexErrDB{kivikDB: testDB(t), err: test.err}
+			err := createIndex("foo", "foo", map[string]interface{}{})(context.Background(), db)
+			checkErr(t, test.expected, err)
+		})
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	type tst struct {
+		db       schemaDB
+		version  int
+		err      string
+		applied  []int
+		expected int
+	}
+	tests := map[string]func(*testing.T) tst{
+		"get error": func(t *testing.T) tst {
+			return tst{
+				db:  &failGetDB{saveCards(t, testClient(t))},
+				err: "fetch schema version: get failed",
+			}
+		},
+		"new database": func(t *testing.T) tst {
+			return tst{
+				db:       saveCards(t, testClient(t)),
+				applied:  []int{1, 2, 3},
+				expected: 3,
+			}
+		},
+		"up to date": func(t *testing.T) tst {
+			db := saveCards(t, testClient(t))
+			if _, err := db.Put(context.Background(), schemaDocID, map[string]int{"version": 3}); err != nil {
+				t.Fatal(err)
+			}
+			return tst{
+				db:       db,
+				applied:  []int{},
+				expected: 3,
+			}
+		},
+		"upgrade": func(t *testing.T) tst {
+			db := saveCards(t, testClient(t))
+			if _, err := db.Put(context.Background(), schemaDocID, map[string]int{"version": 1}); err != nil {
+				t.Fatal(err)
+			}
+			return tst{
+				db:       db,
+				applied:  []int{2, 3},
+				expected: 3,
+			}
+		},
+		"migration error": func(t *testing.T) tst {
+			return tst{
+				db:       saveCards(t, testClient(t)),
+				version:  2,
+				err:      "migration 2 (two): migration failed",
+				applied:  []int{1},
+				expected: 1,
+			}
+		},
+	}
+	for name, fn := range tests {
+		t.Run(name, func(t *testing.T) {
+			test := fn(t)
+			applied := []int{}
+			migrations := make([]migration, 3)
+			for i, desc := range []string{"one", "two", "three"} {
+				version := i + 1
+				migrations[i] = migration{
+					version:     version,
+					description: desc,
+					apply: func(_ context.Context, _ schemaDB) error {
+						if version == test.version {
+							return errors.New("migration failed")
+						}
+						applied = append(applied, version)
+						return nil
+					},
+				}
+			}
+			err := migrate(context.Background(), test.db, migrations)
+			checkErr(t, test.err, err)
+			if d := diff.Interface(test.applied, applied); d != nil && test.applied != nil {
+				t.Error(d)
+			}
+			if err != nil && test.applied == nil {
+				return
+			}
+			schema := &schemaDoc{}
+			if e := getDoc(context.Background(), test.db, schemaDocID, schema); e != nil {
+				t.Fatal(e)
+			}
+			if schema.Version != test.expected {
+				t.Errorf("Expected version %d, got %d", test.expected, schema.Version)
+			}
+		})
+	}
+}
+
+func TestMigrateUserDB(t *testing.T) {
+	repo := &Repo{}
+	db := saveCards(t, testClient(t), &fb.Card{ID: "card-foo.bar.0"})
+	if err := repo.migrateUserDB(context.Background(), db); err != nil {
+		t.Fatal(err)
+	}
+	schema := &schemaDoc{}
+	if err := getDoc(context.Background(), db, schemaDocID, schema); err != nil {
+		t.Fatal(err)
+	}
+	if expected := userMigrations[len(userMigrations)-1].version; schema.Version != expected {
+		t.Errorf("Expected version %d, got %d", expected, schema.Version)
+	}
+	if _, err := db.Put(context.Background(), schemaDocID, map[string]int{"version": 0}); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.migrateUserDB(context.Background(), db); err != nil {
+		t.Fatal(err)
+	}
+	if err := getDoc(context.Background(), db, schemaDocID, schema); err != nil {
+		t.Fatal(err)
+	}
+	if schema.Version != 0 {
+		t.Errorf("Expected migrations to run once per session")
+	}
+}
+
+func TestBackfillCardQueues(t *testing.T) {
+	ctx := context.Background()
+	db := saveCards(t, testClient(t),
+		&fb.Card{ID: "card-foo.bar.0"},
+		&fb.Card{ID: "card-foo.bar.1", Suspended: true},
+	)
+	legacy := map[string]interface{}{
+		"_id":      "card-foo.bar.2",
+		"type":     "card",
+		"model":    "theme-foo/0",
+		"created":  "2016-12-01T00:00:00Z",
+		"modified": "2016-12-01T00:00:00Z",
+		"due":      "2016-12-30",
+		"interval": fb.Day,
+	}
+	if _, err := db.Put(ctx, "card-foo.bar.2", legacy); err != nil {
+		t.Fatal(err)
+	}
+	if err := backfillCardQueues(ctx, db); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{
+		"card-foo.bar.0": "1-",
+		"card-foo.bar.1": "1-",
+		"card-foo.bar.2": "2-",
+	}
+	for id, rev := range expected {
+		var doc struct {
+			Rev       string `json:"_rev"`
+			Queue     string `json:"queue"`
+			Available string `json:"available"`
+		}
+		if err := getDoc(ctx, db, id, &doc); err != nil {
+			t.Fatal(err)
+		}
+		if doc.Rev[:2] != rev {
+			t.Errorf("%s: expected rev %s*, got %s", id, rev, doc.Rev)
+		}
+		if id == "card-foo.bar.2" && (doc.Queue != fb.QueueReview || doc.Available != "2016-12-30") {
+			t.Errorf("%s: unexpected queue %q, available %q", id, doc.Queue, doc.Available)
+		}
+	}
+	cards, err := fetchQueue(ctx, db, studyClock{}, fb.QueueReview, 10, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cards) != 1 || cards[0].ID != "card-foo.bar.2" {
+		t.Errorf("Expected backfilled card in review queue, got %v", cards)
+	}
+}
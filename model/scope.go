@@ -0,0 +1,138 @@
+package model
+
+import (
+	"context"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/flimzy/kivik"
+	"github.com/pkg/errors"
+
+	"github.com/FlashbackSRS/flashback/fb"
+)
+
+// StudyScope restricts regular study to part of the collection. The zero
+// value studies all cards.
+type StudyScope struct {
+	// BundleID limits study to cards in the bundle.
+	BundleID string `json:"bundleID,omitempty"`
+	// DeckID limits study to cards in the deck.
+	DeckID string `json:"deckID,omitempty"`
+	// Tags limits study to cards whose note has any of the tags. Tags may
+	// contain * wildcards.
+	Tags []string `json:"tags,omitempty"`
+}
+
+// IsZero returns true if the scope includes all cards.
+func (s StudyScope) IsZero() bool {
+	return s.BundleID == "" && s.DeckID == "" && len(s.Tags) == 0
+}
+
+// query returns the query matching the cards in scope.
+func (s StudyScope) query() *Query {
+	var root andNode
+	if s.BundleID != "" {
+		root = append(root, bundleTerm(strings.TrimPrefix(s.BundleID, "bundle-")))
+	}
+	if s.DeckID != "" {
+		root = append(root, deckTerm{id: s.DeckID})
+	}
+	if len(s.Tags) > 0 {
+		tags := make(orNode, len(s.Tags))
+		for i, tag := range s.Tags {
+			tags[i] = tagTerm{globPattern(tag, true)}
+		}
+		root = append(root, tags)
+	}
+	return &Query{root: root}
+}
+
+// SetStudyScope restricts the cards selected by GetCardToStudy to those in
+// scope. A custom study session takes precedence over the scope.
+func (r *Repo) SetStudyScope(scope StudyScope) {
+	r.sessionMu.Lock()
+	defer r.sessionMu.Unlock()
+	r.scope = scope
+}
+
+// StudyScope returns the current study scope.
+func (r *Repo) StudyScope() StudyScope {
+	r.sessionMu.Lock()
+	defer r.sessionMu.Unlock()
+	return r.scope
+}
+
+// scopeFilter returns a function which reports whether a card is in scope.
+// Bundle data is fetched as needed, and cached for the life of the filter.
+func (r *Repo) scopeFilter(ctx context.Context, scope StudyScope) func(*cardSchedule) (bool, error) {
+	q := scope.query()
+	needs := q.needs()
+	bundles := make(map[string]*searchBundle)
+	return func(card *cardSchedule) (bool, error) {
+		item := &searchItem{card: &fb.Card{ID: card.ID}}
+		if needs != 0 {
+			bundleID := item.card.BundleID()
+			b, ok := bundles[bundleID]
+			if !ok {
+				var err error
+				if b, err = r.fetchSearchBundle(ctx, bundleID, needs); err != nil {
+					return false, err
+				}
+				bundles[bundleID] = b
+			}
+			b.populate(item)
+		}
+		return q.match(item), nil
+	}
+}
+
+// Bundles returns the user's bundles, sorted by name.
+func (r *Repo) Bundles(ctx context.Context) ([]*fb.Bundle, error) {
+	udb, err := r.userDB(ctx)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := udb.Find(ctx, map[string]interface{}{
+		"selector": map[string]string{"type": "bundle"},
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "find bundles")
+	}
+	defer func() { _ = rows.Close() }()
+	bundles := make([]*fb.Bundle, 0)
+	for rows.Next() {
+		bundle := &fb.Bundle{}
+		if err := rows.ScanDoc(bundle); err != nil {
+			return nil, errors.Wrapf(err, "scan bundle %s", rows.ID())
+		}
+		bundles = append(bundles, bundle)
+	}
+	if err := rows.Err(); err != nil && err != io.EOF {
+		return nil, err
+	}
+	sort.Slice(bundles, func(i, j int) bool {
+		return bundles[i].Name < bundles[j].Name
+	})
+	return bundles, nil
+}
+
+// BundleDecks returns the decks in the bundle, sorted by name. A bundle which
+// isn't stored locally has no decks.
+func (r *Repo) BundleDecks(ctx context.Context, bundleID string) ([]*fb.Deck, error) {
+	bdb, err := r.local.DB(ctx, bundleID)
+	if kivik.StatusCode(err) == kivik.StatusNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, bundleID)
+	}
+	decks, err := fetchDecks(ctx, bdb)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(decks, func(i, j int) bool {
+		return decks[i].Name < decks[j].Name
+	})
+	return decks, nil
+}
@@ -0,0 +1,146 @@
+package model
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/FlashbackSRS/flashback/fb"
+	"github.com/flimzy/diff"
+)
+
+func TestStudyScope(t *testing.T) {
+	ctx := context.Background()
+	repo := &Repo{user: "mjxwe", local: testClient(t)}
+	bundleID := fb.EncodeDBID("bundle", []byte{1, 2, 3, 4})
+	foo := strings.TrimPrefix(bundleID, "bundle-")
+	for _, name := range []string{"user-mjxwe", bundleID} {
+		if err := repo.local.CreateDB(ctx, name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	udb, err := repo.userDB(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bdb, err := repo.local.DB(ctx, bundleID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	created := parseTime(t, "2017-01-01T00:00:00Z")
+	bundle := &fb.Bundle{ID: bundleID, Owner: "mjxwe", Name: "Foo", Created: created, Modified: created}
+	if err := saveDoc(ctx, udb, bundle); err != nil {
+		t.Fatal(err)
+	}
+	for _, deck := range []*fb.Deck{
+		{ID: "deck-foo", Name: "Verbs", Created: created, Modified: created, Cards: fb.NewCardCollection()},
+		{ID: "deck-bar", Name: "Nouns", Created: created, Modified: created, Cards: fb.NewCardCollection()},
+	} {
+		if deck.ID == "deck-foo" {
+			deck.AddCard("card-" + foo + ".bar.0")
+		}
+		if err := saveDoc(ctx, bdb, deck); err != nil {
+			t.Fatal(err)
+		}
+	}
+	notes := map[string][]string{"note-bar": {"verbs", "Spanish"}, "note-baz": {"nouns"}}
+	for id, tags := range notes {
+		doc := map[string]interface{}{"type": "note", "tags": tags}
+		if _, err := bdb.Put(ctx, id, doc); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cardIDs := []string{"card-" + foo + ".bar.0", "card-" + foo + ".bar.1", "card-" + foo + ".baz.0", "card-qux.quux.0"}
+
+	t.Run("zero", func(t *testing.T) {
+		if repo.StudyScope().IsZero() != true {
+			t.Error("Expected the default scope to be zero")
+		}
+		repo.SetStudyScope(StudyScope{Tags: []string{"verbs"}})
+		defer repo.SetStudyScope(StudyScope{})
+		if repo.StudyScope().IsZero() {
+			t.Error("Expected the scope to be set")
+		}
+	})
+	tests := []struct {
+		name     string
+		scope    StudyScope
+		expected []string
+	}{
+		{name: "all", expected: cardIDs},
+		{
+			name:     "bundle",
+			scope:    StudyScope{BundleID: bundleID},
+			expected: []string{"card-" + foo + ".bar.0", "card-" + foo + ".bar.1", "card-" + foo + ".baz.0"},
+		},
+		{
+			name:     "deck",
+			scope:    StudyScope{DeckID: "deck-foo"},
+			expected: []string{"card-" + foo + ".bar.0"},
+		},
+		{
+			name:     "empty deck",
+			scope:    StudyScope{DeckID: "deck-bar"},
+			expected: []string{},
+		},
+		{
+			name:     "tags",
+			scope:    StudyScope{Tags: []string{"spanish", "noun*"}},
+			expected: []string{"card-" + foo + ".bar.0", "card-" + foo + ".bar.1", "card-" + foo + ".baz.0"},
+		},
+		{
+			name:     "bundle and tag",
+			scope:    StudyScope{BundleID: bundleID, Tags: []string{"nouns"}},
+			expected: []string{"card-" + foo + ".baz.0"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			inScope := repo.scopeFilter(ctx, test.scope)
+			result := []string{}
+			for _, id := range cardIDs {
+				ok, err := inScope(&cardSchedule{ID: id})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if ok {
+					result = append(result, id)
+				}
+			}
+			if d := diff.Interface(test.expected, result); d != nil {
+				t.Error(d)
+			}
+		})
+	}
+	t.Run("bundles", func(t *testing.T) {
+		bundles, err := repo.Bundles(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(bundles) != 1 || bundles[0].ID != bundleID {
+			t.Errorf("Unexpected bundles: %v", bundles)
+		}
+	})
+	t.Run("decks", func(t *testing.T) {
+		decks, err := repo.BundleDecks(ctx, bundleID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		names := make([]string, len(decks))
+		for i, deck := range decks {
+			names[i] = deck.Name
+		}
+		if d := diff.Interface([]string{"Nouns", "Verbs"}, names); d != nil {
+			t.Error(d)
+		}
+	})
+	t.Run("missing bundle decks", func(t *testing.T) {
+		decks, err := repo.BundleDecks(ctx, "bundle-qux")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(decks) != 0 {
+			t.Errorf("Unexpected decks: %v", decks)
+		}
+	})
+}
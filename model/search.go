@@ -0,0 +1,189 @@
+package model
+
+import (
+	"context"
+	"io"
+	"strings"
+
+	"github.com/flimzy/kivik"
+	"github.com/pkg/errors"
+
+	"github.com/FlashbackSRS/flashback/fb"
+)
+
+// Search returns the cards matching the query. See Query for the syntax.
+func (r *Repo) Search(ctx context.Context, query string) ([]*fb.Card, error) {
+	q, err := ParseQuery(query)
+	if err != nil {
+		return nil, errors.Wrap(err, "parse query")
+	}
+	udb, err := r.userDB(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return r.search(ctx, udb, q)
+}
+
+func (r *Repo) search(ctx context.Context, udb finder, q *Query) ([]*fb.Card, error) {
+	cards, err := findCards(ctx, udb, q.Selector())
+	if err != nil {
+		return nil, err
+	}
+	clock, err := r.studyClock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	needs := q.needs()
+	bundles := make(map[string]*searchBundle)
+	matches := make([]*fb.Card, 0, len(cards))
+	for _, card := range cards {
+		item := &searchItem{card: card, clock: clock}
+		if needs != 0 {
+			bundleID := card.BundleID()
+			b, ok := bundles[bundleID]
+			if !ok {
+				if b, err = r.fetchSearchBundle(ctx, bundleID, needs); err != nil {
+					return nil, err
+				}
+				bundles[bundleID] = b
+			}
+			b.populate(item)
+		}
+		if q.match(item) {
+			matches = append(matches, card)
+		}
+	}
+	return matches, nil
+}
+
+// searchNote holds the parts of a note used for searching.
+type searchNote struct {
+	ID          string   `json:"_id"`
+	ThemeID     string   `json:"theme"`
+	ModelID     uint32   `json:"model"`
+	Tags        []string `json:"tags"`
+	FieldValues []struct {
+		Text string `json:"text"`
+	} `json:"fieldValues"`
+}
+
+// searchTheme holds the parts of a theme used for searching.
+type searchTheme struct {
+	ID     string `json:"_id"`
+	Models []struct {
+		ID     uint32 `json:"id"`
+		Fields []struct {
+			Name string `json:"name"`
+		} `json:"fields"`
+	} `json:"models"`
+}
+
+// searchBundle holds the bundle data needed to evaluate a query.
+type searchBundle struct {
+	notes  map[string]*searchNote
+	themes map[string]*searchTheme
+	decks  []*fb.Deck
+}
+
+// fetchSearchBundle fetches the notes, themes and decks of a bundle, as
+// required by needs. A bundle which isn't stored locally has no data.
+func (r *Repo) fetchSearchBundle(ctx context.Context, bundleID string, needs queryNeeds) (*searchBundle, error) {
+	b := &searchBundle{}
+	bdb, err := r.local.DB(ctx, bundleID)
+	if kivik.StatusCode(err) == kivik.StatusNotFound {
+		return b, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, bundleID)
+	}
+	if needs&needNote != 0 {
+		b.notes = make(map[string]*searchNote)
+		b.themes = make(map[string]*searchTheme)
+		err := findDocs(ctx, bdb, "note", func(scan func(interface{}) error) error {
+			note := &searchNote{}
+			if err := scan(note); err != nil {
+				return err
+			}
+			b.notes[note.ID] = note
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		err = findDocs(ctx, bdb, "theme", func(scan func(interface{}) error) error {
+			theme := &searchTheme{}
+			if err := scan(theme); err != nil {
+				return err
+			}
+			b.themes[theme.ID] = theme
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	if needs&needDecks != 0 {
+		if b.decks, err = fetchDecks(ctx, bdb); err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+// populate sets the item's note and deck data.
+func (b *searchBundle) populate(item *searchItem) {
+	if b.decks != nil {
+		for _, deck := range b.decks {
+			if deck.Cards == nil {
+				continue
+			}
+			for _, id := range deck.Cards.All() {
+				if id == item.card.ID {
+					item.decks = append(item.decks, deck)
+					break
+				}
+			}
+		}
+	}
+	note, ok := b.notes[item.card.NoteID()]
+	if !ok {
+		return
+	}
+	item.tags = note.Tags
+	item.fields = make(map[string]string, len(note.FieldValues))
+	theme, ok := b.themes[note.ThemeID]
+	if !ok {
+		return
+	}
+	for _, model := range theme.Models {
+		if model.ID != note.ModelID {
+			continue
+		}
+		for i, field := range model.Fields {
+			if i < len(note.FieldValues) {
+				item.fields[strings.ToLower(field.Name)] = note.FieldValues[i].Text
+			}
+		}
+	}
+}
+
+// findDocs calls fn for each document of the given type in db. fn is passed a
+// function which scans the document.
+func findDocs(ctx context.Context, db finder, docType string, fn func(scan func(interface{}) error) error) error {
+	rows, err := db.Find(ctx, map[string]interface{}{
+		"selector": map[string]string{"type": docType},
+	})
+	if err != nil {
+		return errors.Wrapf(err, "find %ss", docType)
+	}
+	defer func() { _ = rows.Close() }()
+	for rows.Next() {
+		if err := fn(rows.ScanDoc); err != nil {
+			return errors.Wrapf(err, "scan %s %s", docType, rows.ID())
+		}
+	}
+	if err := rows.Err(); err != nil && err != io.EOF {
+		return err
+	}
+	return nil
+}
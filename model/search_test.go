@@ -0,0 +1,122 @@
+package model
+
+import (
+	"context"
+	"sort"
+	"testing"
+
+	"github.com/FlashbackSRS/flashback/fb"
+	"github.com/flimzy/diff"
+)
+
+func TestRepoSearch(t *testing.T) {
+	ctx := context.Background()
+	t.Run("not logged in", func(t *testing.T) {
+		_, err := (&Repo{}).Search(ctx, "is:new")
+		checkErr(t, "not logged in", err)
+	})
+	repo := &Repo{user: "mjxwe", local: testClient(t)}
+	t.Run("invalid query", func(t *testing.T) {
+		_, err := repo.Search(ctx, "is:lost")
+		checkErr(t, "parse query: unknown state 'lost'", err)
+	})
+	for _, name := range []string{"user-mjxwe", "bundle-foo"} {
+		if err := repo.local.CreateDB(ctx, name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	udb, err := repo.userDB(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bdb, err := repo.local.DB(ctx, "bundle-foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	created := parseTime(t, "2017-01-01T00:00:00Z")
+	deck := &fb.Deck{ID: "deck-foo", Name: "Verbs", Created: created, Modified: created, Cards: fb.NewCardCollection()}
+	deck.AddCard("card-foo.bar.0")
+	if err := saveDoc(ctx, bdb, deck); err != nil {
+		t.Fatal(err)
+	}
+	docs := map[string]interface{}{
+		"theme-foo": map[string]interface{}{
+			"type": "theme",
+			"models": []interface{}{
+				map[string]interface{}{"id": 0, "fields": []interface{}{
+					map[string]interface{}{"name": "Front"},
+					map[string]interface{}{"name": "Back"},
+				}},
+			},
+		},
+		"note-bar": map[string]interface{}{
+			"type":  "note",
+			"theme": "theme-foo",
+			"model": 0,
+			"tags":  []string{"verbs"},
+			"fieldValues": []interface{}{
+				map[string]interface{}{"text": "comer"},
+				map[string]interface{}{"text": "to eat"},
+			},
+		},
+		"note-baz": map[string]interface{}{
+			"type":  "note",
+			"theme": "theme-foo",
+			"model": 0,
+			"fieldValues": []interface{}{
+				map[string]interface{}{"text": "perro"},
+				map[string]interface{}{"text": "dog"},
+			},
+		},
+	}
+	for id, doc := range docs {
+		if _, err := bdb.Put(ctx, id, doc); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cards := []*fb.Card{
+		{ID: "card-foo.bar.0", Interval: 30 * fb.Day, Due: parseDue(t, "2017-01-01")},
+		{ID: "card-foo.bar.1", Interval: 3 * fb.Day, Due: parseDue(t, "2017-01-03"), Suspended: true},
+		{ID: "card-foo.baz.0"},
+		{ID: "card-qux.quux.0", Interval: 10 * fb.Day, Due: parseDue(t, "2017-01-05"), Leech: true},
+	}
+	for _, card := range cards {
+		card.ModelID = "theme-foo/0"
+		card.Created = created
+		card.Modified = created
+		if err := saveDoc(ctx, udb, card); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		query    string
+		expected []string
+	}{
+		{query: "", expected: []string{"card-foo.bar.0", "card-foo.bar.1", "card-foo.baz.0", "card-qux.quux.0"}},
+		{query: "is:suspended", expected: []string{"card-foo.bar.1"}},
+		{query: "prop:ivl>5 -is:leech", expected: []string{"card-foo.bar.0"}},
+		{query: "bundle:foo", expected: []string{"card-foo.bar.0", "card-foo.bar.1", "card-foo.baz.0"}},
+		{query: "bundle:foo is:new", expected: []string{"card-foo.baz.0"}},
+		{query: "tag:verbs", expected: []string{"card-foo.bar.0", "card-foo.bar.1"}},
+		{query: "field:back:dog or eat", expected: []string{"card-foo.bar.0", "card-foo.bar.1", "card-foo.baz.0"}},
+		{query: "deck:verbs", expected: []string{"card-foo.bar.0"}},
+		{query: "is:leech or deck:verbs", expected: []string{"card-foo.bar.0", "card-qux.quux.0"}},
+		{query: "perro", expected: []string{"card-foo.baz.0"}},
+	}
+	for _, test := range tests {
+		t.Run(test.query, func(t *testing.T) {
+			result, err := repo.Search(ctx, test.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ids := make([]string, len(result))
+			for i, card := range result {
+				ids[i] = card.ID
+			}
+			sort.Strings(ids)
+			if d := diff.Interface(test.expected, ids); d != nil {
+				t.Error(d)
+			}
+		})
+	}
+}
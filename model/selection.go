@@ -0,0 +1,130 @@
+package model
+
+import (
+	"math/rand"
+	"sort"
+
+	"github.com/FlashbackSRS/flashback/fb"
+)
+
+// SelectionStrategy determines the order in which cards are selected for
+// study.
+type SelectionStrategy string
+
+// Selection strategies
+const (
+	// SelectWeighted selects a card at random, weighted by cardPriority, so
+	// that overdue cards are most likely to be studied.
+	SelectWeighted SelectionStrategy = "weighted"
+	// SelectDue selects cards strictly in the order in which they became
+	// available, so that new cards are studied in the order they were added.
+	SelectDue SelectionStrategy = "due"
+	// SelectNewFirst selects new cards, in the order they were added, before
+	// any reviews.
+	SelectNewFirst SelectionStrategy = "new-first"
+	// SelectReviewsFirst selects reviews, by weight, before any new cards.
+	SelectReviewsFirst SelectionStrategy = "reviews-first"
+	// SelectInterleave alternates between bundles, selecting a card by weight
+	// from each in turn.
+	SelectInterleave SelectionStrategy = "interleave"
+)
+
+// validSelectionStrategy returns true if s is a known strategy.
+func validSelectionStrategy(s SelectionStrategy) bool {
+	switch s {
+	case SelectWeighted, SelectDue, SelectNewFirst, SelectReviewsFirst, SelectInterleave:
+		return true
+	}
+	return false
+}
+
+// SetSelectionSeed makes card selection deterministic, so that a study
+// session can be reproduced, by seeding the random source used to select and
+// shuffle cards.
+func (r *Repo) SetSelectionSeed(seed int64) {
+	r.selectMu.Lock()
+	defer r.selectMu.Unlock()
+	r.rnd = rand.New(rand.NewSource(seed))
+}
+
+// random returns the seeded random source, if set, or the shared one. The
+// caller must hold selectMu, as neither source is safe for concurrent use.
+func (r *Repo) random() *rand.Rand {
+	if r.rnd != nil {
+		return r.rnd
+	}
+	return rnd
+}
+
+// pick returns a function which selects the ID of a card to study from the
+// candidates, which are each in queue order, according to strategy.
+func (r *Repo) pick(strategy SelectionStrategy, clock studyClock) func(newCards, oldCards []*cardSchedule) string {
+	return func(newCards, oldCards []*cardSchedule) string {
+		return r.selectCard(strategy, clock, newCards, oldCards)
+	}
+}
+
+// selectCard selects the ID of a card to study, according to strategy.
+func (r *Repo) selectCard(strategy SelectionStrategy, clock studyClock, newCards, oldCards []*cardSchedule) string {
+	r.selectMu.Lock()
+	defer r.selectMu.Unlock()
+	rnd := r.random()
+	switch strategy {
+	case SelectDue:
+		return selectDueCard(append(newCards, oldCards...))
+	case SelectNewFirst:
+		if len(newCards) > 0 {
+			return newCards[0].ID
+		}
+		return selectWeightedCard(rnd, clock, oldCards)
+	case SelectReviewsFirst:
+		if len(oldCards) > 0 {
+			return selectWeightedCard(rnd, clock, oldCards)
+		}
+		return selectWeightedCard(rnd, clock, newCards)
+	case SelectInterleave:
+		bundleID, cards := nextBundle(r.lastBundle, append(newCards, oldCards...))
+		r.lastBundle = bundleID
+		return selectWeightedCard(rnd, clock, cards)
+	}
+	return selectWeightedCard(rnd, clock, append(newCards, oldCards...))
+}
+
+// selectDueCard returns the ID of the card which became available first.
+func selectDueCard(cards []*cardSchedule) string {
+	var first *cardSchedule
+	for _, card := range cards {
+		if first == nil || first.Available.After(card.Available) ||
+			first.Available.Equal(card.Available) && card.ID < first.ID {
+			first = card
+		}
+	}
+	if first == nil {
+		return ""
+	}
+	return first.ID
+}
+
+// nextBundle groups cards by bundle, and returns the bundle following last,
+// in order of bundle ID, along with its cards.
+func nextBundle(last string, cards []*cardSchedule) (string, []*cardSchedule) {
+	if len(cards) == 0 {
+		return last, nil
+	}
+	byBundle := make(map[string][]*cardSchedule)
+	bundleIDs := make([]string, 0)
+	for _, card := range cards {
+		bundleID := (&fb.Card{ID: card.ID}).BundleID()
+		if _, ok := byBundle[bundleID]; !ok {
+			bundleIDs = append(bundleIDs, bundleID)
+		}
+		byBundle[bundleID] = append(byBundle[bundleID], card)
+	}
+	sort.Strings(bundleIDs)
+	i := sort.SearchStrings(bundleIDs, last)
+	if i < len(bundleIDs) && bundleIDs[i] == last {
+		i++
+	}
+	next := bundleIDs[i%len(bundleIDs)]
+	return next, byBundle[next]
+}
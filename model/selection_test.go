@@ -0,0 +1,187 @@
+package model
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/FlashbackSRS/flashback/fb"
+	"github.com/flimzy/diff"
+)
+
+func TestSettingsSelection(t *testing.T) {
+	s := &Settings{
+		Default: DeckSettings{SelectionStrategy: SelectDue},
+		Overrides: map[string]*DeckSettings{
+			"bundle-foo": {SelectionStrategy: SelectNewFirst},
+			"deck-foo":   {SelectionStrategy: SelectInterleave},
+		},
+	}
+	tests := []struct {
+		name     string
+		settings *Settings
+		scope    StudyScope
+		expected SelectionStrategy
+	}{
+		{
+			name:     "defaults",
+			settings: &Settings{},
+			expected: SelectWeighted,
+		},
+		{
+			name:     "default",
+			settings: s,
+			expected: SelectDue,
+		},
+		{
+			name:     "bundle",
+			settings: s,
+			scope:    StudyScope{BundleID: "bundle-foo"},
+			expected: SelectNewFirst,
+		},
+		{
+			name:     "deck",
+			settings: s,
+			scope:    StudyScope{BundleID: "bundle-foo", DeckID: "deck-foo"},
+			expected: SelectInterleave,
+		},
+		{
+			name:     "deck without override",
+			settings: s,
+			scope:    StudyScope{DeckID: "deck-bar"},
+			expected: SelectDue,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if result := test.settings.selection(test.scope); result != test.expected {
+				t.Errorf("Unexpected strategy: %s", result)
+			}
+		})
+	}
+}
+
+func TestSelectCard(t *testing.T) {
+	newCards := []*cardSchedule{
+		{ID: "card-bar.new.0", Available: parseDue(t, "2016-12-01")},
+		{ID: "card-foo.new.0", Available: parseDue(t, "2016-12-02")},
+	}
+	oldCards := []*cardSchedule{
+		{ID: "card-foo.old.0", Due: parseDue(t, "2015-01-01"), Interval: 20 * fb.Day, Available: parseDue(t, "2015-01-01")},
+		{ID: "card-foo.old.1", Due: parseDue(t, "2016-12-31"), Interval: fb.Day, Available: parseDue(t, "2016-12-31")},
+	}
+	tests := []struct {
+		name     string
+		strategy SelectionStrategy
+		newCards []*cardSchedule
+		oldCards []*cardSchedule
+		expected string
+	}{
+		{
+			name:     "no cards",
+			strategy: SelectDue,
+		},
+		{
+			name:     "weighted",
+			strategy: SelectWeighted,
+			newCards: newCards,
+			oldCards: oldCards,
+			expected: "card-foo.old.0",
+		},
+		{
+			name:     "due",
+			strategy: SelectDue,
+			newCards: newCards,
+			oldCards: oldCards,
+			expected: "card-foo.old.0",
+		},
+		{
+			name:     "new first",
+			strategy: SelectNewFirst,
+			newCards: newCards,
+			oldCards: oldCards,
+			expected: "card-bar.new.0",
+		},
+		{
+			name:     "new first, no new cards",
+			strategy: SelectNewFirst,
+			oldCards: oldCards,
+			expected: "card-foo.old.0",
+		},
+		{
+			name:     "reviews first",
+			strategy: SelectReviewsFirst,
+			newCards: newCards,
+			oldCards: oldCards[1:],
+			expected: "card-foo.old.1",
+		},
+		{
+			name:     "reviews first, no reviews",
+			strategy: SelectReviewsFirst,
+			newCards: newCards[:1],
+			expected: "card-bar.new.0",
+		},
+		{
+			name:     "interleave",
+			strategy: SelectInterleave,
+			newCards: newCards,
+			oldCards: oldCards,
+			expected: "card-bar.new.0",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			repo := &Repo{}
+			repo.SetSelectionSeed(1)
+			if result := repo.selectCard(test.strategy, studyClock{}, test.newCards, test.oldCards); result != test.expected {
+				t.Errorf("Unexpected card: %q", result)
+			}
+		})
+	}
+}
+
+func TestSelectInterleave(t *testing.T) {
+	cards := []*cardSchedule{
+		{ID: "card-foo.a.0"},
+		{ID: "card-bar.a.0"},
+		{ID: "card-baz.a.0"},
+		{ID: "card-foo.b.0"},
+	}
+	repo := &Repo{}
+	repo.SetSelectionSeed(1)
+	bundles := make([]string, 0, 4)
+	for i := 0; i < 4; i++ {
+		repo.selectCard(SelectInterleave, studyClock{}, cards, nil)
+		bundles = append(bundles, repo.lastBundle)
+	}
+	expected := []string{"bundle-bar", "bundle-baz", "bundle-foo", "bundle-bar"}
+	if d := diff.Interface(expected, bundles); d != nil {
+		t.Error(d)
+	}
+}
+
+func TestSetSelectionSeed(t *testing.T) {
+	cards := make([]*cardSchedule, 20)
+	for i := range cards {
+		cards[i] = &cardSchedule{ID: string(rune('a' + i))}
+	}
+	run := func(seed int64) []string {
+		repo := &Repo{}
+		repo.SetSelectionSeed(seed)
+		ids := make([]string, 10)
+		for i := range ids {
+			ids[i] = repo.selectCard(SelectWeighted, studyClock{}, nil, cards)
+		}
+		return ids
+	}
+	if d := diff.Interface(run(42), run(42)); d != nil {
+		t.Errorf("Selection with the same seed differs:\n%s", d)
+	}
+	if (&Repo{}).random() != rnd {
+		t.Errorf("Expected the shared random source without a seed")
+	}
+	repo := &Repo{}
+	repo.SetSelectionSeed(1)
+	if expected := rand.New(rand.NewSource(1)).Float64(); repo.random().Float64() != expected {
+		t.Errorf("Expected the seeded random source")
+	}
+}
@@ -0,0 +1,318 @@
+package model
+
+import (
+	"context"
+	"strings"
+
+	"github.com/flimzy/kivik"
+	"github.com/pkg/errors"
+
+	"github.com/FlashbackSRS/flashback"
+	"github.com/FlashbackSRS/flashback/fb"
+)
+
+// settingsDocID is the doc ID of the user's settings document, stored in the
+// user database.
+const settingsDocID = "settings"
+
+// DeckSettings are the study settings which may be configured per deck or
+// per bundle. Zero values are inherited from the less specific level.
+type DeckSettings struct {
+	// Scheduler is the name of the registered Scheduler used to schedule
+	// cards.
+	Scheduler string `json:"scheduler,omitempty"`
+	// TargetRetention is the desired probability of recall at review time, as
+	// used by the FSRS scheduler. It must be between 0 and 1.
+	TargetRetention float64 `json:"targetRetention,omitempty"`
+	// LearningSteps are the intervals at which new cards are shown, before
+	// graduating to review. A nil value is inherited; an empty list means new
+	// cards graduate on their first correct answer.
+	LearningSteps []fb.Interval `json:"learningSteps"`
+	// RelearningSteps are the intervals at which lapsed cards are shown,
+	// before returning to review. A nil value is inherited; an empty list
+	// means lapsed cards are rescheduled directly by the scheduler.
+	RelearningSteps []fb.Interval `json:"relearningSteps"`
+	// LeechThreshold is the number of lapses after which a card is considered
+	// a leech.
+	LeechThreshold int `json:"leechThreshold,omitempty"`
+	// LeechAction is the action taken when a card becomes a leech.
+	LeechAction LeechAction `json:"leechAction,omitempty"`
+	// NewPerDay is the maximum number of new cards to study per day. A nil
+	// value is inherited; zero means no new cards, and a negative value means
+	// no limit.
+	NewPerDay *int `json:"newPerDay,omitempty"`
+	// ReviewsPerDay is the maximum number of review cards to study per day. A
+	// nil value is inherited; zero means no reviews, and a negative value
+	// means no limit.
+	ReviewsPerDay *int `json:"reviewsPerDay,omitempty"`
+	// BuryStrategy determines how the siblings of a studied card are buried.
+	BuryStrategy BuryStrategy `json:"buryStrategy,omitempty"`
+	// SelectionStrategy determines the order in which cards are selected for
+	// study.
+	SelectionStrategy SelectionStrategy `json:"selectionStrategy,omitempty"`
+}
+
+// defaultDeckSettings are used for any value not configured by the user.
+var defaultDeckSettings = DeckSettings{
+	Scheduler:         DefaultScheduler,
+	TargetRetention:   DefaultTargetRetention,
+	LearningSteps:     []fb.Interval{},
+	RelearningSteps:   []fb.Interval{flashback.LapseInterval},
+	LeechThreshold:    DefaultLeechThreshold,
+	LeechAction:       LeechTag,
+	NewPerDay:         DailyLimit(DefaultNewPerDay),
+	ReviewsPerDay:     DailyLimit(DefaultReviewsPerDay),
+	BuryStrategy:      BuryProportional,
+	SelectionStrategy: SelectWeighted,
+}
+
+// DailyLimit returns a NewPerDay or ReviewsPerDay value of n.
+func DailyLimit(n int) *int {
+	return &n
+}
+
+// merge overlays any non-zero values in o onto s.
+func (s *DeckSettings) merge(o *DeckSettings) {
+	if o == nil {
+		return
+	}
+	if o.Scheduler != "" {
+		s.Scheduler = o.Scheduler
+	}
+	if o.TargetRetention != 0 {
+		s.TargetRetention = o.TargetRetention
+	}
+	if o.LearningSteps != nil {
+		s.LearningSteps = o.LearningSteps
+	}
+	if o.RelearningSteps != nil {
+		s.RelearningSteps = o.RelearningSteps
+	}
+	if o.LeechThreshold != 0 {
+		s.LeechThreshold = o.LeechThreshold
+	}
+	if o.LeechAction != "" {
+		s.LeechAction = o.LeechAction
+	}
+	if o.NewPerDay != nil {
+		s.NewPerDay = o.NewPerDay
+	}
+	if o.ReviewsPerDay != nil {
+		s.ReviewsPerDay = o.ReviewsPerDay
+	}
+	if o.BuryStrategy != "" {
+		s.BuryStrategy = o.BuryStrategy
+	}
+	if o.SelectionStrategy != "" {
+		s.SelectionStrategy = o.SelectionStrategy
+	}
+}
+
+// validate checks that any configured values are valid.
+func (s *DeckSettings) validate() error {
+	if s.Scheduler != "" {
+		if _, err := GetScheduler(s.Scheduler); err != nil {
+			return err
+		}
+	}
+	if s.TargetRetention < 0 || s.TargetRetention >= 1 {
+		return errors.Errorf("invalid target retention %v", s.TargetRetention)
+	}
+	if err := validateSteps(s.LearningSteps); err != nil {
+		return errors.Wrap(err, "learning steps")
+	}
+	if err := validateSteps(s.RelearningSteps); err != nil {
+		return errors.Wrap(err, "relearning steps")
+	}
+	if s.LeechThreshold < 0 {
+		return errors.Errorf("invalid leech threshold %d", s.LeechThreshold)
+	}
+	switch s.LeechAction {
+	case "", LeechSuspend, LeechTag:
+	default:
+		return errors.Errorf("invalid leech action '%s'", s.LeechAction)
+	}
+	switch s.BuryStrategy {
+	case "", BuryNone, BuryNextDay, BuryProportional:
+	default:
+		return errors.Errorf("invalid bury strategy '%s'", s.BuryStrategy)
+	}
+	if s.SelectionStrategy != "" && !validSelectionStrategy(s.SelectionStrategy) {
+		return errors.Errorf("invalid selection strategy '%s'", s.SelectionStrategy)
+	}
+	return nil
+}
+
+func validateSteps(steps []fb.Interval) error {
+	for _, step := range steps {
+		if step <= 0 {
+			return errors.Errorf("invalid step %s", step)
+		}
+	}
+	return nil
+}
+
+// Settings represents the user's study settings.
+type Settings struct {
+	ID  string `json:"_id"`
+	Rev string `json:"_rev,omitempty"`
+	// DayStart is the local hour, from 0 to 23, at which a new study day
+	// starts. Daily limits, burials and day-granularity due dates roll over
+	// at this time.
+	DayStart int `json:"dayStart,omitempty"`
+	// TimeZone is the IANA name of the time zone in which study days are
+	// counted. If empty, the device's local time zone is used.
+	TimeZone string `json:"timeZone,omitempty"`
+	// Default holds the settings applied to all cards.
+	Default DeckSettings `json:"default"`
+	// Overrides holds per-bundle, per-model and per-deck settings, keyed by
+	// bundle, model (theme-<theme>/<model>) or deck ID. Deck settings take
+	// precedence over model settings, which take precedence over bundle
+	// settings.
+	Overrides map[string]*DeckSettings `json:"overrides,omitempty"`
+}
+
+// For returns the effective settings for a card in the given bundle. ids are
+// the card's model and deck IDs, in increasing order of precedence.
+func (s *Settings) For(bundleID string, ids ...string) *DeckSettings {
+	result := defaultDeckSettings
+	result.merge(&s.Default)
+	result.merge(s.Overrides[bundleID])
+	for _, id := range ids {
+		result.merge(s.Overrides[id])
+	}
+	return &result
+}
+
+// selection returns the selection strategy for study within scope. The
+// settings of the scope's deck, or failing that its bundle, apply.
+func (s *Settings) selection(scope StudyScope) SelectionStrategy {
+	var ids []string
+	if scope.DeckID != "" {
+		ids = append(ids, scope.DeckID)
+	}
+	return s.For(scope.BundleID, ids...).SelectionStrategy
+}
+
+// hasDeckOverrides returns true if any deck-level overrides are configured.
+func (s *Settings) hasDeckOverrides() bool {
+	for id := range s.Overrides {
+		if strings.HasPrefix(id, "deck-") {
+			return true
+		}
+	}
+	return false
+}
+
+// Settings returns the user's study settings. If none have been saved, an
+// empty Settings value is returned, which results in the default settings.
+func (r *Repo) Settings(ctx context.Context) (*Settings, error) {
+	udb, err := r.userDB(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return fetchSettings(ctx, udb)
+}
+
+func fetchSettings(ctx context.Context, db getter) (*Settings, error) {
+	s := &Settings{}
+	err := getDoc(ctx, db, settingsDocID, s)
+	if kivik.StatusCode(err) == kivik.StatusNotFound {
+		return &Settings{ID: settingsDocID}, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "fetch settings")
+	}
+	return s, nil
+}
+
+// SaveSettings stores the user's study settings.
+func (r *Repo) SaveSettings(ctx context.Context, s *Settings) error {
+	if s == nil {
+		return errors.New("nil settings")
+	}
+	udb, err := r.userDB(ctx)
+	if err != nil {
+		return err
+	}
+	if err := s.validateClock(); err != nil {
+		return err
+	}
+	if err := s.Default.validate(); err != nil {
+		return err
+	}
+	for id, o := range s.Overrides {
+		if o == nil {
+			continue
+		}
+		if err := o.validate(); err != nil {
+			return errors.Wrap(err, id)
+		}
+	}
+	s.ID = settingsDocID
+	doc := struct {
+		*Settings
+		Type string `json:"type"`
+	}{
+		Settings: s,
+		Type:     "settings",
+	}
+	rev, err := udb.Put(ctx, settingsDocID, doc)
+	if err != nil {
+		return errors.Wrap(err, "save settings")
+	}
+	s.Rev = rev
+	return nil
+}
+
+// cardSettings loads the effective settings for the card, taking into
+// account any deck, model or bundle overrides, and the user's study clock. If
+// any deck overrides are configured, the IDs of the decks containing the card
+// are also loaded.
+func (r *Repo) cardSettings(ctx context.Context, c *Card) error {
+	udb, err := r.userDB(ctx)
+	if err != nil {
+		return err
+	}
+	s, err := fetchSettings(ctx, udb)
+	if err != nil {
+		return err
+	}
+	var deckIDs []string
+	if s.hasDeckOverrides() {
+		bdb, err := r.local.DB(ctx, c.BundleID())
+		if err != nil {
+			return err
+		}
+		if deckIDs, err = cardDecks(ctx, bdb, c.ID); err != nil {
+			return err
+		}
+	}
+	c.config = s.For(c.BundleID(), append([]string{c.ModelID}, deckIDs...)...)
+	c.decks = deckIDs
+	c.clock = s.clock()
+	return nil
+}
+
+// cardDecks returns the IDs of the decks in db which contain cardID.
+func cardDecks(ctx context.Context, db finder, cardID string) ([]string, error) {
+	decks, err := fetchDecks(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	return decksContaining(decks, cardID), nil
+}
+
+// decksContaining returns the IDs of those decks which contain cardID.
+func decksContaining(decks []*fb.Deck, cardID string) []string {
+	var ids []string
+	for _, deck := range decks {
+		for _, id := range deck.Cards.All() {
+			if id == cardID {
+				ids = append(ids, deck.ID)
+				break
+			}
+		}
+	}
+	return ids
+}
@@ -0,0 +1,187 @@
+package model
+
+import (
+	"context"
+	"testing"
+
+	"github.com/flimzy/diff"
+
+	"github.com/FlashbackSRS/flashback/fb"
+)
+
+func TestSettingsFor(t *testing.T) {
+	withScheduler := func(name string) *DeckSettings {
+		s := defaultDeckSettings
+		s.Scheduler = name
+		return &s
+	}
+	tests := []struct {
+		name     string
+		settings *Settings
+		bundle   string
+		decks    []string
+		expected *DeckSettings
+	}{
+		{
+			name:     "defaults",
+			settings: &Settings{},
+			expected: withScheduler(DefaultScheduler),
+		},
+		{
+			name:     "user default",
+			settings: &Settings{Default: DeckSettings{Scheduler: "foo"}},
+			expected: withScheduler("foo"),
+		},
+		{
+			name: "bundle override",
+			settings: &Settings{
+				Default:   DeckSettings{Scheduler: "foo"},
+				Overrides: map[string]*DeckSettings{"bundle-foo": {Scheduler: "bar"}},
+			},
+			bundle:   "bundle-foo",
+			expected: withScheduler("bar"),
+		},
+		{
+			name: "deck beats bundle",
+			settings: &Settings{
+				Overrides: map[string]*DeckSettings{
+					"bundle-foo": {Scheduler: "bar"},
+					"deck-foo":   {Scheduler: "baz"},
+				},
+			},
+			bundle:   "bundle-foo",
+			decks:    []string{"deck-foo"},
+			expected: withScheduler("baz"),
+		},
+		{
+			name: "model override",
+			settings: &Settings{
+				Overrides: map[string]*DeckSettings{
+					"bundle-foo":  {BuryStrategy: BuryNone},
+					"theme-foo/0": {BuryStrategy: BuryNextDay},
+				},
+			},
+			bundle: "bundle-foo",
+			decks:  []string{"theme-foo/0"},
+			expected: func() *DeckSettings {
+				s := defaultDeckSettings
+				s.BuryStrategy = BuryNextDay
+				return &s
+			}(),
+		},
+		{
+			name: "empty override inherits",
+			settings: &Settings{
+				Overrides: map[string]*DeckSettings{"deck-foo": {}},
+			},
+			decks:    []string{"deck-foo"},
+			expected: withScheduler(DefaultScheduler),
+		},
+		{
+			name: "steps override",
+			settings: &Settings{
+				Overrides: map[string]*DeckSettings{
+					"deck-foo": {
+						LearningSteps:   []fb.Interval{fb.Minute, 10 * fb.Minute},
+						RelearningSteps: []fb.Interval{},
+					},
+				},
+			},
+			decks: []string{"deck-foo"},
+			expected: func() *DeckSettings {
+				s := defaultDeckSettings
+				s.LearningSteps = []fb.Interval{fb.Minute, 10 * fb.Minute}
+				s.RelearningSteps = []fb.Interval{}
+				return &s
+			}(),
+		},
+		{
+			name: "explicit zero limits",
+			settings: &Settings{
+				Default: DeckSettings{NewPerDay: DailyLimit(10), ReviewsPerDay: DailyLimit(-1)},
+				Overrides: map[string]*DeckSettings{
+					"deck-foo": {NewPerDay: DailyLimit(0), ReviewsPerDay: DailyLimit(0)},
+				},
+			},
+			decks: []string{"deck-foo"},
+			expected: func() *DeckSettings {
+				s := defaultDeckSettings
+				s.NewPerDay = DailyLimit(0)
+				s.ReviewsPerDay = DailyLimit(0)
+				return &s
+			}(),
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result := test.settings.For(test.bundle, test.decks...)
+			if d := diff.Interface(test.expected, result); d != nil {
+				t.Error(d)
+			}
+		})
+	}
+}
+
+func TestSaveSettings(t *testing.T) {
+	repo := &Repo{user: "mjxwe", local: testClient(t)}
+	if err := repo.local.CreateDB(context.Background(), "user-mjxwe"); err != nil {
+		t.Fatal(err)
+	}
+	t.Run("not found", func(t *testing.T) {
+		s, err := repo.Settings(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d := diff.Interface(&Settings{ID: settingsDocID}, s); d != nil {
+			t.Error(d)
+		}
+	})
+	t.Run("unknown scheduler", func(t *testing.T) {
+		err := repo.SaveSettings(context.Background(), &Settings{Default: DeckSettings{Scheduler: "unknown"}})
+		checkErr(t, "Scheduler 'unknown' not found", err)
+	})
+	t.Run("invalid step", func(t *testing.T) {
+		err := repo.SaveSettings(context.Background(), &Settings{Default: DeckSettings{LearningSteps: []fb.Interval{0}}})
+		checkErr(t, "learning steps: invalid step 0h", err)
+	})
+	t.Run("invalid leech action", func(t *testing.T) {
+		err := repo.SaveSettings(context.Background(), &Settings{Default: DeckSettings{LeechAction: "delete"}})
+		checkErr(t, "invalid leech action 'delete'", err)
+	})
+	t.Run("invalid bury strategy", func(t *testing.T) {
+		err := repo.SaveSettings(context.Background(), &Settings{
+			Overrides: map[string]*DeckSettings{"theme-foo/0": {BuryStrategy: "forever"}},
+		})
+		checkErr(t, "theme-foo/0: invalid bury strategy 'forever'", err)
+	})
+	t.Run("invalid day start", func(t *testing.T) {
+		err := repo.SaveSettings(context.Background(), &Settings{DayStart: 24})
+		checkErr(t, "invalid day start hour 24", err)
+	})
+	t.Run("invalid time zone", func(t *testing.T) {
+		err := repo.SaveSettings(context.Background(), &Settings{TimeZone: "Mars/Olympus_Mons"})
+		checkErr(t, "invalid time zone: unknown time zone Mars/Olympus_Mons", err)
+	})
+	t.Run("invalid selection strategy", func(t *testing.T) {
+		err := repo.SaveSettings(context.Background(), &Settings{
+			Overrides: map[string]*DeckSettings{"deck-foo": {SelectionStrategy: "alphabetical"}},
+		})
+		checkErr(t, "deck-foo: invalid selection strategy 'alphabetical'", err)
+	})
+	t.Run("round trip", func(t *testing.T) {
+		s := &Settings{
+			Default:   DeckSettings{Scheduler: DefaultScheduler},
+			Overrides: map[string]*DeckSettings{"deck-foo": {Scheduler: DefaultScheduler, RelearningSteps: []fb.Interval{}}},
+		}
+		if err := repo.SaveSettings(context.Background(), s); err != nil {
+			t.Fatal(err)
+		}
+		result, err := repo.Settings(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d := diff.Interface(s, result); d != nil {
+			t.Error(d)
+		}
+	})
+}
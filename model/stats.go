@@ -0,0 +1,328 @@
+package model
+
+import (
+	"context"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/FlashbackSRS/flashback/fb"
+	"github.com/flimzy/kivik"
+	"github.com/pkg/errors"
+)
+
+// CardState describes a card's progress through the learning process.
+type CardState int
+
+// The possible card states.
+const (
+	// StateNew cards have never been studied.
+	StateNew CardState = iota
+	// StateLearning cards have a sub-day interval, either because they are
+	// being learned for the first time, or re-learned after a lapse.
+	StateLearning
+	// StateYoung cards have an interval of at least one day, but less than
+	// MatureInterval.
+	StateYoung
+	// StateMature cards have an interval of at least MatureInterval.
+	StateMature
+	// StateSuspended cards are excluded from study.
+	StateSuspended
+)
+
+func (s CardState) String() string {
+	switch s {
+	case StateNew:
+		return "new"
+	case StateLearning:
+		return "learning"
+	case StateYoung:
+		return "young"
+	case StateMature:
+		return "mature"
+	case StateSuspended:
+		return "suspended"
+	}
+	return "unknown"
+}
+
+// MatureInterval is the interval at which a card is considered mature.
+const MatureInterval = 21 * fb.Day
+
+// cardState returns the card's current state.
+func cardState(card *fb.Card) CardState {
+	switch {
+	case card.Suspended:
+		return StateSuspended
+	case card.Interval == 0:
+		return StateNew
+	case card.Interval < fb.Day:
+		return StateLearning
+	case card.Interval < MatureInterval:
+		return StateYoung
+	}
+	return StateMature
+}
+
+// StatsFilter limits the cards and reviews considered when calculating
+// statistics. Zero values match everything.
+type StatsFilter struct {
+	// BundleID limits stats to cards in the bundle.
+	BundleID string
+	// DeckID limits stats to cards in the deck.
+	DeckID string
+	// From and To limit the reviews considered to those which took place
+	// during [From, To). They have no effect on card counts or distributions,
+	// which always reflect the cards' current state.
+	From, To time.Time
+}
+
+func (f *StatsFilter) includesTime(t time.Time) bool {
+	if !f.From.IsZero() && t.Before(f.From) {
+		return false
+	}
+	if !f.To.IsZero() && !t.Before(f.To) {
+		return false
+	}
+	return true
+}
+
+// DayStats summarizes the reviews done on a single day.
+type DayStats struct {
+	Day     fb.Due `json:"day"`
+	Reviews int    `json:"reviews"`
+	// Correct is the number of reviews not answered 'wrong'.
+	Correct int `json:"correct"`
+	// Time is the total time spent answering.
+	Time time.Duration `json:"time"`
+}
+
+// IntervalBucket counts reviews or cards with an interval in the range
+// [Min, Max). A zero Max means no upper bound.
+type IntervalBucket struct {
+	Min   fb.Interval `json:"min"`
+	Max   fb.Interval `json:"max,omitempty"`
+	Count int         `json:"count"`
+	// Passed is the number of reviews not answered 'wrong'. It is only set
+	// for retention buckets.
+	Passed int `json:"passed,omitempty"`
+}
+
+// Retention returns the fraction of reviews in the bucket which passed, or
+// NaN if there are none.
+func (b *IntervalBucket) Retention() float64 {
+	if b.Count == 0 {
+		return math.NaN()
+	}
+	return float64(b.Passed) / float64(b.Count)
+}
+
+// EaseBucket counts cards with an ease factor, rounded to one decimal place.
+type EaseBucket struct {
+	Ease  float32 `json:"ease"`
+	Count int     `json:"count"`
+}
+
+// Stats are statistics calculated from the user's cards and review log.
+type Stats struct {
+	// Reviews is the total number of reviews.
+	Reviews int `json:"reviews"`
+	// Days holds per-day review counts, in chronological order. Days without
+	// reviews are omitted.
+	Days []*DayStats `json:"days"`
+	// Retention holds the pass rate of reviews (excluding learning steps),
+	// bucketed by the interval preceding the review.
+	Retention []*IntervalBucket `json:"retention"`
+	// AverageAnswerTime is the mean time taken to answer.
+	AverageAnswerTime time.Duration `json:"averageAnswerTime"`
+	// Intervals is the distribution of current card intervals, excluding new
+	// cards.
+	Intervals []*IntervalBucket `json:"intervals"`
+	// Eases is the distribution of current card ease factors, excluding new
+	// cards, in ascending order.
+	Eases []*EaseBucket `json:"eases"`
+	// Cards counts cards by state.
+	Cards map[CardState]int `json:"cards"`
+}
+
+// TrueRetention returns the pass rate of all reviews (excluding learning
+// steps), or NaN if there are none.
+func (s *Stats) TrueRetention() float64 {
+	total := &IntervalBucket{}
+	for _, b := range s.Retention {
+		total.Count += b.Count
+		total.Passed += b.Passed
+	}
+	return total.Retention()
+}
+
+// statsBuckets are the lower bounds of the interval buckets used for stats.
+var statsBuckets = []fb.Interval{
+	0, fb.Day, 2 * fb.Day, 4 * fb.Day, 8 * fb.Day, MatureInterval, 90 * fb.Day, 365 * fb.Day,
+}
+
+func newIntervalBuckets() []*IntervalBucket {
+	buckets := make([]*IntervalBucket, len(statsBuckets))
+	for i, min := range statsBuckets {
+		buckets[i] = &IntervalBucket{Min: min}
+		if i+1 < len(statsBuckets) {
+			buckets[i].Max = statsBuckets[i+1]
+		}
+	}
+	return buckets
+}
+
+func findBucket(buckets []*IntervalBucket, ivl fb.Interval) *IntervalBucket {
+	for i := len(buckets) - 1; i > 0; i-- {
+		if ivl >= buckets[i].Min {
+			return buckets[i]
+		}
+	}
+	return buckets[0]
+}
+
+// Stats calculates study statistics for the cards and reviews matching filter.
+func (r *Repo) Stats(ctx context.Context, filter StatsFilter) (*Stats, error) {
+	udb, err := r.userDB(ctx)
+	if err != nil {
+		return nil, err
+	}
+	cards, err := findCards(ctx, udb, nil)
+	if err != nil {
+		return nil, err
+	}
+	reviews, err := findReviews(ctx, udb)
+	if err != nil {
+		return nil, err
+	}
+	clock, err := r.studyClock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	include := func(cardID string) bool {
+		return filter.BundleID == "" || (&fb.Card{ID: cardID}).BundleID() == filter.BundleID
+	}
+	if filter.DeckID != "" {
+		deckCards, err := r.deckCards(ctx, filter.BundleID, filter.DeckID, cards)
+		if err != nil {
+			return nil, err
+		}
+		include = func(cardID string) bool {
+			_, ok := deckCards[cardID]
+			return ok
+		}
+	}
+
+	stats := &Stats{
+		Days:      make([]*DayStats, 0),
+		Retention: newIntervalBuckets(),
+		Intervals: newIntervalBuckets(),
+		Eases:     make([]*EaseBucket, 0),
+		Cards:     make(map[CardState]int),
+	}
+	days := make(map[fb.Due]*DayStats)
+	var answerTime time.Duration
+	for _, review := range reviews {
+		if !include(review.CardID) || !filter.includesTime(review.Timestamp) {
+			continue
+		}
+		stats.Reviews++
+		answerTime += review.ReviewTime
+		passed := review.Ease != fb.ReviewEaseWrong
+		day := clock.on(review.Timestamp)
+		ds, ok := days[day]
+		if !ok {
+			ds = &DayStats{Day: day}
+			days[day] = ds
+			stats.Days = append(stats.Days, ds)
+		}
+		ds.Reviews++
+		ds.Time += review.ReviewTime
+		if passed {
+			ds.Correct++
+		}
+		if review.Type == fb.ReviewTypeReview {
+			b := findBucket(stats.Retention, review.PreviousInterval)
+			b.Count++
+			if passed {
+				b.Passed++
+			}
+		}
+	}
+	sort.Slice(stats.Days, func(i, j int) bool {
+		return stats.Days[j].Day.After(stats.Days[i].Day)
+	})
+	if stats.Reviews > 0 {
+		stats.AverageAnswerTime = answerTime / time.Duration(stats.Reviews)
+	}
+
+	eases := make(map[float32]*EaseBucket)
+	for _, card := range cards {
+		if !include(card.ID) {
+			continue
+		}
+		state := cardState(card)
+		stats.Cards[state]++
+		if card.Interval == 0 {
+			continue
+		}
+		findBucket(stats.Intervals, card.Interval).Count++
+		if card.EaseFactor == 0 {
+			continue
+		}
+		ease := float32(round(float64(card.EaseFactor)*10) / 10)
+		eb, ok := eases[ease]
+		if !ok {
+			eb = &EaseBucket{Ease: ease}
+			eases[ease] = eb
+			stats.Eases = append(stats.Eases, eb)
+		}
+		eb.Count++
+	}
+	sort.Slice(stats.Eases, func(i, j int) bool {
+		return stats.Eases[i].Ease < stats.Eases[j].Ease
+	})
+	return stats, nil
+}
+
+// deckCards returns the set of card IDs belonging to deckID. The deck is
+// sought in bundleID, if set, or otherwise in each bundle containing one of
+// cards. Bundles which aren't stored locally are skipped.
+func (r *Repo) deckCards(ctx context.Context, bundleID, deckID string, cards []*fb.Card) (map[string]struct{}, error) {
+	bundleIDs := []string{bundleID}
+	if bundleID == "" {
+		seen := make(map[string]struct{})
+		bundleIDs = bundleIDs[:0]
+		for _, card := range cards {
+			id := card.BundleID()
+			if _, ok := seen[id]; !ok {
+				seen[id] = struct{}{}
+				bundleIDs = append(bundleIDs, id)
+			}
+		}
+	}
+	for _, bundleID := range bundleIDs {
+		bdb, err := r.local.DB(ctx, bundleID)
+		if kivik.StatusCode(err) == kivik.StatusNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, bundleID)
+		}
+		decks, err := fetchDecks(ctx, bdb)
+		if err != nil {
+			return nil, err
+		}
+		for _, deck := range decks {
+			if deck.ID != deckID {
+				continue
+			}
+			ids := make(map[string]struct{})
+			for _, id := range deck.Cards.All() {
+				ids[id] = struct{}{}
+			}
+			return ids, nil
+		}
+	}
+	return nil, errors.Errorf("deck '%s' not found", deckID)
+}
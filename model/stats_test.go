@@ -0,0 +1,181 @@
+package model
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/FlashbackSRS/flashback/fb"
+	"github.com/flimzy/diff"
+)
+
+func TestCardState(t *testing.T) {
+	tests := []struct {
+		name     string
+		card     *fb.Card
+		expected CardState
+	}{
+		{name: "new", card: &fb.Card{}, expected: StateNew},
+		{name: "learning", card: &fb.Card{Interval: 10 * fb.Minute}, expected: StateLearning},
+		{name: "young", card: &fb.Card{Interval: 20 * fb.Day}, expected: StateYoung},
+		{name: "mature", card: &fb.Card{Interval: 21 * fb.Day}, expected: StateMature},
+		{name: "suspended", card: &fb.Card{Interval: 21 * fb.Day, Suspended: true}, expected: StateSuspended},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if state := cardState(test.card); state != test.expected {
+				t.Errorf("Unexpected state: %s", state)
+			}
+		})
+	}
+}
+
+func TestRepoStats(t *testing.T) {
+	ctx := context.Background()
+	t.Run("not logged in", func(t *testing.T) {
+		_, err := (&Repo{}).Stats(ctx, StatsFilter{})
+		checkErr(t, "not logged in", err)
+	})
+	repo := &Repo{user: "mjxwe", local: testClient(t)}
+	for _, name := range []string{"user-mjxwe", "bundle-foo"} {
+		if err := repo.local.CreateDB(ctx, name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	udb, err := repo.userDB(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bdb, err := repo.local.DB(ctx, "bundle-foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	created := parseTime(t, "2017-01-01T00:00:00Z")
+	deck := &fb.Deck{ID: "deck-foo", Created: created, Modified: created, Cards: fb.NewCardCollection()}
+	deck.AddCard("card-foo.bar.0")
+	deck.AddCard("card-foo.bar.1")
+	if err := saveDoc(ctx, bdb, deck); err != nil {
+		t.Fatal(err)
+	}
+	cards := []*fb.Card{
+		{ID: "card-foo.bar.0", Interval: 30 * fb.Day, EaseFactor: 2.52},
+		{ID: "card-foo.bar.1", Interval: 3 * fb.Day, EaseFactor: 2.3},
+		{ID: "card-foo.bar.2", Interval: 10 * fb.Minute},
+		{ID: "card-foo.bar.3"},
+		{ID: "card-baz.qux.0", Interval: 5 * fb.Day, EaseFactor: 2.5, Suspended: true},
+	}
+	for _, card := range cards {
+		card.ModelID = "theme-foo/0"
+		card.Created = created
+		card.Modified = created
+		if err := saveDoc(ctx, udb, card); err != nil {
+			t.Fatal(err)
+		}
+	}
+	reviews := []*fb.Review{
+		{CardID: "card-foo.bar.0", Timestamp: parseTime(t, "2017-01-01T10:00:00Z"), Ease: fb.ReviewEaseOK, PreviousInterval: 10 * fb.Day, ReviewTime: 4 * time.Second, Type: fb.ReviewTypeReview},
+		{CardID: "card-foo.bar.1", Timestamp: parseTime(t, "2017-01-01T11:00:00Z"), Ease: fb.ReviewEaseWrong, PreviousInterval: 30 * fb.Day, ReviewTime: 8 * time.Second, Type: fb.ReviewTypeReview},
+		{CardID: "card-foo.bar.1", Timestamp: parseTime(t, "2017-01-01T11:10:00Z"), Ease: fb.ReviewEaseOK, PreviousInterval: 10 * fb.Minute, ReviewTime: 6 * time.Second, Type: fb.ReviewTypeRelearn},
+		{CardID: "card-foo.bar.2", Timestamp: parseTime(t, "2017-01-02T09:00:00Z"), Ease: fb.ReviewEaseWrong, ReviewTime: 2 * time.Second, Type: fb.ReviewTypeLearn},
+		{CardID: "card-baz.qux.0", Timestamp: parseTime(t, "2017-01-02T10:00:00Z"), Ease: fb.ReviewEaseEasy, PreviousInterval: 2 * fb.Day, ReviewTime: 10 * time.Second, Type: fb.ReviewTypeReview},
+	}
+	for _, review := range reviews {
+		review.ID = "review-" + review.CardID[5:] + "." + review.Timestamp.Format("150405")
+		if err := saveDoc(ctx, udb, review); err != nil {
+			t.Fatal(err)
+		}
+	}
+	retention := func(counts ...[2]int) []*IntervalBucket {
+		buckets := newIntervalBuckets()
+		for i, c := range counts {
+			buckets[i].Count, buckets[i].Passed = c[0], c[1]
+		}
+		return buckets
+	}
+	intervals := func(counts ...int) []*IntervalBucket {
+		buckets := newIntervalBuckets()
+		for i, c := range counts {
+			buckets[i].Count = c
+		}
+		return buckets
+	}
+
+	tests := []struct {
+		name     string
+		filter   StatsFilter
+		expected *Stats
+		err      string
+	}{
+		{
+			name: "all",
+			expected: &Stats{
+				Reviews: 5,
+				Days: []*DayStats{
+					{Day: parseDue(t, "2017-01-01"), Reviews: 3, Correct: 2, Time: 18 * time.Second},
+					{Day: parseDue(t, "2017-01-02"), Reviews: 2, Correct: 1, Time: 12 * time.Second},
+				},
+				Retention:         retention([2]int{}, [2]int{}, [2]int{1, 1}, [2]int{}, [2]int{1, 1}, [2]int{1, 0}),
+				AverageAnswerTime: 6 * time.Second,
+				Intervals:         intervals(1, 0, 1, 1, 0, 1),
+				Eases:             []*EaseBucket{{Ease: 2.3, Count: 1}, {Ease: 2.5, Count: 2}},
+				Cards:             map[CardState]int{StateNew: 1, StateLearning: 1, StateYoung: 1, StateMature: 1, StateSuspended: 1},
+			},
+		},
+		{
+			name:   "bundle and date range",
+			filter: StatsFilter{BundleID: "bundle-foo", From: parseTime(t, "2017-01-01T10:30:00Z"), To: parseTime(t, "2017-01-02T00:00:00Z")},
+			expected: &Stats{
+				Reviews: 2,
+				Days: []*DayStats{
+					{Day: parseDue(t, "2017-01-01"), Reviews: 2, Correct: 1, Time: 14 * time.Second},
+				},
+				Retention:         retention([2]int{}, [2]int{}, [2]int{}, [2]int{}, [2]int{}, [2]int{1, 0}),
+				AverageAnswerTime: 7 * time.Second,
+				Intervals:         intervals(1, 0, 1, 0, 0, 1),
+				Eases:             []*EaseBucket{{Ease: 2.3, Count: 1}, {Ease: 2.5, Count: 1}},
+				Cards:             map[CardState]int{StateNew: 1, StateLearning: 1, StateYoung: 1, StateMature: 1},
+			},
+		},
+		{
+			name:   "deck",
+			filter: StatsFilter{DeckID: "deck-foo"},
+			expected: &Stats{
+				Reviews: 3,
+				Days: []*DayStats{
+					{Day: parseDue(t, "2017-01-01"), Reviews: 3, Correct: 2, Time: 18 * time.Second},
+				},
+				Retention:         retention([2]int{}, [2]int{}, [2]int{}, [2]int{}, [2]int{1, 1}, [2]int{1, 0}),
+				AverageAnswerTime: 6 * time.Second,
+				Intervals:         intervals(0, 0, 1, 0, 0, 1),
+				Eases:             []*EaseBucket{{Ease: 2.3, Count: 1}, {Ease: 2.5, Count: 1}},
+				Cards:             map[CardState]int{StateYoung: 1, StateMature: 1},
+			},
+		},
+		{
+			name:   "unknown deck",
+			filter: StatsFilter{DeckID: "deck-bar"},
+			err:    "deck 'deck-bar' not found",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result, err := repo.Stats(ctx, test.filter)
+			checkErr(t, test.err, err)
+			if err != nil {
+				return
+			}
+			if d := diff.Interface(test.expected, result); d != nil {
+				t.Error(d)
+			}
+		})
+	}
+}
+
+func TestTrueRetention(t *testing.T) {
+	s := &Stats{Retention: newIntervalBuckets()}
+	s.Retention[1].Count, s.Retention[1].Passed = 3, 2
+	s.Retention[4].Count, s.Retention[4].Passed = 1, 1
+	if r := s.TrueRetention(); r != 0.75 {
+		t.Errorf("Unexpected retention: %f", r)
+	}
+}
@@ -0,0 +1,61 @@
+package model
+
+import (
+	"github.com/FlashbackSRS/flashback"
+	"github.com/FlashbackSRS/flashback/fb"
+)
+
+// scheduleStep moves a card which is new, or in its learning or relearning
+// steps, to its next step. A wrong answer returns the card to the first step,
+// a hard answer repeats the current step, and an easy answer graduates the
+// card immediately.
+//
+// It returns false if the card is not in learning, or has just graduated, in
+// which case the card should be rescheduled by the Scheduler.
+func scheduleStep(card *fb.Card, clock studyClock, config *DeckSettings, quality flashback.AnswerQuality) bool {
+	var steps []fb.Interval
+	switch {
+	case card.LearningStep > 0 && card.Relearning:
+		steps = config.RelearningSteps
+	case card.LearningStep > 0 || card.Interval == 0:
+		steps = config.LearningSteps
+	default:
+		return false
+	}
+	step := card.LearningStep
+	if step == 0 {
+		// New cards start at the first step
+		step = 1
+	}
+	switch reviewEase(quality) {
+	case fb.ReviewEaseWrong:
+		step = 1
+	case fb.ReviewEaseHard:
+	case fb.ReviewEaseOK:
+		step++
+	default:
+		step = len(steps) + 1
+	}
+	if step > len(steps) {
+		card.LearningStep = 0
+		card.Relearning = false
+		return false
+	}
+	card.LearningStep = step
+	card.Interval = steps[step-1]
+	card.Due = clock.dueIn(card.Interval)
+	return true
+}
+
+// startRelearning moves a review card which has just lapsed into its
+// relearning steps, if any are configured. prevInterval is the card's
+// interval before it was rescheduled.
+func startRelearning(card *fb.Card, clock studyClock, prevInterval fb.Interval, config *DeckSettings, quality flashback.AnswerQuality) {
+	if prevInterval < fb.Day || reviewEase(quality) != fb.ReviewEaseWrong || len(config.RelearningSteps) == 0 {
+		return
+	}
+	card.LearningStep = 1
+	card.Relearning = true
+	card.Interval = config.RelearningSteps[0]
+	card.Due = clock.dueIn(card.Interval)
+}
@@ -0,0 +1,168 @@
+package model
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/FlashbackSRS/flashback"
+	"github.com/FlashbackSRS/flashback/fb"
+)
+
+func TestScheduleStep(t *testing.T) {
+	config := &DeckSettings{
+		LearningSteps:   []fb.Interval{fb.Minute, 10 * fb.Minute, fb.Hour},
+		RelearningSteps: []fb.Interval{10 * fb.Minute, fb.Day},
+	}
+	type expected struct {
+		stepped    bool
+		step       int
+		relearning bool
+		interval   fb.Interval
+	}
+	tests := []struct {
+		name     string
+		card     *fb.Card
+		config   *DeckSettings
+		quality  flashback.AnswerQuality
+		expected expected
+	}{
+		{
+			name:     "new card, wrong",
+			card:     &fb.Card{},
+			quality:  flashback.AnswerBlackout,
+			expected: expected{true, 1, false, fb.Minute},
+		},
+		{
+			name:     "new card, hard",
+			card:     &fb.Card{},
+			quality:  flashback.AnswerCorrectDifficult,
+			expected: expected{true, 1, false, fb.Minute},
+		},
+		{
+			name:     "new card, good",
+			card:     &fb.Card{},
+			quality:  flashback.AnswerCorrect,
+			expected: expected{true, 2, false, 10 * fb.Minute},
+		},
+		{
+			name:     "new card, easy",
+			card:     &fb.Card{},
+			quality:  flashback.AnswerPerfect,
+			expected: expected{false, 0, false, 0},
+		},
+		{
+			name:     "new card, no steps",
+			card:     &fb.Card{},
+			config:   &DeckSettings{},
+			quality:  flashback.AnswerCorrect,
+			expected: expected{false, 0, false, 0},
+		},
+		{
+			name:     "learning, wrong",
+			card:     &fb.Card{LearningStep: 3, Interval: fb.Hour},
+			quality:  flashback.AnswerIncorrectEasy,
+			expected: expected{true, 1, false, fb.Minute},
+		},
+		{
+			name:     "learning, last step",
+			card:     &fb.Card{LearningStep: 3, Interval: fb.Hour},
+			quality:  flashback.AnswerCorrect,
+			expected: expected{false, 0, false, fb.Hour},
+		},
+		{
+			name:     "relearning, good",
+			card:     &fb.Card{LearningStep: 1, Relearning: true, Interval: 10 * fb.Minute},
+			quality:  flashback.AnswerCorrect,
+			expected: expected{true, 2, true, fb.Day},
+		},
+		{
+			name:     "relearning, last step",
+			card:     &fb.Card{LearningStep: 2, Relearning: true, Interval: fb.Day},
+			quality:  flashback.AnswerCorrect,
+			expected: expected{false, 0, false, fb.Day},
+		},
+		{
+			name:     "steps removed from config",
+			card:     &fb.Card{LearningStep: 2, Interval: 10 * fb.Minute},
+			config:   &DeckSettings{LearningSteps: []fb.Interval{fb.Minute}},
+			quality:  flashback.AnswerCorrectDifficult,
+			expected: expected{false, 0, false, 10 * fb.Minute},
+		},
+		{
+			name:     "review card",
+			card:     &fb.Card{Interval: 10 * fb.Day},
+			quality:  flashback.AnswerBlackout,
+			expected: expected{false, 0, false, 10 * fb.Day},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := config
+			if test.config != nil {
+				c = test.config
+			}
+			stepped := scheduleStep(test.card, studyClock{}, c, test.quality)
+			if stepped != test.expected.stepped {
+				t.Errorf("Unexpected result: %t", stepped)
+			}
+			if test.card.LearningStep != test.expected.step {
+				t.Errorf("Unexpected step: %d", test.card.LearningStep)
+			}
+			if test.card.Relearning != test.expected.relearning {
+				t.Errorf("Unexpected relearning: %t", test.card.Relearning)
+			}
+			if test.card.Interval != test.expected.interval {
+				t.Errorf("Unexpected interval: %s", test.card.Interval)
+			}
+			if stepped {
+				if due := fb.Due(now()).Add(test.expected.interval); !test.card.Due.Equal(due) {
+					t.Errorf("Unexpected due: %s", test.card.Due)
+				}
+			}
+		})
+	}
+}
+
+func TestScheduleWithSteps(t *testing.T) {
+	defer func(n func() time.Time) { now = n }(now)
+	now = func() time.Time { return parseTime(t, "2017-01-01T12:00:00Z") }
+	defer func(orig *rand.Rand) { rnd = orig }(rnd)
+	rnd = rand.New(rand.NewSource(1))
+	config := defaultDeckSettings
+	config.LearningSteps = []fb.Interval{fb.Minute, 10 * fb.Minute}
+	config.RelearningSteps = []fb.Interval{5 * fb.Minute}
+	card := &Card{Card: &fb.Card{ID: "card-foo.bar.0"}, config: &config}
+
+	answers := []struct {
+		quality    flashback.AnswerQuality
+		interval   fb.Interval
+		relearning bool
+		reviewType fb.ReviewType
+	}{
+		{flashback.AnswerCorrect, 10 * fb.Minute, false, fb.ReviewTypeLearn},
+		{flashback.AnswerBlackout, fb.Minute, false, fb.ReviewTypeLearn},
+		{flashback.AnswerCorrect, 10 * fb.Minute, false, fb.ReviewTypeLearn},
+		{flashback.AnswerCorrect, flashback.InitialInterval, false, fb.ReviewTypeLearn},
+		{flashback.AnswerCorrect, 5 * fb.Day, false, fb.ReviewTypeReview}, // SecondInterval, fuzzed
+		{flashback.AnswerBlackout, 5 * fb.Minute, true, fb.ReviewTypeReview},
+		{flashback.AnswerCorrect, flashback.InitialInterval, false, fb.ReviewTypeRelearn},
+	}
+	for i, answer := range answers {
+		if err := Schedule(card, time.Second, answer.quality); err != nil {
+			t.Fatal(err)
+		}
+		if card.Interval != answer.interval {
+			t.Errorf("Answer %d: Unexpected interval: %s", i, card.Interval)
+		}
+		if card.Relearning != answer.relearning {
+			t.Errorf("Answer %d: Unexpected relearning: %t", i, card.Relearning)
+		}
+		if card.review.Type != answer.reviewType {
+			t.Errorf("Answer %d: Unexpected review type: %d", i, card.review.Type)
+		}
+		if card.review.Interval != card.Interval {
+			t.Errorf("Answer %d: Review interval %s doesn't match card", i, card.review.Interval)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package model
+
+import (
+	"context"
+	"io"
+	"sort"
+
+	"github.com/FlashbackSRS/flashback/fb"
+)
+
+// SuspendCards suspends the cards with the given IDs, so that they are no
+// longer studied.
+func (r *Repo) SuspendCards(ctx context.Context, cardIDs ...string) error {
+	return r.setSuspended(ctx, cardIDs, true)
+}
+
+// UnsuspendCards returns the suspended cards with the given IDs to study.
+func (r *Repo) UnsuspendCards(ctx context.Context, cardIDs ...string) error {
+	return r.setSuspended(ctx, cardIDs, false)
+}
+
+func (r *Repo) setSuspended(ctx context.Context, cardIDs []string, suspended bool) error {
+	return r.updateCards(ctx, cardIDs, func(card *fb.Card) bool {
+		if card.Suspended == suspended {
+			return false
+		}
+		card.Suspended = suspended
+		return true
+	})
+}
+
+// NoteCardIDs returns the sorted IDs of all of the cards generated from the
+// same note as cardID, including cardID itself.
+func (r *Repo) NoteCardIDs(ctx context.Context, cardID string) ([]string, error) {
+	udb, err := r.userDB(ctx)
+	if err != nil {
+		return nil, err
+	}
+	startKey, endKey := relatedKeyRange(cardID)
+	rows, err := udb.AllDocs(ctx, map[string]interface{}{
+		"start_key": startKey,
+		"end_key":   endKey,
+	})
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, 0, 1)
+	for rows.Next() {
+		if id := rows.ID(); inKeyRange(id, startKey, endKey) {
+			ids = append(ids, id)
+		}
+	}
+	if err := rows.Err(); err != nil && err != io.EOF {
+		return nil, err
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
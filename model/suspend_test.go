@@ -0,0 +1,76 @@
+package model
+
+import (
+	"context"
+	"testing"
+
+	"github.com/FlashbackSRS/flashback/fb"
+	"github.com/flimzy/diff"
+)
+
+func TestRepoSuspendCards(t *testing.T) {
+	ctx := context.Background()
+	t.Run("not logged in", func(t *testing.T) {
+		err := (&Repo{}).SuspendCards(ctx, "card-foo.bar.0")
+		checkErr(t, "not logged in", err)
+	})
+	repo := &Repo{user: "mjxwe", local: testClient(t)}
+	if err := repo.local.CreateDB(ctx, "user-mjxwe"); err != nil {
+		t.Fatal(err)
+	}
+	udb, err := repo.userDB(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"card-foo.bar.0", "card-foo.bar.1", "card-foo.baz.0"} {
+		card := &fb.Card{ID: id, ModelID: "theme-foo/0", Created: now(), Modified: now()}
+		if err := saveDoc(ctx, udb, card); err != nil {
+			t.Fatal(err)
+		}
+	}
+	suspended := func(t *testing.T, id string) bool {
+		card := &fb.Card{}
+		if err := getDoc(ctx, udb, id, card); err != nil {
+			t.Fatal(err)
+		}
+		return card.Suspended
+	}
+	t.Run("missing card", func(t *testing.T) {
+		err := repo.SuspendCards(ctx, "card-foo.bar.9")
+		checkErr(t, "card-foo.bar.9: missing", err)
+	})
+	t.Run("note", func(t *testing.T) {
+		ids, err := repo.NoteCardIDs(ctx, "card-foo.bar.1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d := diff.Interface([]string{"card-foo.bar.0", "card-foo.bar.1"}, ids); d != nil {
+			t.Fatal(d)
+		}
+		if err := repo.SuspendCards(ctx, ids...); err != nil {
+			t.Fatal(err)
+		}
+		if !suspended(t, "card-foo.bar.0") || !suspended(t, "card-foo.bar.1") {
+			t.Error("Expected note's cards to be suspended")
+		}
+		if suspended(t, "card-foo.baz.0") {
+			t.Error("Unrelated card should not be suspended")
+		}
+	})
+	t.Run("already suspended", func(t *testing.T) {
+		if err := repo.SuspendCards(ctx, "card-foo.bar.0"); err != nil {
+			t.Fatal(err)
+		}
+	})
+	t.Run("unsuspend", func(t *testing.T) {
+		if err := repo.UnsuspendCards(ctx, "card-foo.bar.0"); err != nil {
+			t.Fatal(err)
+		}
+		if suspended(t, "card-foo.bar.0") {
+			t.Error("Expected card to be unsuspended")
+		}
+		if !suspended(t, "card-foo.bar.1") {
+			t.Error("Expected card to remain suspended")
+		}
+	})
+}
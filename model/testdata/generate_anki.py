@@ -0,0 +1,110 @@
+#!/usr/bin/env python3
+"""Generates collection.anki2, a small Anki collection in the legacy (schema
+11) format, for the Anki import tests."""
+import json
+import os
+import sqlite3
+
+here = os.path.dirname(os.path.abspath(__file__))
+path = os.path.join(here, "collection.anki2")
+if os.path.exists(path):
+    os.remove(path)
+db = sqlite3.connect(path)
+db.executescript("""
+CREATE TABLE col (
+    id integer primary key, crt integer not null, mod integer not null,
+    scm integer not null, ver integer not null, dty integer not null,
+    usn integer not null, ls integer not null, conf text not null,
+    models text not null, decks text not null, dconf text not null,
+    tags text not null);
+CREATE TABLE notes (
+    id integer primary key, guid text not null, mid integer not null,
+    mod integer not null, usn integer not null, tags text not null,
+    flds text not null, sfld integer not null, csum integer not null,
+    flags integer not null, data text not null);
+CREATE TABLE cards (
+    id integer primary key, nid integer not null, did integer not null,
+    ord integer not null, mod integer not null, usn integer not null,
+    type integer not null, queue integer not null, due integer not null,
+    ivl integer not null, factor integer not null, reps integer not null,
+    lapses integer not null, left integer not null, odue integer not null,
+    odid integer not null, flags integer not null, data text not null);
+CREATE TABLE revlog (
+    id integer primary key, cid integer not null, usn integer not null,
+    ease integer not null, ivl integer not null, lastIvl integer not null,
+    factor integer not null, time integer not null, type integer not null);
+CREATE TABLE graves (usn integer not null, oid integer not null, type integer not null);
+""")
+
+crt = 1483228800  # 2017-01-01T00:00:00Z
+basic = 1483228800001
+cloze = 1483228800002
+models = {
+    str(basic): {
+        "id": basic, "name": "Basic", "type": 0, "mod": 1483315200,
+        "css": ".card { font-family: custom; src: url('_font.ttf'); }",
+        "flds": [{"name": "Back", "ord": 1}, {"name": "Front", "ord": 0}],
+        "tmpls": [
+            {"name": "Card 2", "ord": 1, "qfmt": "{{Back}}",
+             "afmt": "{{FrontSide}}<hr id=answer>{{Front}}"},
+            {"name": "Card 1", "ord": 0,
+             "qfmt": "{{Front}}{{#Back}}<i>has back</i>{{/Back}}{{type:Back}}",
+             "afmt": "{{FrontSide}}<hr id=answer>{{Back}}<p>{{Tags}} {{Type}}/{{Card}}{{Deck}}</p>"},
+        ],
+    },
+    str(cloze): {
+        "id": cloze, "name": "Cloze", "type": 1, "mod": 1483315200, "css": "",
+        "flds": [{"name": "Text", "ord": 0}, {"name": "Extra", "ord": 1}],
+        "tmpls": [
+            {"name": "Cloze", "ord": 0, "qfmt": "{{cloze:Text}}",
+             "afmt": "{{cloze:Text}}{{^Extra}}no extra{{/Extra}}"},
+        ],
+    },
+}
+spanish = 1483228800003
+verbs = 1483228800004
+filtered = 1483228800005
+decks = {
+    "1": {"id": 1, "name": "Default", "desc": "", "dyn": 0, "mod": 0},
+    str(spanish): {"id": spanish, "name": "Spanish", "desc": "Spanish words", "dyn": 0, "mod": 1483315200},
+    str(verbs): {"id": verbs, "name": "Spanish::Verbs", "desc": "", "dyn": 0, "mod": 1483315200, "conf": 2},
+    str(filtered): {"id": filtered, "name": "Filtered", "desc": "", "dyn": 1, "mod": 1483315200},
+}
+dconf = {
+    "1": {"id": 1, "name": "Default", "new": {"delays": [1, 10]}, "lapse": {"delays": [10]}},
+    "2": {"id": 2, "name": "Verbs", "new": {"delays": [1, 10, 60]}, "lapse": {"delays": [10, 60]}},
+}
+db.execute("INSERT INTO col VALUES (1, ?, ?, 0, 11, 0, 0, 0, '{}', ?, ?, ?, '{}')",
+           (crt, 1483401600000, json.dumps(models), json.dumps(decks), json.dumps(dconf)))
+
+notes = [
+    (1483228800100, basic, " spanish verb ",
+     ['hablar <img src="cat.jpg">', 'to speak [sound:meow.mp3][sound:missing.mp3]']),
+    (1483228800200, cloze, "",
+     ['{{c1::Madrid}} is the capital of {{c2::Spain}} <img src="cat.jpg">', '']),
+]
+for nid, mid, tags, flds in notes:
+    db.execute("INSERT INTO notes VALUES (?, ?, ?, ?, 0, ?, ?, ?, 0, 0, '')",
+               (nid, "guid%d" % nid, mid, 1483315200, tags, "\x1f".join(flds), flds[0]))
+
+# id, nid, did, ord, type, queue, due, ivl, factor, reps, lapses, left, odue, odid
+cards = [
+    # new
+    (1483228800101, 1483228800100, spanish, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0),
+    # review, in a filtered deck
+    (1483228800102, 1483228800100, filtered, 1, 2, 2, -100000, 3, 2300, 5, 1, 0, 10, verbs),
+    # learning, due at a time, with 2 of 3 steps left
+    (1483228800201, 1483228800200, verbs, 0, 1, 1, 1483318800, 0, 2500, 1, 0, 1002, 0, 0),
+    # relearning, suspended, with 2 of 2 steps left
+    (1483228800202, 1483228800200, verbs, 1, 3, -1, 1483318800, 2, 2100, 9, 2, 2, 0, 0),
+]
+for c in cards:
+    (cid, nid, did, ord_, type_, queue, due, ivl, factor, reps, lapses, left, odue, odid) = c
+    db.execute("INSERT INTO cards VALUES (?, ?, ?, ?, ?, 0, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, 0, '')",
+               (cid, nid, did, ord_, 1483315200, type_, queue, due, ivl, factor,
+                reps, lapses, left, odue, odid))
+for rid, cid in [(1483300000000, 1483228800102), (1483310000000, 1483228800102)]:
+    db.execute("INSERT INTO revlog VALUES (?, ?, 0, 3, 3, 1, 2300, 5000, 1)", (rid, cid))
+db.commit()
+db.execute("VACUUM")
+db.close()
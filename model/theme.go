@@ -9,7 +9,7 @@ import (
 	"github.com/flimzy/log"
 	"github.com/pkg/errors"
 
-	fb "github.com/FlashbackSRS/flashback-model"
+	"github.com/FlashbackSRS/flashback/fb"
 )
 
 // fbModel is a wrapper around a *fb.Model
@@ -3,7 +3,7 @@ package model
 import (
 	"encoding/json"
 
-	fb "github.com/FlashbackSRS/flashback-model"
+	"github.com/FlashbackSRS/flashback/fb"
 )
 
 var realTheme = func() (theme *fb.Theme) {
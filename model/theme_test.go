@@ -10,7 +10,7 @@ import (
 	"strings"
 	"testing"
 
-	fb "github.com/FlashbackSRS/flashback-model"
+	"github.com/FlashbackSRS/flashback/fb"
 	"github.com/flimzy/diff"
 	"github.com/flimzy/kivik"
 )
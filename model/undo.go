@@ -0,0 +1,133 @@
+package model
+
+import (
+	"context"
+	"sync"
+
+	"github.com/pkg/errors"
+
+	"github.com/FlashbackSRS/flashback"
+	"github.com/FlashbackSRS/flashback/fb"
+)
+
+// MaxUndo is the number of answers which may be undone.
+const MaxUndo = 10
+
+// undoEntry records the changes made by answering a card.
+type undoEntry struct {
+	// card is the state of the card before it was answered.
+	card *fb.Card
+	// review is the stored review log entry.
+	review *fb.Review
+	// day is the study day, and scopes the scopes, in which the review was
+	// counted.
+	day    fb.Due
+	scopes []string
+	// siblings hold the state of the card's siblings before they were buried.
+	siblings []*fb.Card
+}
+
+// undoStack holds the most recent answers, so they can be undone. The zero
+// value is ready to use.
+type undoStack struct {
+	mu      sync.Mutex
+	entries []*undoEntry
+	// burials holds the state of sibling cards before they were buried when a
+	// card was fetched for study, keyed by card ID, until the card is
+	// answered.
+	burials map[string][]*fb.Card
+}
+
+// setBurials records the state of cardID's siblings prior to burial.
+func (s *undoStack) setBurials(cardID string, siblings []*fb.Card) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.burials == nil {
+		s.burials = make(map[string][]*fb.Card)
+	}
+	s.burials[cardID] = siblings
+}
+
+// push adds an entry to the stack, along with any burials recorded for the
+// card, discarding the oldest entry if the stack is full.
+func (s *undoStack) push(e *undoEntry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e.siblings = s.burials[e.card.ID]
+	delete(s.burials, e.card.ID)
+	s.entries = append(s.entries, e)
+	if len(s.entries) > MaxUndo {
+		s.entries = s.entries[len(s.entries)-MaxUndo:]
+	}
+}
+
+// pop removes and returns the most recent entry, or nil if the stack is empty.
+func (s *undoStack) pop() *undoEntry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.entries) == 0 {
+		return nil
+	}
+	e := s.entries[len(s.entries)-1]
+	s.entries = s.entries[:len(s.entries)-1]
+	return e
+}
+
+func (s *undoStack) len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.entries)
+}
+
+// CanUndo returns true if there is an answer which may be undone.
+func (r *Repo) CanUndo() bool {
+	return r.undo.len() > 0
+}
+
+// Undo reverts the most recent answer: The card is restored to its prior
+// state, its review log entry is removed, and its siblings are unburied. The
+// card is returned, so that it may be studied again.
+func (r *Repo) Undo(ctx context.Context) (flashback.CardView, error) {
+	card, err := r.undoAnswer(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return r.loadCard(ctx, card)
+}
+
+func (r *Repo) undoAnswer(ctx context.Context) (*fb.Card, error) {
+	udb, err := r.userDB(ctx)
+	if err != nil {
+		return nil, err
+	}
+	e := r.undo.pop()
+	if e == nil {
+		return nil, errors.New("nothing to undo")
+	}
+	if _, err := udb.Delete(ctx, e.review.ID, e.review.Rev); err != nil {
+		return nil, errors.Wrap(err, "delete review")
+	}
+	if err := countAnswer(ctx, udb, e.day, e.review, e.scopes, -1); err != nil {
+		return nil, err
+	}
+	for _, sibling := range e.siblings {
+		if err := restoreCard(ctx, udb, sibling); err != nil {
+			return nil, errors.Wrap(err, "restore sibling")
+		}
+	}
+	if err := restoreCard(ctx, udb, e.card); err != nil {
+		return nil, errors.Wrap(err, "restore card")
+	}
+	return e.card, nil
+}
+
+// restoreCard overwrites the stored card with card.
+func restoreCard(ctx context.Context, db getPutter, card *fb.Card) error {
+	current := &fb.Card{}
+	if err := getDoc(ctx, db, card.ID, current); err != nil {
+		return err
+	}
+	card.Rev = current.Rev
+	card.Modified = now().UTC()
+	return saveDoc(ctx, db, card)
+}
@@ -0,0 +1,140 @@
+package model
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/FlashbackSRS/flashback"
+	"github.com/FlashbackSRS/flashback/fb"
+	"github.com/flimzy/diff"
+	"github.com/flimzy/kivik"
+)
+
+func TestUndoStackPush(t *testing.T) {
+	s := &undoStack{}
+	sibling := &fb.Card{ID: "card-foo.bar.1"}
+	s.setBurials("card-foo.bar.0", []*fb.Card{sibling})
+	for i := 0; i < MaxUndo+2; i++ {
+		s.push(&undoEntry{card: &fb.Card{ID: "card-foo.bar.0"}, review: &fb.Review{ID: fmt.Sprintf("review-%d", i)}})
+	}
+	if l := s.len(); l != MaxUndo {
+		t.Errorf("Unexpected stack size: %d", l)
+	}
+	if s.entries[0].review.ID != "review-2" {
+		t.Errorf("Oldest entries should be discarded, got %s", s.entries[0].review.ID)
+	}
+	if len(s.entries[0].siblings) != 0 {
+		t.Errorf("Burials should only be attached to the next answer")
+	}
+	if len(s.burials) != 0 {
+		t.Errorf("Burials should be cleared once attached")
+	}
+	if e := s.pop(); e.review.ID != fmt.Sprintf("review-%d", MaxUndo+1) {
+		t.Errorf("Unexpected entry popped: %s", e.review.ID)
+	}
+}
+
+func TestRepoUndo(t *testing.T) {
+	ctx := context.Background()
+	t.Run("not logged in", func(t *testing.T) {
+		_, err := (&Repo{}).Undo(ctx)
+		checkErr(t, "not logged in", err)
+	})
+	repo := &Repo{user: "mjxwe", local: testClient(t)}
+	if err := repo.local.CreateDB(ctx, "user-mjxwe"); err != nil {
+		t.Fatal(err)
+	}
+	udb, err := repo.userDB(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Run("nothing to undo", func(t *testing.T) {
+		_, err := repo.undoAnswer(ctx)
+		checkErr(t, "nothing to undo", err)
+	})
+	for _, id := range []string{"card-foo.bar.0", "card-foo.bar.1"} {
+		card := &fb.Card{
+			ID:          id,
+			ModelID:     "theme-foo/0",
+			Created:     now(),
+			Modified:    now(),
+			Interval:    3 * fb.Day,
+			ReviewCount: 2,
+			EaseFactor:  2.5,
+			Due:         fb.On(now()),
+		}
+		if err := saveDoc(ctx, udb, card); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stored := &fb.Card{}
+	if err := getDoc(ctx, udb, "card-foo.bar.0", stored); err != nil {
+		t.Fatal(err)
+	}
+	card := &Card{
+		Card:  stored,
+		model: &fbModel{Model: &fb.Model{Type: "answer"}},
+		repo:  repo,
+	}
+	// Bury the sibling, as BuryRelatedCards would
+	sibling := &fb.Card{}
+	if err := getDoc(ctx, udb, "card-foo.bar.1", sibling); err != nil {
+		t.Fatal(err)
+	}
+	prev := *sibling
+	toBury := setBurials(studyClock{}, BuryProportional, card.Interval, []*fb.Card{sibling})
+	if err := updateDocs(ctx, udb, toBury); err != nil {
+		t.Fatal(err)
+	}
+	repo.undo.setBurials(card.ID, []*fb.Card{&prev})
+	face := Answer
+	if _, err := card.Action(ctx, &face, now(), flashback.AnswerCorrect); err != nil {
+		t.Fatal(err)
+	}
+	if !repo.CanUndo() {
+		t.Fatal("Expected to be able to undo")
+	}
+	result, err := repo.undoAnswer(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.ID != "card-foo.bar.0" {
+		t.Errorf("Unexpected card returned: %s", result.ID)
+	}
+	if repo.CanUndo() {
+		t.Error("Expected undo stack to be empty")
+	}
+	for _, id := range []string{"card-foo.bar.0", "card-foo.bar.1"} {
+		restored := &fb.Card{}
+		if err := getDoc(ctx, udb, id, restored); err != nil {
+			t.Fatal(err)
+		}
+		expected := &fb.Card{
+			ID:          id,
+			ModelID:     "theme-foo/0",
+			Created:     now(),
+			Interval:    3 * fb.Day,
+			ReviewCount: 2,
+			EaseFactor:  2.5,
+			Due:         fb.On(now()),
+		}
+		restored.Rev = ""
+		restored.Modified = time.Time{}
+		if d := diff.Interface(expected, restored); d != nil {
+			t.Errorf("%s: %s", id, d)
+		}
+	}
+	reviewID := fmt.Sprintf("review-foo.bar.0.%d", now().UnixNano())
+	if _, err := udb.Get(ctx, reviewID); kivik.StatusCode(err) != kivik.StatusNotFound {
+		t.Errorf("Expected review to be deleted, got %v", err)
+	}
+	counts, err := fetchDailyCounts(ctx, udb, fb.On(now()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := diff.Interface(map[string]int{"": 0, "bundle-foo": 0}, counts.Reviews); d != nil {
+		t.Error(d)
+	}
+}
@@ -7,7 +7,7 @@ import (
 	"reflect"
 	"time"
 
-	fb "github.com/FlashbackSRS/flashback-model"
+	"github.com/FlashbackSRS/flashback/fb"
 	"github.com/flimzy/kivik"
 	"github.com/flimzy/kivik/errors"
 )
@@ -124,3 +124,10 @@ func updateDocs(ctx context.Context, db bulkDocer, docs interface{}) error {
 	}
 	return nil
 }
+
+// inKeyRange reports whether id lies within [startKey, endKey]. Not every
+// backend honours the start_key and end_key options of AllDocs, so rows are
+// checked against the range as well.
+func inKeyRange(id, startKey, endKey string) bool {
+	return id >= startKey && id <= endKey
+}
@@ -9,7 +9,7 @@ import (
 	"testing"
 	"time"
 
-	fb "github.com/FlashbackSRS/flashback-model"
+	"github.com/FlashbackSRS/flashback/fb"
 	"github.com/flimzy/diff"
 	"github.com/flimzy/kivik"
 	"github.com/flimzy/kivik/errors"
@@ -417,3 +417,19 @@ func TestUpdateDocs(t *testing.T) {
 		})
 	}
 }
+
+func TestInKeyRange(t *testing.T) {
+	startKey, endKey := relatedKeyRange("card-foo.bar.1")
+	tests := map[string]bool{
+		"card-foo.bar.0":  true,
+		"card-foo.bar.12": true,
+		"card-foo.ba":     false,
+		"card-foo.baz.0":  false,
+		"card-foo.bar":    false,
+	}
+	for id, expected := range tests {
+		if result := inKeyRange(id, startKey, endKey); result != expected {
+			t.Errorf("%s: expected %t, got %t", id, expected, result)
+		}
+	}
+}
@@ -39,8 +39,8 @@ func wrapClient(c *kivik.Client) kivikClient {
 	return &clientWrapper{Client: c}
 }
 
-type queryGetter interface {
-	querier
+type findGetter interface {
+	finder
 	getter
 }
 
@@ -91,6 +91,10 @@ type finder interface {
 	Find(ctx context.Context, query interface{}) (kivikRows, error)
 }
 
+type indexer interface {
+	CreateIndex(ctx context.Context, ddoc, name string, index interface{}) error
+}
+
 type deleter interface {
 	Delete(ctx context.Context, docID, rev string) (newRev string, err error)
 }
@@ -110,6 +114,7 @@ type kivikDB interface {
 	querier
 	bulkDocer
 	finder
+	indexer
 	deleter
 	statser
 	clientNamer
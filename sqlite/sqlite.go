@@ -0,0 +1,545 @@
+// Package sqlite reads and writes tables in SQLite 3 database files, such as
+// the collection database of an Anki package. It is a pure Go implementation
+// of the file format, so that it works in the browser, and supports only what
+// is needed to read or write a complete, UTF-8 encoded database: tables may be
+// scanned or written in full, but indexes, views and the write-ahead log are
+// ignored.
+package sqlite
+
+import (
+	"encoding/binary"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	headerMagic = "SQLite format 3\x00"
+	headerSize  = 100
+
+	// maxDepth limits the depth of the B-trees we'll descend, to protect
+	// against loops in corrupt files.
+	maxDepth = 32
+
+	// minUsable is the minimum number of usable bytes per page allowed by
+	// the file format.
+	minUsable = 480
+)
+
+// B-tree page types
+const (
+	pageInteriorIndex = 0x02
+	pageInteriorTable = 0x05
+	pageLeafIndex     = 0x0a
+	pageLeafTable     = 0x0d
+)
+
+// DB is a SQLite database, read into memory.
+type DB struct {
+	data     []byte
+	pageSize int
+	usable   int
+	tables   map[string]*table
+}
+
+type table struct {
+	name     string
+	rootPage int
+	columns  []column
+	// rowidColumn is the index of the INTEGER PRIMARY KEY column, which is an
+	// alias for the rowid, or -1 if there is none.
+	rowidColumn int
+}
+
+// column is a table column, as declared.
+type column struct {
+	name string
+	// real is true if the column has REAL affinity, in which case integers
+	// are stored for values which have no fractional part.
+	real bool
+}
+
+// Row is a single table row, mapping column names to values. Values are of
+// type int64, float64, string, []byte, or nil for NULL.
+type Row map[string]interface{}
+
+// Open parses the database in data.
+func Open(data []byte) (*DB, error) {
+	if len(data) < headerSize || string(data[:len(headerMagic)]) != headerMagic {
+		return nil, errors.New("not a SQLite 3 database")
+	}
+	pageSize := int(binary.BigEndian.Uint16(data[16:18]))
+	if pageSize == 1 {
+		pageSize = 65536
+	}
+	if pageSize < 512 || pageSize&(pageSize-1) != 0 {
+		return nil, errors.Errorf("invalid page size %d", pageSize)
+	}
+	if reserved := int(data[20]); pageSize-reserved < minUsable {
+		return nil, errors.Errorf("invalid reserved space %d", reserved)
+	}
+	if encoding := binary.BigEndian.Uint32(data[56:60]); encoding > 1 {
+		return nil, errors.Errorf("unsupported text encoding %d", encoding)
+	}
+	db := &DB{
+		data:     data,
+		pageSize: pageSize,
+		usable:   pageSize - int(data[20]),
+		tables:   make(map[string]*table),
+	}
+	if err := db.readSchema(); err != nil {
+		return nil, errors.Wrap(err, "read schema")
+	}
+	return db, nil
+}
+
+// Tables returns the names of the tables in the database, in sorted order.
+func (db *DB) Tables() []string {
+	names := make([]string, 0, len(db.tables))
+	for name := range db.tables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Rows returns all rows of the named table, in rowid order.
+func (db *DB) Rows(name string) ([]Row, error) {
+	t, ok := db.tables[strings.ToLower(name)]
+	if !ok {
+		return nil, errors.Errorf("no such table: %s", name)
+	}
+	rows := make([]Row, 0)
+	err := db.scan(t.rootPage, 0, func(rowid int64, values []interface{}) error {
+		row := make(Row, len(t.columns))
+		for i, col := range t.columns {
+			var value interface{}
+			if i < len(values) {
+				value = values[i]
+			}
+			if i == t.rowidColumn {
+				value = rowid
+			}
+			if v, ok := value.(int64); ok && col.real {
+				value = float64(v)
+			}
+			row[col.name] = value
+		}
+		rows = append(rows, row)
+		return nil
+	})
+	return rows, errors.Wrapf(err, "read table %s", name)
+}
+
+// readSchema reads the table definitions from the sqlite_master table, which
+// is rooted at page 1.
+func (db *DB) readSchema() error {
+	return db.scan(1, 0, func(_ int64, values []interface{}) error {
+		if len(values) < 5 {
+			return errors.New("invalid schema record")
+		}
+		if kind, _ := values[0].(string); kind != "table" {
+			return nil
+		}
+		name, _ := values[1].(string)
+		rootPage, _ := values[3].(int64)
+		sql, _ := values[4].(string)
+		columns, rowidColumn := parseColumns(sql)
+		db.tables[strings.ToLower(name)] = &table{
+			name:        name,
+			rootPage:    int(rootPage),
+			columns:     columns,
+			rowidColumn: rowidColumn,
+		}
+		return nil
+	})
+}
+
+// page returns the content of page n, which is 1-based.
+func (db *DB) page(n int) ([]byte, error) {
+	start := (n - 1) * db.pageSize
+	if n < 1 || start+db.pageSize > len(db.data) {
+		return nil, errors.Errorf("page %d out of range", n)
+	}
+	return db.data[start : start+db.pageSize], nil
+}
+
+// scan calls fn for each record in the table B-tree rooted at page n.
+func (db *DB) scan(n, depth int, fn func(rowid int64, values []interface{}) error) error {
+	if depth > maxDepth {
+		return errors.New("B-tree too deep")
+	}
+	page, err := db.page(n)
+	if err != nil {
+		return err
+	}
+	offset := 0
+	if n == 1 {
+		offset = headerSize
+	}
+	if len(page) < offset+12 {
+		return errors.Errorf("page %d truncated", n)
+	}
+	kind := page[offset]
+	cells := int(binary.BigEndian.Uint16(page[offset+3:]))
+	headerLen := 8
+	if kind == pageInteriorTable {
+		headerLen = 12
+	}
+	pointers := offset + headerLen
+	if pointers+2*cells > len(page) {
+		return errors.Errorf("page %d: invalid cell count", n)
+	}
+	for i := 0; i < cells; i++ {
+		cell := int(binary.BigEndian.Uint16(page[pointers+2*i:]))
+		if cell >= len(page) {
+			return errors.Errorf("page %d: invalid cell pointer", n)
+		}
+		switch kind {
+		case pageInteriorTable:
+			if cell+4 > len(page) {
+				return errors.Errorf("page %d: cell truncated", n)
+			}
+			if err := db.scan(int(binary.BigEndian.Uint32(page[cell:])), depth+1, fn); err != nil {
+				return err
+			}
+		case pageLeafTable:
+			rowid, payload, err := db.leafCell(page, cell)
+			if err != nil {
+				return errors.Wrapf(err, "page %d", n)
+			}
+			values, err := decodeRecord(payload)
+			if err != nil {
+				return errors.Wrapf(err, "page %d: row %d", n, rowid)
+			}
+			if err := fn(rowid, values); err != nil {
+				return err
+			}
+		default:
+			return errors.Errorf("page %d: unexpected page type %d", n, kind)
+		}
+	}
+	if kind == pageInteriorTable {
+		return db.scan(int(binary.BigEndian.Uint32(page[offset+8:])), depth+1, fn)
+	}
+	return nil
+}
+
+// leafCell returns the rowid and payload of the table leaf cell at offset,
+// following any overflow pages.
+func (db *DB) leafCell(page []byte, offset int) (int64, []byte, error) {
+	size, n := varint(page[offset:])
+	if n == 0 {
+		return 0, nil, errors.New("invalid payload size")
+	}
+	offset += n
+	rowid, n := varint(page[offset:])
+	if n == 0 {
+		return 0, nil, errors.New("invalid rowid")
+	}
+	offset += n
+	if size > uint64(len(db.data)) {
+		return 0, nil, errors.New("invalid payload size")
+	}
+	total := int(size)
+	local := localPayload(db.usable, total)
+	if offset+local > len(page) {
+		return 0, nil, errors.New("cell truncated")
+	}
+	payload := make([]byte, 0, total)
+	payload = append(payload, page[offset:offset+local]...)
+	if local == total {
+		return int64(rowid), payload, nil
+	}
+	if offset+local+4 > len(page) {
+		return 0, nil, errors.New("cell truncated")
+	}
+	next := int(binary.BigEndian.Uint32(page[offset+local:]))
+	for pages := 0; len(payload) < total; pages++ {
+		if next == 0 || pages > len(db.data)/db.pageSize {
+			return 0, nil, errors.New("overflow chain truncated")
+		}
+		overflow, err := db.page(next)
+		if err != nil {
+			return 0, nil, err
+		}
+		chunk := overflow[4:db.usable]
+		if remaining := total - len(payload); len(chunk) > remaining {
+			chunk = chunk[:remaining]
+		}
+		payload = append(payload, chunk...)
+		next = int(binary.BigEndian.Uint32(overflow))
+	}
+	return int64(rowid), payload, nil
+}
+
+// localPayload returns the number of bytes of a table leaf payload of the
+// given size which are stored on the page itself, as defined by the file
+// format, for pages with usable bytes.
+func localPayload(usable, size int) int {
+	return payloadOnPage(usable, size, usable-35)
+}
+
+// payloadOnPage returns the number of bytes of a payload which are stored on
+// the page, given the maximum for the type of page.
+func payloadOnPage(usable, size, maxLocal int) int {
+	if size <= maxLocal {
+		return size
+	}
+	minLocal := (usable-12)*32/255 - 23
+	local := minLocal + (size-minLocal)%(usable-4)
+	if local > maxLocal {
+		return minLocal
+	}
+	return local
+}
+
+// decodeRecord decodes a record in the SQLite record format.
+func decodeRecord(payload []byte) ([]interface{}, error) {
+	headerLen, n := varint(payload)
+	if n == 0 || headerLen < uint64(n) || headerLen > uint64(len(payload)) {
+		return nil, errors.New("invalid record header")
+	}
+	header := payload[n:headerLen]
+	body := payload[headerLen:]
+	values := make([]interface{}, 0)
+	for len(header) > 0 {
+		serialType, n := varint(header)
+		if n == 0 {
+			return nil, errors.New("invalid serial type")
+		}
+		header = header[n:]
+		size, err := serialSize(serialType)
+		if err != nil {
+			return nil, err
+		}
+		if size > len(body) {
+			return nil, errors.New("record truncated")
+		}
+		values = append(values, decodeValue(serialType, body[:size]))
+		body = body[size:]
+	}
+	return values, nil
+}
+
+var intSizes = map[uint64]int{1: 1, 2: 2, 3: 3, 4: 4, 5: 6, 6: 8}
+
+// serialSize returns the size of a value of the given serial type.
+func serialSize(serialType uint64) (int, error) {
+	switch {
+	case serialType == 10 || serialType == 11:
+		return 0, errors.Errorf("reserved serial type %d", serialType)
+	case serialType == 7:
+		return 8, nil
+	case serialType >= 12:
+		size := (serialType - 12) / 2
+		if size > math.MaxInt32 {
+			return 0, errors.Errorf("invalid serial type %d", serialType)
+		}
+		return int(size), nil
+	}
+	return intSizes[serialType], nil
+}
+
+func decodeValue(serialType uint64, data []byte) interface{} {
+	switch {
+	case serialType == 0:
+		return nil
+	case serialType <= 6:
+		var v int64
+		for _, b := range data {
+			v = v<<8 | int64(b)
+		}
+		// Sign-extend
+		shift := uint(64 - 8*len(data))
+		return v << shift >> shift
+	case serialType == 7:
+		return math.Float64frombits(binary.BigEndian.Uint64(data))
+	case serialType == 8:
+		return int64(0)
+	case serialType == 9:
+		return int64(1)
+	case serialType >= 12 && serialType%2 == 0:
+		return append([]byte{}, data...)
+	case serialType >= 13:
+		return string(data)
+	}
+	return nil
+}
+
+// varint decodes a SQLite variable-length integer, returning the value and
+// the number of bytes read, or 0 if buf is too short.
+func varint(buf []byte) (uint64, int) {
+	var v uint64
+	for i := 0; i < 9; i++ {
+		if i >= len(buf) {
+			return 0, 0
+		}
+		if i == 8 {
+			return v<<8 | uint64(buf[i]), 9
+		}
+		v = v<<7 | uint64(buf[i]&0x7f)
+		if buf[i] < 0x80 {
+			return v, i + 1
+		}
+	}
+	return 0, 0
+}
+
+// parseColumns extracts the columns from a CREATE TABLE statement, along with
+// the index of the INTEGER PRIMARY KEY column, or -1.
+func parseColumns(sql string) ([]column, int) {
+	start := strings.Index(sql, "(")
+	end := strings.LastIndex(sql, ")")
+	if start < 0 || end < start {
+		return nil, -1
+	}
+	columns := make([]column, 0)
+	rowidColumn := -1
+	for _, def := range splitDefinitions(sql[start+1 : end]) {
+		name, rest := columnName(strings.TrimSpace(def))
+		if name == "" {
+			continue
+		}
+		switch strings.ToUpper(name) {
+		case "CONSTRAINT", "PRIMARY", "UNIQUE", "CHECK", "FOREIGN":
+			continue
+		}
+		fields := strings.Fields(strings.ToLower(rest))
+		var declType string
+		if len(fields) > 0 {
+			declType = fields[0]
+		}
+		if declType == "integer" && strings.Contains(strings.Join(fields, " "), "primary key") {
+			rowidColumn = len(columns)
+		}
+		columns = append(columns, column{name: name, real: realAffinity(declType)})
+	}
+	return columns, rowidColumn
+}
+
+// realAffinity returns true if the declared column type has REAL affinity.
+func realAffinity(declType string) bool {
+	if strings.Contains(declType, "int") {
+		return false
+	}
+	for _, s := range []string{"real", "floa", "doub"} {
+		if strings.Contains(declType, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// columnName splits a column definition into the unquoted column name and
+// the remainder of the definition.
+func columnName(def string) (name, rest string) {
+	if def == "" {
+		return "", ""
+	}
+	var end byte
+	switch def[0] {
+	case '"', '`':
+		end = def[0]
+	case '[':
+		end = ']'
+	default:
+		i := strings.IndexAny(def, " \t\r\n(")
+		if i < 0 {
+			return def, ""
+		}
+		return def[:i], def[i:]
+	}
+	for i := 1; i < len(def); i++ {
+		if def[i] != end {
+			continue
+		}
+		if end != ']' && i+1 < len(def) && def[i+1] == end {
+			i++
+			continue
+		}
+		name = def[1:i]
+		if end != ']' {
+			name = strings.Replace(name, string(end)+string(end), string(end), -1)
+		}
+		return name, def[i+1:]
+	}
+	return def, ""
+}
+
+// splitDefinitions splits the column definitions of a CREATE TABLE statement
+// on commas which are not nested within parentheses or quotes.
+func splitDefinitions(s string) []string {
+	defs := make([]string, 0)
+	var depth int
+	var quote byte
+	last := 0
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'' || c == '`':
+			quote = c
+		case c == '[':
+			quote = ']'
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		case c == ',' && depth == 0:
+			defs = append(defs, s[last:i])
+			last = i + 1
+		}
+	}
+	return append(defs, s[last:])
+}
+
+// Int returns the named column as an integer. Text values are parsed, and
+// other values yield 0.
+func (r Row) Int(column string) int64 {
+	switch v := r[column].(type) {
+	case int64:
+		return v
+	case float64:
+		return int64(v)
+	case string:
+		i, _ := strconv.ParseInt(strings.TrimSpace(v), 10, 64)
+		return i
+	}
+	return 0
+}
+
+// Float returns the named column as a floating point number.
+func (r Row) Float(column string) float64 {
+	switch v := r[column].(type) {
+	case int64:
+		return float64(v)
+	case float64:
+		return v
+	case string:
+		f, _ := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		return f
+	}
+	return 0
+}
+
+// Text returns the named column as a string. NULL yields "".
+func (r Row) Text(column string) string {
+	switch v := r[column].(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+	return ""
+}
@@ -0,0 +1,377 @@
+package sqlite
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+
+	"github.com/flimzy/diff"
+)
+
+// testdata/test.db is created by testdata/generate.py
+
+func testDB(t *testing.T) *DB {
+	data, err := ioutil.ReadFile("testdata/test.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := Open(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestOpen(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		err  string
+	}{
+		{
+			name: "empty",
+			err:  "not a SQLite 3 database",
+		},
+		{
+			name: "not sqlite",
+			data: bytes.Repeat([]byte("x"), 200),
+			err:  "not a SQLite 3 database",
+		},
+		{
+			name: "invalid page size",
+			data: append([]byte(headerMagic+"\x03\x00"), make([]byte, 200)...),
+			err:  "invalid page size 768",
+		},
+		{
+			name: "invalid reserved space",
+			data: func() []byte {
+				data := append([]byte(headerMagic+"\x02\x00"), make([]byte, 200)...)
+				data[20] = 64
+				return data
+			}(),
+			err: "invalid reserved space 64",
+		},
+		{
+			name: "truncated",
+			data: append([]byte(headerMagic+"\x02\x00"), make([]byte, 200)...),
+			err:  "read schema: page 1 out of range",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := Open(test.data)
+			var msg string
+			if err != nil {
+				msg = err.Error()
+			}
+			if msg != test.err {
+				t.Errorf("Unexpected error: %s", msg)
+			}
+		})
+	}
+}
+
+func TestTables(t *testing.T) {
+	expected := []string{"empty", "items"}
+	if d := diff.Interface(expected, testDB(t).Tables()); d != nil {
+		t.Error(d)
+	}
+}
+
+func TestRows(t *testing.T) {
+	db := testDB(t)
+	if _, err := db.Rows("missing"); err == nil || err.Error() != "no such table: missing" {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	empty, err := db.Rows("empty")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(empty) != 0 {
+		t.Errorf("Expected no rows, got %d", len(empty))
+	}
+
+	rows, err := db.Rows("ITEMS")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 301 {
+		t.Fatalf("Expected 301 rows, got %d", len(rows))
+	}
+	for i, row := range rows[:300] {
+		if id := row.Int("id"); id != int64(i+1)*10 {
+			t.Fatalf("Row %d: unexpected id %d", i, id)
+		}
+	}
+	expected := []Row{
+		{"id": int64(10), "name": "item 1", "n": int64(1), "f": 0.25, "b": nil, "quoted, name": "q1"},
+		{"id": int64(20), "name": "item 2", "n": int64(-1), "f": 0.5, "b": []byte{2, 2, 2}, "quoted, name": "q2"},
+		{"id": int64(30), "name": "item 3", "n": int64(127), "f": 0.75, "b": nil, "quoted, name": "q3"},
+		{"id": int64(40), "name": "item 4", "n": int64(-128), "f": float64(1), "b": []byte{4, 4, 4}, "quoted, name": "q4"},
+	}
+	if d := diff.Interface(expected, rows[:4]); d != nil {
+		t.Error(d)
+	}
+	ints := make([]int64, 0, 15)
+	for _, row := range rows[14:29] {
+		ints = append(ints, row.Int("n"))
+	}
+	expectedInts := []int64{0, 1, -1, 127, -128, 32767, -32768, 8388607, -8388608, 2147483647,
+		-2147483648, 140737488355327, -140737488355328, 9223372036854775807, -9223372036854775808}
+	if d := diff.Interface(expectedInts, ints); d != nil {
+		t.Error(d)
+	}
+
+	last := rows[300]
+	if id := last.Int("id"); id != 5000 {
+		t.Errorf("Unexpected id %d", id)
+	}
+	if name := last.Text("name"); name != string(bytes.Repeat([]byte("x"), 3000)) {
+		t.Errorf("Unexpected overflow text of length %d", len(name))
+	}
+	blob := make([]byte, 0, 256*20)
+	for i := 0; i < 20; i++ {
+		for j := 0; j < 256; j++ {
+			blob = append(blob, byte(j))
+		}
+	}
+	if !bytes.Equal(blob, last["b"].([]byte)) {
+		t.Errorf("Unexpected overflow blob")
+	}
+	if last["n"] != nil {
+		t.Errorf("Expected NULL, got %v", last["n"])
+	}
+}
+
+func TestRowConversions(t *testing.T) {
+	row := Row{"int": int64(3), "float": 2.5, "text": "42", "blob": []byte("abc"), "null": nil}
+	tests := []struct {
+		column string
+		i      int64
+		f      float64
+		text   string
+	}{
+		{column: "int", i: 3, f: 3, text: "3"},
+		{column: "float", i: 2, f: 2.5, text: "2.5"},
+		{column: "text", i: 42, f: 42, text: "42"},
+		{column: "blob", text: "abc"},
+		{column: "null"},
+		{column: "missing"},
+	}
+	for _, test := range tests {
+		t.Run(test.column, func(t *testing.T) {
+			if i := row.Int(test.column); i != test.i {
+				t.Errorf("Unexpected int %d", i)
+			}
+			if f := row.Float(test.column); f != test.f {
+				t.Errorf("Unexpected float %f", f)
+			}
+			if text := row.Text(test.column); text != test.text {
+				t.Errorf("Unexpected text %q", text)
+			}
+		})
+	}
+}
+
+func TestParseColumns(t *testing.T) {
+	tests := []struct {
+		sql     string
+		columns []column
+		rowid   int
+	}{
+		{
+			sql:     "CREATE TABLE col (id integer primary key, crt integer not null, models text not null)",
+			columns: []column{{name: "id"}, {name: "crt"}, {name: "models"}},
+			rowid:   0,
+		},
+		{
+			sql:     "CREATE TABLE t (a text, `b c` int, [d] , \"e\"\"f\" real, PRIMARY KEY (a), CHECK (length(a, b) > 1))",
+			columns: []column{{name: "a"}, {name: "b c"}, {name: "d"}, {name: "e\"f", real: true}},
+			rowid:   -1,
+		},
+		{
+			sql:     "CREATE TABLE t (a, b INTEGER NOT NULL PRIMARY KEY)",
+			columns: []column{{name: "a"}, {name: "b"}},
+			rowid:   1,
+		},
+		{
+			sql:   "CREATE TABLE t",
+			rowid: -1,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.sql, func(t *testing.T) {
+			columns, rowid := parseColumns(test.sql)
+			if d := diff.Interface(test.columns, columns); d != nil {
+				t.Error(d)
+			}
+			if rowid != test.rowid {
+				t.Errorf("Unexpected rowid column %d", rowid)
+			}
+		})
+	}
+}
+
+func TestLeafCell(t *testing.T) {
+	const pageSize = 512
+	cell := func(offset int, content ...[]byte) []byte {
+		page := make([]byte, pageSize)
+		copy(page[offset:], bytes.Join(content, nil))
+		return page
+	}
+	varints := func(values ...uint64) []byte {
+		var buf []byte
+		for _, v := range values {
+			buf = appendVarint(buf, v)
+		}
+		return buf
+	}
+	// overflowAt is the offset of a cell with a 1000 byte payload, whose
+	// overflow page pointer extends past the end of the page.
+	overflowAt := pageSize - 3 - localPayload(pageSize, 1000) - 2
+	tests := []struct {
+		name    string
+		page    []byte
+		offset  int
+		rowid   int64
+		payload []byte
+		err     string
+	}{
+		{
+			name:    "local payload",
+			page:    cell(100, varints(3, 7), []byte("abc")),
+			offset:  100,
+			rowid:   7,
+			payload: []byte("abc"),
+		},
+		{
+			name:   "truncated payload size",
+			page:   cell(pageSize-1, []byte{0xff}),
+			offset: pageSize - 1,
+			err:    "invalid payload size",
+		},
+		{
+			name:   "truncated rowid",
+			page:   cell(pageSize-2, []byte{0x03, 0xff}),
+			offset: pageSize - 2,
+			err:    "invalid rowid",
+		},
+		{
+			name:   "payload larger than file",
+			page:   cell(100, varints(1<<40, 1)),
+			offset: 100,
+			err:    "invalid payload size",
+		},
+		{
+			name:   "negative payload size",
+			page:   cell(100, varints(1<<64-1, 1)),
+			offset: 100,
+			err:    "invalid payload size",
+		},
+		{
+			name:   "local payload truncated",
+			page:   cell(pageSize-10, varints(100, 1)),
+			offset: pageSize - 10,
+			err:    "cell truncated",
+		},
+		{
+			name:   "overflow pointer truncated",
+			page:   cell(overflowAt, varints(1000, 1)),
+			offset: overflowAt,
+			err:    "cell truncated",
+		},
+		{
+			name:   "overflow chain truncated",
+			page:   cell(100, varints(1000, 1)),
+			offset: 100,
+			err:    "overflow chain truncated",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			db := &DB{data: make([]byte, 4*pageSize), pageSize: pageSize, usable: pageSize}
+			rowid, payload, err := db.leafCell(test.page, test.offset)
+			var msg string
+			if err != nil {
+				msg = err.Error()
+			}
+			if msg != test.err {
+				t.Errorf("Unexpected error: %s", msg)
+			}
+			if err != nil {
+				return
+			}
+			if rowid != test.rowid {
+				t.Errorf("Unexpected rowid %d", rowid)
+			}
+			if !bytes.Equal(test.payload, payload) {
+				t.Errorf("Unexpected payload %q", payload)
+			}
+		})
+	}
+}
+
+func TestDecodeRecord(t *testing.T) {
+	tests := []struct {
+		name     string
+		record   []byte
+		expected []interface{}
+		err      string
+	}{
+		{
+			name:     "values",
+			record:   []byte{0x04, 0x00, 0x01, 0x0f, 0x05, 'a'},
+			expected: []interface{}{nil, int64(5), "a"},
+		},
+		{
+			name:   "empty",
+			record: []byte{},
+			err:    "invalid record header",
+		},
+		{
+			name:   "header shorter than its length",
+			record: []byte{0x00, 0x01},
+			err:    "invalid record header",
+		},
+		{
+			name:   "header past end",
+			record: []byte{0x05, 0x01},
+			err:    "invalid record header",
+		},
+		{
+			name:   "truncated serial type",
+			record: []byte{0x02, 0x81},
+			err:    "invalid serial type",
+		},
+		{
+			name:   "reserved serial type",
+			record: []byte{0x02, 0x0a},
+			err:    "reserved serial type 10",
+		},
+		{
+			name:   "oversized serial type",
+			record: append([]byte{0x0a}, bytes.Repeat([]byte{0xff}, 9)...),
+			err:    "invalid serial type 18446744073709551615",
+		},
+		{
+			name:   "body truncated",
+			record: []byte{0x03, 0x01, 0x11, 0x05, 'a'},
+			err:    "record truncated",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			values, err := decodeRecord(test.record)
+			var msg string
+			if err != nil {
+				msg = err.Error()
+			}
+			if msg != test.err {
+				t.Errorf("Unexpected error: %s", msg)
+			}
+			if d := diff.Interface(test.expected, values); d != nil {
+				t.Error(d)
+			}
+		})
+	}
+}
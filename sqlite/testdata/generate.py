@@ -0,0 +1,35 @@
+#!/usr/bin/env python3
+"""Generates the test databases for the sqlite package."""
+import os
+import sqlite3
+
+here = os.path.dirname(os.path.abspath(__file__))
+path = os.path.join(here, "test.db")
+if os.path.exists(path):
+    os.remove(path)
+db = sqlite3.connect(path)
+# A small page size forces interior and overflow pages.
+db.execute("PRAGMA page_size = 512")
+db.execute("""CREATE TABLE items (
+    id integer primary key,
+    name text not null,
+    n integer,
+    f real,
+    b blob,
+    "quoted, name" text,
+    CHECK (n >= -9223372036854775808)
+)""")
+values = [0, 1, -1, 127, -128, 32767, -32768, 8388607, -8388608, 2147483647,
+          -2147483648, 140737488355327, -140737488355328, 9223372036854775807,
+          -9223372036854775808]
+for i in range(1, 301):
+    db.execute("INSERT INTO items VALUES (?, ?, ?, ?, ?, ?)",
+               (i * 10, "item %d" % i, values[i % len(values)], i / 4.0,
+                None if i % 2 else bytes([i % 256]) * 3, "q%d" % i))
+db.execute("INSERT INTO items (id, name, b) VALUES (5000, ?, ?)",
+           ("x" * 3000, bytes(range(256)) * 20))
+db.execute("CREATE INDEX items_name ON items (name)")
+db.execute("CREATE TABLE empty (a, b)")
+db.commit()
+db.execute("VACUUM")
+db.close()
@@ -0,0 +1,690 @@
+package sqlite
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// defaultPageSize is the page size of written databases.
+const defaultPageSize = 4096
+
+// Writer builds a new SQLite database in memory, such as an Anki collection
+// to be exported. Tables and their indexes are written in full by Bytes.
+type Writer struct {
+	pageSize int
+	tables   []*newTable
+	byName   map[string]*newTable
+}
+
+type newTable struct {
+	name        string
+	sql         string
+	columns     []column
+	rowidColumn int
+	rows        []newRow
+	rowids      map[int64]bool
+	lastRowid   int64
+	indexes     []*newIndex
+}
+
+type newIndex struct {
+	name string
+	sql  string
+	// columns are the indexes of the indexed columns of the table.
+	columns []int
+}
+
+type newRow struct {
+	rowid  int64
+	values []interface{}
+}
+
+// NewWriter returns a new, empty database.
+func NewWriter() *Writer {
+	return &Writer{
+		pageSize: defaultPageSize,
+		byName:   make(map[string]*newTable),
+	}
+}
+
+// CreateTable adds a table, as declared by the CREATE TABLE statement sql.
+func (w *Writer) CreateTable(sql string) error {
+	name := tableName(sql)
+	if name == "" {
+		return errors.Errorf("invalid CREATE TABLE statement: %s", sql)
+	}
+	key := strings.ToLower(name)
+	if _, ok := w.byName[key]; ok {
+		return errors.Errorf("table %s already exists", name)
+	}
+	columns, rowidColumn := parseColumns(sql)
+	if len(columns) == 0 {
+		return errors.Errorf("table %s has no columns", name)
+	}
+	t := &newTable{
+		name:        name,
+		sql:         sql,
+		columns:     columns,
+		rowidColumn: rowidColumn,
+		rowids:      make(map[int64]bool),
+	}
+	w.tables = append(w.tables, t)
+	w.byName[key] = t
+	return nil
+}
+
+// tableName returns the unquoted name of the table declared by sql, or "".
+func tableName(sql string) string {
+	const prefix = "CREATE TABLE"
+	sql = strings.TrimSpace(sql)
+	if len(sql) < len(prefix) || !strings.EqualFold(sql[:len(prefix)], prefix) {
+		return ""
+	}
+	rest := strings.TrimSpace(sql[len(prefix):])
+	const ifNotExists = "IF NOT EXISTS"
+	if len(rest) > len(ifNotExists) && strings.EqualFold(rest[:len(ifNotExists)], ifNotExists) {
+		rest = strings.TrimSpace(rest[len(ifNotExists):])
+	}
+	name, _ := columnName(rest)
+	return name
+}
+
+var indexRE = regexp.MustCompile(`(?is)^\s*CREATE\s+INDEX\s+(?:IF\s+NOT\s+EXISTS\s+)?(.+?)\s+ON\s+(.+?)\s*\((.*)\)\s*$`)
+
+// CreateIndex adds an index, as declared by the CREATE INDEX statement sql,
+// to a table which has already been created. Only indexes of columns, in
+// ascending order with the default collation, are supported.
+func (w *Writer) CreateIndex(sql string) error {
+	m := indexRE.FindStringSubmatch(sql)
+	if m == nil {
+		return errors.Errorf("invalid CREATE INDEX statement: %s", sql)
+	}
+	name, _ := columnName(m[1])
+	tableName, _ := columnName(m[2])
+	t := w.byName[strings.ToLower(tableName)]
+	if t == nil {
+		return errors.Errorf("no such table: %s", tableName)
+	}
+	if _, ok := w.byName[strings.ToLower(name)]; ok {
+		return errors.Errorf("index %s already exists", name)
+	}
+	index := &newIndex{name: name, sql: sql}
+	for _, def := range splitDefinitions(m[3]) {
+		col, rest := columnName(strings.TrimSpace(def))
+		if rest = strings.TrimSpace(rest); rest != "" && !strings.EqualFold(rest, "ASC") {
+			return errors.Errorf("%s: unsupported index column %s", name, strings.TrimSpace(def))
+		}
+		i := t.columnIndex(col)
+		if i < 0 {
+			return errors.Errorf("%s: no such column: %s", name, col)
+		}
+		index.columns = append(index.columns, i)
+	}
+	t.indexes = append(t.indexes, index)
+	// Indexes share the namespace of tables.
+	w.byName[strings.ToLower(name)] = nil
+	return nil
+}
+
+// columnIndex returns the index of the named column, or -1.
+func (t *newTable) columnIndex(name string) int {
+	for i, col := range t.columns {
+		if strings.EqualFold(col.name, name) {
+			return i
+		}
+	}
+	return -1
+}
+
+// Insert adds a row to the named table, with a value for each column, in the
+// order in which they were declared. Values may be nil, int, int64, float64,
+// string or []byte. The value of an INTEGER PRIMARY KEY column is the rowid,
+// which is otherwise assigned in order of insertion.
+func (w *Writer) Insert(table string, values ...interface{}) error {
+	t := w.byName[strings.ToLower(table)]
+	if t == nil {
+		return errors.Errorf("no such table: %s", table)
+	}
+	if len(values) != len(t.columns) {
+		return errors.Errorf("table %s has %d columns but %d values were supplied", t.name, len(t.columns), len(values))
+	}
+	values = append([]interface{}{}, values...)
+	for i, value := range values {
+		switch v := value.(type) {
+		case int:
+			values[i] = int64(v)
+		case nil, int64, float64, string, []byte:
+		default:
+			return errors.Errorf("unsupported value of type %T for column %s", value, t.columns[i].name)
+		}
+	}
+	rowid := t.lastRowid + 1
+	if t.rowidColumn >= 0 && values[t.rowidColumn] != nil {
+		id, ok := values[t.rowidColumn].(int64)
+		if !ok {
+			return errors.Errorf("%s: primary key must be an integer", t.name)
+		}
+		rowid = id
+		// The rowid isn't stored in the record.
+		values[t.rowidColumn] = nil
+	}
+	if t.rowids[rowid] {
+		return errors.Errorf("%s: duplicate primary key %d", t.name, rowid)
+	}
+	t.rowids[rowid] = true
+	if rowid > t.lastRowid {
+		t.lastRowid = rowid
+	}
+	t.rows = append(t.rows, newRow{rowid: rowid, values: values})
+	return nil
+}
+
+// Bytes returns the content of the database file.
+func (w *Writer) Bytes() ([]byte, error) {
+	p := &pager{pageSize: w.pageSize}
+	// The schema table is rooted at page 1.
+	p.alloc()
+	schema := make([]newRow, 0, len(w.tables))
+	addSchema := func(kind, name, table string, root int, sql string) {
+		schema = append(schema, newRow{
+			rowid:  int64(len(schema) + 1),
+			values: []interface{}{kind, name, table, int64(root), sql},
+		})
+	}
+	for _, t := range w.tables {
+		root := p.alloc()
+		if err := p.writeTable(root, t.rows); err != nil {
+			return nil, errors.Wrapf(err, "write table %s", t.name)
+		}
+		addSchema("table", t.name, t.name, root, t.sql)
+		for _, index := range t.indexes {
+			root := p.alloc()
+			if err := p.writeIndex(root, t.indexKeys(index)); err != nil {
+				return nil, errors.Wrapf(err, "write index %s", index.name)
+			}
+			addSchema("index", index.name, t.name, root, index.sql)
+		}
+	}
+	if err := p.writeTable(1, schema); err != nil {
+		return nil, errors.Wrap(err, "write schema")
+	}
+	data := make([]byte, 0, len(p.pages)*p.pageSize)
+	for _, page := range p.pages {
+		data = append(data, page...)
+	}
+	p.writeHeader(data)
+	return data, nil
+}
+
+// pager allocates the pages of a database as it is written.
+type pager struct {
+	pageSize int
+	pages    [][]byte
+}
+
+// alloc allocates a new page, and returns its 1-based number.
+func (p *pager) alloc() int {
+	p.pages = append(p.pages, make([]byte, p.pageSize))
+	return len(p.pages)
+}
+
+func (p *pager) page(n int) []byte {
+	return p.pages[n-1]
+}
+
+// headerOffset returns the offset of the B-tree page header of page n, which
+// follows the database header on page 1.
+func headerOffset(n int) int {
+	if n == 1 {
+		return headerSize
+	}
+	return 0
+}
+
+// writeHeader writes the database header to the start of data.
+func (p *pager) writeHeader(data []byte) {
+	copy(data, headerMagic)
+	pageSize := p.pageSize
+	if pageSize == 65536 {
+		pageSize = 1
+	}
+	binary.BigEndian.PutUint16(data[16:], uint16(pageSize))
+	data[18], data[19] = 1, 1 // Legacy (rollback journal) file format
+	data[21], data[22], data[23] = 64, 32, 32
+	binary.BigEndian.PutUint32(data[24:], 1) // File change counter
+	binary.BigEndian.PutUint32(data[28:], uint32(len(p.pages)))
+	binary.BigEndian.PutUint32(data[40:], 1) // Schema cookie
+	binary.BigEndian.PutUint32(data[44:], 4) // Schema format
+	binary.BigEndian.PutUint32(data[56:], 1) // UTF-8
+	binary.BigEndian.PutUint32(data[92:], 1) // Version-valid-for
+	binary.BigEndian.PutUint32(data[96:], 3031001)
+}
+
+// treeEntry is a child of an interior B-tree page, with the largest rowid it
+// contains.
+type treeEntry struct {
+	page     int
+	maxRowid int64
+}
+
+// writeTable writes the table B-tree, rooted at page root, containing rows.
+func (p *pager) writeTable(root int, rows []newRow) error {
+	rows = append([]newRow{}, rows...)
+	sort.Slice(rows, func(i, j int) bool { return rows[i].rowid < rows[j].rowid })
+	cells := make([][]byte, len(rows))
+	for i, row := range rows {
+		record, err := encodeRecord(row.values)
+		if err != nil {
+			return errors.Wrapf(err, "row %d", row.rowid)
+		}
+		cells[i] = p.leafCell(row.rowid, record)
+	}
+	if fits(p.pageSize-headerOffset(root)-8, cells) {
+		writePage(p.page(root), headerOffset(root), pageLeafTable, cells, 0)
+		return nil
+	}
+	// Fill leaf pages in order, then add levels of interior pages until the
+	// children fit on the root page.
+	entries := make([]treeEntry, 0)
+	for start := 0; start < len(cells); {
+		end := start + 1
+		for end < len(cells) && fits(p.pageSize-8, cells[start:end+1]) {
+			end++
+		}
+		n := p.alloc()
+		writePage(p.page(n), 0, pageLeafTable, cells[start:end], 0)
+		entries = append(entries, treeEntry{page: n, maxRowid: rows[end-1].rowid})
+		start = end
+	}
+	for !fits(p.pageSize-headerOffset(root)-12, interiorCells(entries[:len(entries)-1])) {
+		level := make([]treeEntry, 0)
+		for start := 0; start < len(entries); {
+			end := start + 1
+			for end < len(entries) && fits(p.pageSize-12, interiorCells(entries[start:end])) {
+				end++
+			}
+			n := p.alloc()
+			writePage(p.page(n), 0, pageInteriorTable, interiorCells(entries[start:end-1]), entries[end-1].page)
+			level = append(level, treeEntry{page: n, maxRowid: entries[end-1].maxRowid})
+			start = end
+		}
+		entries = level
+	}
+	last := entries[len(entries)-1]
+	writePage(p.page(root), headerOffset(root), pageInteriorTable, interiorCells(entries[:len(entries)-1]), last.page)
+	return nil
+}
+
+// interiorCells returns the cells of an interior page pointing to entries.
+func interiorCells(entries []treeEntry) [][]byte {
+	cells := make([][]byte, len(entries))
+	for i, e := range entries {
+		cell := make([]byte, 4, 13)
+		binary.BigEndian.PutUint32(cell, uint32(e.page))
+		cells[i] = appendVarint(cell, uint64(e.maxRowid))
+	}
+	return cells
+}
+
+// fits returns true if cells, with their pointers, fit in space bytes.
+func fits(space int, cells [][]byte) bool {
+	for _, cell := range cells {
+		space -= len(cell) + 2
+	}
+	return space >= 0
+}
+
+// writePage writes a B-tree page, with its header at offset. right is the
+// right-most child of an interior page.
+func writePage(page []byte, offset int, kind byte, cells [][]byte, right int) {
+	headerLen := 8
+	if kind == pageInteriorTable || kind == pageInteriorIndex {
+		headerLen = 12
+		binary.BigEndian.PutUint32(page[offset+8:], uint32(right))
+	}
+	page[offset] = kind
+	binary.BigEndian.PutUint16(page[offset+3:], uint16(len(cells)))
+	content := len(page)
+	for i, cell := range cells {
+		content -= len(cell)
+		copy(page[content:], cell)
+		binary.BigEndian.PutUint16(page[offset+headerLen+2*i:], uint16(content))
+	}
+	// A content area starting at 65536 is recorded as 0.
+	binary.BigEndian.PutUint16(page[offset+5:], uint16(content))
+}
+
+// leafCell returns a table leaf cell.
+func (p *pager) leafCell(rowid int64, payload []byte) []byte {
+	cell := appendVarint(nil, uint64(len(payload)))
+	cell = appendVarint(cell, uint64(rowid))
+	return p.appendPayload(cell, payload, localPayload(p.pageSize, len(payload)))
+}
+
+// appendPayload appends the first local bytes of payload to cell, and writes
+// the remainder to a chain of overflow pages.
+func (p *pager) appendPayload(cell, payload []byte, local int) []byte {
+	cell = append(cell, payload[:local]...)
+	if local == len(payload) {
+		return cell
+	}
+	rest := payload[local:]
+	first := p.alloc()
+	for n := first; ; {
+		page := p.page(n)
+		chunk := copy(page[4:], rest)
+		rest = rest[chunk:]
+		if len(rest) == 0 {
+			break
+		}
+		next := p.alloc()
+		binary.BigEndian.PutUint32(page, uint32(next))
+		n = next
+	}
+	overflow := make([]byte, 4)
+	binary.BigEndian.PutUint32(overflow, uint32(first))
+	return append(cell, overflow...)
+}
+
+// indexKeys returns the keys of the index, for each row of the table, in
+// index order. A key holds the values of the indexed columns, followed by
+// the rowid.
+func (t *newTable) indexKeys(index *newIndex) [][]interface{} {
+	keys := make([][]interface{}, len(t.rows))
+	for i, row := range t.rows {
+		key := make([]interface{}, 0, len(index.columns)+1)
+		for _, col := range index.columns {
+			value := row.values[col]
+			if col == t.rowidColumn {
+				value = row.rowid
+			}
+			key = append(key, value)
+		}
+		keys[i] = append(key, row.rowid)
+	}
+	sort.Slice(keys, func(i, j int) bool { return compareKeys(keys[i], keys[j]) < 0 })
+	return keys
+}
+
+// compareKeys compares index keys, value by value.
+func compareKeys(a, b []interface{}) int {
+	for i := range a {
+		if c := compareValues(a[i], b[i]); c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+// storageClass returns the rank of the value's type in SQLite's sort order.
+func storageClass(v interface{}) int {
+	switch v.(type) {
+	case nil:
+		return 0
+	case int64, float64:
+		return 1
+	case string:
+		return 2
+	}
+	return 3
+}
+
+// compareValues compares values as SQLite does with the BINARY collation:
+// NULL sorts first, then numbers, text and blobs.
+func compareValues(a, b interface{}) int {
+	ca, cb := storageClass(a), storageClass(b)
+	if ca != cb {
+		return ca - cb
+	}
+	switch ca {
+	case 1:
+		if ia, ok := a.(int64); ok {
+			if ib, ok := b.(int64); ok {
+				switch {
+				case ia < ib:
+					return -1
+				case ia > ib:
+					return 1
+				}
+				return 0
+			}
+		}
+		fa, fb := toFloat(a), toFloat(b)
+		switch {
+		case fa < fb:
+			return -1
+		case fa > fb:
+			return 1
+		}
+		return 0
+	case 2:
+		return strings.Compare(a.(string), b.(string))
+	case 3:
+		return bytes.Compare(a.([]byte), b.([]byte))
+	}
+	return 0
+}
+
+func toFloat(v interface{}) float64 {
+	if i, ok := v.(int64); ok {
+		return float64(i)
+	}
+	return v.(float64)
+}
+
+// indexLocalPayload returns the number of bytes of an index payload of the
+// given size which are stored on the page itself.
+func indexLocalPayload(usable, size int) int {
+	return payloadOnPage(usable, size, (usable-12)*64/255-23)
+}
+
+// indexCell returns an index cell, which for an interior page is preceded by
+// the page number of its left child.
+func (p *pager) indexCell(child int, payload []byte) []byte {
+	var cell []byte
+	if child > 0 {
+		cell = make([]byte, 4)
+		binary.BigEndian.PutUint32(cell, uint32(child))
+	}
+	cell = appendVarint(cell, uint64(len(payload)))
+	return p.appendPayload(cell, payload, indexLocalPayload(p.pageSize, len(payload)))
+}
+
+// indexCellSize returns the size of the index cell holding payload.
+func (p *pager) indexCellSize(interior bool, payload []byte) int {
+	local := indexLocalPayload(p.pageSize, len(payload))
+	size := len(appendVarint(nil, uint64(len(payload)))) + local
+	if local < len(payload) {
+		size += 4
+	}
+	if interior {
+		size += 4
+	}
+	return size
+}
+
+// indexFits returns true if the index cells holding payloads, with their
+// pointers, fit in space bytes.
+func (p *pager) indexFits(space int, interior bool, payloads [][]byte) bool {
+	for _, payload := range payloads {
+		space -= p.indexCellSize(interior, payload) + 2
+	}
+	return space >= 0
+}
+
+// writeIndexPage writes index page n, holding payloads. Each payload of an
+// interior page is preceded by the corresponding child, and followed by the
+// right-most child.
+func (p *pager) writeIndexPage(n int, payloads [][]byte, children []int) {
+	kind := byte(pageLeafIndex)
+	var right int
+	if len(children) > 0 {
+		kind = pageInteriorIndex
+		right = children[len(children)-1]
+	}
+	cells := make([][]byte, len(payloads))
+	for i, payload := range payloads {
+		var child int
+		if len(children) > 0 {
+			child = children[i]
+		}
+		cells[i] = p.indexCell(child, payload)
+	}
+	writePage(p.page(n), headerOffset(n), kind, cells, right)
+}
+
+// writeIndex writes the index B-tree, rooted at page root, containing the
+// sorted keys. Unlike a table, each key of an index is stored once, so the
+// key which separates two pages is stored on their parent.
+func (p *pager) writeIndex(root int, keys [][]interface{}) error {
+	payloads := make([][]byte, len(keys))
+	for i, key := range keys {
+		payload, err := encodeRecord(key)
+		if err != nil {
+			return err
+		}
+		payloads[i] = payload
+	}
+	if p.indexFits(p.pageSize-headerOffset(root)-8, false, payloads) {
+		p.writeIndexPage(root, payloads, nil)
+		return nil
+	}
+	// Fill leaf pages, keeping the key between each pair for the parent.
+	children := make([]int, 0)
+	separators := make([][]byte, 0)
+	for start := 0; start < len(payloads); {
+		end := start + 1
+		for end < len(payloads) && p.indexFits(p.pageSize-8, false, payloads[start:end+1]) {
+			end++
+		}
+		// The last page must not be left empty by taking its separator.
+		if end == len(payloads)-1 {
+			end--
+		}
+		n := p.alloc()
+		p.writeIndexPage(n, payloads[start:end], nil)
+		children = append(children, n)
+		if end < len(payloads) {
+			separators = append(separators, payloads[end])
+		}
+		start = end + 1
+	}
+	// Add levels of interior pages, each holding children[start:end] and the
+	// separators between them, until the children fit on the root page.
+	for !p.indexFits(p.pageSize-headerOffset(root)-12, true, separators) {
+		level := make([]int, 0)
+		levelSeparators := make([][]byte, 0)
+		for start := 0; start < len(children); {
+			end := start + 2
+			for end < len(children) && p.indexFits(p.pageSize-12, true, separators[start:end]) {
+				end++
+			}
+			// Each page needs at least two children.
+			if end == len(children)-1 {
+				end--
+			}
+			n := p.alloc()
+			p.writeIndexPage(n, separators[start:end-1], children[start:end])
+			level = append(level, n)
+			if end < len(children) {
+				levelSeparators = append(levelSeparators, separators[end-1])
+			}
+			start = end
+		}
+		children, separators = level, levelSeparators
+	}
+	p.writeIndexPage(root, separators, children)
+	return nil
+}
+
+// encodeRecord encodes values in the SQLite record format.
+func encodeRecord(values []interface{}) ([]byte, error) {
+	types := make([]byte, 0, len(values))
+	body := make([]byte, 0)
+	for _, value := range values {
+		var serialType uint64
+		switch v := value.(type) {
+		case nil:
+			serialType = 0
+		case int64:
+			serialType, body = appendInt(body, v)
+		case float64:
+			serialType = 7
+			var buf [8]byte
+			binary.BigEndian.PutUint64(buf[:], math.Float64bits(v))
+			body = append(body, buf[:]...)
+		case string:
+			serialType = uint64(len(v))*2 + 13
+			body = append(body, v...)
+		case []byte:
+			serialType = uint64(len(v))*2 + 12
+			body = append(body, v...)
+		default:
+			return nil, errors.Errorf("unsupported value of type %T", value)
+		}
+		types = appendVarint(types, serialType)
+	}
+	// The header size includes the varint which records it.
+	headerLen := len(types) + 1
+	for len(appendVarint(nil, uint64(headerLen))) != headerLen-len(types) {
+		headerLen++
+	}
+	record := appendVarint(make([]byte, 0, headerLen+len(body)), uint64(headerLen))
+	record = append(record, types...)
+	return append(record, body...), nil
+}
+
+// appendInt appends the integer in the smallest serial type which holds it,
+// and returns that type.
+func appendInt(body []byte, v int64) (uint64, []byte) {
+	switch v {
+	case 0:
+		return 8, body
+	case 1:
+		return 9, body
+	}
+	for _, serialType := range []uint64{1, 2, 3, 4, 5, 6} {
+		size := intSizes[serialType]
+		shift := uint(64 - 8*size)
+		if v<<shift>>shift != v {
+			continue
+		}
+		for i := size - 1; i >= 0; i-- {
+			body = append(body, byte(v>>(8*uint(i))))
+		}
+		return serialType, body
+	}
+	panic("unreachable")
+}
+
+// appendVarint appends v as a SQLite variable-length integer.
+func appendVarint(buf []byte, v uint64) []byte {
+	if v > 0x00ffffffffffffff {
+		var b [9]byte
+		b[8] = byte(v)
+		v >>= 8
+		for i := 7; i >= 0; i-- {
+			b[i] = byte(v&0x7f) | 0x80
+			v >>= 7
+		}
+		return append(buf, b[:]...)
+	}
+	var b [8]byte
+	i := len(b)
+	for {
+		i--
+		b[i] = byte(v&0x7f) | 0x80
+		v >>= 7
+		if v == 0 {
+			break
+		}
+	}
+	b[len(b)-1] &= 0x7f
+	return append(buf, b[i:]...)
+}
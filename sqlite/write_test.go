@@ -0,0 +1,420 @@
+package sqlite
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/flimzy/diff"
+)
+
+func TestCreateTable(t *testing.T) {
+	tests := []struct {
+		name string
+		sql  string
+		err  string
+	}{
+		{
+			name: "valid",
+			sql:  "CREATE TABLE t (id integer primary key, name text)",
+		},
+		{
+			name: "if not exists",
+			sql:  "create table if not exists [other t] (a)",
+		},
+		{
+			name: "not create table",
+			sql:  "CREATE INDEX ix ON t (a)",
+			err:  "invalid CREATE TABLE statement: CREATE INDEX ix ON t (a)",
+		},
+		{
+			name: "duplicate",
+			sql:  "CREATE TABLE T (a)",
+			err:  "table T already exists",
+		},
+		{
+			name: "no columns",
+			sql:  "CREATE TABLE x",
+			err:  "table x has no columns",
+		},
+	}
+	w := NewWriter()
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := w.CreateTable(test.sql)
+			var msg string
+			if err != nil {
+				msg = err.Error()
+			}
+			if msg != test.err {
+				t.Errorf("Unexpected error: %s", msg)
+			}
+		})
+	}
+}
+
+func TestCreateIndex(t *testing.T) {
+	tests := []struct {
+		name string
+		sql  string
+		err  string
+	}{
+		{
+			name: "valid",
+			sql:  "CREATE INDEX ix_t_a ON t (a, id ASC)",
+		},
+		{
+			name: "if not exists",
+			sql:  "create index if not exists [ix b] on T ([b])",
+		},
+		{
+			name: "invalid",
+			sql:  "CREATE UNIQUE INDEX ix ON t (a)",
+			err:  "invalid CREATE INDEX statement: CREATE UNIQUE INDEX ix ON t (a)",
+		},
+		{
+			name: "no such table",
+			sql:  "CREATE INDEX ix ON missing (a)",
+			err:  "no such table: missing",
+		},
+		{
+			name: "index of index",
+			sql:  "CREATE INDEX ix ON ix_t_a (a)",
+			err:  "no such table: ix_t_a",
+		},
+		{
+			name: "duplicate",
+			sql:  "CREATE INDEX ix_t_a ON t (b)",
+			err:  "index ix_t_a already exists",
+		},
+		{
+			name: "no such column",
+			sql:  "CREATE INDEX ix ON t (c)",
+			err:  "ix: no such column: c",
+		},
+		{
+			name: "descending",
+			sql:  "CREATE INDEX ix ON t (a DESC)",
+			err:  "ix: unsupported index column a DESC",
+		},
+	}
+	w := NewWriter()
+	if err := w.CreateTable("CREATE TABLE t (id integer primary key, a, b)"); err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := w.CreateIndex(test.sql)
+			var msg string
+			if err != nil {
+				msg = err.Error()
+			}
+			if msg != test.err {
+				t.Errorf("Unexpected error: %s", msg)
+			}
+		})
+	}
+	if err := w.Insert("ix_t_a", 1, 2, 3); err == nil || err.Error() != "no such table: ix_t_a" {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if err := w.CreateTable("CREATE TABLE ix_t_a (a)"); err == nil || err.Error() != "table ix_t_a already exists" {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
+func TestInsert(t *testing.T) {
+	w := NewWriter()
+	if err := w.CreateTable("CREATE TABLE t (id integer primary key, name text)"); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		table  string
+		values []interface{}
+		err    string
+	}{
+		{
+			name:   "valid",
+			table:  "t",
+			values: []interface{}{10, "ten"},
+		},
+		{
+			name:  "no such table",
+			table: "missing",
+			err:   "no such table: missing",
+		},
+		{
+			name:   "wrong count",
+			table:  "t",
+			values: []interface{}{1},
+			err:    "table t has 2 columns but 1 values were supplied",
+		},
+		{
+			name:   "unsupported type",
+			table:  "t",
+			values: []interface{}{1, true},
+			err:    "unsupported value of type bool for column name",
+		},
+		{
+			name:   "invalid primary key",
+			table:  "t",
+			values: []interface{}{"one", "x"},
+			err:    "t: primary key must be an integer",
+		},
+		{
+			name:   "duplicate primary key",
+			table:  "T",
+			values: []interface{}{10, "x"},
+			err:    "t: duplicate primary key 10",
+		},
+		{
+			name:   "assigned rowid",
+			table:  "t",
+			values: []interface{}{nil, "eleven"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := w.Insert(test.table, test.values...)
+			var msg string
+			if err != nil {
+				msg = err.Error()
+			}
+			if msg != test.err {
+				t.Errorf("Unexpected error: %s", msg)
+			}
+		})
+	}
+}
+
+func TestWriterRoundTrip(t *testing.T) {
+	for _, pageSize := range []int{512, defaultPageSize, 65536} {
+		t.Run(fmt.Sprintf("page size %d", pageSize), func(t *testing.T) {
+			w := NewWriter()
+			w.pageSize = pageSize
+			// Enough tables that the schema doesn't fit on the first page
+			for i := 0; i < 40; i++ {
+				if err := w.CreateTable(fmt.Sprintf("CREATE TABLE t%d (id integer primary key, name text, n int, f real, b blob)", i)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := w.CreateTable("CREATE TABLE norowid (a text, b int)"); err != nil {
+				t.Fatal(err)
+			}
+			indexes := map[string][]string{
+				"ix_name": {"name", "id"},
+				"ix_f":    {"f", "b", "n"},
+				"ix_id":   {"id"},
+			}
+			for name, columns := range indexes {
+				sql := fmt.Sprintf("CREATE INDEX %s ON t1 (%s)", name, strings.Join(columns, ", "))
+				if err := w.CreateIndex(sql); err != nil {
+					t.Fatal(err)
+				}
+			}
+			expected := make([]Row, 0)
+			// Inserted in descending order, to be read in rowid order
+			for i := 2000; i > 0; i-- {
+				name := fmt.Sprintf("row %d", i)
+				if i%250 == 0 {
+					// Long enough to overflow several pages
+					name = string(bytes.Repeat([]byte{'a' + byte(i%26)}, 3*pageSize+i))
+				}
+				row := Row{"id": int64(i) * 3, "name": name, "n": int64(i*i*i) * -1000, "f": float64(i) / 4, "b": []byte{byte(i)}}
+				if i%7 == 0 {
+					row["b"] = nil
+				}
+				if err := w.Insert("t1", row["id"], row["name"], row["n"], row["f"], row["b"]); err != nil {
+					t.Fatal(err)
+				}
+				expected = append([]Row{row}, expected...)
+			}
+			for i := 0; i < 3; i++ {
+				if err := w.Insert("norowid", "x", i); err != nil {
+					t.Fatal(err)
+				}
+			}
+			data, err := w.Bytes()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(data)%pageSize != 0 {
+				t.Errorf("Unexpected file size %d", len(data))
+			}
+			db, err := Open(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tables := db.Tables(); len(tables) != 41 {
+				t.Errorf("Unexpected %d tables", len(tables))
+			}
+			rows, err := db.Rows("t1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if d := diff.Interface(expected, rows); d != nil {
+				t.Error(d)
+			}
+			empty, err := db.Rows("t39")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(empty) != 0 {
+				t.Errorf("Expected no rows, got %d", len(empty))
+			}
+			for name, columns := range indexes {
+				keys, err := readIndex(db, name)
+				if err != nil {
+					t.Fatalf("%s: %s", name, err)
+				}
+				if len(keys) != len(expected) {
+					t.Fatalf("%s: expected %d keys, got %d", name, len(expected), len(keys))
+				}
+				for i, key := range keys {
+					if i > 0 && compareKeys(keys[i-1], key) >= 0 {
+						t.Fatalf("%s: key %d out of order", name, i)
+					}
+					row := expected[(key[len(key)-1].(int64)/3)-1]
+					for j, col := range columns {
+						if compareValues(row[col], key[j]) != 0 {
+							t.Fatalf("%s: key %d doesn't match its row", name, i)
+						}
+					}
+				}
+			}
+			rows, err = db.Rows("norowid")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if d := diff.Interface([]Row{{"a": "x", "b": int64(0)}, {"a": "x", "b": int64(1)}, {"a": "x", "b": int64(2)}}, rows); d != nil {
+				t.Error(d)
+			}
+		})
+	}
+}
+
+func TestEncodeRecord(t *testing.T) {
+	values := []interface{}{nil, int64(0), int64(1), int64(-1), int64(127), int64(-129), int64(32768),
+		int64(-8388609), int64(2147483648), int64(-140737488355329), int64(9223372036854775807),
+		1.5, "text", []byte{1, 2}}
+	record, err := encodeRecord(values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := decodeRecord(record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := diff.Interface(values, result); d != nil {
+		t.Error(d)
+	}
+	if _, err := encodeRecord([]interface{}{int32(1)}); err == nil || err.Error() != "unsupported value of type int32" {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
+func TestAppendVarint(t *testing.T) {
+	for _, v := range []uint64{0, 1, 127, 128, 16383, 16384, 1<<56 - 1, 1 << 56, 1<<64 - 1} {
+		buf := appendVarint(nil, v)
+		result, n := varint(buf)
+		if result != v || n != len(buf) {
+			t.Errorf("%d: decoded %d from %d of %d bytes", v, result, n, len(buf))
+		}
+	}
+}
+
+// readIndex returns the keys of the named index, in the order in which they
+// are stored.
+func readIndex(db *DB, name string) ([][]interface{}, error) {
+	schema := make(map[string]int)
+	err := db.scan(1, 0, func(_ int64, values []interface{}) error {
+		if values[0] == "index" {
+			schema[values[1].(string)] = int(values[3].(int64))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	root, ok := schema[name]
+	if !ok {
+		return nil, fmt.Errorf("no such index: %s", name)
+	}
+	keys := make([][]interface{}, 0)
+	var walk func(n int) error
+	walk = func(n int) error {
+		page, err := db.page(n)
+		if err != nil {
+			return err
+		}
+		kind := page[0]
+		cells := int(binary.BigEndian.Uint16(page[3:]))
+		headerLen := 8
+		if kind == pageInteriorIndex {
+			headerLen = 12
+		} else if kind != pageLeafIndex {
+			return fmt.Errorf("page %d: unexpected page type %d", n, kind)
+		}
+		for i := 0; i < cells; i++ {
+			offset := int(binary.BigEndian.Uint16(page[headerLen+2*i:]))
+			if kind == pageInteriorIndex {
+				if err := walk(int(binary.BigEndian.Uint32(page[offset:]))); err != nil {
+					return err
+				}
+				offset += 4
+			}
+			size, n := varint(page[offset:])
+			offset += n
+			local := indexLocalPayload(db.usable, int(size))
+			payload := append([]byte{}, page[offset:offset+local]...)
+			for next := 0; len(payload) < int(size); {
+				if next == 0 {
+					next = int(binary.BigEndian.Uint32(page[offset+local:]))
+				}
+				overflow, err := db.page(next)
+				if err != nil {
+					return err
+				}
+				chunk := overflow[4:]
+				if remaining := int(size) - len(payload); len(chunk) > remaining {
+					chunk = chunk[:remaining]
+				}
+				payload = append(payload, chunk...)
+				next = int(binary.BigEndian.Uint32(overflow))
+			}
+			key, err := decodeRecord(payload)
+			if err != nil {
+				return err
+			}
+			keys = append(keys, key)
+		}
+		if kind == pageInteriorIndex {
+			return walk(int(binary.BigEndian.Uint32(page[8:])))
+		}
+		return nil
+	}
+	return keys, walk(root)
+}
+
+func TestCompareValues(t *testing.T) {
+	ordered := []interface{}{nil, int64(-5), -4.5, int64(0), 0.5, int64(1), 2.0, int64(3), "", "A", "a", "ab", []byte{}, []byte{0}, []byte{1}}
+	for i, a := range ordered {
+		for j, b := range ordered {
+			expected := 0
+			switch {
+			case i < j:
+				expected = -1
+			case i > j:
+				expected = 1
+			}
+			if c := compareValues(a, b); c < 0 && expected >= 0 || c > 0 && expected <= 0 || c == 0 && expected != 0 {
+				t.Errorf("compare %v, %v: %d", a, b, c)
+			}
+		}
+	}
+	if c := compareValues(int64(2), 2.0); c != 0 {
+		t.Errorf("Expected 2 = 2.0, got %d", c)
+	}
+}
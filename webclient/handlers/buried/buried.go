@@ -0,0 +1,80 @@
+// +build js
+
+package buriedhandler
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"html"
+	"net/url"
+
+	"github.com/flimzy/jqeventrouter"
+	"github.com/flimzy/log"
+	"github.com/gopherjs/gopherjs/js"
+	"github.com/gopherjs/jquery"
+
+	"github.com/FlashbackSRS/flashback/model"
+)
+
+var jQuery = jquery.NewJQuery
+
+// BeforeTransition prepares the buried cards page for display.
+func BeforeTransition(repo *model.Repo) jqeventrouter.HandlerFunc {
+	return func(_ *jquery.Event, _ *js.Object, _ url.Values) bool {
+		go func() {
+			container := jQuery(":mobile-pagecontainer")
+			jQuery("#unbury", container).Off("click").On("click", func() {
+				go func() {
+					if err := unbury(repo); err != nil {
+						log.Printf("Error unburying cards: %s\n", err)
+					}
+				}()
+			})
+			if err := showBuried(repo); err != nil {
+				log.Printf("Error fetching buried cards: %s\n", err)
+			}
+			jQuery(".show-until-load", container).Hide()
+			jQuery(".hide-until-load", container).Show()
+		}()
+		return true
+	}
+}
+
+// showBuried lists the buried cards, with the reason for, and end of, each
+// burial.
+func showBuried(repo *model.Repo) error {
+	cards, err := repo.BuriedCards(context.TODO())
+	if err != nil {
+		return err
+	}
+	container := jQuery(":mobile-pagecontainer")
+	buf := &bytes.Buffer{}
+	for i, card := range cards {
+		id := html.EscapeString(card.ID)
+		fmt.Fprintf(buf, `<input type="checkbox" id="buried-%d" value="%s"><label for="buried-%d">%s (%s, %s)</label>`,
+			i, id, i, id, card.BuryReason, card.BuriedUntil)
+	}
+	list := jQuery("#buried-cards", container)
+	list.SetHtml(buf.String())
+	list.Call("enhanceWithin")
+	jQuery("#buried-empty", container).Toggle(len(cards) == 0)
+	jQuery("#unbury", container).Toggle(len(cards) > 0)
+	return nil
+}
+
+// unbury unburies the selected cards, and refreshes the list.
+func unbury(repo *model.Repo) error {
+	checked := jQuery("#buried-cards input:checked", jQuery(":mobile-pagecontainer"))
+	cardIDs := make([]string, 0, checked.Length)
+	for i := 0; i < checked.Length; i++ {
+		cardIDs = append(cardIDs, jQuery(checked.Underlying().Index(i)).Val())
+	}
+	if len(cardIDs) == 0 {
+		return nil
+	}
+	if err := repo.UnburyCards(context.TODO(), cardIDs...); err != nil {
+		return err
+	}
+	return showBuried(repo)
+}
@@ -0,0 +1,91 @@
+// +build js
+
+package customstudyhandler
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+
+	"github.com/flimzy/jqeventrouter"
+	"github.com/flimzy/log"
+	"github.com/gopherjs/gopherjs/js"
+	"github.com/gopherjs/jquery"
+
+	"github.com/FlashbackSRS/flashback/fb"
+	"github.com/FlashbackSRS/flashback/model"
+	"github.com/FlashbackSRS/flashback/webclient/handlers/study"
+)
+
+var jQuery = jquery.NewJQuery
+
+// presets are the custom study sessions offered to the user.
+var presets = map[string]model.StudyFilter{
+	"failed": {FailedToday: true},
+	"due":    {DueWithin: 3 * fb.Day},
+	"random": {Random: 50},
+	"new":    {States: []model.CardState{model.StateNew}},
+	"query":  {},
+}
+
+// BeforeTransition prepares the custom study page for display.
+func BeforeTransition(repo *model.Repo) jqeventrouter.HandlerFunc {
+	return func(_ *jquery.Event, _ *js.Object, _ url.Values) bool {
+		go func() {
+			container := jQuery(":mobile-pagecontainer")
+			showStatus(repo)
+			jQuery("#custom-start", container).Off("click").On("click", func() {
+				go func() {
+					if err := start(repo); err != nil {
+						log.Printf("Error starting custom study: %s\n", err)
+					}
+				}()
+			})
+			jQuery("#custom-end", container).Off("click").On("click", func() {
+				repo.EndCustomStudy()
+				studyhandler.ResetCard()
+				showStatus(repo)
+			})
+			jQuery(".show-until-load", container).Hide()
+			jQuery(".hide-until-load", container).Show()
+		}()
+		return true
+	}
+}
+
+func showStatus(repo *model.Repo) {
+	container := jQuery(":mobile-pagecontainer")
+	remaining := repo.CustomStudyRemaining()
+	status := ""
+	if remaining > 0 {
+		status = fmt.Sprintf("%d cards remaining in the current session", remaining)
+	}
+	jQuery("#custom-status", container).SetText(status)
+	jQuery("#custom-end", container).Toggle(remaining > 0)
+}
+
+// start starts the selected custom study session, and begins studying.
+func start(repo *model.Repo) error {
+	container := jQuery(":mobile-pagecontainer")
+	preset := jQuery("#custom-preset", container).Val()
+	filter := presets[preset]
+	if preset == "query" {
+		filter.Query = jQuery("#custom-query", container).Val()
+	}
+	mode := model.StudyReschedule
+	if jQuery("#custom-cram", container).Is(":checked") {
+		mode = model.StudyCram
+	}
+	n, err := repo.StartCustomStudy(context.TODO(), filter, mode)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		jQuery("#custom-status", container).SetText("No cards match")
+		return nil
+	}
+	studyhandler.ResetCard()
+	// FIXME: Don't hard code /app here
+	container.Call("pagecontainer", "change", "study.html")
+	return nil
+}
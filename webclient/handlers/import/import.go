@@ -11,21 +11,29 @@ import (
 	"github.com/flimzy/log"
 	"github.com/gopherjs/gopherjs/js"
 	"github.com/gopherjs/jquery"
+	"github.com/nicksnyder/go-i18n/i18n/bundle"
+	"github.com/pkg/errors"
 
+	"github.com/FlashbackSRS/flashback/l10n"
 	"github.com/FlashbackSRS/flashback/model"
 )
 
 var jQuery = jquery.NewJQuery
 
 // BeforeTransition prepares import page
-func BeforeTransition(repo *model.Repo) jqeventrouter.HandlerFunc {
+func BeforeTransition(repo *model.Repo, langSet *l10n.Set) jqeventrouter.HandlerFunc {
 	return func(_ *jquery.Event, _ *js.Object, _ url.Values) bool {
 		go func() {
 			container := jQuery(":mobile-pagecontainer")
+			T, err := langSet.Tfunc()
+			if err != nil {
+				log.Printf("Error loading translations: %s\n", err)
+				return
+			}
 			jQuery("#importnow", container).On("click", func() {
 				log.Debug("Attempting to import something...\n")
 				go func() {
-					if err := DoImport(repo); err != nil {
+					if err := DoImport(repo, T); err != nil {
 						log.Printf("Error importing: %s\n", err)
 					}
 					log.Printf("DoImport() complete\n")
@@ -39,14 +47,52 @@ func BeforeTransition(repo *model.Repo) jqeventrouter.HandlerFunc {
 	}
 }
 
-// DoImport does an import of a *.fbb package
-func DoImport(repo *model.Repo) error {
-	files := file.InternalizeFileList(jQuery("#apkg", ":mobile-pagecontainer").Get(0).Get("files"))
+// DoImport does an import of a *.fbb package, or an Anki *.apkg package
+func DoImport(repo *model.Repo, T bundle.TranslateFunc) error {
+	container := jQuery(":mobile-pagecontainer")
+	files := file.InternalizeFileList(jQuery("#apkg", container).Get(0).Get("files"))
+	jQuery("#import-progress", container).Show()
 	for i := 0; i < files.Length; i++ {
-		if err := repo.ImportFile(context.TODO(), files.Item(i)); err != nil {
+		if err := repo.ImportFileProgress(context.TODO(), files.Item(i), showProgress(T)); err != nil {
+			jQuery("#import-status", container).SetText(importError(T, err))
 			return err
 		}
 	}
+	jQuery("#import-status", container).SetText(T("import_complete"))
 	log.Debugf("Done with import\n")
 	return nil
 }
+
+// importError returns the message to show for an import error. Anki packages
+// in the newer format get an explanation of how to export them instead.
+func importError(T bundle.TranslateFunc, err error) string {
+	if errors.Cause(err) == model.ErrUnsupportedAnkiFormat {
+		return T("import_anki_unsupported_format")
+	}
+	return err.Error()
+}
+
+// showProgress returns a function which displays the progress of an import.
+// While the package is being checked, the bytes read are shown; once its
+// documents are being stored, the number stored.
+func showProgress(T bundle.TranslateFunc) func(model.ImportProgress) {
+	return func(p model.ImportProgress) {
+		container := jQuery(":mobile-pagecontainer")
+		bar := jQuery("#import-progress", container)
+		status := jQuery("#import-status", container)
+		switch {
+		case p.Total > 0:
+			bar.SetAttr("value", float64(p.Docs)/float64(p.Total))
+			status.SetText(T("import_progress_docs", map[string]interface{}{
+				"Docs":  p.Docs,
+				"Total": p.Total,
+			}))
+		case p.TotalBytes > 0:
+			bar.SetAttr("value", float64(p.Bytes)/float64(p.TotalBytes))
+			status.SetText(T("import_progress_bytes", map[string]interface{}{
+				"KiB":      p.Bytes >> 10,
+				"TotalKiB": p.TotalBytes >> 10,
+			}))
+		}
+	}
+}
@@ -0,0 +1,158 @@
+// +build js
+
+package statshandler
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/flimzy/jqeventrouter"
+	"github.com/flimzy/log"
+	"github.com/gopherjs/gopherjs/js"
+	"github.com/gopherjs/jquery"
+	"github.com/nicksnyder/go-i18n/i18n/bundle"
+
+	"github.com/FlashbackSRS/flashback/l10n"
+	"github.com/FlashbackSRS/flashback/model"
+)
+
+var jQuery = jquery.NewJQuery
+
+// BeforeTransition prepares the stats page for display.
+func BeforeTransition(repo *model.Repo, langSet *l10n.Set) jqeventrouter.HandlerFunc {
+	return func(_ *jquery.Event, _ *js.Object, _ url.Values) bool {
+		go func() {
+			container := jQuery(":mobile-pagecontainer")
+			T, err := langSet.Tfunc()
+			if err != nil {
+				log.Printf("Error loading translations: %s\n", err)
+				return
+			}
+			if err := setupScope(repo, T); err != nil {
+				log.Printf("Error fetching bundles: %s\n", err)
+			}
+			period := jQuery("#stats-period", container)
+			scope := jQuery("#stats-scope", container)
+			refresh := func() {
+				go func() {
+					if err := showStats(repo, T, period.Val(), scope.Val()); err != nil {
+						log.Printf("Error fetching stats: %s\n", err)
+					}
+				}()
+			}
+			period.On("change", refresh)
+			scope.On("change", refresh)
+			if err := showStats(repo, T, period.Val(), scope.Val()); err != nil {
+				log.Printf("Error fetching stats: %s\n", err)
+			}
+			jQuery(".show-until-load", container).Hide()
+			jQuery(".hide-until-load", container).Show()
+		}()
+		return true
+	}
+}
+
+// setupScope populates the scope selector with the user's bundles and decks.
+// Each option's value is the bundle ID, followed by a slash and the deck ID
+// for a deck.
+func setupScope(repo *model.Repo, T bundle.TranslateFunc) error {
+	ctx := context.TODO()
+	bundles, err := repo.Bundles(ctx)
+	if err != nil {
+		return err
+	}
+	sel := jQuery("#stats-scope", jQuery(":mobile-pagecontainer")).Empty()
+	addOption := func(value, label string) {
+		sel.Append(jQuery("<option>").SetAttr("value", value).SetText(label))
+	}
+	addOption("", T("stats_scope_all"))
+	for _, bundle := range bundles {
+		addOption(bundle.ID, bundle.Name)
+		decks, err := repo.BundleDecks(ctx, bundle.ID)
+		if err != nil {
+			return err
+		}
+		for _, deck := range decks {
+			addOption(bundle.ID+"/"+deck.ID, bundle.Name+" / "+deck.Name)
+		}
+	}
+	sel.Call("selectmenu").Call("selectmenu", "refresh")
+	return nil
+}
+
+// showStats renders stats for the past 'days' days, or for all time if days
+// is 0, limited to the bundle or deck selected by scope.
+func showStats(repo *model.Repo, T bundle.TranslateFunc, days, scope string) error {
+	filter := model.StatsFilter{}
+	if n, _ := strconv.Atoi(days); n > 0 {
+		filter.From = time.Now().AddDate(0, 0, -n)
+	}
+	parts := strings.SplitN(scope, "/", 2)
+	filter.BundleID = parts[0]
+	if len(parts) == 2 {
+		filter.DeckID = parts[1]
+	}
+	stats, err := repo.Stats(context.TODO(), filter)
+	if err != nil {
+		return err
+	}
+	container := jQuery(":mobile-pagecontainer")
+
+	buf := &bytes.Buffer{}
+	for _, state := range []model.CardState{model.StateNew, model.StateLearning, model.StateYoung, model.StateMature, model.StateSuspended} {
+		fmt.Fprintf(buf, "<tr><th>%s</th><td>%d</td></tr>", T("stats_state_"+state.String()), stats.Cards[state])
+	}
+	jQuery("#stats-cards", container).SetHtml(buf.String())
+
+	jQuery("#stats-summary", container).SetText(T("stats_summary", map[string]interface{}{
+		"Reviews":     stats.Reviews,
+		"AverageTime": stats.AverageAnswerTime.Round(100 * time.Millisecond),
+		"Retention":   percent(stats.TrueRetention()),
+	}))
+
+	buf.Reset()
+	for _, day := range stats.Days {
+		fmt.Fprintf(buf, "<tr><th>%s</th><td>%d</td><td>%s</td><td>%s</td></tr>",
+			day.Day, day.Reviews, percent(float64(day.Correct)/float64(day.Reviews)), day.Time.Round(time.Second))
+	}
+	jQuery("#stats-days", container).SetHtml(buf.String())
+
+	buf.Reset()
+	for _, b := range stats.Retention {
+		fmt.Fprintf(buf, "<tr><th>%s</th><td>%d</td><td>%s</td></tr>", bucketLabel(b), b.Count, percent(b.Retention()))
+	}
+	jQuery("#stats-retention", container).SetHtml(buf.String())
+
+	buf.Reset()
+	for _, b := range stats.Intervals {
+		fmt.Fprintf(buf, "<tr><th>%s</th><td>%d</td></tr>", bucketLabel(b), b.Count)
+	}
+	jQuery("#stats-intervals", container).SetHtml(buf.String())
+
+	buf.Reset()
+	for _, b := range stats.Eases {
+		fmt.Fprintf(buf, "<tr><th>%.0f%%</th><td>%d</td></tr>", b.Ease*100, b.Count)
+	}
+	jQuery("#stats-eases", container).SetHtml(buf.String())
+	return nil
+}
+
+func bucketLabel(b *model.IntervalBucket) string {
+	if b.Max == 0 {
+		return "≥ " + b.Min.String()
+	}
+	return b.Min.String() + " – " + b.Max.String()
+}
+
+func percent(f float64) string {
+	if math.IsNaN(f) {
+		return "-"
+	}
+	return fmt.Sprintf("%.1f%%", f*100)
+}
@@ -0,0 +1,84 @@
+// +build js
+
+package studyhandler
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+
+	"github.com/flimzy/log"
+	"github.com/gopherjs/gopherjs/js"
+	"github.com/gopherjs/jquery"
+	"github.com/pkg/errors"
+
+	"github.com/FlashbackSRS/flashback/model"
+)
+
+// setupScope populates the study scope selector with the user's bundles and
+// decks, and changes the scope when a selection is made.
+func setupScope(repo *model.Repo) error {
+	ctx := context.TODO()
+	container := jQuery(":mobile-pagecontainer")
+	bundles, err := repo.Bundles(ctx)
+	if err != nil {
+		return errors.Wrap(err, "fetch bundles")
+	}
+	current := repo.StudyScope()
+	tags := strings.Join(current.Tags, ", ")
+	current.Tags = nil
+	selected, _ := json.Marshal(current)
+
+	sel := jQuery("#study-scope", container).Off("change")
+	setScope := func(option jquery.JQuery, scope model.StudyScope) {
+		value, _ := json.Marshal(scope)
+		option.SetAttr("value", string(value))
+		if string(value) == string(selected) {
+			option.SetAttr("selected", "selected")
+		}
+	}
+	addOption := func(scope model.StudyScope, label string) {
+		option := jQuery("<option>").SetText(label)
+		setScope(option, scope)
+		sel.Append(option)
+	}
+	// The first option, for all cards, is part of the page, so that it is
+	// localized with the rest of it.
+	sel.Children("option").Not(":first").Remove()
+	setScope(sel.Children("option").First(), model.StudyScope{})
+	for _, bundle := range bundles {
+		addOption(model.StudyScope{BundleID: bundle.ID}, bundle.Name)
+		decks, err := repo.BundleDecks(ctx, bundle.ID)
+		if err != nil {
+			return errors.Wrapf(err, "fetch decks for %s", bundle.ID)
+		}
+		for _, deck := range decks {
+			addOption(model.StudyScope{BundleID: bundle.ID, DeckID: deck.ID}, bundle.Name+" / "+deck.Name)
+		}
+	}
+	sel.Call("selectmenu").Call("selectmenu", "refresh")
+	tagInput := jQuery("#study-tags", container).Off("change").SetVal(tags)
+
+	change := func(_ *js.Object) {
+		scope := model.StudyScope{}
+		if err := json.Unmarshal([]byte(sel.Val()), &scope); err != nil {
+			log.Printf("Invalid study scope: %s\n", err)
+			return
+		}
+		for _, tag := range strings.Split(tagInput.Val(), ",") {
+			if tag = strings.TrimSpace(tag); tag != "" {
+				scope.Tags = append(scope.Tags, tag)
+			}
+		}
+		repo.SetStudyScope(scope)
+		currentCard = nil
+		go func() {
+			if err := ShowCard(repo); err != nil {
+				log.Printf("Error showing card: %v", err)
+			}
+		}()
+	}
+	sel.On("change", change)
+	tagInput.On("change", change)
+	return nil
+}
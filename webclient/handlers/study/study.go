@@ -37,6 +37,9 @@ func BeforeTransition(repo *model.Repo) jqeventrouter.HandlerFunc {
 			return false
 		}
 		go func() {
+			if err := setupScope(repo); err != nil {
+				log.Printf("Error setting up study scope: %v", err)
+			}
 			if err := ShowCard(repo); err != nil {
 				log.Printf("Error showing card: %v", err)
 			}
@@ -46,6 +49,12 @@ func BeforeTransition(repo *model.Repo) jqeventrouter.HandlerFunc {
 	}
 }
 
+// ResetCard discards the card currently being studied, so that a new card is
+// fetched when the study page is next shown.
+func ResetCard() {
+	currentCard = nil
+}
+
 func ShowCard(repo *model.Repo) error {
 	if currentCard == nil {
 		log.Debug("Fetching card\n")
@@ -114,6 +123,43 @@ func ShowCard(repo *model.Repo) error {
 
 	container := jQuery(":mobile-pagecontainer")
 
+	cardID := currentCard.Card.DocID()
+	actionFunc := func(action func(context.Context, ...string) error, note bool) func(*js.Object) {
+		return func(_ *js.Object) {
+			go func() { // DB updates block
+				if err := applyAction(repo, action, cardID, note); err != nil {
+					log.Printf("Error updating card %s: %s\n", cardID, err)
+					return
+				}
+				currentCard = nil
+				// FIXME: Don't hard code /app here
+				jQuery(":mobile-pagecontainer").Call("pagecontainer", "change", "study.html")
+			}()
+		}
+	}
+	for id, click := range map[string]func(*js.Object){
+		"suspend-card": actionFunc(repo.SuspendCards, false),
+		"suspend-note": actionFunc(repo.SuspendCards, true),
+		"bury-card":    actionFunc(repo.BuryCards, false),
+		"bury-note":    actionFunc(repo.BuryCards, true),
+	} {
+		jQuery(`[data-id="`+id+`"]`, container).Off("click").On("click", click)
+	}
+	undoButton := jQuery(`[data-id="undo"]`, container).Off("click")
+	undoButton.ToggleClass("ui-state-disabled", !repo.CanUndo())
+	undoButton.On("click", func(_ *js.Object) {
+		go func() { // DB updates block
+			card, err := repo.Undo(context.TODO())
+			if err != nil {
+				log.Printf("Error undoing answer: %s\n", err)
+				return
+			}
+			currentCard = &cardState{Card: card}
+			// FIXME: Don't hard code /app here
+			jQuery(":mobile-pagecontainer").Call("pagecontainer", "change", "study.html")
+		}()
+	})
+
 	oldIframes := jQuery("#cardframe", container).Find("iframe").Underlying()
 	for i := 0; i < oldIframes.Length(); i++ {
 		oldIframeID := oldIframes.Index(i).Get("src").String()
@@ -131,6 +177,20 @@ func ShowCard(repo *model.Repo) error {
 	return nil
 }
 
+// applyAction applies action to the card, or if note is true, to all of the
+// cards of its note.
+func applyAction(repo *model.Repo, action func(context.Context, ...string) error, cardID string, note bool) error {
+	ctx := context.TODO()
+	cardIDs := []string{cardID}
+	if note {
+		var err error
+		if cardIDs, err = repo.NoteCardIDs(ctx, cardID); err != nil {
+			return errors.Wrap(err, "fetch note cards")
+		}
+	}
+	return action(ctx, cardIDs...)
+}
+
 func StudyInit() {
 	log.Debug("Registering iframes listener\n")
 	iframes.RegisterListener("submit", handleSubmit())
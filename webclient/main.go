@@ -21,11 +21,14 @@ import (
 
 	_ "github.com/FlashbackSRS/flashback/controllers/anki" // Anki model controllers
 	"github.com/FlashbackSRS/flashback/webclient/handlers/auth"
+	"github.com/FlashbackSRS/flashback/webclient/handlers/buried"
+	"github.com/FlashbackSRS/flashback/webclient/handlers/customstudy"
 	"github.com/FlashbackSRS/flashback/webclient/handlers/general"
 	"github.com/FlashbackSRS/flashback/webclient/handlers/import"
 	"github.com/FlashbackSRS/flashback/webclient/handlers/l10n"
 	"github.com/FlashbackSRS/flashback/webclient/handlers/login"
 	"github.com/FlashbackSRS/flashback/webclient/handlers/logout"
+	"github.com/FlashbackSRS/flashback/webclient/handlers/stats"
 	"github.com/FlashbackSRS/flashback/webclient/handlers/study"
 	synchandler "github.com/FlashbackSRS/flashback/webclient/handlers/sync"
 )
@@ -122,8 +125,11 @@ func RouterInit(prefix, baseURL string, repo *model.Repo, langSet *l10n.Set, pro
 	beforeTransition.HandleFunc(prefix+"/login.html", loginhandler.BeforeTransition(repo, providers))
 	beforeTransition.HandleFunc(prefix+"/callback.html", loginhandler.BTCallback(repo, providers))
 	beforeTransition.HandleFunc(prefix+"/logout.html", logouthandler.BeforeTransition(repo))
-	beforeTransition.HandleFunc(prefix+"/import.html", importhandler.BeforeTransition(repo))
+	beforeTransition.HandleFunc(prefix+"/import.html", importhandler.BeforeTransition(repo, langSet))
 	beforeTransition.HandleFunc(prefix+"/study.html", studyhandler.BeforeTransition(repo))
+	beforeTransition.HandleFunc(prefix+"/stats.html", statshandler.BeforeTransition(repo, langSet))
+	beforeTransition.HandleFunc(prefix+"/buried.html", buriedhandler.BeforeTransition(repo))
+	beforeTransition.HandleFunc(prefix+"/custom.html", customstudyhandler.BeforeTransition(repo))
 	jqeventrouter.Listen("pagecontainerbeforetransition", beforeTransition)
 
 	// beforeshow